@@ -11,13 +11,11 @@ func ToErr(code int32) ErrCode {
 	}
 }
 
-//func IsNotFound(err error)bool{
-//	err = errors.Cause(err)
-//	if _,ok:= err.(NotFound);ok{
-//		return true
-//	}
-//	return false
-//}
+// IsNotFound err(或其Cause)实现了NotFound时返回true
+func IsNotFound(err error) bool {
+	_, ok := errors.Cause(err).(NotFound)
+	return ok
+}
 
 func GetCode(err error) int32 {
 	if err != nil {
@@ -1,6 +1,7 @@
 package consul
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/hashicorp/consul/api"
@@ -12,6 +13,7 @@ import (
 	"gopkg.in/yaml.v2"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -19,6 +21,11 @@ type Client struct {
 	address string
 	option  option
 	client  *api.Client
+
+	mu            sync.Mutex
+	closed        bool
+	watchers      map[*Watcher]struct{}
+	registrations map[string]func() // serviceID=>注销函数
 }
 
 func NewClient(addr string, opts ...WithOption) (*Client, error) {
@@ -27,15 +34,22 @@ func NewClient(addr string, opts ...WithOption) (*Client, error) {
 		return nil, errors.WithMessage(err, "new option")
 	}
 
-	consulClient, err := api.NewClient(&api.Config{Address: addr})
+	apiConfig, err := clientOption.apiConfig(addr)
+	if err != nil {
+		return nil, errors.WithMessage(err, "apiConfig")
+	}
+
+	consulClient, err := api.NewClient(apiConfig)
 	if err != nil {
 		return nil, errors.WithMessage(err, "api.NewClient")
 	}
 
 	return &Client{
-		client:  consulClient,
-		option:  clientOption,
-		address: addr,
+		client:        consulClient,
+		option:        clientOption,
+		address:       addr,
+		watchers:      make(map[*Watcher]struct{}),
+		registrations: make(map[string]func()),
 	}, nil
 }
 
@@ -56,12 +70,24 @@ func (c *Client) GetYaml(key string, obj interface{}) error {
 }
 
 // WatchJson watch到新值时会调用callback,callback可空
+// watch会一直运行到Client.Close,需要单独停止时用WatchJsonContext
 func (c *Client) WatchJson(key string, obj interface{}, callback func()) error {
-	return c.watch(key, obj, callback, json.Unmarshal)
+	_, err := c.WatchJsonContext(context.Background(), key, obj, callback)
+	return err
 }
 
 func (c *Client) WatchYaml(key string, obj interface{}, callback func()) error {
-	return c.watch(key, obj, callback, yaml.Unmarshal)
+	_, err := c.WatchYamlContext(context.Background(), key, obj, callback)
+	return err
+}
+
+// WatchJsonContext ctx取消或调用Watcher.Stop后停止watch
+func (c *Client) WatchJsonContext(ctx context.Context, key string, obj interface{}, callback func()) (*Watcher, error) {
+	return c.watch(ctx, key, obj, callback, json.Unmarshal)
+}
+
+func (c *Client) WatchYamlContext(ctx context.Context, key string, obj interface{}, callback func()) (*Watcher, error) {
+	return c.watch(ctx, key, obj, callback, yaml.Unmarshal)
 }
 
 // RegisterService 服务注册,并返回注销函数
@@ -93,6 +119,13 @@ func (c *Client) RegisterService(serverIP string, port int, conf consul.Conf) (f
 		},
 	}
 
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return nil, ErrClientClosed
+	}
+
 	client := c.GetClient()
 	// 注册服务
 	if err := client.Agent().ServiceRegister(reg); err != nil {
@@ -109,7 +142,7 @@ func (c *Client) RegisterService(serverIP string, port int, conf consul.Conf) (f
 	//}
 
 	// routine to update ttl
-	stopTicker := make(chan int)
+	stopTicker := make(chan struct{})
 	go func() {
 		ticker := time.NewTicker(time.Second * time.Duration(conf.TTL) / 2) // 注意：小于注册的check ttl时间
 		defer ticker.Stop()
@@ -134,21 +167,30 @@ func (c *Client) RegisterService(serverIP string, port int, conf consul.Conf) (f
 		}
 	}()
 
-	// consul deregister
-	fn := proc.AddShutdownListener(func() {
-		stopTicker <- 1
-		err := client.Agent().ServiceDeregister(serviceID)
-		if err != nil {
-			logx.Errorw("deregister service failed ", sidField, logx.Field("err", err))
-		} else {
-			logx.Infow("deregistered service from consul server.", sidField)
-		}
-		//err = client.Agent().CheckDeregister(serviceID)
-		//if err != nil {
-		//	logx.Errorw("deregister service Check error.", logx.Field("err", err))
-		//}
-	})
-	return fn, nil
+	// consul deregister, 进程退出、Client.Close和调用方都可能触发,只执行一次
+	var once sync.Once
+	deregister := func() {
+		once.Do(func() {
+			close(stopTicker)
+			err := client.Agent().ServiceDeregister(serviceID)
+			if err != nil {
+				logx.Errorw("deregister service failed ", sidField, logx.Field("err", err))
+			} else {
+				logx.Infow("deregistered service from consul server.", sidField)
+			}
+
+			c.mu.Lock()
+			delete(c.registrations, serviceID)
+			c.mu.Unlock()
+		})
+	}
+
+	c.mu.Lock()
+	c.registrations[serviceID] = deregister
+	c.mu.Unlock()
+
+	proc.AddShutdownListener(deregister)
+	return deregister, nil
 }
 
 type Unmarshal func(data []byte, v interface{}) error
@@ -168,7 +210,14 @@ func (c *Client) getWithUnmarshal(key string, obj interface{}, unmarshal Unmarsh
 	if err != nil {
 		return errors.WithMessage(err, fmt.Sprintf("unmarshal bytes(%s)", string(bytes)))
 	}
-	return nil
+
+	err = decryptSecrets(obj, c.option.keyProvider)
+	return errors.WithMessage(err, "decryptSecrets")
+}
+
+// EncryptValue 用客户端配置的密钥加密明文,结果可直接写入consul的配置中
+func (c *Client) EncryptValue(plaintext string) (string, error) {
+	return EncryptValue(c.option.keyProvider, plaintext)
 }
 
 func (c *Client) getKey(key string) string {
@@ -191,19 +240,29 @@ func (c *Client) getValue(key string) ([]byte, error) {
 	return pair.Value, nil
 }
 
-func (c *Client) watch(key string, obj interface{}, callback func(), unmarshal Unmarshal) error {
+func (c *Client) watch(ctx context.Context, key string, obj interface{}, callback func(), unmarshal Unmarshal) (*Watcher, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+
 	realKey := c.getKey(key)
-	plan, err := watch.Parse(map[string]interface{}{
-		"type": "key",
-		"key":  realKey,
-	})
-	if err != nil {
-		return errors.WithMessage(err, "watch.Parse")
+	newParams := func() map[string]interface{} {
+		// watch.Parse会修改params,每次都重新生成
+		return map[string]interface{}{
+			"type": "key",
+			"key":  realKey,
+		}
+	}
+	if _, err := watch.Parse(newParams()); err != nil {
+		cancel()
+		return nil, errors.WithMessage(err, "watch.Parse")
 	}
 
-	err = c.getWithUnmarshal(key, obj, unmarshal)
+	err := c.getWithUnmarshal(key, obj, unmarshal)
 	if err != nil {
-		return errors.WithMessage(err, "getWithUnmarshal")
+		cancel()
+		return nil, errors.WithMessage(err, "getWithUnmarshal")
 	}
 
 	if callback != nil {
@@ -211,7 +270,7 @@ func (c *Client) watch(key string, obj interface{}, callback func(), unmarshal U
 	}
 
 	rt := reflect.TypeOf(obj)
-	plan.Handler = func(idx uint64, raw interface{}) {
+	handler := func(idx uint64, raw interface{}) {
 		defer func() {
 			//避免对外部造成影响
 			if r := recover(); r != nil {
@@ -219,6 +278,11 @@ func (c *Client) watch(key string, obj interface{}, callback func(), unmarshal U
 			}
 		}()
 
+		// Stop之后不再回调
+		if ctx.Err() != nil {
+			return
+		}
+
 		var value []byte
 		if kv, ok := raw.(*api.KVPair); ok && kv != nil {
 			value = kv.Value
@@ -230,6 +294,10 @@ func (c *Client) watch(key string, obj interface{}, callback func(), unmarshal U
 				logx.Errorw("watch unmarshal", field)
 				return
 			}
+			if err := decryptSecrets(tmp, c.option.keyProvider); err != nil {
+				logx.Errorw("watch decryptSecrets", logx.Field("key", realKey), logx.Field("err", err))
+				return
+			}
 
 			reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(tmp).Elem())
 
@@ -245,11 +313,13 @@ func (c *Client) watch(key string, obj interface{}, callback func(), unmarshal U
 		}
 	}
 
-	go func() {
-		err = plan.Run(c.address)
+	w, err := c.startWatcher(ctx, cancel, realKey, func() (*watch.Plan, error) {
+		plan, err := watch.Parse(newParams())
 		if err != nil {
-			logx.Errorf("plan.Run %s", err)
+			return nil, err
 		}
-	}()
-	return nil
+		plan.Handler = handler
+		return plan, nil
+	})
+	return w, errors.WithMessage(err, "startWatcher")
 }
@@ -1,28 +1,48 @@
 package consul
 
 import (
+	"context"
 	"fmt"
-	"github.com/zeromicro/go-zero/core/proc"
-	"github.com/zeromicro/zero-contrib/zrpc/registry/consul"
 	"testing"
 	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/zeromicro/zero-contrib/zrpc/registry/consul"
+	"github.com/ziyoumeng/sdk/consul/consultest"
 )
 
 const (
-	testAddr = "127.0.0.1:8500"
+	testPrefix      = "/test/service/counter"
+	testWaitTimeout = 5 * time.Second
 )
 
-func getClient(t *testing.T) *Client {
-	c, err := NewClient(testAddr, WithPrefix("/test/service/counter"))
+func getClient(t *testing.T) (*Client, *consultest.Server) {
+	srv := consultest.NewServer()
+	t.Cleanup(srv.Close)
+
+	srv.Put(testPrefix+"/tmp.json", []byte(`{"D":"5s"}`))
+	srv.Put(testPrefix+"/tmp.yaml", []byte("D: 1s\n"))
+	srv.Put(testPrefix+"/tmp", []byte("hello"))
+
+	c, err := NewClient(srv.Addr(), WithPrefix(testPrefix))
 	if err != nil {
 		t.Fatalf("NewClient %s", err)
 	}
-	return c
+	t.Cleanup(c.Close)
+	return c, srv
+}
+
+func waitNotify(t *testing.T, ch <-chan struct{}, msg string) {
+	select {
+	case <-ch:
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("timeout waiting %s", msg)
+	}
 }
 
 func TestGetJson(t *testing.T) {
 	var tmp = make(map[string]interface{})
-	c := getClient(t)
+	c, _ := getClient(t)
 	err := c.GetJson("tmp.json", &tmp)
 	if err != nil {
 		t.Errorf("GetJson %s", err)
@@ -31,12 +51,16 @@ func TestGetJson(t *testing.T) {
 		t.Errorf("GetJson %s", tmp["D"])
 	}
 
+	err = c.GetJson("not-exist.json", &tmp)
+	if err == nil {
+		t.Errorf("GetJson not exist key should fail")
+	}
 }
 
 func TestGetYaml(t *testing.T) {
 	tmp := make(map[string]interface{})
 
-	c := getClient(t)
+	c, _ := getClient(t)
 	err := c.GetYaml("tmp.yaml", &tmp)
 	if err != nil {
 		t.Errorf("GetYaml %s", err)
@@ -56,60 +80,202 @@ func TestGetYaml(t *testing.T) {
 }
 
 func TestWatchJson(t *testing.T) {
-	c := getClient(t)
+	c, srv := getClient(t)
 
 	tmp := make(map[string]interface{})
+	notify := make(chan struct{}, 10)
 	err := c.WatchJson("tmp.json", &tmp, func() {
 		fmt.Println("watchJson", tmp)
+		notify <- struct{}{}
 	})
 	if err != nil {
-		t.Errorf("watchJson %s", err)
+		t.Fatalf("watchJson %s", err)
+	}
+	// 首次plan.Run的结果与初始值相同,也会回调一次
+	waitNotify(t, notify, "initial value")
+	waitNotify(t, notify, "first plan result")
+	if tmp["D"] != "5s" {
+		t.Errorf("watchJson %s", tmp["D"])
+	}
+
+	srv.Put(testPrefix+"/tmp.json", []byte(`{"D":"10s"}`))
+	waitNotify(t, notify, "updated value")
+	if tmp["D"] != "10s" {
+		t.Errorf("watchJson %s", tmp["D"])
+	}
+
+	// 非法的值不应该覆盖旧值
+	srv.Put(testPrefix+"/tmp.json", []byte(`{"D":`))
+	srv.Put(testPrefix+"/tmp.json", []byte(`{"D":"20s"}`))
+	waitNotify(t, notify, "value after invalid one")
+	if tmp["D"] != "20s" {
+		t.Errorf("watchJson %s", tmp["D"])
 	}
-	wait := make(chan struct{})
-	<-wait
 }
 
 func TestWatchYaml(t *testing.T) {
-	c := getClient(t)
+	c, srv := getClient(t)
 
 	tmp := make(map[string]interface{})
+	notify := make(chan struct{}, 10)
 	err := c.WatchYaml("tmp.yaml", &tmp, func() {
 		fmt.Println("watchYaml", tmp)
+		notify <- struct{}{}
 	})
 	if err != nil {
-		t.Errorf("watchYaml %s", err)
+		t.Fatalf("watchYaml %s", err)
 	}
+	waitNotify(t, notify, "initial yaml")
+	waitNotify(t, notify, "first plan result")
 
 	tp := ""
-
+	tpNotify := make(chan struct{}, 10)
 	err = c.WatchYaml("tmp", &tp, func() {
 		fmt.Println("watchYaml", tp)
+		tpNotify <- struct{}{}
 	})
 	if err != nil {
-		t.Errorf("watchYaml %s", err)
+		t.Fatalf("watchYaml %s", err)
+	}
+	waitNotify(t, tpNotify, "initial string")
+	waitNotify(t, tpNotify, "first plan result")
+	if tp != "hello" {
+		t.Errorf("watchYaml %s", tp)
+	}
+
+	srv.Put(testPrefix+"/tmp.yaml", []byte("D: 2s\n"))
+	waitNotify(t, notify, "updated yaml")
+	if tmp["D"] != "2s" {
+		t.Errorf("watchYaml %s", tmp["D"])
 	}
 
-	wait := make(chan struct{})
-	<-wait
+	srv.Put(testPrefix+"/tmp", []byte("world"))
+	waitNotify(t, tpNotify, "updated string")
+	if tp != "world" {
+		t.Errorf("watchYaml %s", tp)
+	}
 }
 
 func TestRegister(t *testing.T) {
-	c := getClient(t)
+	c, srv := getClient(t)
 	dreg, err := c.RegisterService("127.0.0.1", 80, consul.Conf{
 		Host:  "127.0.0.1",
 		Key:   "test",
 		Token: "teat",
 		Tag:   []string{"testTag"},
 		Meta:  nil,
-		TTL:   4,
+		TTL:   1,
 	})
 	if err != nil {
 		t.Fatalf("%s", err)
 	}
-	defer dreg()
 
-	time.Sleep(10 * time.Second)
-	proc.Shutdown()
+	serviceID := "test-127.0.0.1-80"
+	if _, ok := srv.Services()[serviceID]; !ok {
+		t.Fatalf("service %s not registered", serviceID)
+	}
+
+	// 超过一个TTL后,check仍然是passing说明续约正常
+	time.Sleep(1500 * time.Millisecond)
+	status, ok := srv.CheckStatus(serviceID)
+	if !ok || status != api.HealthPassing {
+		t.Errorf("check status %s %v", status, ok)
+	}
+
+	entries, _, err := c.GetClient().Health().Service("test", "testTag", true, nil)
+	if err != nil {
+		t.Fatalf("health service %s", err)
+	}
+	if len(entries) != 1 || entries[0].Service.Port != 80 {
+		t.Errorf("health service entries %+v", entries)
+	}
+
+	dreg()
+	if _, ok := srv.Services()[serviceID]; ok {
+		t.Errorf("service %s not deregistered", serviceID)
+	}
+	// 重复注销没有副作用
+	dreg()
+}
+
+func TestWatchStop(t *testing.T) {
+	c, srv := getClient(t)
+
+	tmp := make(map[string]interface{})
+	notify := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err := c.WatchJsonContext(ctx, "tmp.json", &tmp, func() {
+		notify <- struct{}{}
+	})
+	if err != nil {
+		t.Fatalf("WatchJsonContext %s", err)
+	}
+	waitNotify(t, notify, "initial value")
+	waitNotify(t, notify, "first plan result")
+
+	cancel()
+	select {
+	case <-w.Done():
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("timeout waiting watch stopped")
+	}
+	w.Stop()
+
+	srv.Put(testPrefix+"/tmp.json", []byte(`{"D":"10s"}`))
+	select {
+	case <-notify:
+		t.Errorf("callback after stop")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	_, err = c.WatchJsonContext(ctx, "tmp.json", &tmp, nil)
+	if err == nil {
+		t.Errorf("watch with canceled ctx should fail")
+	}
+}
+
+func TestClose(t *testing.T) {
+	c, srv := getClient(t)
+
+	tmp := make(map[string]interface{})
+	w, err := c.WatchJsonContext(context.Background(), "tmp.json", &tmp, nil)
+	if err != nil {
+		t.Fatalf("WatchJsonContext %s", err)
+	}
+	_, err = c.RegisterService("127.0.0.1", 81, consul.Conf{Key: "close", TTL: 1})
+	if err != nil {
+		t.Fatalf("RegisterService %s", err)
+	}
+	if len(srv.Services()) != 1 {
+		t.Fatalf("service not registered")
+	}
+
+	c.Close()
+	select {
+	case <-w.Done():
+	default:
+		t.Errorf("watch not stopped by Close")
+	}
+	if len(srv.Services()) != 0 {
+		t.Errorf("service not deregistered by Close")
+	}
+
+	if _, err := c.WatchJsonContext(context.Background(), "tmp.json", &tmp, nil); err == nil {
+		t.Errorf("watch after Close should fail")
+	}
+	if _, err := c.RegisterService("127.0.0.1", 81, consul.Conf{Key: "close"}); err != ErrClientClosed {
+		t.Errorf("register after Close %v", err)
+	}
+	c.Close()
+}
+
+func TestBackoff(t *testing.T) {
+	if d := backoff(1); d < watchMinBackoff || d > watchMinBackoff*5/4 {
+		t.Errorf("backoff(1) %s", d)
+	}
+	if d := backoff(100); d < watchMaxBackoff || d > watchMaxBackoff*5/4 {
+		t.Errorf("backoff(100) %s", d)
+	}
 }
 
 type tmp struct {
@@ -142,16 +308,81 @@ func (t *tmp) Key() string {
 }
 
 func TestLoader(t *testing.T) {
-	c := getClient(t)
+	c, srv := getClient(t)
 	l := NewLoader(c)
 	tp := tmp{}
+	notify := make(chan struct{}, 10)
 	l.Watch(&tp, func() {
 		fmt.Println("i'm watching.")
+		notify <- struct{}{}
 	})
-	go func() {
-		for err := range l.GetErrChan() {
-			fmt.Println("errChan:", err)
+
+	// 初始值D为5s,校验不通过
+	select {
+	case err := <-l.GetErrChan():
+		fmt.Println("errChan:", err)
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("timeout waiting validate error")
+	}
+	if l.Get(tp.Key()) != nil {
+		t.Errorf("invalid conf should not be stored")
+	}
+
+	srv.Put(testPrefix+"/tmp.json", []byte(`{"D":"1s","Check":{"Check":"a"},"Check1":{"Check":"b"}}`))
+	deadline := time.Now().Add(testWaitTimeout)
+	for l.Get(tp.Key()) == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting valid conf")
 		}
-	}()
-	select {}
+		waitNotify(t, notify, "loader callback")
+	}
+	if conf := l.Get(tp.Key()).(*tmp); conf.D != "1s" || conf.Check.Check != "a" {
+		t.Errorf("loader conf %+v", conf)
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	_, srv := getClient(t)
+	srv.SetACLToken("secret")
+
+	c, err := NewClient(srv.Addr(), WithPrefix(testPrefix))
+	if err != nil {
+		t.Fatalf("NewClient %s", err)
+	}
+	t.Cleanup(c.Close)
+	var tmp map[string]interface{}
+	if err := c.GetJson("tmp.json", &tmp); err == nil {
+		t.Errorf("GetJson without token should fail")
+	}
+
+	c, err = NewClient(srv.Addr(), WithPrefix(testPrefix), WithToken("secret"),
+		WithDatacenter("dc1"), WithHTTPTimeout(2*time.Second))
+	if err != nil {
+		t.Fatalf("NewClient %s", err)
+	}
+	t.Cleanup(c.Close)
+	if err := c.GetJson("tmp.json", &tmp); err != nil {
+		t.Errorf("GetJson %s", err)
+	}
+
+	// watch与KV使用相同的token,且阻塞查询时间小于http超时
+	notify := make(chan struct{}, 10)
+	err = c.WatchJson("tmp.json", &tmp, func() {
+		notify <- struct{}{}
+	})
+	if err != nil {
+		t.Fatalf("watchJson %s", err)
+	}
+	waitNotify(t, notify, "initial value")
+	waitNotify(t, notify, "first plan result")
+	srv.Put(testPrefix+"/tmp.json", []byte(`{"D":"10s"}`))
+	waitNotify(t, notify, "updated value")
+	if tmp["D"] != "10s" {
+		t.Errorf("watchJson %s", tmp["D"])
+	}
+
+	_, err = NewClient(srv.Addr(), WithHTTPTimeout(time.Second), WithWaitTime(time.Second))
+	if err == nil {
+		t.Errorf("wait time longer than http timeout should fail")
+	}
 }
@@ -0,0 +1,581 @@
+// Package consultest 提供进程内的consul HTTP替身,用于在go test中端到端地测试consul.Client
+//
+// 支持的接口:
+//   - KV: GET/PUT/DELETE /v1/kv/<key>,GET支持index/wait阻塞查询
+//   - Agent: 服务注册/注销,TTL check更新(/v1/agent/check/update|pass|warn|fail)
+//   - Health: GET /v1/health/service/<name>,支持passing/tag过滤和阻塞查询
+//   - ACL: 通过SetACLToken要求所有请求携带指定token
+package consultest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	nodeName       = "consultest"
+	defaultWait    = 5 * time.Minute
+	maxWait        = 10 * time.Minute
+	kvPath         = "/v1/kv/"
+	healthPath     = "/v1/health/service/"
+	registerPath   = "/v1/agent/service/register"
+	deregisterPath = "/v1/agent/service/deregister/"
+	checkPath      = "/v1/agent/check/"
+)
+
+type kvEntry struct {
+	value       []byte
+	flags       uint64
+	createIndex uint64
+	modifyIndex uint64
+}
+
+type check struct {
+	api.HealthCheck
+	ttl             time.Duration
+	deregisterAfter time.Duration
+	lastUpdate      time.Time
+	criticalSince   time.Time
+}
+
+type service struct {
+	api.AgentService
+	checks []*check
+}
+
+type Server struct {
+	mu        sync.Mutex
+	index     uint64
+	changed   chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	kv         map[string]*kvEntry
+	tombstones map[string]uint64
+	services   map[string]*service
+	now        func() time.Time
+	aclToken   string
+
+	httpServer *httptest.Server
+}
+
+// NewServer 启动一个监听本地随机端口的consul替身,用完需要调用Close
+func NewServer() *Server {
+	s := &Server{
+		index:      1,
+		changed:    make(chan struct{}),
+		closed:     make(chan struct{}),
+		kv:         make(map[string]*kvEntry),
+		tombstones: make(map[string]uint64),
+		services:   make(map[string]*service),
+		now:        time.Now,
+	}
+	s.httpServer = httptest.NewServer(s)
+	return s
+}
+
+// Addr 返回host:port,可直接传给consul.NewClient
+func (s *Server) Addr() string {
+	return strings.TrimPrefix(s.httpServer.URL, "http://")
+}
+
+// Close 释放所有阻塞查询并关闭http服务
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.httpServer.Close()
+	})
+}
+
+// SetNow 替换时钟,用于测试TTL过期等依赖时间的逻辑
+func (s *Server) SetNow(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// SetACLToken 设置后,未携带该token(X-Consul-Token头或token参数)的请求返回403
+func (s *Server) SetACLToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aclToken = token
+}
+
+// Put 写入key,会唤醒该key上的阻塞查询
+func (s *Server) Put(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putLocked(strings.TrimPrefix(key, "/"), value, 0)
+}
+
+// Get 读取key当前的值
+func (s *Server) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.kv[strings.TrimPrefix(key, "/")]
+	if !ok {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Delete 删除key
+func (s *Server) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteLocked(strings.TrimPrefix(key, "/"))
+}
+
+// Services 返回当前注册的服务,key为服务ID
+func (s *Server) Services() map[string]*api.AgentService {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reapLocked()
+	ret := make(map[string]*api.AgentService, len(s.services))
+	for id, svc := range s.services {
+		tmp := svc.AgentService
+		ret[id] = &tmp
+	}
+	return ret
+}
+
+// CheckStatus 返回check当前的状态(passing/warning/critical),TTL过期的check为critical
+func (s *Server) CheckStatus(checkID string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reapLocked()
+	for _, svc := range s.services {
+		for _, c := range svc.checks {
+			if c.CheckID == checkID {
+				return c.Status, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "Permission denied", http.StatusForbidden)
+		return
+	}
+
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, kvPath):
+		s.handleKV(w, r, strings.TrimPrefix(path, kvPath))
+	case strings.HasPrefix(path, healthPath):
+		s.handleHealth(w, r, strings.TrimPrefix(path, healthPath))
+	case path == registerPath:
+		s.handleRegister(w, r)
+	case strings.HasPrefix(path, deregisterPath):
+		s.handleDeregister(w, r, strings.TrimPrefix(path, deregisterPath))
+	case strings.HasPrefix(path, checkPath):
+		s.handleCheck(w, r, strings.TrimPrefix(path, checkPath))
+	case path == "/v1/agent/services":
+		s.handleAgentServices(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) handleKV(w http.ResponseWriter, r *http.Request, key string) {
+	switch r.Method {
+	case http.MethodGet:
+		var entry *kvEntry
+		index, ok := s.block(r, func() uint64 {
+			entry = s.kv[key]
+			if entry != nil {
+				return entry.modifyIndex
+			}
+			if idx, ok := s.tombstones[key]; ok {
+				return idx
+			}
+			return 1
+		})
+		if !ok {
+			return
+		}
+		writeMeta(w, index)
+		if entry == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeJSON(w, []*api.KVPair{{
+			Key:         key,
+			Value:       entry.value,
+			Flags:       entry.flags,
+			CreateIndex: entry.createIndex,
+			ModifyIndex: entry.modifyIndex,
+		}})
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var flags uint64
+		if f := r.URL.Query().Get("flags"); f != "" {
+			flags, _ = strconv.ParseUint(f, 10, 64)
+		}
+
+		s.mu.Lock()
+		if cas := r.URL.Query().Get("cas"); cas != "" {
+			casIndex, _ := strconv.ParseUint(cas, 10, 64)
+			entry := s.kv[key]
+			if (casIndex == 0 && entry != nil) || (casIndex != 0 && (entry == nil || entry.modifyIndex != casIndex)) {
+				s.mu.Unlock()
+				writeJSON(w, false)
+				return
+			}
+		}
+		s.putLocked(key, body, flags)
+		s.mu.Unlock()
+		writeJSON(w, true)
+	case http.MethodDelete:
+		s.mu.Lock()
+		s.deleteLocked(key)
+		s.mu.Unlock()
+		writeJSON(w, true)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	var reg api.AgentServiceRegistration
+	if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if reg.Name == "" {
+		http.Error(w, "Missing service name", http.StatusBadRequest)
+		return
+	}
+	if reg.ID == "" {
+		reg.ID = reg.Name
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := s.bumpLocked()
+	svc := &service{
+		AgentService: api.AgentService{
+			ID:          reg.ID,
+			Service:     reg.Name,
+			Tags:        reg.Tags,
+			Meta:        reg.Meta,
+			Port:        reg.Port,
+			Address:     reg.Address,
+			CreateIndex: idx,
+			ModifyIndex: idx,
+		},
+	}
+
+	checks := reg.Checks
+	if reg.Check != nil {
+		checks = append(checks, reg.Check)
+	}
+	now := s.now()
+	for i, def := range checks {
+		c := &check{
+			HealthCheck: api.HealthCheck{
+				Node:        nodeName,
+				CheckID:     def.CheckID,
+				Name:        def.Name,
+				Status:      def.Status,
+				ServiceID:   reg.ID,
+				ServiceName: reg.Name,
+				ServiceTags: reg.Tags,
+				Type:        "ttl",
+				CreateIndex: idx,
+				ModifyIndex: idx,
+			},
+			lastUpdate: now,
+		}
+		if c.CheckID == "" {
+			c.CheckID = "service:" + reg.ID
+			if len(checks) > 1 {
+				c.CheckID += ":" + strconv.Itoa(i+1)
+			}
+		}
+		if c.Status == "" {
+			c.Status = api.HealthCritical
+		}
+		if c.Status == api.HealthCritical {
+			c.criticalSince = now
+		}
+		c.ttl, _ = time.ParseDuration(def.TTL)
+		c.deregisterAfter, _ = time.ParseDuration(def.DeregisterCriticalServiceAfter)
+		svc.checks = append(svc.checks, c)
+	}
+	s.services[reg.ID] = svc
+}
+
+func (s *Server) handleDeregister(w http.ResponseWriter, r *http.Request, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.services[id]; !ok {
+		http.Error(w, "Unknown service ID "+id, http.StatusNotFound)
+		return
+	}
+	delete(s.services, id)
+	s.bumpLocked()
+}
+
+func (s *Server) handleCheck(w http.ResponseWriter, r *http.Request, rest string) {
+	parts := strings.SplitN(rest, "/", 2)
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	op, checkID := parts[0], parts[1]
+
+	var status, output string
+	switch op {
+	case "update":
+		var update struct {
+			Status string
+			Output string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		status, output = update.Status, update.Output
+	case "pass":
+		status, output = api.HealthPassing, r.URL.Query().Get("note")
+	case "warn":
+		status, output = api.HealthWarning, r.URL.Query().Get("note")
+	case "fail":
+		status, output = api.HealthCritical, r.URL.Query().Get("note")
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reapLocked()
+	for _, svc := range s.services {
+		for _, c := range svc.checks {
+			if c.CheckID != checkID {
+				continue
+			}
+			if c.ttl <= 0 {
+				http.Error(w, "check is not a TTL type", http.StatusInternalServerError)
+				return
+			}
+			now := s.now()
+			c.lastUpdate = now
+			c.Output = output
+			if c.Status != status {
+				c.Status = status
+				c.ModifyIndex = s.bumpLocked()
+			}
+			if status == api.HealthCritical {
+				if c.criticalSince.IsZero() {
+					c.criticalSince = now
+				}
+			} else {
+				c.criticalSince = time.Time{}
+			}
+			return
+		}
+	}
+	http.Error(w, "CheckID \""+checkID+"\" does not have associated TTL", http.StatusNotFound)
+}
+
+func (s *Server) handleAgentServices(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Services())
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request, name string) {
+	query := r.URL.Query()
+	_, passingOnly := query[api.HealthPassing]
+	tags := query["tag"]
+
+	var entries []*api.ServiceEntry
+	index, ok := s.block(r, func() uint64 {
+		s.reapLocked()
+		entries = entries[:0]
+		for _, svc := range s.services {
+			if svc.Service != name || !hasTags(svc.Tags, tags) {
+				continue
+			}
+			entry := &api.ServiceEntry{
+				Node: &api.Node{Node: nodeName, Address: "127.0.0.1"},
+			}
+			tmp := svc.AgentService
+			entry.Service = &tmp
+			passing := true
+			for _, c := range svc.checks {
+				hc := c.HealthCheck
+				entry.Checks = append(entry.Checks, &hc)
+				if c.Status != api.HealthPassing {
+					passing = false
+				}
+			}
+			if passingOnly && !passing {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		return s.index
+	})
+	if !ok {
+		return
+	}
+	writeMeta(w, index)
+	if entries == nil {
+		entries = []*api.ServiceEntry{}
+	}
+	writeJSON(w, entries)
+}
+
+// block 实现consul的阻塞查询:请求带index时,等到indexOf返回的值大于index或者wait超时
+// indexOf在持锁状态下调用
+func (s *Server) block(r *http.Request, indexOf func() uint64) (uint64, bool) {
+	query := r.URL.Query()
+	minIndex, _ := strconv.ParseUint(query.Get("index"), 10, 64)
+	wait := defaultWait
+	if w := query.Get("wait"); w != "" {
+		if d, err := time.ParseDuration(w); err == nil {
+			wait = d
+		}
+	}
+	if wait > maxWait {
+		wait = maxWait
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		index := indexOf()
+		changed := s.changed
+		s.mu.Unlock()
+
+		if minIndex == 0 || index > minIndex {
+			return index, true
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			s.mu.Lock()
+			index = indexOf()
+			s.mu.Unlock()
+			return index, true
+		case <-r.Context().Done():
+			return 0, false
+		case <-s.closed:
+			return 0, false
+		}
+	}
+}
+
+func (s *Server) putLocked(key string, value []byte, flags uint64) {
+	idx := s.bumpLocked()
+	entry, ok := s.kv[key]
+	if !ok {
+		entry = &kvEntry{createIndex: idx}
+		s.kv[key] = entry
+	}
+	entry.value = append([]byte(nil), value...)
+	entry.flags = flags
+	entry.modifyIndex = idx
+	delete(s.tombstones, key)
+}
+
+func (s *Server) deleteLocked(key string) {
+	if _, ok := s.kv[key]; !ok {
+		return
+	}
+	delete(s.kv, key)
+	s.tombstones[key] = s.bumpLocked()
+}
+
+// bumpLocked 推进raft index并唤醒所有阻塞查询
+func (s *Server) bumpLocked() uint64 {
+	s.index++
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return s.index
+}
+
+// reapLocked 把超过TTL未更新的check置为critical,并注销critical超过DeregisterCriticalServiceAfter的服务
+func (s *Server) reapLocked() {
+	now := s.now()
+	for id, svc := range s.services {
+		for _, c := range svc.checks {
+			if c.ttl > 0 && c.Status != api.HealthCritical && now.Sub(c.lastUpdate) > c.ttl {
+				c.Status = api.HealthCritical
+				c.Output = "TTL expired"
+				c.criticalSince = c.lastUpdate.Add(c.ttl)
+				c.ModifyIndex = s.bumpLocked()
+			}
+			if c.deregisterAfter > 0 && c.Status == api.HealthCritical &&
+				!c.criticalSince.IsZero() && now.Sub(c.criticalSince) > c.deregisterAfter {
+				delete(s.services, id)
+				s.bumpLocked()
+				break
+			}
+		}
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	s.mu.Lock()
+	aclToken := s.aclToken
+	s.mu.Unlock()
+	if aclToken == "" {
+		return true
+	}
+
+	token := r.Header.Get("X-Consul-Token")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+	}
+	return token == aclToken
+}
+
+func hasTags(have, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if h == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func writeMeta(w http.ResponseWriter, index uint64) {
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	w.Header().Set("X-Consul-LastContact", "0")
+	w.Header().Set("X-Consul-KnownLeader", "true")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,192 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/zeromicro/go-zero/core/logx"
+	"gopkg.in/yaml.v2"
+)
+
+// percentageBuckets 按万分比分桶,百分比最多支持两位小数
+const percentageBuckets = 10000
+
+// FlagRule 单个开关的规则,按以下顺序判断:
+//  1. On为false时关闭
+//  2. 设置了StartAt/EndAt时,只在[StartAt, EndAt)时间窗口内生效
+//  3. subject在AllowList中时打开
+//  4. 设置了Percentage时,按subject的hash稳定地放量(0-100)
+//  5. 只配置了AllowList时,名单外的subject关闭;否则打开
+type FlagRule struct {
+	On         bool       `json:"on" yaml:"on"`
+	Percentage *float64   `json:"percentage,omitempty" yaml:"percentage,omitempty"`
+	AllowList  []string   `json:"allowList,omitempty" yaml:"allowList,omitempty"`
+	StartAt    *time.Time `json:"startAt,omitempty" yaml:"startAt,omitempty"`
+	EndAt      *time.Time `json:"endAt,omitempty" yaml:"endAt,omitempty"`
+}
+
+// FlagDocument consul中存放的开关配置, 开关名=>规则
+type FlagDocument map[string]FlagRule
+
+type compiledFlag struct {
+	on         bool
+	buckets    int64 // <0 表示未设置Percentage
+	allow      map[string]struct{}
+	start, end time.Time
+}
+
+// FeatureFlags 基于consul watch的动态开关,Enabled只做原子读,不加锁
+type FeatureFlags struct {
+	key     string
+	flags   atomic.Value // map[string]*compiledFlag
+	now     func() time.Time
+	watcher *Watcher
+}
+
+// NewFeatureFlags watch key对应的开关配置, key以.yaml结尾时按yaml解析,否则按json解析
+// 新配置校验失败时会保留旧配置。不再使用时调用Stop,或由Client.Close统一停止
+func NewFeatureFlags(client *Client, key string) (*FeatureFlags, error) {
+	f := &FeatureFlags{
+		key: key,
+		now: time.Now,
+	}
+	f.flags.Store(map[string]*compiledFlag{})
+
+	var unmarshal Unmarshal = json.Unmarshal
+	if strings.HasSuffix(key, ".yaml") {
+		unmarshal = yaml.Unmarshal
+	}
+
+	doc := FlagDocument{}
+	watcher, err := client.watch(context.Background(), key, &doc, func() {
+		flags, err := compileFlags(doc)
+		if err != nil {
+			// 校验已在unmarshal中做过,这里不应该出错
+			logx.Errorw("compile feature flags", logx.Field("key", key), logx.Field("err", err))
+			return
+		}
+		f.flags.Store(flags)
+	}, func(data []byte, v interface{}) error {
+		if err := unmarshal(data, v); err != nil {
+			return err
+		}
+		_, err := compileFlags(*v.(*FlagDocument))
+		return err
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "watch")
+	}
+	f.watcher = watcher
+	return f, nil
+}
+
+// Stop 停止watch,之后Enabled使用最后一次加载的配置
+func (f *FeatureFlags) Stop() {
+	f.watcher.Stop()
+}
+
+// Enabled 判断开关对subject(一般是用户id)是否打开,未配置的开关视为关闭
+// ctx中通过WithFlagOverride设置的值优先
+func (f *FeatureFlags) Enabled(ctx context.Context, flag, subject string) bool {
+	if ctx != nil {
+		if overrides, ok := ctx.Value(flagOverrideKey{}).(map[string]bool); ok {
+			if enabled, ok := overrides[flag]; ok {
+				return enabled
+			}
+		}
+	}
+
+	c, ok := f.flags.Load().(map[string]*compiledFlag)[flag]
+	if !ok {
+		return false
+	}
+	return c.enabled(flag, subject, f.now())
+}
+
+func (c *compiledFlag) enabled(flag, subject string, now time.Time) bool {
+	if !c.on {
+		return false
+	}
+	if !c.start.IsZero() && now.Before(c.start) {
+		return false
+	}
+	if !c.end.IsZero() && !now.Before(c.end) {
+		return false
+	}
+	if _, ok := c.allow[subject]; ok {
+		return true
+	}
+	if c.buckets >= 0 {
+		if c.buckets >= percentageBuckets {
+			return true
+		}
+		if c.buckets == 0 || subject == "" {
+			return false
+		}
+		return bucketOf(flag, subject) < c.buckets
+	}
+	return len(c.allow) == 0
+}
+
+// bucketOf 带上开关名做hash,避免不同开关总是放量给同一批用户
+func bucketOf(flag, subject string) int64 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(flag))
+	_, _ = h.Write([]byte{':'})
+	_, _ = h.Write([]byte(subject))
+	return int64(h.Sum32() % percentageBuckets)
+}
+
+func compileFlags(doc FlagDocument) (map[string]*compiledFlag, error) {
+	flags := make(map[string]*compiledFlag, len(doc))
+	for name, rule := range doc {
+		c := &compiledFlag{
+			on:      rule.On,
+			buckets: -1,
+		}
+		if rule.Percentage != nil {
+			pct := *rule.Percentage
+			if pct < 0 || pct > 100 {
+				return nil, errors.Errorf("flag %s: percentage %v out of range [0, 100]", name, pct)
+			}
+			c.buckets = int64(pct * percentageBuckets / 100)
+		}
+		if len(rule.AllowList) > 0 {
+			c.allow = make(map[string]struct{}, len(rule.AllowList))
+			for _, subject := range rule.AllowList {
+				c.allow[subject] = struct{}{}
+			}
+		}
+		if rule.StartAt != nil {
+			c.start = *rule.StartAt
+		}
+		if rule.EndAt != nil {
+			c.end = *rule.EndAt
+		}
+		if !c.start.IsZero() && !c.end.IsZero() && !c.start.Before(c.end) {
+			return nil, errors.Errorf("flag %s: startAt must be before endAt", name)
+		}
+		flags[name] = c
+	}
+	return flags, nil
+}
+
+type flagOverrideKey struct{}
+
+// WithFlagOverride 在ctx上强制指定开关的值,用于测试、灰度排查等场景
+func WithFlagOverride(ctx context.Context, flag string, enabled bool) context.Context {
+	overrides := map[string]bool{flag: enabled}
+	if old, ok := ctx.Value(flagOverrideKey{}).(map[string]bool); ok {
+		for k, v := range old {
+			if k != flag {
+				overrides[k] = v
+			}
+		}
+	}
+	return context.WithValue(ctx, flagOverrideKey{}, overrides)
+}
@@ -0,0 +1,82 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeatureFlags(t *testing.T) {
+	c, srv := getClient(t)
+	srv.Put(testPrefix+"/flags.json", []byte(`{
+		"off": {"on": false, "allowList": ["u1"]},
+		"all": {"on": true},
+		"allow": {"on": true, "allowList": ["u1", "u2"]},
+		"half": {"on": true, "percentage": 50, "allowList": ["vip"]},
+		"window": {"on": true, "startAt": "2026-01-01T00:00:00Z", "endAt": "2026-02-01T00:00:00Z"}
+	}`))
+
+	f, err := NewFeatureFlags(c, "flags.json")
+	if err != nil {
+		t.Fatalf("NewFeatureFlags %s", err)
+	}
+	f.now = func() time.Time { return time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC) }
+	ctx := context.Background()
+
+	assert.False(t, f.Enabled(ctx, "off", "u1"))
+	assert.False(t, f.Enabled(ctx, "not-exist", "u1"))
+	assert.True(t, f.Enabled(ctx, "all", "anyone"))
+	assert.True(t, f.Enabled(ctx, "allow", "u2"))
+	assert.False(t, f.Enabled(ctx, "allow", "u3"))
+	assert.True(t, f.Enabled(ctx, "half", "vip"))
+	assert.True(t, f.Enabled(ctx, "window", "u1"))
+
+	f.now = func() time.Time { return time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC) }
+	assert.False(t, f.Enabled(ctx, "window", "u1"))
+
+	// 放量稳定且比例大致正确
+	hit := 0
+	for i := 0; i < 10000; i++ {
+		subject := fmt.Sprintf("user-%d", i)
+		enabled := f.Enabled(ctx, "half", subject)
+		assert.Equal(t, enabled, f.Enabled(ctx, "half", subject))
+		if enabled {
+			hit++
+		}
+	}
+	assert.InDelta(t, 5000, hit, 300)
+
+	assert.True(t, f.Enabled(WithFlagOverride(ctx, "off", true), "off", "u1"))
+	assert.False(t, f.Enabled(WithFlagOverride(ctx, "all", false), "all", "u1"))
+}
+
+func TestFeatureFlagsWatch(t *testing.T) {
+	c, srv := getClient(t)
+	srv.Put(testPrefix+"/flags.yaml", []byte("f1:\n  on: false\n"))
+
+	f, err := NewFeatureFlags(c, "flags.yaml")
+	if err != nil {
+		t.Fatalf("NewFeatureFlags %s", err)
+	}
+	ctx := context.Background()
+	assert.False(t, f.Enabled(ctx, "f1", "u1"))
+
+	// 非法配置不生效
+	srv.Put(testPrefix+"/flags.yaml", []byte("f1:\n  on: true\n  percentage: 101\n"))
+	srv.Put(testPrefix+"/flags.yaml", []byte("f1:\n  on: true\n"))
+
+	deadline := time.Now().Add(testWaitTimeout)
+	for !f.Enabled(ctx, "f1", "u1") {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting flag update")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	srv.Put(testPrefix+"/bad.json", []byte(`{"f1": {"on": true, "percentage": -1}}`))
+	_, err = NewFeatureFlags(c, "bad.json")
+	assert.Error(t, err)
+}
@@ -1,6 +1,7 @@
 package consul
 
 import (
+	"context"
 	"fmt"
 	"github.com/creasty/defaults"
 	"github.com/go-playground/validator"
@@ -42,22 +43,27 @@ func (l *Loader) SetUpdateCallback(updateCallback func(val Conf)) {
 
 // callback是watch到新对象时的回调方法
 func (l *Loader) Watch(conf Conf, callback func()) {
+	_, err := l.WatchContext(context.Background(), conf, callback)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// WatchContext 与Watch相同,但出错时返回error而不是panic,ctx取消或Watcher.Stop后停止watch
+func (l *Loader) WatchContext(ctx context.Context, conf Conf, callback func()) (*Watcher, error) {
 	key := conf.Key()
-	var watchFunc func(key string, obj interface{}, callback func()) error
+	var watchFunc func(ctx context.Context, key string, obj interface{}, callback func()) (*Watcher, error)
 	if strings.HasSuffix(key, ".yaml") {
-		watchFunc = l.client.WatchYaml
+		watchFunc = l.client.WatchYamlContext
 	} else {
-		watchFunc = l.client.WatchJson
+		watchFunc = l.client.WatchJsonContext
 	}
-	err := watchFunc(conf.Key(), conf, func() {
+	return watchFunc(ctx, conf.Key(), conf, func() {
 		l.add(conf)
 		if callback != nil {
 			callback()
 		}
 	})
-	if err != nil {
-		panic(err)
-	}
 }
 
 func (l *Loader) add(val Conf) {
@@ -1,8 +1,10 @@
 package consul
 
 import (
+	"github.com/hashicorp/consul/api"
 	"github.com/pkg/errors"
 	"strings"
+	"time"
 )
 
 type WithOption func(*option) error
@@ -19,9 +21,90 @@ func WithPrefix(prefix string) WithOption {
 	}
 }
 
+// WithKeyProvider 设置解密ENC[aes-gcm:...]配置值所用的密钥来源
+func WithKeyProvider(provider KeyProvider) WithOption {
+	return func(o *option) error {
+		if provider == nil {
+			return errors.New("nil key provider")
+		}
+		o.keyProvider = provider
+		return nil
+	}
+}
+
+// WithToken 设置ACL token,KV读写、服务注册和watch都会使用
+func WithToken(token string) WithOption {
+	return func(o *option) error {
+		o.token = token
+		return nil
+	}
+}
+
+// WithTLS 使用https访问consul
+func WithTLS(tlsConfig api.TLSConfig) WithOption {
+	return func(o *option) error {
+		o.tlsConfig = &tlsConfig
+		return nil
+	}
+}
+
+// WithDatacenter 指定数据中心,默认使用agent所在的数据中心
+func WithDatacenter(dc string) WithOption {
+	return func(o *option) error {
+		o.datacenter = dc
+		return nil
+	}
+}
+
+// WithNamespace 指定namespace(consul企业版)
+func WithNamespace(namespace string) WithOption {
+	return func(o *option) error {
+		o.namespace = namespace
+		return nil
+	}
+}
+
+// WithPartition 指定admin partition(consul企业版)
+func WithPartition(partition string) WithOption {
+	return func(o *option) error {
+		o.partition = partition
+		return nil
+	}
+}
+
+// WithHTTPTimeout 单次http请求的超时时间。未设置WithWaitTime时,阻塞查询的等待时间为timeout的一半
+func WithHTTPTimeout(timeout time.Duration) WithOption {
+	return func(o *option) error {
+		if timeout <= 0 {
+			return errors.New("http timeout must be positive")
+		}
+		o.httpTimeout = timeout
+		return nil
+	}
+}
+
+// WithWaitTime watch等阻塞查询单次最长等待时间,必须小于http超时时间
+func WithWaitTime(waitTime time.Duration) WithOption {
+	return func(o *option) error {
+		if waitTime <= 0 {
+			return errors.New("wait time must be positive")
+		}
+		o.waitTime = waitTime
+		return nil
+	}
+}
+
 type option struct {
-	keyPrefix string
-	address   string
+	keyPrefix   string
+	address     string
+	keyProvider KeyProvider
+	token       string
+	tlsConfig   *api.TLSConfig
+	datacenter  string
+	namespace   string
+	partition   string
+	httpTimeout time.Duration
+	waitTime    time.Duration
 }
 
 func newOption(opts ...WithOption) (option, error) {
@@ -32,5 +115,42 @@ func newOption(opts ...WithOption) (option, error) {
 			return option{}, errors.WithMessage(err, "setOption")
 		}
 	}
+
+	if clientOpt.httpTimeout > 0 {
+		if clientOpt.waitTime == 0 {
+			clientOpt.waitTime = clientOpt.httpTimeout / 2
+		}
+		// consul会在wait上再加最多wait/16的随机抖动
+		if clientOpt.waitTime+clientOpt.waitTime/16 >= clientOpt.httpTimeout {
+			return option{}, errors.Errorf("wait time %s too long for http timeout %s",
+				clientOpt.waitTime, clientOpt.httpTimeout)
+		}
+	}
 	return clientOpt, nil
 }
+
+func (o option) apiConfig(addr string) (*api.Config, error) {
+	conf := &api.Config{
+		Address:    addr,
+		Token:      o.token,
+		Datacenter: o.datacenter,
+		Namespace:  o.namespace,
+		Partition:  o.partition,
+		WaitTime:   o.waitTime,
+	}
+
+	if o.tlsConfig != nil {
+		conf.Scheme = "https"
+		conf.TLSConfig = *o.tlsConfig
+	}
+
+	if o.httpTimeout > 0 {
+		httpClient, err := api.NewHttpClient(api.DefaultConfig().Transport, conf.TLSConfig)
+		if err != nil {
+			return nil, errors.WithMessage(err, "api.NewHttpClient")
+		}
+		httpClient.Timeout = o.httpTimeout
+		conf.HttpClient = httpClient
+	}
+	return conf, nil
+}
@@ -0,0 +1,215 @@
+package consul
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	secretPrefix = "ENC[aes-gcm:"
+	secretSuffix = "]"
+)
+
+var (
+	ErrNoKeyProvider  = errors.New("encrypted value found but no key provider configured")
+	ErrInvalidSecret  = errors.New("invalid encrypted value")
+	ErrEmptySecretKey = errors.New("empty secret key")
+)
+
+// KeyProvider 提供解密配置用的AES密钥(16/24/32字节)
+type KeyProvider interface {
+	Key() ([]byte, error)
+}
+
+type KeyProviderFunc func() ([]byte, error)
+
+func (f KeyProviderFunc) Key() ([]byte, error) {
+	return f()
+}
+
+// FileKeyProvider 从文件读取base64编码的密钥,首尾空白会被忽略
+func FileKeyProvider(path string) KeyProvider {
+	return KeyProviderFunc(func() ([]byte, error) {
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.WithMessage(err, "read key file")
+		}
+		return decodeKey(string(bytes))
+	})
+}
+
+// EnvKeyProvider 从环境变量读取base64编码的密钥
+func EnvKeyProvider(name string) KeyProvider {
+	return KeyProviderFunc(func() ([]byte, error) {
+		return decodeKey(os.Getenv(name))
+	})
+}
+
+// StaticKeyProvider 直接使用给定的密钥,一般用于测试或密钥来自其他系统的场景
+func StaticKeyProvider(key []byte) KeyProvider {
+	return KeyProviderFunc(func() ([]byte, error) {
+		if len(key) == 0 {
+			return nil, ErrEmptySecretKey
+		}
+		return key, nil
+	})
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, ErrEmptySecretKey
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.WithMessage(err, "base64 decode key")
+	}
+	return key, nil
+}
+
+// IsEncrypted 判断是否为ENC[aes-gcm:...]格式的加密值
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, secretPrefix) && strings.HasSuffix(value, secretSuffix)
+}
+
+// EncryptValue 加密明文,返回可直接写入consul配置的ENC[aes-gcm:...]字符串
+func EncryptValue(provider KeyProvider, plaintext string) (string, error) {
+	aead, err := newAEAD(provider)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errors.WithMessage(err, "generate nonce")
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return secretPrefix + base64.StdEncoding.EncodeToString(sealed) + secretSuffix, nil
+}
+
+// DecryptValue 解密ENC[aes-gcm:...]字符串
+func DecryptValue(provider KeyProvider, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return "", ErrInvalidSecret
+	}
+
+	aead, err := newAEAD(provider)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := strings.TrimSuffix(strings.TrimPrefix(value, secretPrefix), secretSuffix)
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", errors.WithMessage(ErrInvalidSecret, err.Error())
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", ErrInvalidSecret
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.WithMessage(ErrInvalidSecret, err.Error())
+	}
+	return string(plaintext), nil
+}
+
+func newAEAD(provider KeyProvider) (cipher.AEAD, error) {
+	if provider == nil {
+		return nil, ErrNoKeyProvider
+	}
+
+	key, err := provider.Key()
+	if err != nil {
+		return nil, errors.WithMessage(err, "provider.Key")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.WithMessage(err, "aes.NewCipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.WithMessage(err, "cipher.NewGCM")
+	}
+	return aead, nil
+}
+
+// decryptSecrets 递归遍历unmarshal后的对象,把所有加密的字符串替换成明文
+func decryptSecrets(obj interface{}, provider KeyProvider) error {
+	return decryptValue(reflect.ValueOf(obj), provider)
+}
+
+func decryptValue(v reflect.Value, provider KeyProvider) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return decryptValue(v.Elem(), provider)
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		// interface里的值不可寻址,拷贝一份处理后再放回去
+		elem := v.Elem()
+		tmp := reflect.New(elem.Type()).Elem()
+		tmp.Set(elem)
+		if err := decryptValue(tmp, provider); err != nil {
+			return err
+		}
+		if v.CanSet() {
+			v.Set(tmp)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			if err := decryptValue(v.Field(i), provider); err != nil {
+				return errors.WithMessage(err, t.Field(i).Name)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := decryptValue(v.Index(i), provider); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			elem := v.MapIndex(k)
+			tmp := reflect.New(elem.Type()).Elem()
+			tmp.Set(elem)
+			if err := decryptValue(tmp, provider); err != nil {
+				return errors.WithMessagef(err, "%v", k.Interface())
+			}
+			v.SetMapIndex(k, tmp)
+		}
+	case reflect.String:
+		if !IsEncrypted(v.String()) {
+			return nil
+		}
+		plaintext, err := DecryptValue(provider, v.String())
+		if err != nil {
+			return err
+		}
+		if v.CanSet() {
+			v.SetString(plaintext)
+		}
+	}
+	return nil
+}
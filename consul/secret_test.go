@@ -0,0 +1,116 @@
+package consul
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+var testSecretKey = []byte("0123456789abcdef0123456789abcdef")
+
+type secretConf struct {
+	User     string                 `json:"user" yaml:"user"`
+	Password string                 `json:"password" yaml:"password"`
+	Replicas []*secretReplica       `json:"replicas" yaml:"replicas"`
+	Extra    map[string]interface{} `json:"extra" yaml:"extra"`
+}
+
+type secretReplica struct {
+	Addr     string `json:"addr" yaml:"addr"`
+	Password string `json:"password" yaml:"password"`
+}
+
+func TestEncryptDecryptValue(t *testing.T) {
+	provider := StaticKeyProvider(testSecretKey)
+	enc, err := EncryptValue(provider, "p@ss\"word")
+	assert.NoError(t, err)
+	assert.True(t, IsEncrypted(enc))
+
+	plain, err := DecryptValue(provider, enc)
+	assert.NoError(t, err)
+	assert.Equal(t, "p@ss\"word", plain)
+
+	_, err = DecryptValue(StaticKeyProvider([]byte("fedcba9876543210fedcba9876543210")), enc)
+	assert.Error(t, err)
+
+	_, err = DecryptValue(nil, enc)
+	assert.Equal(t, ErrNoKeyProvider, err)
+
+	_, err = DecryptValue(provider, "ENC[aes-gcm:!!!]")
+	assert.Error(t, err)
+}
+
+func TestKeyProviders(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(testSecretKey)
+
+	os.Setenv("SDK_TEST_CONSUL_KEY", encoded)
+	defer os.Unsetenv("SDK_TEST_CONSUL_KEY")
+	key, err := EnvKeyProvider("SDK_TEST_CONSUL_KEY").Key()
+	assert.NoError(t, err)
+	assert.Equal(t, testSecretKey, key)
+
+	_, err = EnvKeyProvider("SDK_TEST_CONSUL_KEY_NOT_EXIST").Key()
+	assert.Equal(t, ErrEmptySecretKey, err)
+
+	dir, err := ioutil.TempDir("", "consul-secret")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "key")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(encoded+"\n"), 0600))
+	key, err = FileKeyProvider(path).Key()
+	assert.NoError(t, err)
+	assert.Equal(t, testSecretKey, key)
+}
+
+func TestDecryptSecrets(t *testing.T) {
+	provider := StaticKeyProvider(testSecretKey)
+	encrypt := func(s string) string {
+		enc, err := EncryptValue(provider, s)
+		assert.NoError(t, err)
+		return enc
+	}
+
+	raw := map[string]interface{}{
+		"user":     "root",
+		"password": encrypt("root-pass"),
+		"replicas": []map[string]string{
+			{"addr": "a", "password": encrypt("a-pass")},
+		},
+		"extra": map[string]interface{}{
+			"token": encrypt("tk"),
+			"list":  []interface{}{encrypt("l0"), "plain"},
+		},
+	}
+
+	jsonBytes, err := json.Marshal(raw)
+	assert.NoError(t, err)
+	yamlBytes, err := yaml.Marshal(raw)
+	assert.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		data      []byte
+		unmarshal Unmarshal
+	}{
+		"json": {jsonBytes, json.Unmarshal},
+		"yaml": {yamlBytes, yaml.Unmarshal},
+	} {
+		conf := secretConf{}
+		assert.NoError(t, tc.unmarshal(tc.data, &conf), name)
+		assert.NoError(t, decryptSecrets(&conf, provider), name)
+
+		assert.Equal(t, "root", conf.User, name)
+		assert.Equal(t, "root-pass", conf.Password, name)
+		assert.Equal(t, "a-pass", conf.Replicas[0].Password, name)
+		assert.Equal(t, "tk", conf.Extra["token"], name)
+		assert.Equal(t, []interface{}{"l0", "plain"}, conf.Extra["list"], name)
+	}
+
+	conf := secretConf{Password: encrypt("x")}
+	assert.Error(t, decryptSecrets(&conf, nil))
+}
@@ -0,0 +1,177 @@
+package consul
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api/watch"
+	"github.com/pkg/errors"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+const (
+	watchMinBackoff = time.Second
+	watchMaxBackoff = 30 * time.Second
+)
+
+var ErrClientClosed = errors.New("consul client closed")
+
+// Watcher 单个watch的句柄,Stop后不会再触发回调
+type Watcher struct {
+	key     string
+	cancel  context.CancelFunc
+	done    chan struct{}
+	newPlan func() (*watch.Plan, error)
+}
+
+// Stop 停止watch并等待后台goroutine退出,可重复调用
+func (w *Watcher) Stop() {
+	w.cancel()
+	<-w.done
+}
+
+// Done watch彻底退出后关闭
+func (w *Watcher) Done() <-chan struct{} {
+	return w.done
+}
+
+// run 循环执行plan,plan非预期退出时按指数退避重启,直到ctx取消
+func (w *Watcher) run(ctx context.Context, c *Client) {
+	defer close(w.done)
+	defer c.removeWatcher(w)
+
+	keyField := logx.Field("key", w.key)
+	failures := 0
+	for {
+		start := time.Now()
+		err := w.runPlan(ctx, c)
+		if ctx.Err() != nil {
+			logx.Infow("consul watch stopped", keyField)
+			return
+		}
+
+		// 运行足够久后退出的,认为是偶发问题,重新从最小间隔开始
+		if time.Since(start) > watchMaxBackoff {
+			failures = 0
+		}
+		failures++
+		retry := backoff(failures)
+		logx.Errorw("consul watch exited unexpectedly", keyField,
+			logx.Field("err", err), logx.Field("retry", retry.String()))
+
+		timer := time.NewTimer(retry)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			logx.Infow("consul watch stopped", keyField)
+			return
+		}
+	}
+}
+
+func (w *Watcher) runPlan(ctx context.Context, c *Client) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("panic: %v", r)
+		}
+	}()
+
+	plan, err := w.newPlan()
+	if err != nil {
+		return errors.WithMessage(err, "newPlan")
+	}
+
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		select {
+		case <-ctx.Done():
+			plan.Stop()
+		case <-exited:
+		}
+	}()
+
+	// 复用c.client,token/tls/datacenter等配置与KV读写保持一致
+	err = plan.RunWithClientAndHclog(c.client, nil)
+	if err == nil && !plan.IsStopped() {
+		err = errors.New("plan exited")
+	}
+	return err
+}
+
+func backoff(failures int) time.Duration {
+	retry := watchMinBackoff
+	for i := 1; i < failures && retry < watchMaxBackoff; i++ {
+		retry *= 2
+	}
+	if retry > watchMaxBackoff {
+		retry = watchMaxBackoff
+	}
+	// 加上最多1/4的抖动,避免大量实例同时重连
+	return retry + time.Duration(rand.Int63n(int64(retry/4)+1))
+}
+
+// startWatcher 启动watch并登记到client上,Client.Close时统一停止
+// cancel用于取消ctx,Stop时调用
+func (c *Client) startWatcher(ctx context.Context, cancel context.CancelFunc, key string,
+	newPlan func() (*watch.Plan, error)) (*Watcher, error) {
+	w := &Watcher{
+		key:     key,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		newPlan: newPlan,
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		cancel()
+		return nil, ErrClientClosed
+	}
+	c.watchers[w] = struct{}{}
+	c.mu.Unlock()
+
+	go w.run(ctx, c)
+	return w, nil
+}
+
+func (c *Client) removeWatcher(w *Watcher) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.watchers, w)
+}
+
+// Close 停止所有watch并注销通过RegisterService注册的服务,之后不能再watch或注册
+func (c *Client) Close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	watchers := make([]*Watcher, 0, len(c.watchers))
+	for w := range c.watchers {
+		watchers = append(watchers, w)
+	}
+	deregisters := make([]func(), 0, len(c.registrations))
+	for _, deregister := range c.registrations {
+		deregisters = append(deregisters, deregister)
+	}
+	c.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, w := range watchers {
+		wg.Add(1)
+		go func(w *Watcher) {
+			defer wg.Done()
+			w.Stop()
+		}(w)
+	}
+	for _, deregister := range deregisters {
+		deregister()
+	}
+	wg.Wait()
+}
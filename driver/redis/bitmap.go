@@ -0,0 +1,98 @@
+package redis
+
+import (
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// SetBit 返回offset原来的值
+func (w *Wrapper) SetBit(key string, offset int64, value bool) (old bool, err error) {
+	bit := 0
+	if value {
+		bit = 1
+	}
+	w.Wrap(func(conn redigo.Conn) {
+		old, err = replyBool(w.do(conn, "SETBIT", w.WithPrefix(key), offset, bit))
+	})
+	return
+}
+
+func (w *Wrapper) GetBit(key string, offset int64) (value bool, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		value, err = replyBool(w.do(conn, "GETBIT", w.WithPrefix(key), offset))
+	})
+	return
+}
+
+// BitCount 值为1的位数
+func (w *Wrapper) BitCount(key string) (n int64, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt64(w.do(conn, "BITCOUNT", w.WithPrefix(key)))
+	})
+	return
+}
+
+// BitCountRange 第start到end个字节(可以为负数)中值为1的位数
+func (w *Wrapper) BitCountRange(key string, start, end int64) (n int64, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt64(w.do(conn, "BITCOUNT", w.WithPrefix(key), start, end))
+	})
+	return
+}
+
+// BitPos 第一个值为bit的位置,找不到时返回-1
+func (w *Wrapper) BitPos(key string, bit bool) (pos int64, err error) {
+	b := 0
+	if bit {
+		b = 1
+	}
+	w.Wrap(func(conn redigo.Conn) {
+		pos, err = replyInt64(w.do(conn, "BITPOS", w.WithPrefix(key), b))
+	})
+	return
+}
+
+// BitOp op为AND/OR/XOR/NOT, 结果写入dst, 返回dst的字节数; cluster模式下keys需要在同一slot
+func (w *Wrapper) BitOp(op, dst string, keys ...string) (n int64, err error) {
+	if len(keys) == 0 {
+		return 0, errors.New("invalid keys num")
+	}
+	args := append([]interface{}{op, w.WithPrefix(dst)}, w.prefixKeys(keys)...)
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt64(w.do(conn, "BITOP", args...))
+	})
+	return
+}
+
+// PFAdd 加入HyperLogLog, 基数估计值有变化时返回true
+func (w *Wrapper) PFAdd(key string, elements ...interface{}) (changed bool, err error) {
+	if len(elements) == 0 {
+		return false, errors.New("invalid elements num")
+	}
+	w.Wrap(func(conn redigo.Conn) {
+		changed, err = replyBool(w.do(conn, "PFADD", w.keyArgs(key, elements...)...))
+	})
+	return
+}
+
+// PFCount 多个key时返回并集的基数估计值, 误差约0.81%
+func (w *Wrapper) PFCount(keys ...string) (n int64, err error) {
+	if len(keys) == 0 {
+		return 0, errors.New("invalid keys num")
+	}
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt64(w.do(conn, "PFCOUNT", w.prefixKeys(keys)...))
+	})
+	return
+}
+
+// PFMerge 合并keys写入dst
+func (w *Wrapper) PFMerge(dst string, keys ...string) (err error) {
+	if len(keys) == 0 {
+		return errors.New("invalid keys num")
+	}
+	w.Wrap(func(conn redigo.Conn) {
+		_, err = w.do(conn, "PFMERGE", w.keyArgs(dst, w.prefixKeys(keys)...)...)
+	})
+	return
+}
@@ -0,0 +1,89 @@
+package redis
+
+import (
+	"hash/fnv"
+	"math"
+
+	"github.com/pkg/errors"
+	"github.com/ziyoumeng/sdk/util"
+)
+
+// redis string最大512MB
+const maxBloomBits = 512 * 1024 * 1024 * 8
+
+// BloomFilter 基于SETBIT/GETBIT实现的布隆过滤器,不依赖RedisBloom模块。
+// 不支持删除, 判断存在时有fpRate的误判率, 判断不存在时一定不存在
+type BloomFilter struct {
+	w      *Wrapper
+	key    string
+	bits   uint64
+	hashes int
+}
+
+// NewBloomFilter expectedItems为预计元素个数, fpRate为期望的误判率
+func NewBloomFilter(w *Wrapper, key string, expectedItems uint64, fpRate float64) (*BloomFilter, error) {
+	if expectedItems == 0 {
+		return nil, errors.New("expectedItems must be positive")
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		return nil, errors.New("fpRate must be in (0, 1)")
+	}
+
+	n := float64(expectedItems)
+	bits := math.Ceil(-n * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	if bits > maxBloomBits {
+		return nil, errors.Errorf("bloom filter needs %.0f bits, exceeds redis string limit", bits)
+	}
+	hashes := int(math.Round(bits / n * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &BloomFilter{
+		w:      w,
+		key:    key,
+		bits:   uint64(bits),
+		hashes: hashes,
+	}, nil
+}
+
+// Add 加入元素, 所有位在一个pipeline里设置
+func (b *BloomFilter) Add(item interface{}) error {
+	p := b.w.Pipeline()
+	for _, offset := range b.offsets(item) {
+		p.Do("SETBIT", b.key, offset, 1)
+	}
+	return errors.WithMessage(p.Exec(), "setbit")
+}
+
+// Exists 返回false时元素一定不存在
+func (b *BloomFilter) Exists(item interface{}) (bool, error) {
+	p := b.w.Pipeline()
+	offsets := b.offsets(item)
+	results := make([]*Result, len(offsets))
+	for i, offset := range offsets {
+		results[i] = p.Do("GETBIT", b.key, offset)
+	}
+	if err := p.Exec(); err != nil {
+		return false, errors.WithMessage(err, "getbit")
+	}
+	for _, r := range results {
+		if bit, _ := r.Int(); bit == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// offsets 双重哈希: h1 + i*h2
+func (b *BloomFilter) offsets(item interface{}) []uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(util.ToStr(item)))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+
+	offsets := make([]uint64, b.hashes)
+	for i := range offsets {
+		offsets[i] = (h1 + uint64(i)*h2) % b.bits
+	}
+	return offsets
+}
@@ -1,8 +1,16 @@
 package redis
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
 	"github.com/pkg/errors"
+	"github.com/zeromicro/go-zero/core/syncx"
 	"github.com/ziyoumeng/sdk/util"
 )
 
@@ -14,6 +22,12 @@ type Persist interface {
 	GetEmpty() CacheValue
 }
 
+// BatchPersist Persist可以选择实现, GetMany缓存未命中的id一次查询
+type BatchPersist interface {
+	// GetMany 返回存在的记录, 顺序任意
+	GetMany(ids []interface{}) ([]CacheValue, error)
+}
+
 type CacheValue interface {
 	GetID() interface{}
 	ExpiredSecond() int64
@@ -22,16 +36,194 @@ type CacheValue interface {
 	Unmarshal([]byte) error
 }
 
+var (
+	// ErrCacheNotFound 开启WithCacheNotFoundError后, id不存在时返回,实现了code.NotFound
+	ErrCacheNotFound error = cacheNotFound{}
+
+	// 负缓存写入的哨兵值
+	negativeCacheValue = []byte("\x00cache:not_found")
+)
+
+type cacheNotFound struct{}
+
+func (cacheNotFound) NotFound() {}
+
+func (cacheNotFound) Error() string {
+	return "cache: not found"
+}
+
+const (
+	defaultCacheTTLJitter   = 0.1
+	defaultCacheEarlyBeta   = 1.0
+	defaultRebuildLockTTL   = 5 * time.Second
+	rebuildLockPollInterval = 20 * time.Millisecond
+)
+
+type cacheAsideOption struct {
+	ttlJitter float64
+
+	rebuildLock     bool
+	rebuildLockWait time.Duration
+
+	earlyRefresh bool
+	earlyBeta    float64
+
+	negativeTTL time.Duration
+	notFoundErr bool
+	bloom       *BloomFilter
+
+	localSize int
+	localTTL  time.Duration
+
+	writeBehindWorkers   int
+	writeBehindQueueSize int
+	writeBehindAttempts  int
+}
+
+type CacheAsideOption func(o *cacheAsideOption)
+
+// WithCacheTTLJitter 写缓存时过期时间随机增加[0, jitter*ttl],避免批量写入的key同时过期,默认0.1
+func WithCacheTTLJitter(jitter float64) CacheAsideOption {
+	return func(o *cacheAsideOption) {
+		o.ttlJitter = jitter
+	}
+}
+
+// WithCacheRebuildLock 未命中时先抢redis锁,只有一个副本回源,
+// 其他副本最多等待wait后重新读缓存,等不到再自己回源
+func WithCacheRebuildLock(wait time.Duration) CacheAsideOption {
+	return func(o *cacheAsideOption) {
+		o.rebuildLock = true
+		o.rebuildLockWait = wait
+	}
+}
+
+// WithCacheEarlyRefresh 命中时按剩余过期时间和回源耗时概率性地提前在后台刷新(XFetch),
+// beta越大越早刷新,默认1
+func WithCacheEarlyRefresh(beta float64) CacheAsideOption {
+	return func(o *cacheAsideOption) {
+		o.earlyRefresh = true
+		if beta > 0 {
+			o.earlyBeta = beta
+		}
+	}
+}
+
+// WithCacheNegative id不存在时缓存哨兵值ttl时间,避免不存在的id每次都查库,ttl不足1秒按1秒
+func WithCacheNegative(ttl time.Duration) CacheAsideOption {
+	return func(o *cacheAsideOption) {
+		o.negativeTTL = ttl
+	}
+}
+
+// WithCacheNotFoundError id不存在时Get返回ErrCacheNotFound而不是nil, nil
+func WithCacheNotFoundError() CacheAsideOption {
+	return func(o *cacheAsideOption) {
+		o.notFoundErr = true
+	}
+}
+
+// WithCacheBloomFilter Get前先查布隆过滤器,一定不存在的id不再查缓存和库。
+// Set新建的id会自动加入, 已有的id需要业务预先Add
+func WithCacheBloomFilter(bloom *BloomFilter) CacheAsideOption {
+	return func(o *cacheAsideOption) {
+		o.bloom = bloom
+	}
+}
+
+// WithCacheLocal 在redis前加一层进程内的LRU缓存,最多size个key,每个key最多缓存ttl。
+// Set/Del时通过Pub/Sub通知所有副本删除本地缓存, 消息丢失时本地缓存最多旧ttl时间
+func WithCacheLocal(size int, ttl time.Duration) CacheAsideOption {
+	return func(o *cacheAsideOption) {
+		o.localSize = size
+		o.localTTL = ttl
+	}
+}
+
 type CacheAsidePattern struct {
 	persist Persist
 	cache   *Wrapper
+	option  cacheAsideOption
+	state   *cacheAsideState
+}
+
+// cacheAsideState 拷贝CacheAsidePattern时共享
+type cacheAsideState struct {
+	// 同一个key同时只有一个goroutine回源
+	flight syncx.SingleFlight
+	// 回源耗时的滑动平均(纳秒),用于提前刷新
+	rebuildNanos int64
+
+	local       *localCache
+	sub         *Subscription
+	writeBehind *writeBehind
 }
 
-func NewCacheAsidePattern(cache *Wrapper, persist Persist) CacheAsidePattern {
-	return CacheAsidePattern{
+func NewCacheAsidePattern(cache *Wrapper, persist Persist, opts ...CacheAsideOption) CacheAsidePattern {
+	option := cacheAsideOption{
+		ttlJitter: defaultCacheTTLJitter,
+		earlyBeta: defaultCacheEarlyBeta,
+	}
+	for _, opt := range opts {
+		opt(&option)
+	}
+	c := CacheAsidePattern{
 		persist: persist,
 		cache:   cache,
+		option:  option,
+		state:   &cacheAsideState{flight: syncx.NewSingleFlight()},
+	}
+	if option.localSize > 0 && option.localTTL > 0 {
+		c.state.local = newLocalCache(option.localSize, option.localTTL)
+		c.subscribeInvalidation()
 	}
+	if option.writeBehindWorkers > 0 {
+		if c.option.writeBehindAttempts <= 0 {
+			c.option.writeBehindAttempts = 1
+		}
+		c.startWriteBehind()
+	}
+	return c
+}
+
+// Close 等待write-behind队列写完,停止接收本地缓存的失效通知。
+// 没有开启WithCacheLocal和WithCacheWriteBehind时不需要调用
+func (c CacheAsidePattern) Close() {
+	if c.state.writeBehind != nil {
+		c.state.writeBehind.close()
+	}
+	if c.state.sub != nil {
+		c.state.sub.Stop()
+	}
+}
+
+func (c CacheAsidePattern) invalidateChannel() string {
+	return "cache:invalidate:" + c.persist.GetCachePrefix()
+}
+
+func (c CacheAsidePattern) subscribeInvalidation() {
+	local := c.state.local
+	sub, err := c.cache.Subscribe(context.Background(), []string{c.invalidateChannel()}, func(msg PubSubMessage) {
+		local.del(string(msg.Data))
+	}, WithOnSubscribed(local.purge)) // 断线期间可能漏掉通知
+	if err != nil {
+		log.Printf("cache subscribe invalidation err:%s", err)
+		return
+	}
+	c.state.sub = sub
+}
+
+// invalidate 删除redis和所有副本的本地缓存
+func (c CacheAsidePattern) invalidate(key string) error {
+	if err := c.cache.Del(key); err != nil {
+		return errors.WithMessage(err, "cache.Del")
+	}
+	if c.state.local == nil {
+		return nil
+	}
+	c.state.local.del(key)
+	_, err := c.cache.Publish(c.invalidateChannel(), key)
+	return errors.WithMessage(err, "Publish")
 }
 
 func (c CacheAsidePattern) Get(id interface{}) (interface{}, error) {
@@ -44,31 +236,317 @@ func (c CacheAsidePattern) Get(id interface{}) (interface{}, error) {
 		return nil, errors.WithMessage(err, "genKey")
 	}
 
-	val, err := c.cache.Get(key)
+	if c.state.local != nil {
+		if bytes, ok := c.state.local.get(key); ok {
+			cacheVal, err := c.decode(bytes)
+			if err != nil {
+				return nil, err
+			}
+			if cacheVal == nil {
+				return c.notFound()
+			}
+			return cacheVal, nil
+		}
+	}
+
+	if c.option.bloom != nil {
+		exists, err := c.option.bloom.Exists(id)
+		if err != nil {
+			return nil, errors.WithMessage(err, "bloom.Exists")
+		}
+		if !exists {
+			return c.notFound()
+		}
+	}
+
+	cacheVal, hit, ttl, err := c.getCache(key)
+	if err != nil {
+		return nil, errors.WithMessage(err, "getCache")
+	}
+	if hit {
+		if c.shouldRefreshEarly(ttl) {
+			go c.refresh(id, key)
+		}
+		if cacheVal == nil {
+			return c.notFound()
+		}
+		return cacheVal, nil
+	}
+
+	val, err := c.state.flight.Do(key, func() (interface{}, error) {
+		return c.rebuild(id, key)
+	})
 	if err != nil {
-		return nil, errors.WithMessage(err, "cache.Get")
+		return nil, err
+	}
+	if val == nil {
+		return c.notFound()
 	}
+	return val, nil
+}
 
-	if val != nil {
-		bytes, ok := val.([]byte)
+// GetMany 返回值与ids一一对应,不存在的为nil。
+// 一次MGET读缓存, 未命中的id在Persist实现了BatchPersist时一次查询,最后用pipeline回填缓存
+func (c CacheAsidePattern) GetMany(ids []interface{}) ([]CacheValue, error) {
+	ret := make([]CacheValue, len(ids))
+	keys := make([]string, len(ids))
+	var missIdx []int
+	for i, id := range ids {
+		if id == nil {
+			return nil, errors.New("id is nil")
+		}
+		key, err := c.genKey(id)
+		if err != nil {
+			return nil, errors.WithMessage(err, "genKey")
+		}
+		keys[i] = key
+
+		if c.state.local != nil {
+			if bytes, ok := c.state.local.get(key); ok {
+				if ret[i], err = c.decode(bytes); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+		missIdx = append(missIdx, i)
+	}
+	if len(missIdx) == 0 {
+		return ret, nil
+	}
+
+	missKeys := make([]string, len(missIdx))
+	for j, i := range missIdx {
+		missKeys[j] = keys[i]
+	}
+	values, err := c.cache.MGetBytes(missKeys...)
+	if err != nil {
+		return nil, errors.WithMessage(err, "cache.MGetBytes")
+	}
+	var loadIdx []int
+	for j, i := range missIdx {
+		if values[j] == nil {
+			loadIdx = append(loadIdx, i)
+			continue
+		}
+		if ret[i], err = c.decode(values[j]); err != nil {
+			return nil, err
+		}
+		c.setLocal(keys[i], values[j])
+	}
+
+	if c.option.bloom != nil {
+		exists := loadIdx[:0]
+		for _, i := range loadIdx {
+			ok, err := c.option.bloom.Exists(ids[i])
+			if err != nil {
+				return nil, errors.WithMessage(err, "bloom.Exists")
+			}
+			if ok {
+				exists = append(exists, i)
+			}
+		}
+		loadIdx = exists
+	}
+	if len(loadIdx) == 0 {
+		return ret, nil
+	}
+
+	loadIDs := make([]interface{}, len(loadIdx))
+	for j, i := range loadIdx {
+		loadIDs[j] = ids[i]
+	}
+	loaded, err := c.loadMany(loadIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	p := c.cache.Pipeline()
+	fill := make(map[string][]byte, len(loadIdx))
+	for _, i := range loadIdx {
+		key := keys[i]
+		if _, ok := fill[key]; ok {
+			ret[i] = loaded[util.ToStr(ids[i])]
+			continue
+		}
+		cacheVal, ok := loaded[util.ToStr(ids[i])]
 		if !ok {
-			return nil, errors.New("not []byte")
+			if c.option.negativeTTL > 0 {
+				p.SetEX(key, c.negativeSeconds(), negativeCacheValue)
+				fill[key] = negativeCacheValue
+			}
+			continue
 		}
+		bytes, err := cacheVal.Marshal()
+		if err != nil {
+			return nil, errors.WithMessage(err, "Marshal")
+		}
+		ret[i] = cacheVal
+		p.SetEX(key, c.jitterTTL(cacheVal.ExpiredSecond()), bytes)
+		fill[key] = bytes
+	}
+	if p.Len() == 0 {
+		return ret, nil
+	}
+	if err := p.Exec(); err != nil {
+		return ret, errors.WithMessage(err, "pipeline.Exec")
+	}
+	for key, bytes := range fill {
+		c.setLocal(key, bytes)
+	}
+	return ret, nil
+}
 
-		cacheVal := c.persist.GetEmpty()
-		err = cacheVal.Unmarshal(bytes)
+// loadMany 回源, 返回id=>记录, 不存在的id不在结果中
+func (c CacheAsidePattern) loadMany(ids []interface{}) (map[string]CacheValue, error) {
+	ret := make(map[string]CacheValue, len(ids))
+	if batch, ok := c.persist.(BatchPersist); ok {
+		values, err := batch.GetMany(ids)
 		if err != nil {
-			return nil, errors.WithMessage(err, "cacheVal.Unmarshal")
+			return nil, errors.WithMessage(err, "persist.GetMany")
 		}
-		return cacheVal, nil
+		for _, v := range values {
+			if v != nil {
+				ret[util.ToStr(v.GetID())] = v
+			}
+		}
+	} else {
+		for _, id := range ids {
+			v, err := c.persist.Get(id)
+			if err != nil {
+				return nil, errors.WithMessage(err, "persist.Get")
+			}
+			if v != nil {
+				ret[util.ToStr(id)] = v
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (c CacheAsidePattern) notFound() (interface{}, error) {
+	if c.option.notFoundErr {
+		return nil, ErrCacheNotFound
+	}
+	return nil, nil
+}
+
+// getCache 读缓存和剩余过期时间, hit为false表示缓存不存在,
+// hit为true且cacheVal为nil表示命中负缓存
+func (c CacheAsidePattern) getCache(key string) (cacheVal CacheValue, hit bool, ttl time.Duration, err error) {
+	p := c.cache.Pipeline()
+	get := p.Get(key)
+	var pttl *Result
+	if c.option.earlyRefresh {
+		pttl = p.Do("PTTL", key)
+	}
+	if err = p.Exec(); err != nil {
+		return nil, false, 0, errors.WithMessage(err, "cache.Get")
+	}
+
+	val, _ := get.Value()
+	if val == nil {
+		return nil, false, 0, nil
+	}
+	bytes, ok := val.([]byte)
+	if !ok {
+		return nil, false, 0, errors.New("not []byte")
+	}
+	if pttl != nil {
+		ms, _ := pttl.Int64()
+		ttl = time.Duration(ms) * time.Millisecond
+	}
+	if cacheVal, err = c.decode(bytes); err != nil {
+		return nil, false, 0, err
+	}
+	c.setLocal(key, bytes)
+	return cacheVal, true, ttl, nil
+}
+
+// decode 负缓存返回nil
+func (c CacheAsidePattern) decode(bytes []byte) (CacheValue, error) {
+	if string(bytes) == string(negativeCacheValue) {
+		return nil, nil
+	}
+	cacheVal := c.persist.GetEmpty()
+	if err := cacheVal.Unmarshal(bytes); err != nil {
+		return nil, errors.WithMessage(err, "cacheVal.Unmarshal")
+	}
+	return cacheVal, nil
+}
+
+func (c CacheAsidePattern) setLocal(key string, bytes []byte) {
+	if c.state.local != nil {
+		c.state.local.set(key, bytes)
+	}
+}
+
+// rebuild 回源并写缓存, 开启WithCacheRebuildLock时跨副本互斥
+func (c CacheAsidePattern) rebuild(id interface{}, key string) (interface{}, error) {
+	if c.option.rebuildLock {
+		locker := NewLocker(rebuildLockKey(key), c.cache, WithLockTTL(defaultRebuildLockTTL), WithLockWatchdog(false))
+		ok, err := locker.TryLock(context.Background())
+		if err != nil {
+			log.Printf("cache rebuild lock %s err:%s", key, err)
+		}
+		if ok {
+			defer func() {
+				if err := locker.Unlock(); err != nil && err != ErrLockNotHeld {
+					log.Printf("cache rebuild unlock %s err:%s", key, err)
+				}
+			}()
+			// 等锁期间可能已经有其他副本写好了缓存
+			if cacheVal, hit, _, err := c.getCache(key); err == nil && hit {
+				return cachedValue(cacheVal), nil
+			}
+		} else if err == nil {
+			if cacheVal, hit := c.waitRebuild(key); hit {
+				return cachedValue(cacheVal), nil
+			}
+		}
+	}
+	return c.load(id, key)
+}
+
+// waitRebuild 等待持锁的副本写好缓存,超时返回hit为false
+func (c CacheAsidePattern) waitRebuild(key string) (CacheValue, bool) {
+	deadline := time.Now().Add(c.option.rebuildLockWait)
+	for time.Now().Before(deadline) {
+		time.Sleep(rebuildLockPollInterval)
+		cacheVal, hit, _, err := c.getCache(key)
+		if err != nil {
+			return nil, false
+		}
+		if hit {
+			return cacheVal, true
+		}
+	}
+	return nil, false
+}
+
+// cachedValue 负缓存返回nil interface, 而不是值为nil的CacheValue
+func cachedValue(cacheVal CacheValue) interface{} {
+	if cacheVal == nil {
+		return nil
 	}
+	return cacheVal
+}
 
+func (c CacheAsidePattern) load(id interface{}, key string) (interface{}, error) {
+	start := time.Now()
 	cacheVal, err := c.persist.Get(id)
 	if err != nil {
 		return nil, errors.WithMessage(err, "persist.Get")
 	}
+	c.observeRebuild(time.Since(start))
 
 	if cacheVal == nil {
+		if c.option.negativeTTL > 0 {
+			if err := c.cache.SetEX(key, c.negativeSeconds(), negativeCacheValue); err != nil {
+				log.Printf("cache negative %s err:%s", key, err)
+			}
+			c.setLocal(key, negativeCacheValue)
+		}
 		return nil, nil
 	}
 
@@ -77,11 +555,93 @@ func (c CacheAsidePattern) Get(id interface{}) (interface{}, error) {
 		return nil, errors.WithMessage(err, "Marshal")
 	}
 
-	err = c.cache.SetEX(key, cacheVal.ExpiredSecond(), bytes)
-	return cacheVal, errors.WithMessage(err, "cache.setEX")
+	err = c.cache.SetEX(key, c.jitterTTL(cacheVal.ExpiredSecond()), bytes)
+	if err != nil {
+		return cacheVal, errors.WithMessage(err, "cache.setEX")
+	}
+	c.setLocal(key, bytes)
+	return cacheVal, nil
+}
+
+// refresh 后台提前刷新, 其他goroutine或副本正在刷新时直接返回
+func (c CacheAsidePattern) refresh(id interface{}, key string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("cache refresh %s panic: %v", key, r)
+		}
+	}()
+
+	_, err := c.state.flight.Do(key, func() (interface{}, error) {
+		if c.option.rebuildLock {
+			locker := NewLocker(rebuildLockKey(key), c.cache, WithLockTTL(defaultRebuildLockTTL), WithLockWatchdog(false))
+			ok, err := locker.TryLock(context.Background())
+			if err != nil || !ok {
+				return nil, err
+			}
+			defer func() {
+				if err := locker.Unlock(); err != nil && err != ErrLockNotHeld {
+					log.Printf("cache refresh unlock %s err:%s", key, err)
+				}
+			}()
+		}
+		return c.load(id, key)
+	})
+	if err != nil {
+		log.Printf("cache refresh %s err:%s", key, err)
+	}
+}
+
+// shouldRefreshEarly XFetch: now - delta*beta*ln(rand) >= expiry 时提前刷新
+func (c CacheAsidePattern) shouldRefreshEarly(ttl time.Duration) bool {
+	if !c.option.earlyRefresh || ttl <= 0 {
+		return false
+	}
+	delta := float64(atomic.LoadInt64(&c.state.rebuildNanos))
+	if delta <= 0 {
+		return false
+	}
+	return -delta*c.option.earlyBeta*math.Log(rand.Float64()) >= float64(ttl)
+}
+
+func (c CacheAsidePattern) observeRebuild(d time.Duration) {
+	for {
+		old := atomic.LoadInt64(&c.state.rebuildNanos)
+		next := int64(d)
+		if old > 0 {
+			next = old + (int64(d)-old)/4
+		}
+		if atomic.CompareAndSwapInt64(&c.state.rebuildNanos, old, next) {
+			return
+		}
+	}
+}
+
+func (c CacheAsidePattern) negativeSeconds() int64 {
+	return int64(math.Ceil(c.option.negativeTTL.Seconds()))
+}
+
+func (c CacheAsidePattern) jitterTTL(seconds int64) int64 {
+	if seconds <= 0 || c.option.ttlJitter <= 0 {
+		return seconds
+	}
+	max := int64(float64(seconds) * c.option.ttlJitter)
+	if max <= 0 {
+		return seconds
+	}
+	return seconds + rand.Int63n(max+1)
+}
+
+func rebuildLockKey(key string) string {
+	return "rebuild:" + key
 }
 
 func (c CacheAsidePattern) Set(value CacheValue) error {
+	if c.state.writeBehind != nil && value.GetID() != nil {
+		if queued, err := c.setBehind(value); queued {
+			return errors.WithMessage(err, "setBehind")
+		}
+	}
+
 	newID, err := c.persist.Save(value)
 	if err != nil {
 		return errors.WithMessage(err, "persist.Set")
@@ -89,15 +649,28 @@ func (c CacheAsidePattern) Set(value CacheValue) error {
 
 	if value.GetID() == nil {
 		value.SetID(newID)
-		return nil
+		if c.option.bloom != nil {
+			if err := c.option.bloom.Add(newID); err != nil {
+				return errors.WithMessage(err, "bloom.Add")
+			}
+		}
+		if c.option.negativeTTL <= 0 {
+			return nil
+		}
+		// 新id可能已经被写入了负缓存
+		key, err := c.genKey(newID)
+		if err != nil {
+			return errors.WithMessage(err, "genKey")
+		}
+		return errors.WithMessage(c.invalidate(key), "invalidate")
 	} else {
 		key, err := c.genKey(value.GetID())
 		if err != nil {
 			return errors.WithMessage(err, "genKey")
 		}
 
-		err = c.cache.Del(key)
-		return errors.WithMessage(err, "cache.Del")
+		err = c.invalidate(key)
+		return errors.WithMessage(err, "invalidate")
 	}
 }
 
@@ -111,16 +684,23 @@ func (c CacheAsidePattern) genKey(id interface{}) (string, error) {
 }
 
 func (c CacheAsidePattern) Del(id interface{}) error {
-	err := c.persist.Del(id)
+	key, err := c.genKey(id)
 	if err != nil {
-		return errors.WithMessage(err, "persist.Del")
+		return errors.WithMessage(err, "genKey")
 	}
 
-	key, err := c.genKey(id)
+	// 开启write-behind时排在该id之前的Save之后删除, 避免删除后又被写回
+	queued := false
+	if c.state.writeBehind != nil {
+		queued, err = c.delBehind(id, key)
+	}
+	if !queued {
+		err = c.persist.Del(id)
+	}
 	if err != nil {
-		return errors.WithMessage(err, "genKey")
+		return errors.WithMessage(err, "persist.Del")
 	}
 
-	err = c.cache.Del(key)
-	return errors.WithMessage(err, "cache.Del")
+	err = c.invalidate(key)
+	return errors.WithMessage(err, "invalidate")
 }
@@ -0,0 +1,386 @@
+package redis_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ziyoumeng/sdk/code"
+	"github.com/ziyoumeng/sdk/driver/redis"
+)
+
+type testItem struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+func (i *testItem) GetID() interface{}       { return i.ID }
+func (i *testItem) ExpiredSecond() int64     { return 60 }
+func (i *testItem) SetID(id interface{})     { i.ID = id.(int64) }
+func (i *testItem) Marshal() ([]byte, error) { return json.Marshal(i) }
+func (i *testItem) Unmarshal(b []byte) error { return json.Unmarshal(b, i) }
+
+// testPersist 内存中的Persist, 记录查询次数
+type testPersist struct {
+	mu        sync.Mutex
+	items     map[int64]testItem
+	gets      int
+	getDelay  time.Duration // 模拟慢查询
+	saveDelay time.Duration // 模拟慢写库
+}
+
+func (p *testPersist) Get(id interface{}) (redis.CacheValue, error) {
+	time.Sleep(p.getDelay)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gets++
+	item, ok := p.items[id.(int64)]
+	if !ok {
+		return nil, nil
+	}
+	return &item, nil
+}
+
+func (p *testPersist) Save(value redis.CacheValue) (interface{}, error) {
+	time.Sleep(p.saveDelay)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	item := *value.(*testItem)
+	p.items[item.ID] = item
+	return item.ID, nil
+}
+
+func (p *testPersist) Del(id interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.items, id.(int64))
+	return nil
+}
+
+func (p *testPersist) GetCachePrefix() string     { return "item" }
+func (p *testPersist) GetEmpty() redis.CacheValue { return &testItem{} }
+
+func (p *testPersist) getCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.gets
+}
+
+func (p *testPersist) item(id int64) (testItem, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	item, ok := p.items[id]
+	return item, ok
+}
+
+func TestCacheAsidePattern(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+	p := &testPersist{items: map[int64]testItem{1: {ID: 1, Name: "a"}}}
+	c := redis.NewCacheAsidePattern(w, p)
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		v, err := c.Get(int64(1))
+		assert.NoError(t, err)
+		assert.Equal(t, &testItem{ID: 1, Name: "a"}, v)
+	}
+	assert.Equal(t, 1, p.getCount())
+	assert.True(t, srv.Exists(testPrefix+":item:1"))
+
+	assert.NoError(t, c.Set(&testItem{ID: 1, Name: "b"}))
+	v, err := c.Get(int64(1))
+	assert.NoError(t, err)
+	assert.Equal(t, &testItem{ID: 1, Name: "b"}, v)
+}
+
+func TestCacheAsideSingleFlight(t *testing.T) {
+	w, _, _ := getWrapper(t)
+	p := &testPersist{items: map[int64]testItem{1: {ID: 1, Name: "a"}}, getDelay: 50 * time.Millisecond}
+	c := redis.NewCacheAsidePattern(w, p)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.Get(int64(1))
+			assert.NoError(t, err)
+			assert.Equal(t, &testItem{ID: 1, Name: "a"}, v)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, p.getCount())
+}
+
+func TestCacheAsideRebuildLock(t *testing.T) {
+	w, _, _ := getWrapper(t)
+	p := &testPersist{items: map[int64]testItem{1: {ID: 1, Name: "a"}, 2: {ID: 2, Name: "b"}}}
+	c := redis.NewCacheAsidePattern(w, p, redis.WithCacheRebuildLock(time.Second))
+
+	// 其他副本持有重建锁, 等它写好缓存后直接读取
+	other := redis.NewLocker("rebuild:item:1", w, redis.WithLockWatchdog(false))
+	ok, err := other.TryLock(context.Background())
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		assert.NoError(t, w.SetEX("item:1", 60, `{"id":1,"name":"rebuilt"}`))
+	}()
+	v, err := c.Get(int64(1))
+	assert.NoError(t, err)
+	assert.Equal(t, &testItem{ID: 1, Name: "rebuilt"}, v)
+	assert.Equal(t, 0, p.getCount())
+	assert.NoError(t, other.Unlock())
+
+	// 等待超时后自己回源
+	short := redis.NewCacheAsidePattern(w, p, redis.WithCacheRebuildLock(50*time.Millisecond))
+	other = redis.NewLocker("rebuild:item:2", w, redis.WithLockWatchdog(false))
+	ok, err = other.TryLock(context.Background())
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	v, err = short.Get(int64(2))
+	assert.NoError(t, err)
+	assert.Equal(t, &testItem{ID: 2, Name: "b"}, v)
+	assert.Equal(t, 1, p.getCount())
+}
+
+func TestCacheAsideEarlyRefresh(t *testing.T) {
+	w, _, _ := getWrapper(t)
+	p := &testPersist{items: map[int64]testItem{1: {ID: 1, Name: "a"}}, getDelay: time.Millisecond}
+	// beta足够大, 命中时几乎总是提前刷新
+	c := redis.NewCacheAsidePattern(w, p, redis.WithCacheEarlyRefresh(1e12))
+	plain := redis.NewCacheAsidePattern(w, p)
+
+	_, err := c.Get(int64(1))
+	assert.NoError(t, err)
+	_, err = p.Save(&testItem{ID: 1, Name: "b"})
+	assert.NoError(t, err)
+	// 没有开启时命中的旧值不会刷新
+	v, err := plain.Get(int64(1))
+	assert.NoError(t, err)
+	assert.Equal(t, &testItem{ID: 1, Name: "a"}, v)
+
+	// 命中时返回旧值, 在后台刷新缓存
+	v, err = c.Get(int64(1))
+	assert.NoError(t, err)
+	assert.Equal(t, &testItem{ID: 1, Name: "a"}, v)
+	assert.Eventually(t, func() bool {
+		v, err := plain.Get(int64(1))
+		return err == nil && v.(*testItem).Name == "b"
+	}, testWaitTimeout, 10*time.Millisecond)
+	assert.Equal(t, 2, p.getCount())
+}
+
+func TestCacheAsideTTLJitter(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+	p := &testPersist{items: map[int64]testItem{}}
+	for i := int64(0); i < 20; i++ {
+		p.items[i] = testItem{ID: i}
+	}
+
+	for _, jitter := range []float64{0, 0.5} {
+		srv.FlushAll()
+		c := redis.NewCacheAsidePattern(w, p, redis.WithCacheTTLJitter(jitter))
+		ttls := make(map[time.Duration]bool)
+		for i := int64(0); i < 20; i++ {
+			_, err := c.Get(i)
+			assert.NoError(t, err)
+			ttl := srv.TTL(fmt.Sprintf("%s:item:%d", testPrefix, i))
+			// ExpiredSecond为60, 随机增加[0, jitter*60]
+			assert.True(t, ttl >= time.Minute && ttl <= time.Duration(float64(time.Minute)*(1+jitter)), "jitter %v ttl %s", jitter, ttl)
+			ttls[ttl] = true
+		}
+		if jitter == 0 {
+			assert.Len(t, ttls, 1)
+		} else {
+			assert.True(t, len(ttls) > 1)
+		}
+	}
+}
+
+func TestCacheAsideNotFound(t *testing.T) {
+	w, _, clock := getWrapper(t)
+	p := &testPersist{items: map[int64]testItem{}}
+	c := redis.NewCacheAsidePattern(w, p, redis.WithCacheNegative(time.Minute), redis.WithCacheNotFoundError())
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := c.Get(int64(2))
+		assert.True(t, code.IsNotFound(err))
+	}
+	assert.Equal(t, 1, p.getCount())
+
+	// 负缓存过期后重新查库
+	p.Save(&testItem{ID: 2, Name: "c"})
+	clock.Add(time.Minute)
+	v, err := c.Get(int64(2))
+	assert.NoError(t, err)
+	assert.Equal(t, &testItem{ID: 2, Name: "c"}, v)
+	assert.Equal(t, 2, p.getCount())
+}
+
+func TestCacheAsideGetMany(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+	p := &testPersist{items: map[int64]testItem{1: {ID: 1, Name: "a"}, 2: {ID: 2, Name: "b"}}}
+	c := redis.NewCacheAsidePattern(w, p, redis.WithCacheNegative(time.Minute))
+
+	ids := []interface{}{int64(1), int64(2), int64(3)}
+	want := []redis.CacheValue{&testItem{ID: 1, Name: "a"}, &testItem{ID: 2, Name: "b"}, nil}
+	values, err := c.GetMany(ids)
+	assert.NoError(t, err)
+	assert.Equal(t, want, values)
+	assert.Equal(t, 3, p.getCount())
+	assert.True(t, srv.Exists(testPrefix+":item:3"))
+
+	// 全部命中缓存, 包括负缓存
+	values, err = c.GetMany(ids)
+	assert.NoError(t, err)
+	assert.Equal(t, want, values)
+	assert.Equal(t, 3, p.getCount())
+}
+
+func TestCacheAsideWriteBehind(t *testing.T) {
+	w, _, _ := getWrapper(t)
+	p := &testPersist{items: map[int64]testItem{}, saveDelay: 20 * time.Millisecond}
+	c := redis.NewCacheAsidePattern(w, p, redis.WithCacheWriteBehind(2, 10, 1))
+
+	// Set立即返回, 缓存中已经是新值
+	assert.NoError(t, c.Set(&testItem{ID: 1, Name: "a"}))
+	v, err := c.Get(int64(1))
+	assert.NoError(t, err)
+	assert.Equal(t, &testItem{ID: 1, Name: "a"}, v)
+
+	// Del排在之前的Save之后, 不会被写回
+	assert.NoError(t, c.Del(int64(1)))
+	_, ok := p.item(1)
+	assert.False(t, ok)
+
+	// Close等待队列写完
+	for i := int64(2); i < 6; i++ {
+		assert.NoError(t, c.Set(&testItem{ID: i, Name: "b"}))
+	}
+	c.Close()
+	for i := int64(2); i < 6; i++ {
+		item, ok := p.item(i)
+		assert.True(t, ok)
+		assert.Equal(t, "b", item.Name)
+	}
+	_, ok = p.item(1)
+	assert.False(t, ok)
+
+	// Close之后同步写库
+	assert.NoError(t, c.Set(&testItem{ID: 6, Name: "c"}))
+	_, ok = p.item(6)
+	assert.True(t, ok)
+}
+
+func TestCacheAsideLocal(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+	p := &testPersist{items: map[int64]testItem{1: {ID: 1, Name: "a"}}}
+	// 两个副本
+	c1 := redis.NewCacheAsidePattern(w, p, redis.WithCacheLocal(10, time.Minute))
+	defer c1.Close()
+	c2 := redis.NewCacheAsidePattern(w, p, redis.WithCacheLocal(10, time.Minute))
+	defer c2.Close()
+	assert.Eventually(t, func() bool {
+		n, _ := w.Publish("cache:invalidate:item", "probe")
+		return n == 2
+	}, testWaitTimeout, 10*time.Millisecond)
+
+	for _, c := range []redis.CacheAsidePattern{c1, c2} {
+		v, err := c.Get(int64(1))
+		assert.NoError(t, err)
+		assert.Equal(t, &testItem{ID: 1, Name: "a"}, v)
+	}
+	assert.Equal(t, 1, p.getCount())
+
+	// redis中没有了, 本地缓存仍然命中
+	srv.FlushAll()
+	v, err := c1.Get(int64(1))
+	assert.NoError(t, err)
+	assert.Equal(t, &testItem{ID: 1, Name: "a"}, v)
+	assert.Equal(t, 1, p.getCount())
+
+	// Set通过Pub/Sub删除其他副本的本地缓存
+	assert.NoError(t, c1.Set(&testItem{ID: 1, Name: "b"}))
+	assert.Eventually(t, func() bool {
+		v, err := c2.Get(int64(1))
+		return err == nil && v.(*testItem).Name == "b"
+	}, testWaitTimeout, 10*time.Millisecond)
+	v, err = c1.Get(int64(1))
+	assert.NoError(t, err)
+	assert.Equal(t, &testItem{ID: 1, Name: "b"}, v)
+}
+
+func TestBloomFilter(t *testing.T) {
+	w, _, _ := getWrapper(t)
+	b, err := redis.NewBloomFilter(w, "bloom", 200, 0.01)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for i := 0; i < 200; i++ {
+		assert.NoError(t, b.Add(i))
+	}
+	for i := 0; i < 200; i++ {
+		ok, err := b.Exists(i)
+		assert.NoError(t, err)
+		assert.True(t, ok, i)
+	}
+	// 误判率在预期附近
+	falsePositive := 0
+	for i := 200; i < 2200; i++ {
+		ok, err := b.Exists(i)
+		assert.NoError(t, err)
+		if ok {
+			falsePositive++
+		}
+	}
+	assert.True(t, falsePositive < 100, falsePositive)
+
+	_, err = redis.NewBloomFilter(w, "bloom", 0, 0.01)
+	assert.Error(t, err)
+	_, err = redis.NewBloomFilter(w, "bloom", 1000, 1)
+	assert.Error(t, err)
+}
+
+func TestCacheAsideBloomFilter(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+	p := &testPersist{items: map[int64]testItem{1: {ID: 1, Name: "a"}}}
+	b, err := redis.NewBloomFilter(w, "bloom:item", 100, 0.01)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// 3在过滤器中但库里没有
+	assert.NoError(t, b.Add(int64(1)))
+	assert.NoError(t, b.Add(int64(3)))
+	c := redis.NewCacheAsidePattern(w, p, redis.WithCacheBloomFilter(b), redis.WithCacheNegative(time.Minute),
+		redis.WithCacheNotFoundError(), redis.WithCacheLocal(10, time.Minute))
+	defer c.Close()
+
+	// 过滤器判断不存在的id不查缓存和库
+	_, err = c.Get(int64(2))
+	assert.True(t, code.IsNotFound(err))
+	values, err := c.GetMany([]interface{}{int64(1), int64(2)})
+	assert.NoError(t, err)
+	assert.Equal(t, []redis.CacheValue{&testItem{ID: 1, Name: "a"}, nil}, values)
+	assert.Equal(t, 1, p.getCount())
+	assert.False(t, srv.Exists(testPrefix+":item:2"))
+
+	// 误判的id写入负缓存, 之后从本地缓存和GetMany读到的仍是不存在
+	_, err = c.Get(int64(3))
+	assert.True(t, code.IsNotFound(err))
+	assert.Equal(t, 2, p.getCount())
+	assert.True(t, srv.Exists(testPrefix+":item:3"))
+	srv.FlushAll()
+	_, err = c.Get(int64(3))
+	assert.True(t, code.IsNotFound(err))
+	values, err = c.GetMany([]interface{}{int64(3), int64(1)})
+	assert.NoError(t, err)
+	assert.Equal(t, []redis.CacheValue{nil, &testItem{ID: 1, Name: "a"}}, values)
+	assert.Equal(t, 2, p.getCount())
+}
@@ -0,0 +1,157 @@
+package redis
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	writeBehindMinBackoff = 100 * time.Millisecond
+	writeBehindMaxBackoff = 5 * time.Second
+)
+
+// WithCacheWriteBehind Set写入缓存后立即返回,由workers个goroutine异步调用Persist.Save,
+// 失败时退避重试,最多maxAttempts次,仍失败时删除缓存。
+// 同一个id固定由同一个goroutine写入,保证顺序; 每个goroutine最多排队queueSize个,队列满时Set阻塞。
+// Del也放入同一个队列,在之前排队的Save之后执行,并等待删除完成再返回。
+// 没有id的新记录需要Persist.Save生成id,仍然同步写入。Set之后不能再修改value, 退出前需要调用Close等待写完
+func WithCacheWriteBehind(workers, queueSize, maxAttempts int) CacheAsideOption {
+	return func(o *cacheAsideOption) {
+		o.writeBehindWorkers = workers
+		o.writeBehindQueueSize = queueSize
+		o.writeBehindAttempts = maxAttempts
+	}
+}
+
+// writeBehind 异步写库的队列
+type writeBehind struct {
+	mu     sync.RWMutex
+	closed bool
+	queues []chan writeBehindTask
+	wg     sync.WaitGroup
+}
+
+// writeBehindTask done不为nil时为删除id的任务, 完成后返回Persist.Del的结果
+type writeBehindTask struct {
+	key   string
+	value CacheValue
+	id    interface{}
+	done  chan error
+}
+
+func (c CacheAsidePattern) startWriteBehind() {
+	wb := &writeBehind{queues: make([]chan writeBehindTask, c.option.writeBehindWorkers)}
+	for i := range wb.queues {
+		queue := make(chan writeBehindTask, c.option.writeBehindQueueSize)
+		wb.queues[i] = queue
+		wb.wg.Add(1)
+		go func() {
+			defer wb.wg.Done()
+			for task := range queue {
+				if task.done != nil {
+					task.done <- c.persist.Del(task.id)
+					continue
+				}
+				c.flush(task)
+			}
+		}()
+	}
+	c.state.writeBehind = wb
+}
+
+// setBehind 写缓存并放入写库队列, 已Close时返回false
+func (c CacheAsidePattern) setBehind(value CacheValue) (bool, error) {
+	wb := c.state.writeBehind
+	wb.mu.RLock()
+	defer wb.mu.RUnlock()
+	if wb.closed {
+		return false, nil
+	}
+
+	key, err := c.genKey(value.GetID())
+	if err != nil {
+		return true, errors.WithMessage(err, "genKey")
+	}
+	bytes, err := value.Marshal()
+	if err != nil {
+		return true, errors.WithMessage(err, "Marshal")
+	}
+	if err := c.cache.SetEX(key, c.jitterTTL(value.ExpiredSecond()), bytes); err != nil {
+		return true, errors.WithMessage(err, "cache.SetEX")
+	}
+	if c.state.local != nil {
+		if _, err := c.cache.Publish(c.invalidateChannel(), key); err != nil {
+			log.Printf("cache publish invalidation %s err:%s", key, err)
+		}
+		c.state.local.set(key, bytes)
+	}
+
+	wb.enqueue(writeBehindTask{key: key, value: value})
+	return true, nil
+}
+
+// delBehind 删除放入id所在的队列并等待完成, 已Close时返回false
+func (c CacheAsidePattern) delBehind(id interface{}, key string) (bool, error) {
+	wb := c.state.writeBehind
+	wb.mu.RLock()
+	if wb.closed {
+		wb.mu.RUnlock()
+		return false, nil
+	}
+	done := make(chan error, 1)
+	wb.enqueue(writeBehindTask{key: key, id: id, done: done})
+	wb.mu.RUnlock()
+	return true, <-done
+}
+
+// enqueue 同一个key固定放入同一个队列, 调用方需持有读锁
+func (wb *writeBehind) enqueue(task writeBehindTask) {
+	h := fnv.New32a()
+	h.Write([]byte(task.key))
+	wb.queues[h.Sum32()%uint32(len(wb.queues))] <- task
+}
+
+func (c CacheAsidePattern) flush(task writeBehindTask) {
+	attempts := 0
+	err := retryWithBackoff(context.Background(), writeBehindMinBackoff, writeBehindMaxBackoff, func() (bool, error) {
+		attempts++
+		_, err := c.persist.Save(task.value)
+		if err == nil {
+			return true, nil
+		}
+		if attempts >= c.option.writeBehindAttempts {
+			return false, err
+		}
+		log.Printf("cache write behind %s attempt %d err:%s", task.key, attempts, err)
+		return false, nil
+	})
+	if err == nil {
+		return
+	}
+
+	// 库里没有写入, 删除缓存避免和库长期不一致
+	log.Printf("cache write behind %s give up after %d attempts err:%s", task.key, attempts, err)
+	if err := c.invalidate(task.key); err != nil {
+		log.Printf("cache write behind invalidate %s err:%s", task.key, err)
+	}
+}
+
+// close 等待队列中的记录写完
+func (wb *writeBehind) close() {
+	wb.mu.Lock()
+	if wb.closed {
+		wb.mu.Unlock()
+		return
+	}
+	wb.closed = true
+	for _, queue := range wb.queues {
+		close(queue)
+	}
+	wb.mu.Unlock()
+	wb.wg.Wait()
+}
@@ -0,0 +1,864 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	clusterSlots        = 16384
+	clusterMaxRedirects = 5
+)
+
+var ErrClusterNoNode = errors.New("redis cluster: no available node")
+
+// clusterSource 按CLUSTER SLOTS维护slot=>master地址,每个master一个连接池
+type clusterSource struct {
+	conf  Config
+	seeds []string
+
+	mu     sync.RWMutex
+	slots  [clusterSlots]string
+	pools  map[string]*redigo.Pool
+	addrs  []string // 当前所有master
+	closed bool
+
+	refreshing int32
+}
+
+func newClusterSource(conf Config) (ConnSource, error) {
+	if conf.RedisDB != 0 {
+		return nil, errors.New("redis cluster only supports db 0")
+	}
+
+	s := &clusterSource{
+		conf:  conf,
+		seeds: append([]string(nil), conf.RedisClusterAddrs...),
+		pools: make(map[string]*redigo.Pool),
+	}
+	if err := s.refresh(); err != nil {
+		s.Close()
+		return nil, errors.WithMessage(err, "refresh slots")
+	}
+	return s, nil
+}
+
+func (s *clusterSource) GetContext(ctx context.Context) (redigo.Conn, error) {
+	s.mu.RLock()
+	closed := s.closed
+	s.mu.RUnlock()
+	if closed {
+		return nil, errors.New("redis cluster: source closed")
+	}
+	return &clusterConn{
+		source: s,
+		ctx:    ctx,
+		conns:  make(map[string]redigo.Conn),
+	}, nil
+}
+
+// pubSubConn 订阅不需要路由,从任意master取一个连接
+func (s *clusterSource) pubSubConn(ctx context.Context) (redigo.Conn, error) {
+	addr := s.randomAddr()
+	if addr == "" {
+		return nil, ErrClusterNoNode
+	}
+	pool, err := s.pool(addr)
+	if err != nil {
+		return nil, err
+	}
+	return pool.GetContext(ctx)
+}
+
+func (s *clusterSource) Stats() PoolStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var stats PoolStats
+	for _, pool := range s.pools {
+		ps := pool.Stats()
+		stats.ActiveCount += ps.ActiveCount
+		stats.IdleCount += ps.IdleCount
+		stats.WaitCount += ps.WaitCount
+		stats.WaitDuration += ps.WaitDuration
+	}
+	return stats
+}
+
+func (s *clusterSource) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	pools := s.pools
+	s.pools = make(map[string]*redigo.Pool)
+	s.mu.Unlock()
+
+	var err error
+	for _, pool := range pools {
+		if e := pool.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// poolLocked 调用方需持有写锁
+func (s *clusterSource) poolLocked(addr string) *redigo.Pool {
+	pool, ok := s.pools[addr]
+	if !ok {
+		pool = newNodePool(s.conf, addr, nil)
+		s.pools[addr] = pool
+	}
+	return pool
+}
+
+func (s *clusterSource) pool(addr string) (*redigo.Pool, error) {
+	s.mu.RLock()
+	pool, ok := s.pools[addr]
+	closed := s.closed
+	s.mu.RUnlock()
+	if closed {
+		return nil, errors.New("redis cluster: source closed")
+	}
+	if ok {
+		return pool, nil
+	}
+
+	// MOVED/ASK指向的新节点
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.poolLocked(addr), nil
+}
+
+func (s *clusterSource) addrForSlot(slot int) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if addr := s.slots[slot]; addr != "" {
+		return addr
+	}
+	return s.randomAddrLocked()
+}
+
+func (s *clusterSource) randomAddr() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.randomAddrLocked()
+}
+
+func (s *clusterSource) randomAddrLocked() string {
+	if len(s.addrs) == 0 {
+		return ""
+	}
+	return s.addrs[rand.Intn(len(s.addrs))]
+}
+
+func (s *clusterSource) masters() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string(nil), s.addrs...)
+}
+
+// nodeConn 指定节点的连接, SCAN等需要在每个master上执行的命令使用
+func (s *clusterSource) nodeConn(ctx context.Context, addr string) (redigo.Conn, error) {
+	pool, err := s.pool(addr)
+	if err != nil {
+		return nil, err
+	}
+	return pool.GetContext(ctx)
+}
+
+func (s *clusterSource) setSlot(slot int, addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.slots[slot] = addr
+	s.poolLocked(addr)
+}
+
+// triggerRefresh 收到MOVED后异步刷新slot表,同一时间只有一个刷新
+func (s *clusterSource) triggerRefresh() {
+	if !atomic.CompareAndSwapInt32(&s.refreshing, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&s.refreshing, 0)
+		if err := s.refresh(); err != nil {
+			log.Printf("redis cluster refresh slots failed: %s", err)
+		}
+	}()
+}
+
+// refresh 依次向已知节点和种子节点请求CLUSTER SLOTS,直到成功
+func (s *clusterSource) refresh() error {
+	candidates := append(s.masters(), s.seeds...)
+	var lastErr error = ErrClusterNoNode
+	for _, addr := range candidates {
+		slots, err := s.fetchSlots(addr)
+		if err != nil {
+			lastErr = errors.WithMessage(err, addr)
+			continue
+		}
+		s.installSlots(slots)
+		return nil
+	}
+	return lastErr
+}
+
+type slotRange struct {
+	start, end int
+	addr       string
+}
+
+func (s *clusterSource) fetchSlots(addr string) ([]slotRange, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, errors.New("redis cluster: source closed")
+	}
+	pool := s.poolLocked(addr)
+	s.mu.Unlock()
+
+	conn := pool.Get()
+	defer conn.Close()
+	values, err := redigo.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	ranges := make([]slotRange, 0, len(values))
+	for _, v := range values {
+		// [start, end, [ip, port, id], replicas...]
+		item, err := redigo.Values(v, nil)
+		if err != nil || len(item) < 3 {
+			return nil, errors.Errorf("unexpected CLUSTER SLOTS item %v", v)
+		}
+		start, err1 := redigo.Int(item[0], nil)
+		end, err2 := redigo.Int(item[1], nil)
+		node, err3 := redigo.Values(item[2], nil)
+		if err1 != nil || err2 != nil || err3 != nil || len(node) < 2 {
+			return nil, errors.Errorf("unexpected CLUSTER SLOTS item %v", v)
+		}
+		ip, _ := redigo.String(node[0], nil)
+		port, err := redigo.Int(node[1], nil)
+		if err != nil {
+			return nil, errors.Errorf("unexpected CLUSTER SLOTS node %v", node)
+		}
+		// ip为空表示与被请求的节点相同
+		if ip == "" {
+			ip = host
+		}
+		ranges = append(ranges, slotRange{start: start, end: end, addr: net.JoinHostPort(ip, strconv.Itoa(port))})
+	}
+	if len(ranges) == 0 {
+		return nil, errors.New("empty CLUSTER SLOTS")
+	}
+	return ranges, nil
+}
+
+// installSlots 替换slot表,关闭已不是master的节点的连接池
+func (s *clusterSource) installSlots(ranges []slotRange) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+
+	var slots [clusterSlots]string
+	masters := make(map[string]struct{})
+	for _, r := range ranges {
+		for slot := r.start; slot <= r.end && slot < clusterSlots; slot++ {
+			slots[slot] = r.addr
+		}
+		masters[r.addr] = struct{}{}
+	}
+	s.slots = slots
+	s.addrs = s.addrs[:0]
+	for addr := range masters {
+		s.addrs = append(s.addrs, addr)
+		s.poolLocked(addr)
+	}
+
+	var stale []*redigo.Pool
+	for addr, pool := range s.pools {
+		if _, ok := masters[addr]; !ok {
+			stale = append(stale, pool)
+			delete(s.pools, addr)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, pool := range stale {
+		pool.Close()
+	}
+}
+
+// clusterConn 按key路由到对应master,处理MOVED/ASK重定向。
+// WATCH/MULTI之后的命令固定发往同一节点,事务内的key需要用hash tag落在同一slot
+type clusterConn struct {
+	source *clusterSource
+	ctx    context.Context
+	conns  map[string]redigo.Conn // 地址=>从连接池借出的连接
+	err    error
+
+	pinned       string // WATCH/MULTI后固定的节点
+	pendingMulti bool   // 已收到MULTI,等第一个带key的命令确定节点后再发送
+	inMulti      bool
+
+	pending []clusterCommand
+	replies []clusterReply
+}
+
+type clusterCommand struct {
+	name string
+	args []interface{}
+}
+
+type clusterReply struct {
+	reply interface{}
+	err   error
+}
+
+func (c *clusterConn) Close() error {
+	if c.err == nil {
+		c.err = errors.New("redis cluster: connection closed")
+	}
+	var err error
+	for addr, conn := range c.conns {
+		if e := conn.Close(); e != nil && err == nil {
+			err = e
+		}
+		delete(c.conns, addr)
+	}
+	return err
+}
+
+func (c *clusterConn) Err() error {
+	return c.err
+}
+
+func (c *clusterConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return c.DoContext(c.ctx, cmd, args...)
+}
+
+// DoContext 与redigo一致: 先执行Send缓存的命令,返回最后一条的结果和第一个错误;
+// cmd为空时返回所有缓存命令的结果
+func (c *clusterConn) DoContext(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	pending := c.pending
+	c.pending = nil
+	if cmd == "" {
+		replies := make([]interface{}, 0, len(pending))
+		for _, r := range c.execPipeline(ctx, pending) {
+			if r.err != nil {
+				if _, ok := r.err.(redigo.Error); !ok {
+					return nil, r.err
+				}
+				r.reply = r.err
+			}
+			replies = append(replies, r.reply)
+		}
+		return replies, nil
+	}
+
+	var firstErr error
+	for _, r := range c.execPipeline(ctx, pending) {
+		if r.err != nil && firstErr == nil {
+			firstErr = r.err
+		}
+	}
+	reply, err := c.exec(ctx, cmd, args)
+	if firstErr != nil {
+		return reply, firstErr
+	}
+	return reply, err
+}
+
+func (c *clusterConn) Send(cmd string, args ...interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.pending = append(c.pending, clusterCommand{name: cmd, args: args})
+	return nil
+}
+
+// Flush 执行Send缓存的命令,结果由Receive取出。命令按节点分组,每个节点一次往返,见execPipeline
+func (c *clusterConn) Flush() error {
+	return c.flush(c.ctx)
+}
+
+func (c *clusterConn) flush(ctx context.Context) error {
+	if c.err != nil {
+		return c.err
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	pending := c.pending
+	c.pending = nil
+	c.replies = append(c.replies, c.execPipeline(ctx, pending)...)
+	return nil
+}
+
+// execPipeline 执行pipeline中的命令,结果与cmds一一对应。
+// 都是普通的单key命令时按节点分组,每个节点发送一次、读取一次,节点之间不保证先后顺序;
+// 返回MOVED/ASK等重定向的命令随后单独重试。
+// 在事务中、或含有多key/广播/事务控制命令时,依次执行,每条命令一次往返
+func (c *clusterConn) execPipeline(ctx context.Context, cmds []clusterCommand) []clusterReply {
+	replies := make([]clusterReply, len(cmds))
+	groups, order, ok := c.groupByNode(cmds)
+	if !ok {
+		for i, p := range cmds {
+			replies[i].reply, replies[i].err = c.exec(ctx, p.name, p.args)
+		}
+		return replies
+	}
+
+	for _, addr := range order {
+		c.pipelineNode(ctx, addr, cmds, groups[addr], replies)
+	}
+	for i, r := range replies {
+		if redisErr, ok := r.err.(redigo.Error); ok {
+			if kind, _ := parseRedirect(string(redisErr)); kind != "" {
+				replies[i].reply, replies[i].err = c.exec(ctx, cmds[i].name, cmds[i].args)
+			}
+		}
+	}
+	return replies
+}
+
+// groupByNode 按当前slot表把命令分到各节点, 不能分组时ok为false
+func (c *clusterConn) groupByNode(cmds []clusterCommand) (groups map[string][]int, order []string, ok bool) {
+	if c.pinned != "" || c.pendingMulti || c.inMulti || len(cmds) < 2 {
+		return nil, nil, false
+	}
+	groups = make(map[string][]int)
+	for i, p := range cmds {
+		name := strings.ToUpper(p.name)
+		switch name {
+		case "SCRIPT", "FLUSHDB", "FLUSHALL", "KEYS", "MGET", "DEL", "UNLINK", "EXISTS", "TOUCH",
+			"MULTI", "EXEC", "DISCARD", "WATCH", "UNWATCH":
+			return nil, nil, false
+		}
+		key, hasKey := commandKey(name, p.args)
+		if !hasKey {
+			return nil, nil, false
+		}
+		addr := c.source.addrForSlot(keySlot(key))
+		if addr == "" {
+			return nil, nil, false
+		}
+		if _, exists := groups[addr]; !exists {
+			order = append(order, addr)
+		}
+		groups[addr] = append(groups[addr], i)
+	}
+	return groups, order, true
+}
+
+// pipelineNode 在addr上一次发送idx中的命令再依次读取结果, 连接出错时未读到结果的命令都返回该错误
+func (c *clusterConn) pipelineNode(ctx context.Context, addr string, cmds []clusterCommand, idx []int, replies []clusterReply) {
+	fail := func(from int, err error) {
+		for _, i := range idx[from:] {
+			replies[i].err = err
+		}
+	}
+	conn, err := c.nodeConn(ctx, addr)
+	if err != nil {
+		fail(0, err)
+		return
+	}
+	for _, i := range idx {
+		if err := conn.Send(cmds[i].name, cmds[i].args...); err != nil {
+			fail(0, err)
+			c.dropConn(addr, conn)
+			return
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		fail(0, err)
+		c.dropConn(addr, conn)
+		return
+	}
+	for n, i := range idx {
+		replies[i].reply, replies[i].err = redigo.ReceiveContext(conn, ctx)
+		if replies[i].err != nil && conn.Err() != nil {
+			fail(n, replies[i].err)
+			c.dropConn(addr, conn)
+			return
+		}
+	}
+}
+
+// dropConn 连接已不可用,下次重新从连接池取
+func (c *clusterConn) dropConn(addr string, conn redigo.Conn) {
+	conn.Close()
+	delete(c.conns, addr)
+}
+
+func (c *clusterConn) Receive() (interface{}, error) {
+	return c.ReceiveContext(c.ctx)
+}
+
+func (c *clusterConn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if len(c.replies) == 0 && len(c.pending) > 0 {
+		if err := c.flush(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.replies) == 0 {
+		return nil, errors.New("redis cluster: no pending reply")
+	}
+	r := c.replies[0]
+	c.replies = c.replies[1:]
+	return r.reply, r.err
+}
+
+func (c *clusterConn) exec(ctx context.Context, cmd string, args []interface{}) (interface{}, error) {
+	name := strings.ToUpper(cmd)
+	switch name {
+	case "SCRIPT", "FLUSHDB", "FLUSHALL":
+		return c.broadcast(ctx, cmd, args, false)
+	case "KEYS":
+		return c.broadcast(ctx, cmd, args, true)
+	case "MGET", "DEL", "UNLINK", "EXISTS", "TOUCH":
+		if c.pinned == "" && !c.pendingMulti {
+			return c.multiKey(ctx, name, cmd, args)
+		}
+	case "MULTI":
+		if c.inMulti || c.pendingMulti {
+			return nil, redigo.Error("ERR MULTI calls can not be nested")
+		}
+		if c.pinned == "" {
+			c.pendingMulti = true
+			return "OK", nil
+		}
+		reply, err := c.doNode(ctx, c.pinned, false, cmd, args)
+		if err == nil {
+			c.inMulti = true
+		}
+		return reply, err
+	case "EXEC", "DISCARD":
+		if c.pendingMulti {
+			// MULTI后没有任何命令
+			c.pendingMulti = false
+			c.pinned = ""
+			if name == "EXEC" {
+				return []interface{}{}, nil
+			}
+			return "OK", nil
+		}
+		if c.pinned == "" {
+			return nil, redigo.Error("ERR " + name + " without MULTI")
+		}
+		addr := c.pinned
+		c.pinned, c.inMulti = "", false
+		return c.doNode(ctx, addr, false, cmd, args)
+	case "UNWATCH":
+		if c.pinned == "" {
+			return "OK", nil
+		}
+		addr := c.pinned
+		if !c.inMulti && !c.pendingMulti {
+			c.pinned = ""
+		}
+		return c.doNode(ctx, addr, false, cmd, args)
+	}
+
+	key, hasKey := commandKey(name, args)
+	if c.pinned == "" && c.pendingMulti && hasKey {
+		c.pinned = c.source.addrForSlot(keySlot(key))
+	}
+	if c.pinned != "" {
+		if c.pendingMulti {
+			if _, err := c.doNode(ctx, c.pinned, false, "MULTI", nil); err != nil {
+				return nil, err
+			}
+			c.pendingMulti, c.inMulti = false, true
+		}
+		return c.doNode(ctx, c.pinned, false, cmd, args)
+	}
+
+	reply, addr, err := c.route(ctx, key, hasKey, cmd, args)
+	if name == "WATCH" && err == nil {
+		c.pinned = addr
+	}
+	return reply, err
+}
+
+// multiKey 多key命令按slot拆分, MGET结果按原顺序合并, DEL/UNLINK/EXISTS/TOUCH结果相加
+func (c *clusterConn) multiKey(ctx context.Context, name, cmd string, args []interface{}) (interface{}, error) {
+	groups := make(map[int][]int)
+	var slots []int
+	for i, arg := range args {
+		slot := keySlot(keyString(arg))
+		if _, ok := groups[slot]; !ok {
+			slots = append(slots, slot)
+		}
+		groups[slot] = append(groups[slot], i)
+	}
+	if len(slots) <= 1 {
+		key, hasKey := commandKey(name, args)
+		reply, _, err := c.route(ctx, key, hasKey, cmd, args)
+		return reply, err
+	}
+
+	replies := make([]interface{}, len(args))
+	var sum int64
+	for _, slot := range slots {
+		idx := groups[slot]
+		sub := make([]interface{}, len(idx))
+		for i, j := range idx {
+			sub[i] = args[j]
+		}
+		reply, _, err := c.route(ctx, keyString(sub[0]), true, cmd, sub)
+		if name != "MGET" {
+			n, err := redigo.Int64(reply, err)
+			if err != nil {
+				return nil, err
+			}
+			sum += n
+			continue
+		}
+		values, err := redigo.Values(reply, err)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != len(idx) {
+			return nil, errors.Errorf("redis cluster: unexpected MGET reply length %d", len(values))
+		}
+		for i, j := range idx {
+			replies[j] = values[i]
+		}
+	}
+	if name != "MGET" {
+		return sum, nil
+	}
+	return replies, nil
+}
+
+// route 发往key所在节点,跟随MOVED/ASK重定向,TRYAGAIN/CLUSTERDOWN时稍后重试
+func (c *clusterConn) route(ctx context.Context, key string, hasKey bool, cmd string, args []interface{}) (reply interface{}, addr string, err error) {
+	slot := -1
+	if hasKey {
+		slot = keySlot(key)
+	}
+	asking := false
+	for attempt := 0; attempt <= clusterMaxRedirects; attempt++ {
+		if addr == "" {
+			if slot >= 0 {
+				addr = c.source.addrForSlot(slot)
+			} else {
+				addr = c.source.randomAddr()
+			}
+			if addr == "" {
+				return nil, "", ErrClusterNoNode
+			}
+		}
+
+		reply, err = c.doNode(ctx, addr, asking, cmd, args)
+		redisErr, ok := err.(redigo.Error)
+		if !ok {
+			return reply, addr, err
+		}
+
+		kind, target := parseRedirect(string(redisErr))
+		switch kind {
+		case "MOVED":
+			if slot >= 0 {
+				c.source.setSlot(slot, target)
+			}
+			c.source.triggerRefresh()
+			addr, asking = target, false
+		case "ASK":
+			addr, asking = target, true
+		case "TRYAGAIN", "CLUSTERDOWN":
+			addr, asking = "", false
+			select {
+			case <-time.After(time.Duration(attempt+1) * 50 * time.Millisecond):
+			case <-ctx.Done():
+				return nil, "", ctx.Err()
+			}
+		default:
+			return reply, addr, err
+		}
+	}
+	return reply, addr, err
+}
+
+// broadcast 发往所有master, merge为true时合并数组结果(KEYS),否则返回第一个结果
+func (c *clusterConn) broadcast(ctx context.Context, cmd string, args []interface{}, merge bool) (interface{}, error) {
+	masters := c.source.masters()
+	if len(masters) == 0 {
+		return nil, ErrClusterNoNode
+	}
+
+	var first interface{}
+	var merged []interface{}
+	for i, addr := range masters {
+		reply, err := c.doNode(ctx, addr, false, cmd, args)
+		if err != nil {
+			return nil, errors.WithMessage(err, addr)
+		}
+		if i == 0 {
+			first = reply
+		}
+		if merge {
+			values, err := redigo.Values(reply, nil)
+			if err != nil {
+				return nil, err
+			}
+			merged = append(merged, values...)
+		}
+	}
+	if merge {
+		return merged, nil
+	}
+	return first, nil
+}
+
+func (c *clusterConn) doNode(ctx context.Context, addr string, asking bool, cmd string, args []interface{}) (interface{}, error) {
+	conn, err := c.nodeConn(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if asking {
+		if _, err := redigo.DoContext(conn, ctx, "ASKING"); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := redigo.DoContext(conn, ctx, cmd, args...)
+	if err != nil && conn.Err() != nil {
+		c.dropConn(addr, conn)
+	}
+	return reply, err
+}
+
+func (c *clusterConn) nodeConn(ctx context.Context, addr string) (redigo.Conn, error) {
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+	pool, err := c.source.pool(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := pool.GetContext(ctx)
+	if err != nil {
+		return nil, errors.WithMessage(err, addr)
+	}
+	c.conns[addr] = conn
+	return conn, nil
+}
+
+// parseRedirect 解析"MOVED 3999 127.0.0.1:6381"、"ASK 3999 127.0.0.1:6381"等错误
+func parseRedirect(msg string) (kind, addr string) {
+	fields := strings.Fields(msg)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	switch fields[0] {
+	case "MOVED", "ASK":
+		if len(fields) == 3 {
+			return fields[0], fields[2]
+		}
+	case "TRYAGAIN", "CLUSTERDOWN":
+		return fields[0], ""
+	}
+	return "", ""
+}
+
+// commandKey 取用于路由的key,没有key的命令发往任意节点
+func commandKey(name string, args []interface{}) (string, bool) {
+	switch name {
+	case "PING", "ECHO", "INFO", "TIME", "DBSIZE", "ROLE", "CLUSTER", "COMMAND", "CONFIG", "CLIENT",
+		"SCAN", "RANDOMKEY", "PUBLISH", "WAIT", "READONLY", "READWRITE", "ASKING":
+		return "", false
+	case "EVAL", "EVALSHA":
+		if len(args) < 3 {
+			return "", false
+		}
+		if n, err := redigo.Int(args[1], nil); err != nil || n <= 0 {
+			return "", false
+		}
+		return keyString(args[2]), true
+	case "XGROUP", "XINFO", "OBJECT", "MEMORY":
+		// 子命令之后才是key
+		if len(args) < 2 {
+			return "", false
+		}
+		return keyString(args[1]), true
+	case "XREAD", "XREADGROUP":
+		for i, arg := range args {
+			if strings.EqualFold(keyString(arg), "STREAMS") && i+1 < len(args) {
+				return keyString(args[i+1]), true
+			}
+		}
+		return "", false
+	}
+	if len(args) == 0 {
+		return "", false
+	}
+	return keyString(args[0]), true
+}
+
+func keyString(arg interface{}) string {
+	switch v := arg.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// keySlot CRC16(XMODEM) % 16384, key中有非空的{tag}时只计算tag
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % clusterSlots)
+}
+
+var crc16Table = func() (table [256]uint16) {
+	for i := range table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return
+}()
+
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
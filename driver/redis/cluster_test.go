@@ -0,0 +1,148 @@
+package redis_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ziyoumeng/sdk/driver/redis"
+	"github.com/ziyoumeng/sdk/driver/redis/redistest"
+)
+
+func getClusterWrapper(t *testing.T) (*redis.Wrapper, *redistest.Cluster) {
+	c := redistest.NewCluster(3)
+	t.Cleanup(c.Close)
+	w, err := redis.NewWrapper(redis.Config{RedisClusterAddrs: c.Addrs()}, testPrefix)
+	if err != nil {
+		t.Fatalf("NewWrapper %s", err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w, c
+}
+
+// otherNode c中不负责key的一个节点下标
+func otherNode(c *redistest.Cluster, key string) int {
+	for i, s := range c.Nodes {
+		if s != c.NodeFor(key) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestClusterPipeline(t *testing.T) {
+	w, c := getClusterWrapper(t)
+
+	p := w.Pipeline()
+	var results []*redis.Result
+	for i := 0; i < 20; i++ {
+		results = append(results, p.Incr(fmt.Sprintf("k%d", i)))
+	}
+	p.HGetAll("k0")
+	assert.Error(t, p.Exec())
+	for _, r := range results {
+		n, err := r.Int64()
+		assert.NoError(t, err)
+		assert.EqualValues(t, 1, n)
+	}
+	nodes := make(map[*redistest.Server]bool)
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("%s:k%d", testPrefix, i)
+		assert.True(t, c.NodeFor(key).Exists(key), key)
+		nodes[c.NodeFor(key)] = true
+	}
+	assert.Len(t, nodes, 3)
+	assert.Equal(t, 0, c.Redirects())
+
+	// 同一key的命令保持顺序
+	p.Set("ordered", 1)
+	p.Incr("ordered")
+	p.Set("other", 1)
+	get := p.Get("ordered")
+	assert.NoError(t, p.Exec())
+	v, err := get.Int64()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, v)
+}
+
+func TestClusterMoved(t *testing.T) {
+	w, c := getClusterWrapper(t)
+
+	assert.NoError(t, w.Set("k", "v"))
+	key := testPrefix + ":k"
+	slot := redistest.KeySlot(key)
+	c.MoveSlot(slot, otherNode(c, key))
+
+	v, err := w.GetString("k")
+	assert.NoError(t, err)
+	assert.Equal(t, "v", v)
+	assert.Equal(t, 1, c.Redirects())
+	// 收到MOVED后更新了slot表, 不再重定向
+	_, err = w.GetString("k")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, c.Redirects())
+
+	// pipeline中被重定向的命令单独重试
+	c.MoveSlot(slot, otherNode(c, key))
+	p := w.Pipeline()
+	set := p.Set("k", "v2")
+	for i := 0; i < 5; i++ {
+		p.Set(fmt.Sprintf("p%d", i), i)
+	}
+	assert.NoError(t, p.Exec())
+	assert.NoError(t, set.Err())
+	assert.True(t, c.NodeFor(key).Exists(key))
+	v, err = w.GetString("k")
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", v)
+}
+
+func TestClusterAsk(t *testing.T) {
+	w, c := getClusterWrapper(t)
+
+	// 同一hash tag的key在同一slot
+	assert.NoError(t, w.Set("{m}a", "a"))
+	key := testPrefix + ":{m}a"
+	src, target := c.NodeFor(key), otherNode(c, key)
+	c.MigrateSlot(redistest.KeySlot(key), target)
+
+	// 原节点上不存在的key通过ASK写到迁入节点, 不修改slot表
+	assert.NoError(t, w.Set("{m}b", "b"))
+	assert.True(t, c.Nodes[target].Exists(testPrefix+":{m}b"))
+	assert.False(t, src.Exists(testPrefix+":{m}b"))
+	assert.Equal(t, 1, c.Redirects())
+	v, err := w.GetString("{m}a")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", v)
+	assert.Equal(t, 1, c.Redirects())
+
+	c.MoveSlot(redistest.KeySlot(key), target)
+	for _, k := range []string{"{m}a", "{m}b"} {
+		v, err := w.GetString(k)
+		assert.NoError(t, err)
+		assert.Equal(t, k[len(k)-1:], v)
+	}
+}
+
+func TestClusterMultiKey(t *testing.T) {
+	w, _ := getClusterWrapper(t)
+
+	keys := make([]interface{}, 10)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("m%d", i)
+		assert.NoError(t, w.Set(keys[i].(string), i))
+	}
+	n, err := w.DeleteByPattern("m*", 3)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 10, n)
+
+	// 事务中的key需要在同一slot
+	assert.NoError(t, w.Tx(func(tx *redis.Tx) error {
+		tx.Set("{tx}a", 1)
+		tx.Incr("{tx}b")
+		return nil
+	}, "{tx}a"))
+	v, err := w.GetString("{tx}b")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", v)
+}
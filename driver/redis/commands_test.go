@@ -0,0 +1,322 @@
+package redis_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ziyoumeng/sdk/driver/redis"
+)
+
+func TestSet(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+
+	n, err := w.SAdd("a", "x", "y", "z", "x")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.True(t, srv.Exists(testPrefix+":a"))
+	_, err = w.SAdd("b", "y", "z", "w")
+	assert.NoError(t, err)
+
+	ok, err := w.SIsMember("a", "x")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	card, err := w.SCard("a")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, card)
+
+	members, err := w.SInter("a", "b")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"y", "z"}, members)
+	members, err = w.SUnion("a", "b")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"w", "x", "y", "z"}, members)
+	members, err = w.SDiff("a", "b")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"x"}, members)
+	card, err = w.SInterStore("ab", "a", "b")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, card)
+	assert.True(t, srv.Exists(testPrefix+":ab"))
+
+	ok, err = w.SMove("a", "c", "x")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	members, err = w.SMembers("c")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"x"}, members)
+
+	n, err = w.SRem("a", "y", "not-exist")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	member, err := w.SPop("a")
+	assert.NoError(t, err)
+	assert.Equal(t, "z", member)
+	_, err = w.SPop("a")
+	assert.True(t, redis.IsNil(err))
+}
+
+func TestList(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+
+	n, err := w.RPush("l", 1, 2, 3)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, n)
+	n, err = w.LPush("l", 0)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, n)
+	n, err = w.LPushX("missing", 1)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, n)
+	assert.False(t, srv.Exists(testPrefix+":missing"))
+
+	values, err := w.LRangeInt64s("l", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{0, 1, 2, 3}, values)
+
+	assert.NoError(t, w.LSet("l", 1, "one"))
+	n, err = w.LInsert("l", true, "one", "half")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, n)
+	strs, err := w.LRangeStrings("l", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0", "half", "one", "2", "3"}, strs)
+
+	value, err := w.LIndexBytes("l", -1)
+	assert.NoError(t, err)
+	assert.Equal(t, "3", string(value))
+	_, err = w.LIndexBytes("l", 10)
+	assert.True(t, redis.IsNil(err))
+
+	assert.NoError(t, w.LTrim("l", 1, 3))
+	n, err = w.LRem("l", 0, "one")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, n)
+
+	value, err = w.LMoveBytes("l", "dst", "LEFT", "RIGHT")
+	assert.NoError(t, err)
+	assert.Equal(t, "half", string(value))
+	assert.True(t, srv.Exists(testPrefix+":dst"))
+	value, err = w.RPopBytes("l")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", string(value))
+	_, err = w.LPopBytes("l")
+	assert.True(t, redis.IsNil(err))
+
+	value, err = w.BLPopBytes("dst", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "half", string(value))
+	n, err = w.LLen("dst")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, n)
+}
+
+func TestHash(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+
+	n, err := w.HSetMap("h", map[string]interface{}{"a": 1, "b": "x"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.True(t, srv.Exists(testPrefix+":h"))
+	n, err = w.HSet("h", "a", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	ok, err := w.HSetNX("h", "a", 3)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = w.HExists("h", "b")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	fields, err := w.HKeys("h")
+	assert.NoError(t, err)
+	sort.Strings(fields)
+	assert.Equal(t, []string{"a", "b"}, fields)
+	values, err := w.HValsBytes("h")
+	assert.NoError(t, err)
+	assert.Len(t, values, 2)
+	all, err := w.HGetAllBytesMap("h")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"a": []byte("2"), "b": []byte("x")}, all)
+
+	i, err := w.HIncrByInt64("h", "a", 5)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 7, i)
+	f, err := w.HIncrByFloat("h", "c", 1.5)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, f)
+	size, err := w.HLen("h")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, size)
+}
+
+func TestZSet(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+
+	n, err := w.ZAddMembers("z", redis.ZMember{Member: "a", Score: 1}, redis.ZMember{Member: "b", Score: 2},
+		redis.ZMember{Member: "c", Score: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.True(t, srv.Exists(testPrefix+":z"))
+
+	score, err := w.ZIncrBy("z", 10, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, 11.0, score)
+	rank, err := w.ZRevRank("z", "a")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, rank)
+	_, err = w.ZRevRank("z", "not-exist")
+	assert.True(t, redis.IsNil(err))
+
+	members, err := w.ZRevRangeMembers("z", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "c", "b"}, members)
+	withScores, err := w.ZRevRangeWithScores("z", 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []redis.ZMember{{Member: "a", Score: 11}}, withScores)
+
+	members, err = w.ZRangeByScoreMembers("z", "(2", "+inf", 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c", "a"}, members)
+	withScores, err = w.ZRangeByScoreWithScores("z", "-inf", "+inf", 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []redis.ZMember{{Member: "c", Score: 3}}, withScores)
+	members, err = w.ZRevRangeByScoreMembers("z", "3", "0", 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c", "b"}, members)
+	count, err := w.ZCount("z", "2", "3")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+
+	_, err = w.ZAddMembers("z2", redis.ZMember{Member: "b", Score: 5})
+	assert.NoError(t, err)
+	count, err = w.ZUnionStore("u", "z", "z2")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, count)
+	count, err = w.ZInterStore("i", "z", "z2")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+	withScores, err = w.ZRangeWithScores("i", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []redis.ZMember{{Member: "b", Score: 7}}, withScores)
+
+	popped, err := w.ZPopMin("u", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []redis.ZMember{{Member: "c", Score: 3}}, popped)
+	popped, err = w.ZPopMax("u", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []redis.ZMember{{Member: "a", Score: 11}}, popped)
+
+	count, err = w.ZRemRangeByScore("z", "-inf", "2")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+	count, err = w.ZRemRangeByRank("z", 0, 0)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+	card, err := w.ZCard("z")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, card)
+}
+
+func TestBitmap(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+
+	old, err := w.SetBit("a", 7, true)
+	assert.NoError(t, err)
+	assert.False(t, old)
+	_, err = w.SetBit("a", 9, true)
+	assert.NoError(t, err)
+	assert.True(t, srv.Exists(testPrefix+":a"))
+	v, err := w.GetBit("a", 9)
+	assert.NoError(t, err)
+	assert.True(t, v)
+
+	n, err := w.BitCount("a")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, n)
+	n, err = w.BitCountRange("a", -1, -1)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, n)
+	pos, err := w.BitPos("a", true)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 7, pos)
+	pos, err = w.BitPos("a", false)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, pos)
+
+	_, err = w.SetBit("b", 7, true)
+	assert.NoError(t, err)
+	n, err = w.BitOp("AND", "and", "a", "b")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, n)
+	assert.True(t, srv.Exists(testPrefix+":and"))
+	n, err = w.BitCount("and")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, n)
+}
+
+func TestHyperLogLog(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+
+	changed, err := w.PFAdd("a", "x", "y", "z")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = w.PFAdd("a", "x")
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	_, err = w.PFAdd("b", "z", "w")
+	assert.NoError(t, err)
+
+	n, err := w.PFCount("a")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, n)
+	n, err = w.PFCount("a", "b")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, n)
+
+	assert.NoError(t, w.PFMerge("ab", "a", "b"))
+	assert.True(t, srv.Exists(testPrefix+":ab"))
+	n, err = w.PFCount("ab")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, n)
+}
+
+func TestGeo(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+
+	n, err := w.GeoAdd("g",
+		redis.GeoLocation{Name: "Palermo", Longitude: 13.361389, Latitude: 38.115556},
+		redis.GeoLocation{Name: "Catania", Longitude: 15.087269, Latitude: 37.502669})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, n)
+	assert.True(t, srv.Exists(testPrefix+":g"))
+
+	dist, err := w.GeoDist("g", "Palermo", "Catania", redis.GeoKilometers)
+	assert.NoError(t, err)
+	assert.InDelta(t, 166.2742, dist, 0.001)
+	_, err = w.GeoDist("g", "Palermo", "Rome", redis.GeoKilometers)
+	assert.True(t, redis.IsNil(err))
+
+	pos, err := w.GeoPos("g", "Palermo", "Rome")
+	assert.NoError(t, err)
+	if assert.Len(t, pos, 2) && assert.NotNil(t, pos[0]) {
+		assert.InDelta(t, 13.361389, pos[0].Longitude, 1e-5)
+		assert.InDelta(t, 38.115556, pos[0].Latitude, 1e-5)
+		assert.Nil(t, pos[1])
+	}
+
+	locations, err := w.GeoRadius("g", 15, 37, 200, redis.GeoKilometers, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, locations, 2) {
+		assert.Equal(t, "Catania", locations[0].Name)
+		assert.InDelta(t, 56.4413, locations[0].Dist, 0.001)
+		assert.Equal(t, "Palermo", locations[1].Name)
+	}
+	locations, err = w.GeoRadiusByMember("g", "Palermo", 100, redis.GeoKilometers, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, locations, 1) {
+		assert.Equal(t, "Palermo", locations[0].Name)
+		assert.Equal(t, 0.0, locations[0].Dist)
+	}
+}
@@ -1,11 +1,45 @@
 package redis
 
-import "time"
+import (
+	"crypto/tls"
+	"time"
+)
 
 type Config struct {
 	RedisAddr         string
-	RedisPassword     string
+	RedisUsername     string // redis 6+ ACL用户名,为空时只用密码AUTH
+	RedisPassword     string // 为空时不发送AUTH
+	RedisDB           int
+	RedisDialTimeout  time.Duration `default:"3s"`
 	RedisReadTimeout  time.Duration `default:"3s"`
 	RedisWriteTimeout time.Duration `default:"3s"`
-	RedisMaxIdl       int           `default:"128"`
+
+	RedisMaxIdl          int           `default:"128"`
+	RedisMaxActive       int           // 最大连接数, 0表示不限制
+	RedisWait            bool          // 连接数达到RedisMaxActive时等待空闲连接,否则直接返回错误
+	RedisIdleTimeout     time.Duration `default:"5m"` // 空闲超过该时间的连接会被关闭, 负数表示不关闭(0会被替换为默认值)
+	RedisMaxConnLifetime time.Duration // 连接最长使用时间, 0表示不限制
+
+	// 空闲超过该时间的连接借出前先PING一次, 负数表示不检查。
+	// 0会被替换为默认值, 需要每次借出都PING时设为1ns
+	RedisTestOnBorrowAfter time.Duration `default:"1m"`
+	// 后台定期PING并记录连接池状态, 0表示不开启
+	RedisHealthCheckInterval time.Duration
+	// 执行超过该时间的命令用logx记录慢日志, 0表示不记录
+	RedisSlowThreshold time.Duration
+	// 定期用logx记录连接池状态并通知PoolStatsHook, 0表示不开启
+	RedisPoolStatsInterval time.Duration
+
+	// 配置了RedisClusterAddrs时使用cluster模式,RedisAddr被忽略
+	RedisClusterAddrs []string
+
+	// 配置了RedisSentinelAddrs时通过sentinel发现master,RedisAddr被忽略
+	RedisSentinelAddrs        []string
+	RedisSentinelMasterName   string
+	RedisSentinelPassword     string
+	RedisSentinelPollInterval time.Duration `default:"5s"` // 除订阅+switch-master外,定期向sentinel确认master地址
+
+	RedisTLS           bool
+	RedisTLSSkipVerify bool
+	RedisTLSConfig     *tls.Config `json:"-"` // 自定义证书等,为空时使用默认配置
 }
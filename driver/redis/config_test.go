@@ -0,0 +1,155 @@
+package redis_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ziyoumeng/sdk/driver/redis"
+	"github.com/ziyoumeng/sdk/driver/redis/redistest"
+)
+
+func newConfigServer(t *testing.T) *redistest.Server {
+	srv := redistest.NewServer()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newConfigWrapper(t *testing.T, conf redis.Config) (*redis.Wrapper, error) {
+	w, err := redis.NewWrapper(conf, testPrefix)
+	if err == nil {
+		t.Cleanup(func() { w.Close() })
+	}
+	return w, err
+}
+
+func TestConfigAuth(t *testing.T) {
+	// 没有密码时不发送AUTH, 否则没有设置密码的redis会返回错误
+	srv := newConfigServer(t)
+	w, err := newConfigWrapper(t, redis.Config{RedisAddr: srv.Addr()})
+	if assert.NoError(t, err) {
+		assert.NoError(t, w.Ping())
+	}
+
+	srv = newConfigServer(t)
+	srv.SetPassword("secret")
+	w, err = newConfigWrapper(t, redis.Config{RedisAddr: srv.Addr(), RedisPassword: "secret"})
+	if assert.NoError(t, err) {
+		assert.NoError(t, w.Ping())
+	}
+	_, err = newConfigWrapper(t, redis.Config{RedisAddr: srv.Addr(), RedisPassword: "wrong"})
+	assert.Error(t, err)
+	_, err = newConfigWrapper(t, redis.Config{RedisAddr: srv.Addr()})
+	assert.Error(t, err)
+
+	// ACL用户
+	srv = newConfigServer(t)
+	srv.SetUser("app", "secret")
+	w, err = newConfigWrapper(t, redis.Config{RedisAddr: srv.Addr(), RedisUsername: "app", RedisPassword: "secret"})
+	if assert.NoError(t, err) {
+		assert.NoError(t, w.Ping())
+	}
+	_, err = newConfigWrapper(t, redis.Config{RedisAddr: srv.Addr(), RedisUsername: "other", RedisPassword: "secret"})
+	assert.Error(t, err)
+	_, err = newConfigWrapper(t, redis.Config{RedisAddr: srv.Addr(), RedisPassword: "secret"})
+	assert.Error(t, err)
+	_, err = newConfigWrapper(t, redis.Config{RedisAddr: srv.Addr(), RedisUsername: "app"})
+	assert.Error(t, err)
+}
+
+func TestConfigDB(t *testing.T) {
+	srv := newConfigServer(t)
+	srv.SetDatabases(16)
+
+	w, err := newConfigWrapper(t, redis.Config{RedisAddr: srv.Addr(), RedisDB: 3})
+	if assert.NoError(t, err) {
+		assert.NoError(t, w.Ping())
+		assert.Equal(t, []int{3}, srv.SelectedDBs())
+	}
+	_, err = newConfigWrapper(t, redis.Config{RedisAddr: srv.Addr(), RedisDB: 16})
+	assert.Error(t, err)
+}
+
+func TestConfigPool(t *testing.T) {
+	srv := newConfigServer(t)
+
+	// 空闲连接借出前PING, 失效的连接被丢弃后重新建立
+	w, err := newConfigWrapper(t, redis.Config{RedisAddr: srv.Addr(), RedisTestOnBorrowAfter: time.Nanosecond})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, w.Ping())
+	srv.DropClients()
+	assert.NoError(t, w.Ping())
+
+	// 关闭借出检查时直接使用失效的连接
+	w, err = newConfigWrapper(t, redis.Config{RedisAddr: srv.Addr(), RedisTestOnBorrowAfter: -1})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, w.Ping())
+	srv.DropClients()
+	assert.Error(t, w.Ping())
+	assert.NoError(t, w.Ping())
+
+	// 空闲超时关闭连接, 负数时一直复用
+	for _, idle := range []time.Duration{time.Millisecond, -1} {
+		w, err = newConfigWrapper(t, redis.Config{RedisAddr: srv.Addr(), RedisIdleTimeout: idle, RedisTestOnBorrowAfter: -1})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NoError(t, w.Ping())
+		before := srv.Connections()
+		time.Sleep(10 * time.Millisecond)
+		assert.NoError(t, w.Ping())
+		if idle > 0 {
+			assert.Equal(t, before+1, srv.Connections())
+		} else {
+			assert.Equal(t, before, srv.Connections())
+		}
+		assert.Equal(t, 1, w.Stats().ActiveCount)
+		assert.Equal(t, 1, w.Stats().IdleCount)
+	}
+}
+
+type poolStatsHook struct {
+	stats chan redis.PoolStats
+}
+
+func (h poolStatsHook) AfterCommand(context.Context, redis.CommandInfo) {}
+
+func (h poolStatsHook) OnPoolStats(stats redis.PoolStats) {
+	select {
+	case h.stats <- stats:
+	default:
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	srv := newConfigServer(t)
+	w, err := newConfigWrapper(t, redis.Config{
+		RedisAddr:                srv.Addr(),
+		RedisHealthCheckInterval: 10 * time.Millisecond,
+		RedisPoolStatsInterval:   10 * time.Millisecond,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	hook := poolStatsHook{stats: make(chan redis.PoolStats, 1)}
+	w.AddHook(hook)
+
+	assert.NoError(t, w.Ping())
+	assert.True(t, w.Healthy())
+	select {
+	case stats := <-hook.stats:
+		assert.True(t, stats.ActiveCount >= 1)
+	case <-time.After(testWaitTimeout):
+		t.Fatal("timeout waiting pool stats")
+	}
+
+	srv.Close()
+	assert.Eventually(t, func() bool {
+		return !w.Healthy() && w.LastHealthError() != nil
+	}, testWaitTimeout, 10*time.Millisecond)
+}
@@ -0,0 +1,143 @@
+package redis
+
+import (
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// GeoUnit 距离单位
+type GeoUnit string
+
+const (
+	GeoMeters     GeoUnit = "m"
+	GeoKilometers GeoUnit = "km"
+	GeoMiles      GeoUnit = "mi"
+	GeoFeet       GeoUnit = "ft"
+)
+
+// GeoLocation 成员和经纬度, 查询附近时Dist为到中心点的距离
+type GeoLocation struct {
+	Name      string
+	Longitude float64
+	Latitude  float64
+	Dist      float64
+}
+
+// GeoAdd 返回新加入的成员数
+func (w *Wrapper) GeoAdd(key string, locations ...GeoLocation) (n int64, err error) {
+	if len(locations) == 0 {
+		return 0, errors.New("invalid locations num")
+	}
+	args := make([]interface{}, 0, 3*len(locations))
+	for _, l := range locations {
+		args = append(args, l.Longitude, l.Latitude, l.Name)
+	}
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt64(w.do(conn, "GEOADD", w.keyArgs(key, args...)...))
+	})
+	return
+}
+
+// GeoPos 返回值与members一一对应,不存在的成员为nil
+func (w *Wrapper) GeoPos(key string, members ...string) (ret []*GeoLocation, err error) {
+	if len(members) == 0 {
+		return nil, errors.New("invalid members num")
+	}
+	args := make([]interface{}, len(members))
+	for i, m := range members {
+		args[i] = m
+	}
+	w.Wrap(func(conn redigo.Conn) {
+		var values []interface{}
+		values, err = replyValues(w.do(conn, "GEOPOS", w.keyArgs(key, args...)...))
+		if err != nil {
+			return
+		}
+		ret = make([]*GeoLocation, len(values))
+		for i, v := range values {
+			if v == nil {
+				continue
+			}
+			var pos []float64
+			if pos, err = replyFloat64s(v, nil); err != nil {
+				return
+			}
+			if len(pos) != 2 {
+				err = errors.Errorf("unexpected GEOPOS reply length %d", len(pos))
+				return
+			}
+			ret[i] = &GeoLocation{Name: members[i], Longitude: pos[0], Latitude: pos[1]}
+		}
+	})
+	return
+}
+
+// GeoDist 两个成员的距离, 任一成员不存在时返回ErrNil
+func (w *Wrapper) GeoDist(key, member1, member2 string, unit GeoUnit) (dist float64, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		dist, err = replyFloat64(w.do(conn, "GEODIST", w.WithPrefix(key), member1, member2, string(unit)))
+	})
+	return
+}
+
+// GeoRadius 返回中心点radius范围内的成员,按距离从近到远, count<=0时不限制个数
+func (w *Wrapper) GeoRadius(key string, longitude, latitude, radius float64, unit GeoUnit, count int64) (ret []GeoLocation, err error) {
+	args := geoRadiusArgs(w.WithPrefix(key), []interface{}{longitude, latitude}, radius, unit, count)
+	w.Wrap(func(conn redigo.Conn) {
+		ret, err = geoLocations(w.do(conn, "GEORADIUS", args...))
+	})
+	return
+}
+
+// GeoRadiusByMember 以成员为中心查询, 包括成员自己
+func (w *Wrapper) GeoRadiusByMember(key, member string, radius float64, unit GeoUnit, count int64) (ret []GeoLocation, err error) {
+	args := geoRadiusArgs(w.WithPrefix(key), []interface{}{member}, radius, unit, count)
+	w.Wrap(func(conn redigo.Conn) {
+		ret, err = geoLocations(w.do(conn, "GEORADIUSBYMEMBER", args...))
+	})
+	return
+}
+
+func geoRadiusArgs(key string, center []interface{}, radius float64, unit GeoUnit, count int64) []interface{} {
+	args := append([]interface{}{key}, center...)
+	args = append(args, radius, string(unit), "WITHCOORD", "WITHDIST")
+	if count > 0 {
+		args = append(args, "COUNT", count)
+	}
+	return append(args, "ASC")
+}
+
+// geoLocations 解析WITHCOORD WITHDIST的回复: [[name, dist, [lon, lat]], ...]
+func geoLocations(reply interface{}, err error) ([]GeoLocation, error) {
+	values, err := replyValues(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]GeoLocation, 0, len(values))
+	for _, v := range values {
+		fields, err := replyValues(v, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) != 3 {
+			return nil, errors.Errorf("unexpected GEORADIUS item length %d", len(fields))
+		}
+		name, err := replyString(fields[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		dist, err := replyFloat64(fields[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		pos, err := replyFloat64s(fields[2], nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(pos) != 2 {
+			return nil, errors.Errorf("unexpected GEORADIUS coord length %d", len(pos))
+		}
+		ret = append(ret, GeoLocation{Name: name, Longitude: pos[0], Latitude: pos[1], Dist: dist})
+	}
+	return ret, nil
+}
@@ -0,0 +1,109 @@
+package redis
+
+import (
+	"sort"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// HSet 返回新增的field数
+func (w *Wrapper) HSet(key, field string, value interface{}) (n int, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt(w.do(conn, "HSET", w.WithPrefix(key), field, value))
+	})
+	return
+}
+
+// HSetMap 一次写入多个field, 返回新增的field数
+func (w *Wrapper) HSetMap(key string, values map[string]interface{}) (n int, err error) {
+	if len(values) == 0 {
+		return 0, errors.New("empty values")
+	}
+	fields := make([]string, 0, len(values))
+	for field := range values {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	args := make([]interface{}, 0, 2*len(values))
+	for _, field := range fields {
+		args = append(args, field, values[field])
+	}
+
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt(w.do(conn, "HSET", w.keyArgs(key, args...)...))
+	})
+	return
+}
+
+// HSetNX field已存在时不写入,返回false
+func (w *Wrapper) HSetNX(key, field string, value interface{}) (ok bool, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		ok, err = replyBool(w.do(conn, "HSETNX", w.WithPrefix(key), field, value))
+	})
+	return
+}
+
+func (w *Wrapper) HExists(key, field string) (ok bool, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		ok, err = replyBool(w.do(conn, "HEXISTS", w.WithPrefix(key), field))
+	})
+	return
+}
+
+func (w *Wrapper) HLen(key string) (n int64, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt64(w.do(conn, "HLEN", w.WithPrefix(key)))
+	})
+	return
+}
+
+func (w *Wrapper) HKeys(key string) (fields []string, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		fields, err = replyStrings(w.do(conn, "HKEYS", w.WithPrefix(key)))
+	})
+	return
+}
+
+func (w *Wrapper) HValsBytes(key string) (values [][]byte, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		values, err = replyByteSlices(w.do(conn, "HVALS", w.WithPrefix(key)))
+	})
+	return
+}
+
+// HGetAllBytesMap field=>value
+func (w *Wrapper) HGetAllBytesMap(key string) (ret map[string][]byte, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		var values [][]byte
+		values, err = replyByteSlices(w.do(conn, "HGETALL", w.WithPrefix(key)))
+		if err != nil {
+			return
+		}
+		if len(values)%2 != 0 {
+			err = errors.New("expects even number of values result")
+			return
+		}
+		ret = make(map[string][]byte, len(values)/2)
+		for i := 0; i < len(values); i += 2 {
+			ret[string(values[i])] = values[i+1]
+		}
+	})
+	return
+}
+
+// HIncrByInt64 返回增加后的值
+func (w *Wrapper) HIncrByInt64(key, field string, num int64) (ret int64, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		ret, err = replyInt64(w.do(conn, "HINCRBY", w.WithPrefix(key), field, num))
+	})
+	return
+}
+
+// HIncrByFloat 返回增加后的值
+func (w *Wrapper) HIncrByFloat(key, field string, num float64) (ret float64, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		ret, err = replyFloat64(w.do(conn, "HINCRBYFLOAT", w.WithPrefix(key), field, num))
+	})
+	return
+}
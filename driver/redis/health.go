@@ -0,0 +1,95 @@
+package redis
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+// PoolStats 连接池状态
+type PoolStats struct {
+	ActiveCount  int           // 已建立的连接数(含借出和空闲)
+	IdleCount    int           // 空闲连接数
+	WaitCount    int64         // 累计等待空闲连接的次数
+	WaitDuration time.Duration // 累计等待时间
+}
+
+type healthChecker struct {
+	healthy  int32
+	lastErr  atomic.Value // errorHolder
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type errorHolder struct {
+	err error
+}
+
+func newHealthChecker() *healthChecker {
+	return &healthChecker{
+		healthy: 1,
+		stop:    make(chan struct{}),
+	}
+}
+
+func (h *healthChecker) run(w *Wrapper, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := w.Ping()
+			h.set(err)
+			stats := w.Stats()
+			if err != nil {
+				logx.Errorf("redis health check failed: %s, stats: %+v", err, stats)
+			}
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+func (h *healthChecker) set(err error) {
+	h.lastErr.Store(errorHolder{err: err})
+	if err != nil {
+		atomic.StoreInt32(&h.healthy, 0)
+	} else {
+		atomic.StoreInt32(&h.healthy, 1)
+	}
+}
+
+// Ping 发送PING检查redis是否可用
+func (w *Wrapper) Ping() (err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		_, err = w.do(conn, "PING")
+	})
+	return
+}
+
+// Healthy 最近一次健康检查的结果,未开启RedisHealthCheckInterval时总是返回true
+func (w *Wrapper) Healthy() bool {
+	return atomic.LoadInt32(&w.health.healthy) == 1
+}
+
+// LastHealthError 最近一次健康检查的错误
+func (w *Wrapper) LastHealthError() error {
+	holder, _ := w.health.lastErr.Load().(errorHolder)
+	return holder.err
+}
+
+// Stats 连接池状态,sentinel为当前master的连接池,cluster为所有节点之和
+func (w *Wrapper) Stats() PoolStats {
+	return w.source.Stats()
+}
+
+// Close 停止健康检查并关闭连接池,WithContext得到的Wrapper共享同一个连接池
+func (w *Wrapper) Close() error {
+	w.health.stopOnce.Do(func() {
+		close(w.health.stop)
+	})
+	return w.source.Close()
+}
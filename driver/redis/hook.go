@@ -0,0 +1,185 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+// CommandInfo 一条命令的执行情况
+type CommandInfo struct {
+	Command   string        // 大写的命令名
+	Key       string        // 已加前缀的key, 没有key的命令为空
+	Duration  time.Duration // 从发出到收到回复, pipeline/事务中的命令为整批的耗时
+	Err       error         // 连接、超时或redis返回的错误, key不存在(ErrNil)不算错误
+	Pipelined bool          // 是否和其他命令在同一次往返中发送(Pipeline、Tx)
+}
+
+// Hook 通过Wrap/ExecRedisCommand执行的每条命令完成后调用。
+// 在执行命令的goroutine中同步调用, 需要并发安全且尽快返回。
+// 用Send发送后自己Receive读取的命令不会统计
+type Hook interface {
+	AfterCommand(ctx context.Context, info CommandInfo)
+}
+
+// PoolStatsHook Hook可以选择实现, 开启RedisPoolStatsInterval后定期收到连接池状态
+type PoolStatsHook interface {
+	OnPoolStats(stats PoolStats)
+}
+
+// HookFunc 函数形式的Hook
+type HookFunc func(ctx context.Context, info CommandInfo)
+
+func (f HookFunc) AfterCommand(ctx context.Context, info CommandInfo) {
+	f(ctx, info)
+}
+
+// hookRegistry Wrapper上注册的Hook, WithContext得到的Wrapper共用
+type hookRegistry struct {
+	mu    sync.RWMutex
+	hooks []Hook
+}
+
+func (r *hookRegistry) add(hooks ...Hook) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// 复制一份, 已取出的切片不受影响
+	r.hooks = append(append([]Hook(nil), r.hooks...), hooks...)
+}
+
+func (r *hookRegistry) get() []Hook {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.hooks
+}
+
+// AddHook 注册Hook, 对之后执行的命令生效
+func (w *Wrapper) AddHook(hooks ...Hook) {
+	w.hooks.add(hooks...)
+}
+
+// instrument 有Hook时包装连接, 在命令完成后调用Hook
+func (w *Wrapper) instrument(conn redigo.Conn) redigo.Conn {
+	hooks := w.hooks.get()
+	if len(hooks) == 0 {
+		return conn
+	}
+	return &hookConn{Conn: conn, ctx: w.Context(), hooks: hooks}
+}
+
+// hookConn 记录Send排队的命令, 在Do收到回复后一起上报
+type hookConn struct {
+	redigo.Conn
+	ctx     context.Context
+	hooks   []Hook
+	pending []CommandInfo
+}
+
+func (c *hookConn) Send(command string, args ...interface{}) error {
+	if command != "" {
+		c.pending = append(c.pending, newCommandInfo(command, args))
+	}
+	return c.Conn.Send(command, args...)
+}
+
+func (c *hookConn) Do(command string, args ...interface{}) (interface{}, error) {
+	start := time.Now()
+	reply, err := c.Conn.Do(command, args...)
+	c.report(c.ctx, start, command, args, reply, err)
+	return reply, err
+}
+
+func (c *hookConn) DoContext(ctx context.Context, command string, args ...interface{}) (interface{}, error) {
+	start := time.Now()
+	reply, err := redigo.DoContext(c.Conn, ctx, command, args...)
+	c.report(ctx, start, command, args, reply, err)
+	return reply, err
+}
+
+func (c *hookConn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	return redigo.ReceiveContext(c.Conn, ctx)
+}
+
+func (c *hookConn) report(ctx context.Context, start time.Time, command string, args []interface{}, reply interface{}, err error) {
+	duration := time.Since(start)
+	infos := c.pending
+	c.pending = nil
+	if command != "" {
+		infos = append(infos, newCommandInfo(command, args))
+	}
+	// Do("")时回复是排队命令的回复数组, 逐条取出redis返回的错误
+	replies, _ := reply.([]interface{})
+	for i := range infos {
+		info := &infos[i]
+		info.Duration = duration
+		info.Pipelined = len(infos) > 1
+		info.Err = err
+		if command == "" && err == nil && i < len(replies) {
+			if e, ok := replies[i].(redigo.Error); ok {
+				info.Err = e
+			}
+		}
+		if IsNil(info.Err) {
+			info.Err = nil
+		}
+		for _, hook := range c.hooks {
+			hook.AfterCommand(ctx, *info)
+		}
+	}
+}
+
+func newCommandInfo(command string, args []interface{}) CommandInfo {
+	command = strings.ToUpper(command)
+	key, _ := commandKey(command, args)
+	return CommandInfo{Command: command, Key: key}
+}
+
+// slowLogHook 超过阈值的命令用logx记录慢日志
+type slowLogHook struct {
+	threshold time.Duration
+}
+
+// NewSlowLogHook 执行时间超过threshold的命令用logx.Slow记录, 配置RedisSlowThreshold时自动注册
+func NewSlowLogHook(threshold time.Duration) Hook {
+	return slowLogHook{threshold: threshold}
+}
+
+func (h slowLogHook) AfterCommand(ctx context.Context, info CommandInfo) {
+	if info.Duration < h.threshold {
+		return
+	}
+	fields := []logx.LogField{
+		logx.Field("command", info.Command),
+		logx.Field("key", info.Key),
+		logx.Field("pipelined", info.Pipelined),
+	}
+	if info.Err != nil {
+		fields = append(fields, logx.Field("err", info.Err.Error()))
+	}
+	logx.WithContext(ctx).WithDuration(info.Duration).Sloww("slow redis command", fields...)
+}
+
+// runPoolStats 定期记录连接池状态并通知实现了PoolStatsHook的Hook
+func (w *Wrapper) runPoolStats(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			stats := w.Stats()
+			logx.Statf("redis pool %s - active: %d, idle: %d, wait: %d, wait duration: %s",
+				w.prefix, stats.ActiveCount, stats.IdleCount, stats.WaitCount, stats.WaitDuration)
+			for _, hook := range w.hooks.get() {
+				if h, ok := hook.(PoolStatsHook); ok {
+					h.OnPoolStats(stats)
+				}
+			}
+		case <-w.health.stop:
+			return
+		}
+	}
+}
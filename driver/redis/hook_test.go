@@ -0,0 +1,99 @@
+package redis_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ziyoumeng/sdk/driver/redis"
+)
+
+// testHook 记录收到的CommandInfo
+type testHook struct {
+	mu    sync.Mutex
+	infos []redis.CommandInfo
+}
+
+func (h *testHook) AfterCommand(ctx context.Context, info redis.CommandInfo) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.infos = append(h.infos, info)
+}
+
+func (h *testHook) take() []redis.CommandInfo {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	infos := h.infos
+	h.infos = nil
+	return infos
+}
+
+func TestHook(t *testing.T) {
+	w, _, _ := getWrapper(t)
+	hook := &testHook{}
+	w.AddHook(hook)
+
+	assert.NoError(t, w.Set("k", "v"))
+	_, err := w.HGetAll("k")
+	assert.Error(t, err)
+	_, err = w.GetString("not-exist")
+	assert.True(t, redis.IsNil(err))
+
+	infos := hook.take()
+	if assert.Len(t, infos, 3) {
+		assert.Equal(t, "SET", infos[0].Command)
+		assert.Equal(t, testPrefix+":k", infos[0].Key)
+		assert.NoError(t, infos[0].Err)
+		assert.False(t, infos[0].Pipelined)
+
+		assert.Equal(t, "HGETALL", infos[1].Command)
+		assert.Error(t, infos[1].Err)
+
+		assert.Equal(t, "GET", infos[2].Command)
+		assert.NoError(t, infos[2].Err)
+	}
+
+	p := w.Pipeline()
+	p.Set("a", 1)
+	p.HGetAll("a")
+	assert.Error(t, p.Exec())
+
+	infos = hook.take()
+	if assert.Len(t, infos, 2) {
+		assert.Equal(t, "SET", infos[0].Command)
+		assert.True(t, infos[0].Pipelined)
+		assert.NoError(t, infos[0].Err)
+		assert.Equal(t, "HGETALL", infos[1].Command)
+		assert.Equal(t, testPrefix+":a", infos[1].Key)
+		assert.Error(t, infos[1].Err)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	w, _, _ := getWrapper(t)
+	m := redis.NewMetrics()
+	w.AddHook(m)
+
+	assert.NoError(t, w.Set("k", "v"))
+	assert.NoError(t, w.Set("k", "v"))
+	_, err := w.HGetAll("k")
+	assert.Error(t, err)
+	m.OnPoolStats(w.Stats())
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		`redis_commands_total{command="SET"} 2`,
+		`redis_command_errors_total{command="SET"} 0`,
+		`redis_command_errors_total{command="HGETALL"} 1`,
+		`redis_command_duration_seconds_bucket{command="SET",le="+Inf"} 2`,
+		`redis_command_duration_seconds_count{command="HGETALL"} 1`,
+		"# TYPE redis_pool_active_connections gauge",
+	} {
+		assert.True(t, strings.Contains(body, line+"\n"), "missing %q in\n%s", line, body)
+	}
+}
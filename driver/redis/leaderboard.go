@@ -0,0 +1,393 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	LeaderboardSubmitScriptName = "leaderboard_submit"
+
+	// ErrLeaderboardExpired 提交到已过保留期的周期榜, 写入后会被立即删除
+	ErrLeaderboardExpired = errors.New("leaderboard period expired")
+)
+
+func init() {
+	AddScript(NewLuaScript(LeaderboardSubmitScriptName, leaderboardSubmitScript, 1))
+}
+
+// 提交分数, zset中存的是编码后的分数: score*factor + 时间部分
+// KEYS[1] 榜单zset
+// ARGV[1] 成员 ARGV[2] 分数 ARGV[3] 策略best/latest/sum ARGV[4] factor ARGV[5] 时间部分
+// ARGV[6] 1为分数越小越好 ARGV[7] 过期时间戳(毫秒),0为不过期
+// 返回 {是否更新, 更新后的分数}
+var leaderboardSubmitScript = `
+local score, factor, tpart = tonumber(ARGV[2]), tonumber(ARGV[4]), tonumber(ARGV[5]);
+local policy, asc = ARGV[3], ARGV[6] == '1';
+local cur = redis.call('zscore', KEYS[1], ARGV[1]);
+if cur then
+	local old = math.floor(tonumber(cur) / factor);
+	if policy == 'sum' then
+		score = old + score;
+	elseif policy == 'best' then
+		if (asc and score >= old) or (not asc and score <= old) then
+			return {0, old};
+		end;
+	end;
+end;
+redis.call('zadd', KEYS[1], string.format('%.17g', score * factor + tpart), ARGV[1]);
+local expireAt = tonumber(ARGV[7]);
+if expireAt > 0 and redis.call('pttl', KEYS[1]) == -1 then
+	redis.call('pexpireat', KEYS[1], expireAt);
+end;
+return {1, score};
+`
+
+// LeaderboardPolicy 同一成员多次提交时的处理
+type LeaderboardPolicy int
+
+const (
+	LeaderboardBest   LeaderboardPolicy = iota // 保留最好成绩
+	LeaderboardLatest                          // 保留最后一次
+	LeaderboardSum                             // 累加
+)
+
+func (p LeaderboardPolicy) String() string {
+	switch p {
+	case LeaderboardLatest:
+		return "latest"
+	case LeaderboardSum:
+		return "sum"
+	default:
+		return "best"
+	}
+}
+
+// LeaderboardPeriod 周期榜, 每个周期一个zset
+type LeaderboardPeriod int
+
+const (
+	LeaderboardAllTime LeaderboardPeriod = iota
+	LeaderboardDaily
+	LeaderboardWeekly
+)
+
+// 总榜的同分排序时间从这里开始计算,30位秒数可以用到2054年
+var leaderboardEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const leaderboardAllTimeBits = 30
+
+type leaderboardOption struct {
+	policy    LeaderboardPolicy
+	ascending bool
+	tieBreak  bool
+	period    LeaderboardPeriod
+	retention time.Duration
+	location  *time.Location
+}
+
+type LeaderboardOption func(o *leaderboardOption)
+
+// WithLeaderboardPolicy 默认LeaderboardBest
+func WithLeaderboardPolicy(policy LeaderboardPolicy) LeaderboardOption {
+	return func(o *leaderboardOption) {
+		o.policy = policy
+	}
+}
+
+// WithLeaderboardAscending 分数越小排名越靠前,如通关用时
+func WithLeaderboardAscending() LeaderboardOption {
+	return func(o *leaderboardOption) {
+		o.ascending = true
+	}
+}
+
+// WithLeaderboardTieBreak 同分时先达到的排名靠前, 提交时间(秒)编码在zset分数的低位,
+// 分数的绝对值上限: 总榜2^23, 周榜2^33, 日榜2^36
+func WithLeaderboardTieBreak() LeaderboardOption {
+	return func(o *leaderboardOption) {
+		o.tieBreak = true
+	}
+}
+
+// WithLeaderboardPeriod 日榜/周榜,周期结束后再保留retention后自动过期,retention<=0时保留一个周期。
+// 周一为一周的开始
+func WithLeaderboardPeriod(period LeaderboardPeriod, retention time.Duration) LeaderboardOption {
+	return func(o *leaderboardOption) {
+		o.period = period
+		o.retention = retention
+	}
+}
+
+// WithLeaderboardLocation 划分周期使用的时区,默认time.Local
+func WithLeaderboardLocation(loc *time.Location) LeaderboardOption {
+	return func(o *leaderboardOption) {
+		o.location = loc
+	}
+}
+
+// LeaderboardEntry 榜单中的一项, Rank从1开始
+type LeaderboardEntry struct {
+	Member string
+	Score  int64
+	Rank   int64
+	Meta   []byte // SetMeta写入的数据,没有时为nil
+}
+
+// Leaderboard 基于zset的排行榜, 成员资料存在hash中。
+// 所有key都带{name}作为hash tag, cluster模式下落在同一slot:
+//
+//	leaderboard:{name}:all|日期|周   榜单zset
+//	leaderboard:{name}:meta          成员资料hash
+type Leaderboard struct {
+	w      *Wrapper
+	name   string
+	option leaderboardOption
+	at     time.Time // 非零时固定读写该时间所在的周期
+}
+
+func NewLeaderboard(w *Wrapper, name string, opts ...LeaderboardOption) *Leaderboard {
+	option := leaderboardOption{location: time.Local}
+	for _, opt := range opts {
+		opt(&option)
+	}
+	if option.location == nil {
+		option.location = time.Local
+	}
+	return &Leaderboard{w: w, name: name, option: option}
+}
+
+// At 返回t所在周期的榜单,用于查看上一期等; 总榜返回自身。
+// 周期结束并超过retention后,Submit返回ErrLeaderboardExpired
+func (l *Leaderboard) At(t time.Time) *Leaderboard {
+	if l.option.period == LeaderboardAllTime {
+		return l
+	}
+	board := *l
+	board.at = t
+	return &board
+}
+
+func (l *Leaderboard) wrapper(ctx context.Context) *Wrapper {
+	if ctx == nil {
+		return l.w
+	}
+	return l.w.WithContext(ctx)
+}
+
+func (l *Leaderboard) now() time.Time {
+	if !l.at.IsZero() {
+		return l.at
+	}
+	return time.Now()
+}
+
+// period 返回t所在周期的开始和结束, 总榜返回leaderboardEpoch和零值
+func (l *Leaderboard) period(t time.Time) (start, end time.Time) {
+	t = t.In(l.option.location)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, l.option.location)
+	switch l.option.period {
+	case LeaderboardDaily:
+		return day, day.AddDate(0, 0, 1)
+	case LeaderboardWeekly:
+		start = day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7)
+	default:
+		return leaderboardEpoch, time.Time{}
+	}
+}
+
+func (l *Leaderboard) boardKey(t time.Time) string {
+	start, _ := l.period(t)
+	var suffix string
+	switch l.option.period {
+	case LeaderboardDaily:
+		suffix = start.Format("20060102")
+	case LeaderboardWeekly:
+		year, week := start.ISOWeek()
+		suffix = fmt.Sprintf("%dW%02d", year, week)
+	default:
+		suffix = "all"
+	}
+	return fmt.Sprintf("leaderboard:{%s}:%s", l.name, suffix)
+}
+
+func (l *Leaderboard) metaKey() string {
+	return fmt.Sprintf("leaderboard:{%s}:meta", l.name)
+}
+
+// timeBits 编码提交时间用的位数, 需要容纳一个周期内的秒数
+func (l *Leaderboard) timeBits() uint {
+	if !l.option.tieBreak {
+		return 0
+	}
+	switch l.option.period {
+	case LeaderboardDaily:
+		return 17
+	case LeaderboardWeekly:
+		return 20
+	default:
+		return leaderboardAllTimeBits
+	}
+}
+
+func (l *Leaderboard) factor() float64 {
+	return float64(uint64(1) << l.timeBits())
+}
+
+func (l *Leaderboard) decode(encoded float64) int64 {
+	return int64(math.Floor(encoded / l.factor()))
+}
+
+// Submit 按策略提交分数, 返回是否更新和更新后的分数
+func (l *Leaderboard) Submit(ctx context.Context, member string, score int64) (updated bool, newScore int64, err error) {
+	now := l.now()
+	bits := l.timeBits()
+	if limit := int64(1) << (53 - bits); score >= limit || score <= -limit {
+		return false, 0, errors.Errorf("leaderboard score %d out of range", score)
+	}
+
+	start, end := l.period(now)
+	var tpart int64
+	if bits > 0 {
+		elapsed := int64(now.Sub(start) / time.Second)
+		if max := int64(1)<<bits - 1; elapsed > max {
+			elapsed = max
+		} else if elapsed < 0 {
+			elapsed = 0
+		}
+		// 越早提交越靠前
+		if l.option.ascending {
+			tpart = elapsed
+		} else {
+			tpart = int64(1)<<bits - 1 - elapsed
+		}
+	}
+	var expireAt int64
+	if !end.IsZero() {
+		retention := l.option.retention
+		if retention <= 0 {
+			retention = end.Sub(start)
+		}
+		deadline := end.Add(retention)
+		if !deadline.After(time.Now()) {
+			return false, 0, errors.WithMessage(ErrLeaderboardExpired, l.boardKey(now))
+		}
+		expireAt = deadline.UnixNano() / int64(time.Millisecond)
+	}
+	asc := 0
+	if l.option.ascending {
+		asc = 1
+	}
+
+	values, err := replyInt64s(l.wrapper(ctx).EvalSha(LeaderboardSubmitScriptName,
+		[]string{l.boardKey(now)},
+		[]interface{}{member, score, l.option.policy.String(), uint64(1) << bits, tpart, asc, expireAt}))
+	if err != nil {
+		return false, 0, errors.WithMessage(err, LeaderboardSubmitScriptName)
+	}
+	if len(values) != 2 {
+		return false, 0, errors.Errorf("%s: unexpected reply %v", LeaderboardSubmitScriptName, values)
+	}
+	return values[0] == 1, values[1], nil
+}
+
+// Score 成员不在榜单时返回ErrNil
+func (l *Leaderboard) Score(ctx context.Context, member string) (int64, error) {
+	encoded, err := l.wrapper(ctx).ZScoreFloat(l.boardKey(l.now()), member)
+	if err != nil {
+		return 0, err
+	}
+	return l.decode(encoded), nil
+}
+
+// Rank 从1开始, 成员不在榜单时返回ErrNil
+func (l *Leaderboard) Rank(ctx context.Context, member string) (int64, error) {
+	command := "ZREVRANK"
+	if l.option.ascending {
+		command = "ZRANK"
+	}
+	w := l.wrapper(ctx)
+	rank, err := replyInt64(w.ExecRedisCommand(command, w.WithPrefix(l.boardKey(l.now())), member))
+	if err != nil {
+		return 0, err
+	}
+	return rank + 1, nil
+}
+
+// Count 榜单成员数
+func (l *Leaderboard) Count(ctx context.Context) (int64, error) {
+	return l.wrapper(ctx).ZCard(l.boardKey(l.now()))
+}
+
+func (l *Leaderboard) Remove(ctx context.Context, member string) error {
+	_, err := l.wrapper(ctx).ZRem(l.boardKey(l.now()), member)
+	return err
+}
+
+// SetMeta 设置成员资料(昵称、头像等),所有周期共用
+func (l *Leaderboard) SetMeta(ctx context.Context, member string, meta []byte) error {
+	return l.wrapper(ctx).HSetBytes(l.metaKey(), member, meta)
+}
+
+// Top 第offset名之后的limit个成员(offset从0开始),带成员资料
+func (l *Leaderboard) Top(ctx context.Context, offset, limit int64) ([]LeaderboardEntry, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	return l.rangeEntries(ctx, offset, offset+limit-1)
+}
+
+// AroundMe 成员前后各n名, 成员不在榜单时返回ErrNil
+func (l *Leaderboard) AroundMe(ctx context.Context, member string, n int64) ([]LeaderboardEntry, error) {
+	rank, err := l.Rank(ctx, member)
+	if err != nil {
+		return nil, err
+	}
+	start := rank - 1 - n
+	if start < 0 {
+		start = 0
+	}
+	return l.rangeEntries(ctx, start, rank-1+n)
+}
+
+func (l *Leaderboard) rangeEntries(ctx context.Context, start, stop int64) ([]LeaderboardEntry, error) {
+	w := l.wrapper(ctx)
+	var members []ZMember
+	var err error
+	if l.option.ascending {
+		members, err = w.ZRangeWithScores(l.boardKey(l.now()), start, stop)
+	} else {
+		members, err = w.ZRevRangeWithScores(l.boardKey(l.now()), start, stop)
+	}
+	if err != nil {
+		return nil, errors.WithMessage(err, "zrange")
+	}
+	if len(members) == 0 {
+		return nil, nil
+	}
+
+	fields := make([]string, len(members))
+	for i, m := range members {
+		fields[i] = m.Member
+	}
+	metas, err := w.HMGetBytes(l.metaKey(), fields...)
+	if err != nil {
+		return nil, errors.WithMessage(err, "hmget meta")
+	}
+
+	entries := make([]LeaderboardEntry, len(members))
+	for i, m := range members {
+		entries[i] = LeaderboardEntry{
+			Member: m.Member,
+			Score:  l.decode(m.Score),
+			Rank:   start + int64(i) + 1,
+			Meta:   metas[i],
+		}
+	}
+	return entries, nil
+}
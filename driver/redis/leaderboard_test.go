@@ -0,0 +1,141 @@
+package redis_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/ziyoumeng/sdk/driver/redis"
+)
+
+func TestLeaderboardPolicy(t *testing.T) {
+	w, _, _ := getWrapper(t)
+	ctx := context.Background()
+
+	for _, c := range []struct {
+		name   string
+		opts   []redis.LeaderboardOption
+		submit []int64
+		want   []bool
+		score  int64
+	}{
+		{"best", nil, []int64{10, 5, 15}, []bool{true, false, true}, 15},
+		{"best-asc", []redis.LeaderboardOption{redis.WithLeaderboardAscending()}, []int64{10, 15, 5}, []bool{true, false, true}, 5},
+		{"latest", []redis.LeaderboardOption{redis.WithLeaderboardPolicy(redis.LeaderboardLatest)}, []int64{10, 5}, []bool{true, true}, 5},
+		{"sum", []redis.LeaderboardOption{redis.WithLeaderboardPolicy(redis.LeaderboardSum)}, []int64{10, 5, -3}, []bool{true, true, true}, 12},
+	} {
+		board := redis.NewLeaderboard(w, c.name, c.opts...)
+		for i, score := range c.submit {
+			updated, _, err := board.Submit(ctx, "m", score)
+			assert.NoError(t, err, c.name)
+			assert.Equal(t, c.want[i], updated, "%s submit %d", c.name, score)
+		}
+		score, err := board.Score(ctx, "m")
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, c.score, score, c.name)
+	}
+}
+
+func TestLeaderboardTieBreak(t *testing.T) {
+	w, _, _ := getWrapper(t)
+	ctx := context.Background()
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+
+	for _, c := range []struct {
+		name string
+		opts []redis.LeaderboardOption
+		want []string
+	}{
+		// 同分时先提交的靠前
+		{"desc", nil, []string{"c", "a", "b"}},
+		{"asc", []redis.LeaderboardOption{redis.WithLeaderboardAscending()}, []string{"a", "b", "c"}},
+	} {
+		opts := append([]redis.LeaderboardOption{
+			redis.WithLeaderboardTieBreak(),
+			redis.WithLeaderboardPeriod(redis.LeaderboardDaily, 0),
+			redis.WithLeaderboardLocation(time.UTC),
+		}, c.opts...)
+		board := redis.NewLeaderboard(w, c.name, opts...)
+		for i, s := range []struct {
+			member string
+			score  int64
+		}{{"a", 10}, {"b", 10}, {"c", 20}} {
+			_, _, err := board.At(day.Add(time.Duration(i+1)*time.Hour)).Submit(ctx, s.member, s.score)
+			assert.NoError(t, err, c.name)
+		}
+
+		entries, err := board.At(day).Top(ctx, 0, 10)
+		assert.NoError(t, err, c.name)
+		var members []string
+		for _, e := range entries {
+			members = append(members, e.Member)
+		}
+		assert.Equal(t, c.want, members, c.name)
+		score, err := board.At(day).Score(ctx, "c")
+		assert.NoError(t, err, c.name)
+		assert.EqualValues(t, 20, score, c.name)
+	}
+}
+
+func TestLeaderboardAroundMe(t *testing.T) {
+	w, _, _ := getWrapper(t)
+	ctx := context.Background()
+
+	board := redis.NewLeaderboard(w, "around")
+	for i := 1; i <= 10; i++ {
+		member := fmt.Sprintf("m%d", i)
+		_, _, err := board.Submit(ctx, member, int64(i))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, board.SetMeta(ctx, "m5", []byte("five")))
+
+	entries, err := board.AroundMe(ctx, "m5", 2)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 5) {
+		for i, e := range entries {
+			assert.Equal(t, fmt.Sprintf("m%d", 7-i), e.Member)
+			assert.EqualValues(t, 7-i, e.Score)
+			assert.EqualValues(t, 4+i, e.Rank)
+		}
+		assert.Equal(t, []byte("five"), entries[2].Meta)
+		assert.Nil(t, entries[0].Meta)
+	}
+
+	// 靠近榜首时不足n名
+	entries, err = board.AroundMe(ctx, "m10", 2)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, "m10", entries[0].Member)
+		assert.EqualValues(t, 1, entries[0].Rank)
+	}
+
+	_, err = board.AroundMe(ctx, "nobody", 2)
+	assert.True(t, redis.IsNil(err))
+}
+
+func TestLeaderboardExpired(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+	ctx := context.Background()
+
+	board := redis.NewLeaderboard(w, "daily",
+		redis.WithLeaderboardPeriod(redis.LeaderboardDaily, 0),
+		redis.WithLeaderboardLocation(time.UTC))
+	now := time.Now()
+
+	// 保留期已过的周期拒绝写入, 不会留下会被立即删除的key
+	_, _, err := board.At(now.AddDate(0, 0, -3)).Submit(ctx, "m", 1)
+	assert.Equal(t, redis.ErrLeaderboardExpired, errors.Cause(err))
+	assert.Empty(t, srv.Keys())
+
+	// 上一期仍在保留期内
+	updated, _, err := board.At(now.AddDate(0, 0, -1)).Submit(ctx, "m", 1)
+	assert.NoError(t, err)
+	assert.True(t, updated)
+	keys := srv.Keys()
+	if assert.Len(t, keys, 1) {
+		assert.True(t, srv.TTL(keys[0]) > 0)
+	}
+}
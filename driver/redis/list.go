@@ -0,0 +1,147 @@
+package redis
+
+import (
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// LPush 返回push后列表的长度
+func (w *Wrapper) LPush(key string, values ...interface{}) (n int64, err error) {
+	return w.push("LPUSH", key, values)
+}
+
+func (w *Wrapper) RPush(key string, values ...interface{}) (n int64, err error) {
+	return w.push("RPUSH", key, values)
+}
+
+// LPushX 列表不存在时不写入,返回0
+func (w *Wrapper) LPushX(key string, values ...interface{}) (n int64, err error) {
+	return w.push("LPUSHX", key, values)
+}
+
+func (w *Wrapper) RPushX(key string, values ...interface{}) (n int64, err error) {
+	return w.push("RPUSHX", key, values)
+}
+
+func (w *Wrapper) push(command, key string, values []interface{}) (n int64, err error) {
+	if len(values) == 0 {
+		return 0, errors.New("invalid values num")
+	}
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt64(w.do(conn, command, w.keyArgs(key, values...)...))
+	})
+	return
+}
+
+// LPopBytes 列表为空时返回ErrNil
+func (w *Wrapper) LPopBytes(key string) (value []byte, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		value, err = replyBytes(w.do(conn, "LPOP", w.WithPrefix(key)))
+	})
+	return
+}
+
+// RPopBytes 列表为空时返回ErrNil
+func (w *Wrapper) RPopBytes(key string) (value []byte, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		value, err = replyBytes(w.do(conn, "RPOP", w.WithPrefix(key)))
+	})
+	return
+}
+
+// BLPopBytes timeout秒内没有数据时返回ErrNil, timeout为0表示一直阻塞
+func (w *Wrapper) BLPopBytes(key string, timeout int64) (ret []byte, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		var values [][]byte
+		values, err = replyByteSlices(w.do(conn, "BLPOP", w.WithPrefix(key), timeout))
+		if err != nil {
+			return
+		}
+		if len(values) != 2 {
+			err = errors.Errorf("unexpected BLPOP reply length %d", len(values))
+			return
+		}
+		ret = values[1]
+	})
+	return
+}
+
+// LMoveBytes 从src的from端(LEFT/RIGHT)弹出并push到dst的to端, src为空时返回ErrNil
+func (w *Wrapper) LMoveBytes(src, dst, from, to string) (value []byte, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		value, err = replyBytes(w.do(conn, "LMOVE", w.WithPrefix(src), w.WithPrefix(dst), from, to))
+	})
+	return
+}
+
+func (w *Wrapper) LLen(key string) (n int64, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt64(w.do(conn, "LLEN", w.WithPrefix(key)))
+	})
+	return
+}
+
+// LRangeBytes 返回[start, stop]的元素, 下标可以为负数
+func (w *Wrapper) LRangeBytes(key string, start, stop int64) (values [][]byte, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		values, err = replyByteSlices(w.do(conn, "LRANGE", w.WithPrefix(key), start, stop))
+	})
+	return
+}
+
+func (w *Wrapper) LRangeStrings(key string, start, stop int64) (values []string, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		values, err = replyStrings(w.do(conn, "LRANGE", w.WithPrefix(key), start, stop))
+	})
+	return
+}
+
+func (w *Wrapper) LRangeInt64s(key string, start, stop int64) (values []int64, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		values, err = replyInt64s(w.do(conn, "LRANGE", w.WithPrefix(key), start, stop))
+	})
+	return
+}
+
+// LIndexBytes 下标越界时返回ErrNil
+func (w *Wrapper) LIndexBytes(key string, index int64) (value []byte, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		value, err = replyBytes(w.do(conn, "LINDEX", w.WithPrefix(key), index))
+	})
+	return
+}
+
+func (w *Wrapper) LSet(key string, index int64, value interface{}) (err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		_, err = w.do(conn, "LSET", w.WithPrefix(key), index, value)
+	})
+	return
+}
+
+// LRem count>0从头删除count个等于value的元素,count<0从尾部,count=0删除全部; 返回删除的个数
+func (w *Wrapper) LRem(key string, count int64, value interface{}) (n int64, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt64(w.do(conn, "LREM", w.WithPrefix(key), count, value))
+	})
+	return
+}
+
+// LTrim 只保留[start, stop]的元素
+func (w *Wrapper) LTrim(key string, start, stop int64) (err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		_, err = w.do(conn, "LTRIM", w.WithPrefix(key), start, stop)
+	})
+	return
+}
+
+// LInsert before为true时插到pivot前面,否则插到后面; 返回插入后的长度, pivot不存在时返回-1
+func (w *Wrapper) LInsert(key string, before bool, pivot, value interface{}) (n int64, err error) {
+	where := "AFTER"
+	if before {
+		where = "BEFORE"
+	}
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt64(w.do(conn, "LINSERT", w.WithPrefix(key), where, pivot, value))
+	})
+	return
+}
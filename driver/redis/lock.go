@@ -1,86 +1,394 @@
 package redis
 
 import (
-	"fmt"
-	redigo "github.com/garyburd/redigo/redis"
-	"github.com/pkg/errors"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	mrand "math/rand"
+	"sync"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
-var LockScriptName = "lock"
+var (
+	LockScriptName       = "lock"
+	FencedLockScriptName = "lock_fenced"
+	UnlockScriptName     = "unlock"
+	ExtendLockScriptName = "lock_extend"
+	RaiseFenceScriptName = "fence_raise"
+
+	// ErrLockNotHeld 锁已过期或被其他owner持有
+	ErrLockNotHeld = errors.New("redis lock not held")
+	// ErrLockTTLTooShort ttl小于minLockTTL
+	ErrLockTTLTooShort = errors.New("redis lock ttl too short")
+)
 
 func init() {
 	AddScript(NewLuaScript(LockScriptName, lockScript, 1))
+	AddScript(NewLuaScript(FencedLockScriptName, fencedLockScript, 2))
+	AddScript(NewLuaScript(RaiseFenceScriptName, raiseFenceScript, 1))
+	AddScript(NewLuaScript(UnlockScriptName, unlockScript, 1))
+	AddScript(NewLuaScript(ExtendLockScriptName, extendLockScript, 1))
 }
 
-//简单分布式锁
-//KEYS[1] 锁key
-//ARGV[1] 随便设置
-//ARGV[2] 超时时间；注意：业务必须在超时时间内执行完，释放锁
+// 可重入分布式锁, 锁是一个hash: owner token=>重入次数
+// 旧版本的锁是SETNX写入的字符串, 这里视为被其他owner持有, 不会报WRONGTYPE
+// KEYS[1] 锁key
+// ARGV[1] owner token
+// ARGV[2] 超时时间(毫秒)
+// 返回重入次数, 被其他owner持有时返回0
 var lockScript = `
-if (redis.call('setnx', KEYS[1], ARGV[1]) < 1)
-then return 0;
+local t = redis.call('type', KEYS[1]).ok;
+if t == 'none' or (t == 'hash' and redis.call('hexists', KEYS[1], ARGV[1]) == 1) then
+	local n = redis.call('hincrby', KEYS[1], ARGV[1], 1);
+	redis.call('pexpire', KEYS[1], ARGV[2]);
+	return n;
+end;
+return 0;
+`
+
+// 同lockScript, 成功时递增fencing token并返回
+// KEYS[2] fencing token计数器, 不随锁删除
+var fencedLockScript = `
+local t = redis.call('type', KEYS[1]).ok;
+if t == 'none' or (t == 'hash' and redis.call('hexists', KEYS[1], ARGV[1]) == 1) then
+	redis.call('hincrby', KEYS[1], ARGV[1], 1);
+	redis.call('pexpire', KEYS[1], ARGV[2]);
+	return redis.call('incr', KEYS[2]);
+end;
+return 0;
+`
+
+// 计数器小于ARGV[1]时抬高到ARGV[1], Redlock用来让多数节点的计数器都不小于本次发出的token
+var raiseFenceScript = `
+local cur = tonumber(redis.call('get', KEYS[1]) or '0');
+if cur < tonumber(ARGV[1]) then
+	redis.call('set', KEYS[1], ARGV[1]);
 end;
-redis.call('expire', KEYS[1], tonumber(ARGV[2]));
 return 1;
 `
 
+// 释放一次, 重入次数减到0时删除锁
+// 返回剩余重入次数, 不是owner时返回-1
+var unlockScript = `
+if redis.call('type', KEYS[1]).ok ~= 'hash' or redis.call('hexists', KEYS[1], ARGV[1]) == 0 then
+	return -1;
+end;
+local n = redis.call('hincrby', KEYS[1], ARGV[1], -1);
+if n > 0 then
+	redis.call('pexpire', KEYS[1], ARGV[2]);
+	return n;
+end;
+redis.call('del', KEYS[1]);
+return 0;
+`
+
+// owner仍持有锁时续期, 返回1, 否则返回0
+var extendLockScript = `
+if redis.call('type', KEYS[1]).ok == 'hash' and redis.call('hexists', KEYS[1], ARGV[1]) == 1 then
+	return redis.call('pexpire', KEYS[1], ARGV[2]);
+end;
+return 0;
+`
+
+const (
+	defaultLockTTL        = 30 * time.Second
+	minLockTTL            = 10 * time.Millisecond // watchdog每ttl/3续期, 太短没有意义
+	defaultLockMinBackoff = 10 * time.Millisecond
+	defaultLockMaxBackoff = 500 * time.Millisecond
+)
+
+type lockOption struct {
+	ttl                    time.Duration
+	token                  string
+	watchdog               bool
+	minBackoff, maxBackoff time.Duration
+}
+
+type LockOption func(o *lockOption)
+
+// WithLockTTL 锁的过期时间,开启watchdog时每ttl/3续期一次。小于10ms时加锁返回ErrLockTTLTooShort
+func WithLockTTL(ttl time.Duration) LockOption {
+	return func(o *lockOption) {
+		o.ttl = ttl
+	}
+}
+
+// WithLockToken 指定owner token,token相同的Locker视为同一owner,可以重入
+func WithLockToken(token string) LockOption {
+	return func(o *lockOption) {
+		o.token = token
+	}
+}
+
+// WithLockWatchdog 持有锁期间是否自动续期,默认开启
+func WithLockWatchdog(enable bool) LockOption {
+	return func(o *lockOption) {
+		o.watchdog = enable
+	}
+}
+
+// WithLockBackoff LockContext重试的退避区间
+func WithLockBackoff(min, max time.Duration) LockOption {
+	return func(o *lockOption) {
+		o.minBackoff, o.maxBackoff = min, max
+	}
+}
+
+// Locker 基于owner token的可重入分布式锁,只有owner能解锁和续期。
+// 同一个Locker可在多个goroutine间共用,它们属于同一owner。
+// 注意: 锁的存储由SETNX字符串改为hash,旧版本持有的锁在新版本看来被其他owner持有;
+// 但旧版本的Unlock直接DEL,会删掉新版本持有的锁,滚动升级期间新旧版本不要争抢同一个锁
 type Locker struct {
-	key   string
-	redis *Wrapper
+	key    string
+	redis  *Wrapper
+	option lockOption
+
+	mu       sync.Mutex
+	held     int           // 本Locker持有的重入次数
+	ttl      time.Duration // 最近一次加锁使用的ttl,重入释放时按它续期
+	stopDog  chan struct{} // 关闭时停止watchdog
+	lost     chan struct{} // watchdog发现锁丢失时关闭
+	lostOnce *sync.Once
 }
 
-func NewLocker(key string, wrap *Wrapper) *Locker {
+func NewLocker(key string, wrap *Wrapper, opts ...LockOption) *Locker {
+	option := lockOption{
+		ttl:        defaultLockTTL,
+		watchdog:   true,
+		minBackoff: defaultLockMinBackoff,
+		maxBackoff: defaultLockMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(&option)
+	}
+	if option.token == "" {
+		option.token = newLockToken()
+	}
 	return &Locker{
-		key:   key,
-		redis: wrap,
+		key:    key,
+		redis:  wrap,
+		option: option,
 	}
 }
 
-//value 随便填
-func (l *Locker) Lock(value interface{}, seconds int) (lockSuccess bool, err error) {
-	if value == nil {
-		value = time.Now().Unix()
+func newLockToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// 系统随机数不可用时退化为伪随机
+		mrand.Read(b)
 	}
-	keys := []string{l.key}
-	args := []interface{}{value, seconds}
-	ret, err := l.redis.EvalSha(LockScriptName, keys, args)
-	//log.Debugf("%+v,%+v",ret,err)
-	return redigo.Bool(ret, err)
+	return hex.EncodeToString(b)
 }
 
-func (l *Locker) Unlock() error {
-	return l.redis.Del(l.key)
+// Token owner token
+func (l *Locker) Token() string {
+	return l.option.token
 }
 
-//悲观锁策略
-func (l *Locker) PessimisticLock(seconds int, doSomething func() error) error {
-	var count int
-	start := time.Now()
-	defer func() {
-		fmt.Printf("spend %s tryCount %d", time.Now().Sub(start), count)
-	}()
+// Lock 尝试加锁一次,不自动续期。
+//
+// Deprecated: value不再使用,锁的值为owner token。用TryLock或LockContext
+func (l *Locker) Lock(value interface{}, seconds int) (lockSuccess bool, err error) {
+	_, ok, err := l.tryLock(context.Background(), time.Duration(seconds)*time.Second, false, false)
+	return ok, err
+}
+
+// TryLock 尝试加锁一次,锁被其他owner持有时返回false
+func (l *Locker) TryLock(ctx context.Context) (bool, error) {
+	_, ok, err := l.tryLock(ctx, l.option.ttl, l.option.watchdog, false)
+	return ok, err
+}
+
+// LockContext 加锁,锁被其他owner持有时按退避重试,直到成功或ctx结束
+func (l *Locker) LockContext(ctx context.Context) error {
+	_, err := l.lockContext(ctx, l.option.ttl, l.option.watchdog, false)
+	return err
+}
+
+// LockFenced 同LockContext,成功后返回单调递增的fencing token。
+// 写入下游存储时带上token,存储拒绝比已见过的token更小的写入,避免锁过期后旧owner的写入覆盖新owner。
+// token计数器的key为 key+":fence",cluster模式下key需要带hash tag,保证两个key在同一slot
+func (l *Locker) LockFenced(ctx context.Context) (fence int64, err error) {
+	return l.lockContext(ctx, l.option.ttl, l.option.watchdog, true)
+}
+
+func (l *Locker) lockContext(ctx context.Context, ttl time.Duration, watchdog, fenced bool) (fence int64, err error) {
+	err = retryWithBackoff(ctx, l.option.minBackoff, l.option.maxBackoff, func() (ok bool, err error) {
+		fence, ok, err = l.tryLock(ctx, ttl, watchdog, fenced)
+		return ok, errors.WithMessage(err, "tryLock")
+	})
+	return fence, errors.WithMessage(err, "wait lock "+l.key)
+}
+
+// retryWithBackoff 按指数退避重试try,直到成功、出错或ctx结束
+func retryWithBackoff(ctx context.Context, min, max time.Duration, try func() (bool, error)) error {
+	backoff := min
 	for {
-		count++
-		ok, err := l.Lock(nil, seconds)
-		if err != nil {
-			return errors.WithMessage(err, "lock")
+		ok, err := try()
+		if err != nil || ok {
+			return err
 		}
 
-		if !ok {
-			fmt.Printf("wait lock %d!", count)
-			time.Sleep(300 * time.Millisecond)
-			continue
+		// 加上最多一半的抖动,避免多个等待者同时重试
+		wait := backoff + time.Duration(mrand.Int63n(int64(backoff/2)+1))
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > max {
+			backoff = max
 		}
+	}
+}
+
+// tryLock fenced为true时同时递增fencing token并返回
+func (l *Locker) tryLock(ctx context.Context, ttl time.Duration, watchdog, fenced bool) (fence int64, ok bool, err error) {
+	if ttl < minLockTTL {
+		return 0, false, errors.WithMessage(ErrLockTTLTooShort, ttl.String())
+	}
+	fence, err = acquireLock(l.redis.WithContext(ctx), l.key, l.option.token, ttl, fenced)
+	if err != nil || fence == 0 {
+		return 0, false, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.held++
+	l.ttl = ttl
+	if watchdog && l.stopDog == nil {
+		l.stopDog = make(chan struct{})
+		l.lost = make(chan struct{})
+		l.lostOnce = &sync.Once{}
+		go l.watchdog(ttl, l.stopDog, l.lost, l.lostOnce)
+	}
+	if !fenced {
+		fence = 0
+	}
+	return fence, true, nil
+}
 
-		defer func() {
-			err = l.Unlock()
+// acquireLock 加锁失败返回0; 成功时fenced为true返回fencing token,否则返回重入次数
+func acquireLock(w *Wrapper, key, token string, ttl time.Duration, fenced bool) (int64, error) {
+	args := []interface{}{token, ttl.Milliseconds()}
+	if fenced {
+		return replyInt64(w.EvalSha(FencedLockScriptName, []string{key, fenceKey(key)}, args))
+	}
+	return replyInt64(w.EvalSha(LockScriptName, []string{key}, args))
+}
+
+func fenceKey(key string) string {
+	return key + ":fence"
+}
+
+// watchdog 每ttl/3续期一次,续期失败说明锁已丢失
+func (l *Locker) watchdog(ttl time.Duration, stop, lost chan struct{}, lostOnce *sync.Once) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ok, err := l.extend(context.Background(), ttl)
 			if err != nil {
-				fmt.Printf("unlock failed:%s", err)
+				// 网络抖动,下次再试,锁在ttl内不会过期
+				log.Printf("extend lock %s err:%s", l.key, err)
+				continue
+			}
+			if !ok {
+				log.Printf("lock %s lost", l.key)
+				lostOnce.Do(func() { close(lost) })
+				return
 			}
-		}()
+		case <-stop:
+			return
+		}
+	}
+}
 
-		return doSomething()
+// Lost 开启watchdog时,锁被发现丢失(过期后被他人获取等)后关闭;未持有锁时返回nil
+func (l *Locker) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+// Extend 续期到ttl,不是owner时返回false
+func (l *Locker) Extend(ctx context.Context, ttl time.Duration) (bool, error) {
+	return l.extend(ctx, ttl)
+}
+
+func (l *Locker) extend(ctx context.Context, ttl time.Duration) (bool, error) {
+	if ttl < minLockTTL {
+		return false, errors.WithMessage(ErrLockTTLTooShort, ttl.String())
+	}
+	return replyBool(l.redis.WithContext(ctx).EvalSha(ExtendLockScriptName,
+		[]string{l.key}, []interface{}{l.option.token, ttl.Milliseconds()}))
+}
+
+// Unlock 释放一次,重入次数减到0时删除锁。锁已不属于本owner时返回ErrLockNotHeld
+func (l *Locker) Unlock() error {
+	return l.UnlockContext(context.Background())
+}
+
+func (l *Locker) UnlockContext(ctx context.Context) error {
+	l.mu.Lock()
+	ttl := l.ttl
+	l.mu.Unlock()
+	if ttl <= 0 {
+		ttl = l.option.ttl
 	}
 
+	n, err := replyInt(l.redis.WithContext(ctx).EvalSha(UnlockScriptName,
+		[]string{l.key}, []interface{}{l.option.token, ttl.Milliseconds()}))
+	if err != nil {
+		return errors.WithMessage(err, "unlock")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n < 0 {
+		l.held = 0
+	} else if l.held > 0 {
+		l.held--
+	}
+	if l.held == 0 && l.stopDog != nil {
+		close(l.stopDog)
+		l.stopDog, l.lost = nil, nil
+	}
+	if n < 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// WithLock 加锁后执行fn,执行完释放锁
+func (l *Locker) WithLock(ctx context.Context, fn func() error) error {
+	if err := l.LockContext(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		if err := l.Unlock(); err != nil {
+			log.Printf("unlock %s err:%s", l.key, err)
+		}
+	}()
+	return fn()
+}
+
+// PessimisticLock 悲观锁策略,一直等待直到加锁成功,持有期间自动续期
+func (l *Locker) PessimisticLock(seconds int, doSomething func() error) error {
+	ttl := time.Duration(seconds) * time.Second
+	if _, err := l.lockContext(context.Background(), ttl, true, false); err != nil {
+		return errors.WithMessage(err, "lock")
+	}
+	defer func() {
+		if err := l.Unlock(); err != nil {
+			log.Printf("unlock %s err:%s", l.key, err)
+		}
+	}()
+	return doSomething()
 }
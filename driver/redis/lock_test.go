@@ -0,0 +1,118 @@
+package redis_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/ziyoumeng/sdk/driver/redis"
+)
+
+func TestLocker(t *testing.T) {
+	w, srv, clock := getWrapper(t)
+	ctx := context.Background()
+
+	a := redis.NewLocker("lock", w, redis.WithLockTTL(10*time.Second), redis.WithLockWatchdog(false))
+	b := redis.NewLocker("lock", w, redis.WithLockTTL(10*time.Second), redis.WithLockWatchdog(false))
+
+	ok, err := a.TryLock(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	// 可重入
+	ok, err = a.TryLock(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = b.TryLock(ctx)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, a.Unlock())
+	assert.True(t, srv.Exists(testPrefix+":lock"))
+	assert.NoError(t, a.Unlock())
+	assert.False(t, srv.Exists(testPrefix+":lock"))
+
+	// 过期后其他owner可以加锁, 原owner解锁失败
+	ok, err = a.TryLock(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	clock.Add(10 * time.Second)
+	ok, err = b.TryLock(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, redis.ErrLockNotHeld, a.Unlock())
+
+	extended, err := b.Extend(ctx, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, extended)
+	assert.Equal(t, time.Minute, srv.TTL(testPrefix+":lock"))
+}
+
+func TestLockFenced(t *testing.T) {
+	w, _, clock := getWrapper(t)
+	ctx := context.Background()
+
+	a := redis.NewLocker("fenced", w, redis.WithLockTTL(time.Second), redis.WithLockWatchdog(false))
+	b := redis.NewLocker("fenced", w, redis.WithLockTTL(time.Second), redis.WithLockWatchdog(false))
+	fa, err := a.LockFenced(ctx)
+	assert.NoError(t, err)
+	clock.Add(time.Second)
+	fb, err := b.LockFenced(ctx)
+	assert.NoError(t, err)
+	assert.Greater(t, fb, fa)
+}
+
+func TestLockInvalidTTL(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+	ctx := context.Background()
+
+	l := redis.NewLocker("lock", w, redis.WithLockTTL(0))
+	_, err := l.TryLock(ctx)
+	assert.Equal(t, redis.ErrLockTTLTooShort, errors.Cause(err))
+	assert.Equal(t, redis.ErrLockTTLTooShort, errors.Cause(l.LockContext(ctx)))
+
+	called := false
+	err = redis.NewLocker("lock", w).PessimisticLock(0, func() error {
+		called = true
+		return nil
+	})
+	assert.Equal(t, redis.ErrLockTTLTooShort, errors.Cause(err))
+	assert.False(t, called)
+	assert.False(t, srv.Exists(testPrefix+":lock"))
+
+	l = redis.NewLocker("lock", w, redis.WithLockWatchdog(false))
+	ok, err := l.TryLock(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	_, err = l.Extend(ctx, 0)
+	assert.Equal(t, redis.ErrLockTTLTooShort, errors.Cause(err))
+	assert.True(t, srv.Exists(testPrefix+":lock"))
+}
+
+func TestLockLegacyValue(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+	ctx := context.Background()
+
+	// 旧版本用SETNX写入的字符串锁
+	_, err := srv.Do("SET", testPrefix+":lock", "1", "EX", "10")
+	assert.NoError(t, err)
+
+	l := redis.NewLocker("lock", w, redis.WithLockWatchdog(false))
+	ok, err := l.TryLock(ctx)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, err = l.LockFenced(ctxTimeout(t, 50*time.Millisecond))
+	assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+	extended, err := l.Extend(ctx, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, extended)
+	assert.Equal(t, redis.ErrLockNotHeld, l.Unlock())
+	assert.Equal(t, 10*time.Second, srv.TTL(testPrefix+":lock"))
+}
+
+func ctxTimeout(t *testing.T, d time.Duration) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	t.Cleanup(cancel)
+	return ctx
+}
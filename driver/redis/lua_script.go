@@ -1,12 +1,19 @@
 package redis
 
 import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+
+	redigo "github.com/gomodule/redigo/redis"
 	"github.com/pkg/errors"
 )
 
 var Scripts []*LuaScript
 
-//业务通过init方法调用
+// 业务通过init方法调用, 之后创建的Wrapper都会加载
+// 需要在Wrapper创建后注册的脚本用Wrapper.RegisterScript
 func AddScript(s *LuaScript) {
 	Scripts = append(Scripts, s)
 }
@@ -18,11 +25,14 @@ type LuaScript struct {
 	keyCount   int
 }
 
+// NewLuaScript keyCount为负数时不校验key的个数
 func NewLuaScript(name, script string, keyCount int) *LuaScript {
 	return &LuaScript{
 		scriptName: name,
 		script:     script,
-		keyCount:   keyCount,
+		// sha1由脚本内容决定,不依赖SCRIPT LOAD的结果
+		sha:      redigo.NewScript(keyCount, script).Hash(),
+		keyCount: keyCount,
 	}
 }
 
@@ -38,6 +48,7 @@ func (l *LuaScript) GetKeyCount() int {
 	return l.keyCount
 }
 
+// Deprecated: sha由脚本内容算出, 不需要设置; 脚本执行期间调用会产生数据竞争
 func (l *LuaScript) SetScriptSha(sha string) {
 	l.sha = sha
 }
@@ -46,17 +57,78 @@ func (l *LuaScript) GetScriptSha() string {
 	return l.sha
 }
 
+// scriptRegistry Wrapper上已注册的脚本
+type scriptRegistry struct {
+	mu      sync.RWMutex
+	scripts map[string]*LuaScript
+}
+
+func newScriptRegistry() *scriptRegistry {
+	return &scriptRegistry{scripts: make(map[string]*LuaScript)}
+}
+
+func (r *scriptRegistry) add(s *LuaScript) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scripts[s.GetScriptName()] = s
+}
+
+func (r *scriptRegistry) get(name string) (*LuaScript, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.scripts[name]
+	return s, ok
+}
+
+// Script 注册到Wrapper上的脚本
+type Script struct {
+	w      *Wrapper
+	script *LuaScript
+}
+
+// RegisterScript 加载脚本并注册到Wrapper,可以在任意时刻调用,同名脚本会被替换
+func (w *Wrapper) RegisterScript(s *LuaScript) (*Script, error) {
+	if err := w.loadLuaScript(s); err != nil {
+		return nil, errors.WithMessage(err, "loadLuaScript")
+	}
+	w.luaScript.add(s)
+	return &Script{w: w, script: s}, nil
+}
+
+// Script 按名字取已注册的脚本
+func (w *Wrapper) Script(name string) (*Script, bool) {
+	s, ok := w.luaScript.get(name)
+	if !ok {
+		return nil, false
+	}
+	return &Script{w: w, script: s}, true
+}
+
+// Run 执行脚本, keys会加上Wrapper的前缀
+func (s *Script) Run(ctx context.Context, keys []string, args []interface{}) (interface{}, error) {
+	w := s.w
+	if ctx != nil {
+		w = w.WithContext(ctx)
+	}
+	return w.evalScript(s.script, keys, args)
+}
+
 func (w *Wrapper) EvalSha(scriptName string, keys []string, args []interface{}) (interface{}, error) {
-	script, ok := w.luaScript[scriptName]
+	script, ok := w.luaScript.get(scriptName)
 	if !ok {
 		return 0, errors.Errorf("not exist luaScript:%s", scriptName)
 	}
-	if script.GetKeyCount() != len(keys) {
+	return w.evalScript(script, keys, args)
+}
+
+// evalScript 先EVALSHA, redis重启或failover后脚本缓存丢失(NOSCRIPT)时改用EVAL执行,并重新加载脚本
+func (w *Wrapper) evalScript(script *LuaScript, keys []string, args []interface{}) (interface{}, error) {
+	if script.GetKeyCount() >= 0 && script.GetKeyCount() != len(keys) {
 		return nil, errors.New("length of keys not match with script")
 	}
 	values := make([]interface{}, 2+len(keys)+len(args))
 	values[0] = script.GetScriptSha()
-	values[1] = script.GetKeyCount()
+	values[1] = len(keys)
 	for i, key := range keys {
 		values[i+2] = w.WithPrefix(key)
 	}
@@ -64,8 +136,21 @@ func (w *Wrapper) EvalSha(scriptName string, keys []string, args []interface{})
 	for i, arg := range args {
 		values[i+2+len(keys)] = arg
 	}
-	//log.Debugf("len %d ,values %+v", len(values), values)
-	return w.ExecRedisCommand("EVALSHA", values...)
-	//return	 w.ExecRedisCommand("EVALSHA", "2895b9a31eb591b2b7a85c1ab3618c2c6bcd3b03", 2,"cm:tj:dq_bucket_1", "cm:tj:job:test1",1,
-	//	"test1", "2", "1600852152000", 3, 1000, 4, 1, 5, 0, 6, "dq_bucket_1" ,7, `{"sn":"","sid":0,"mn":"","mid":0,"arg":null}`, 8, 0)
+	ret, err := w.ExecRedisCommand("EVALSHA", values...)
+	if !isNoScript(err) {
+		return ret, err
+	}
+
+	values[0] = script.GetScript()
+	ret, err = w.ExecRedisCommand("EVAL", values...)
+	// EVAL只会在执行的节点上缓存脚本,cluster模式下需要SCRIPT LOAD到所有master
+	if loadErr := w.loadLuaScript(script); loadErr != nil {
+		log.Printf("reload script %s err:%s", script.GetScriptName(), loadErr)
+	}
+	return ret, err
+}
+
+func isNoScript(err error) bool {
+	e, ok := errors.Cause(err).(redigo.Error)
+	return ok && strings.HasPrefix(string(e), "NOSCRIPT")
 }
@@ -0,0 +1,194 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// 命令耗时直方图的默认分桶(秒)
+var defaultDurationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+type metricsOption struct {
+	namespace string
+	buckets   []float64
+}
+
+type MetricsOption func(o *metricsOption)
+
+// WithMetricsNamespace 指标名的前缀, 默认为redis
+func WithMetricsNamespace(namespace string) MetricsOption {
+	return func(o *metricsOption) {
+		o.namespace = namespace
+	}
+}
+
+// WithMetricsBuckets 命令耗时直方图的分桶上界(秒), 需要递增
+func WithMetricsBuckets(buckets ...float64) MetricsOption {
+	return func(o *metricsOption) {
+		o.buckets = buckets
+	}
+}
+
+// Metrics 按命令统计次数、错误数和耗时直方图, 并记录最近一次的连接池状态。
+// 注册为Wrapper的Hook后, 作为http.Handler输出Prometheus文本格式, 不依赖prometheus client:
+//
+//	m := redis.NewMetrics()
+//	w.AddHook(m)
+//	http.Handle("/metrics/redis", m)
+type Metrics struct {
+	option metricsOption
+
+	mu       sync.Mutex
+	commands map[string]*commandMetrics
+	pool     *PoolStats
+}
+
+type commandMetrics struct {
+	total  uint64
+	errors uint64
+	sum    float64
+	counts []uint64 // 与buckets对应, 不累加
+}
+
+func NewMetrics(opts ...MetricsOption) *Metrics {
+	option := metricsOption{
+		namespace: "redis",
+		buckets:   defaultDurationBuckets,
+	}
+	for _, opt := range opts {
+		opt(&option)
+	}
+	return &Metrics{
+		option:   option,
+		commands: make(map[string]*commandMetrics),
+	}
+}
+
+func (m *Metrics) AfterCommand(ctx context.Context, info CommandInfo) {
+	seconds := info.Duration.Seconds()
+	// 第一个不小于耗时的分桶
+	bucket := sort.SearchFloat64s(m.option.buckets, seconds)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cm, ok := m.commands[info.Command]
+	if !ok {
+		cm = &commandMetrics{counts: make([]uint64, len(m.option.buckets))}
+		m.commands[info.Command] = cm
+	}
+	cm.total++
+	if info.Err != nil {
+		cm.errors++
+	}
+	cm.sum += seconds
+	if bucket < len(cm.counts) {
+		cm.counts[bucket]++
+	}
+}
+
+func (m *Metrics) OnPoolStats(stats PoolStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pool = &stats
+}
+
+// ServeHTTP 输出Prometheus文本格式
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo 写出Prometheus文本格式
+func (m *Metrics) WriteTo(out io.Writer) (int64, error) {
+	m.mu.Lock()
+	names := make([]string, 0, len(m.commands))
+	snapshot := make(map[string]commandMetrics, len(m.commands))
+	for name, cm := range m.commands {
+		names = append(names, name)
+		c := *cm
+		c.counts = append([]uint64(nil), cm.counts...)
+		snapshot[name] = c
+	}
+	var pool *PoolStats
+	if m.pool != nil {
+		p := *m.pool
+		pool = &p
+	}
+	m.mu.Unlock()
+	sort.Strings(names)
+
+	cw := &countWriter{w: bufio.NewWriter(out)}
+	ns := m.option.namespace
+
+	cw.printf("# HELP %s_commands_total Total number of redis commands.\n", ns)
+	cw.printf("# TYPE %s_commands_total counter\n", ns)
+	for _, name := range names {
+		cw.printf("%s_commands_total{command=%q} %d\n", ns, name, snapshot[name].total)
+	}
+
+	cw.printf("# HELP %s_command_errors_total Total number of failed redis commands.\n", ns)
+	cw.printf("# TYPE %s_command_errors_total counter\n", ns)
+	for _, name := range names {
+		cw.printf("%s_command_errors_total{command=%q} %d\n", ns, name, snapshot[name].errors)
+	}
+
+	cw.printf("# HELP %s_command_duration_seconds Redis command latency.\n", ns)
+	cw.printf("# TYPE %s_command_duration_seconds histogram\n", ns)
+	for _, name := range names {
+		cm := snapshot[name]
+		var cumulative uint64
+		for i, upper := range m.option.buckets {
+			cumulative += cm.counts[i]
+			cw.printf("%s_command_duration_seconds_bucket{command=%q,le=%q} %d\n",
+				ns, name, strconv.FormatFloat(upper, 'g', -1, 64), cumulative)
+		}
+		cw.printf("%s_command_duration_seconds_bucket{command=%q,le=\"+Inf\"} %d\n", ns, name, cm.total)
+		cw.printf("%s_command_duration_seconds_sum{command=%q} %s\n", ns, name, strconv.FormatFloat(cm.sum, 'g', -1, 64))
+		cw.printf("%s_command_duration_seconds_count{command=%q} %d\n", ns, name, cm.total)
+	}
+
+	if pool != nil {
+		gauges := []struct {
+			name, help, kind string
+			value            string
+		}{
+			{"pool_active_connections", "Number of connections in the pool, in use and idle.", "gauge", strconv.Itoa(pool.ActiveCount)},
+			{"pool_idle_connections", "Number of idle connections in the pool.", "gauge", strconv.Itoa(pool.IdleCount)},
+			{"pool_wait_total", "Total number of waits for an idle connection.", "counter", strconv.FormatInt(pool.WaitCount, 10)},
+			{"pool_wait_seconds_total", "Total time spent waiting for an idle connection.", "counter",
+				strconv.FormatFloat(pool.WaitDuration.Seconds(), 'g', -1, 64)},
+		}
+		for _, g := range gauges {
+			cw.printf("# HELP %s_%s %s\n", ns, g.name, g.help)
+			cw.printf("# TYPE %s_%s %s\n", ns, g.name, g.kind)
+			cw.printf("%s_%s %s\n", ns, g.name, g.value)
+		}
+	}
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// countWriter 记录写出的字节数和第一个错误
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) printf(format string, args ...interface{}) {
+	if cw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(cw.w, format, args...)
+	cw.n += int64(n)
+	cw.err = err
+}
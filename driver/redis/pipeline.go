@@ -0,0 +1,365 @@
+package redis
+
+import (
+	"log"
+	"math/rand"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// txMaxRetries WATCH的key被修改导致EXEC失败时的最大重试次数
+const txMaxRetries = 5
+
+var (
+	ErrTxConflict = errors.New("redis tx: watched keys changed, retries exhausted")
+	// ErrNotExecuted Pipeline/Tx执行前读取结果
+	ErrNotExecuted = errors.New("redis: command not executed yet")
+)
+
+// Result 管道或事务中单条命令的结果, Exec之后才能读取
+type Result struct {
+	reply    interface{}
+	err      error
+	executed bool
+}
+
+func (r *Result) set(reply interface{}, err error) {
+	if e, ok := reply.(redigo.Error); ok && err == nil {
+		reply, err = nil, e
+	}
+	r.reply, r.err, r.executed = reply, err, true
+}
+
+func (r *Result) Value() (interface{}, error) {
+	if !r.executed {
+		return nil, ErrNotExecuted
+	}
+	return r.reply, r.err
+}
+
+func (r *Result) Err() error {
+	_, err := r.Value()
+	return err
+}
+
+func (r *Result) String() (string, error) {
+	return replyString(r.Value())
+}
+
+func (r *Result) Bytes() ([]byte, error) {
+	return replyBytes(r.Value())
+}
+
+func (r *Result) Int() (int, error) {
+	return replyInt(r.Value())
+}
+
+func (r *Result) Int64() (int64, error) {
+	return replyInt64(r.Value())
+}
+
+func (r *Result) Float64() (float64, error) {
+	return replyFloat64(r.Value())
+}
+
+func (r *Result) Bool() (bool, error) {
+	return replyBool(r.Value())
+}
+
+func (r *Result) Strings() ([]string, error) {
+	return replyStrings(r.Value())
+}
+
+func (r *Result) StringMap() (map[string]string, error) {
+	return replyStringMap(r.Value())
+}
+
+func (r *Result) ZMembers() ([]ZMember, error) {
+	return zMembers(r.Value())
+}
+
+type queuedCmd struct {
+	name   string
+	args   []interface{}
+	result *Result
+}
+
+// commands Pipeline和Tx共用的命令队列, key会自动加上Wrapper的前缀
+type commands struct {
+	w    *Wrapper
+	cmds []*queuedCmd
+}
+
+// Do 排队任意单key命令, key加前缀后作为第一个参数
+func (c *commands) Do(command, key string, args ...interface{}) *Result {
+	values := make([]interface{}, 0, len(args)+1)
+	values = append(values, c.w.WithPrefix(key))
+	values = append(values, args...)
+	return c.queue(command, values...)
+}
+
+func (c *commands) queue(command string, args ...interface{}) *Result {
+	cmd := &queuedCmd{name: command, args: args, result: &Result{}}
+	c.cmds = append(c.cmds, cmd)
+	return cmd.result
+}
+
+// Len 已排队的命令数
+func (c *commands) Len() int {
+	return len(c.cmds)
+}
+
+func (c *commands) Get(key string) *Result {
+	return c.Do("GET", key)
+}
+
+func (c *commands) Set(key string, value interface{}) *Result {
+	return c.Do("SET", key, value)
+}
+
+func (c *commands) SetEX(key string, seconds int64, value interface{}) *Result {
+	return c.Do("SETEX", key, seconds, value)
+}
+
+func (c *commands) Del(key string) *Result {
+	return c.Do("DEL", key)
+}
+
+func (c *commands) Incr(key string) *Result {
+	return c.Do("INCR", key)
+}
+
+func (c *commands) IncrBy(key string, val int64) *Result {
+	return c.Do("INCRBY", key, val)
+}
+
+func (c *commands) Expire(key string, sec int64) *Result {
+	return c.Do("EXPIRE", key, sec)
+}
+
+func (c *commands) HSet(key, field string, value interface{}) *Result {
+	return c.Do("HSET", key, field, value)
+}
+
+func (c *commands) HGet(key, field string) *Result {
+	return c.Do("HGET", key, field)
+}
+
+func (c *commands) HGetAll(key string) *Result {
+	return c.Do("HGETALL", key)
+}
+
+func (c *commands) HIncrBy(key, field string, num int64) *Result {
+	return c.Do("HINCRBY", key, field, num)
+}
+
+func (c *commands) HDel(key string, fields ...interface{}) *Result {
+	return c.Do("HDEL", key, fields...)
+}
+
+func (c *commands) ZAdd(key string, score float64, member string) *Result {
+	return c.Do("ZADD", key, score, member)
+}
+
+func (c *commands) ZIncrBy(key string, increment float64, member string) *Result {
+	return c.Do("ZINCRBY", key, increment, member)
+}
+
+func (c *commands) ZRem(key string, members ...interface{}) *Result {
+	return c.Do("ZREM", key, members...)
+}
+
+func (c *commands) LPush(key string, values ...interface{}) *Result {
+	return c.Do("LPUSH", key, values...)
+}
+
+func (c *commands) RPush(key string, values ...interface{}) *Result {
+	return c.Do("RPUSH", key, values...)
+}
+
+func (c *commands) SAdd(key string, members ...interface{}) *Result {
+	return c.Do("SADD", key, members...)
+}
+
+func (c *commands) SRem(key string, members ...interface{}) *Result {
+	return c.Do("SREM", key, members...)
+}
+
+// firstErr 返回第一条失败命令的错误
+func (c *commands) firstErr() error {
+	for _, cmd := range c.cmds {
+		if cmd.result.err != nil {
+			return errors.WithMessage(cmd.result.err, cmd.name)
+		}
+	}
+	return nil
+}
+
+// Pipeline 批量发送命令,只需一次往返(cluster模式下每个涉及的节点一次),不保证原子性
+//
+//	p := w.Pipeline()
+//	a := p.Incr("a")
+//	b := p.Get("b")
+//	err := p.Exec()
+//	n, _ := a.Int64()
+type Pipeline struct {
+	commands
+}
+
+func (w *Wrapper) Pipeline() *Pipeline {
+	return &Pipeline{commands: commands{w: w}}
+}
+
+// Exec 发送所有排队的命令并填充结果,返回第一条失败命令的错误,每条命令的结果不受其他命令影响
+// Exec后队列清空,Pipeline可以继续使用
+func (p *Pipeline) Exec() (err error) {
+	cmds := p.cmds
+	p.cmds = nil
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	p.w.Wrap(func(conn redigo.Conn) {
+		for _, cmd := range cmds {
+			if err = conn.Send(cmd.name, cmd.args...); err != nil {
+				return
+			}
+		}
+		var replies []interface{}
+		replies, err = replyValues(p.w.do(conn, ""))
+		if err != nil {
+			return
+		}
+		if len(replies) != len(cmds) {
+			err = errors.Errorf("pipeline expects %d replies, got %d", len(cmds), len(replies))
+			return
+		}
+		for i, cmd := range cmds {
+			cmd.result.set(replies[i], nil)
+		}
+	})
+	if err != nil {
+		for _, cmd := range cmds {
+			if !cmd.result.executed {
+				cmd.result.set(nil, err)
+			}
+		}
+		return errors.WithMessage(err, "pipeline")
+	}
+	return (&commands{cmds: cmds}).firstErr()
+}
+
+// Tx 事务, 通过Wrapper.Tx使用。Read在WATCH之后立即执行,
+// 排队的写命令在fn返回后由MULTI/EXEC原子提交
+type Tx struct {
+	commands
+	conn redigo.Conn
+}
+
+// Read 在事务连接上立即执行单key命令,用于读取WATCH的key后计算新值
+func (tx *Tx) Read(command, key string, args ...interface{}) (interface{}, error) {
+	values := make([]interface{}, 0, len(args)+1)
+	values = append(values, tx.w.WithPrefix(key))
+	values = append(values, args...)
+	return tx.w.do(tx.conn, command, values...)
+}
+
+// Tx WATCH watchKeys后执行fn, fn中通过tx排队的命令用MULTI/EXEC提交。
+// watchKeys在提交前被其他客户端修改时重新执行fn,重试txMaxRetries次后返回ErrTxConflict。
+// fn返回错误时放弃事务并返回该错误。cluster模式下所有key需要用hash tag落在同一slot
+//
+//	err := w.Tx(func(tx *redis.Tx) error {
+//		n, err := redigo.Int64(tx.Read("GET", "counter"))
+//		if err != nil && !redis.IsNil(err) {
+//			return err
+//		}
+//		tx.Set("counter", n*2)
+//		return nil
+//	}, "counter")
+func (w *Wrapper) Tx(fn func(tx *Tx) error, watchKeys ...string) (err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		for attempt := 0; ; attempt++ {
+			var retry bool
+			retry, err = w.txOnce(conn, fn, watchKeys)
+			if !retry {
+				return
+			}
+			if attempt >= txMaxRetries {
+				err = ErrTxConflict
+				return
+			}
+
+			// 冲突时稍微错开,减少与其他客户端再次冲突
+			timer := time.NewTimer(time.Duration(rand.Int63n(int64(time.Millisecond) << uint(attempt))))
+			select {
+			case <-timer.C:
+			case <-w.Context().Done():
+				timer.Stop()
+				err = w.Context().Err()
+				return
+			}
+		}
+	})
+	return
+}
+
+func (w *Wrapper) txOnce(conn redigo.Conn, fn func(tx *Tx) error, watchKeys []string) (retry bool, err error) {
+	if len(watchKeys) > 0 {
+		keys := make([]interface{}, len(watchKeys))
+		for i, key := range watchKeys {
+			keys[i] = w.WithPrefix(key)
+		}
+		if _, err := w.do(conn, "WATCH", keys...); err != nil {
+			return false, errors.WithMessage(err, "WATCH")
+		}
+	}
+
+	tx := &Tx{commands: commands{w: w}, conn: conn}
+	if err := fn(tx); err != nil {
+		w.unwatch(conn, watchKeys)
+		return false, err
+	}
+	if len(tx.cmds) == 0 {
+		w.unwatch(conn, watchKeys)
+		return false, nil
+	}
+
+	if err := conn.Send("MULTI"); err != nil {
+		return false, err
+	}
+	for _, cmd := range tx.cmds {
+		if err := conn.Send(cmd.name, cmd.args...); err != nil {
+			return false, err
+		}
+	}
+	replies, err := replyValues(w.do(conn, "EXEC"))
+	if err == ErrNil {
+		// WATCH的key被修改,事务未执行
+		return true, nil
+	}
+	if err != nil {
+		for _, cmd := range tx.cmds {
+			cmd.result.set(nil, err)
+		}
+		return false, errors.WithMessage(err, "EXEC")
+	}
+	if len(replies) != len(tx.cmds) {
+		return false, errors.Errorf("EXEC expects %d replies, got %d", len(tx.cmds), len(replies))
+	}
+	for i, cmd := range tx.cmds {
+		cmd.result.set(replies[i], nil)
+	}
+	return false, tx.firstErr()
+}
+
+func (w *Wrapper) unwatch(conn redigo.Conn, watchKeys []string) {
+	if len(watchKeys) == 0 {
+		return
+	}
+	if _, err := w.do(conn, "UNWATCH"); err != nil {
+		// 连接归还连接池时redigo也会UNWATCH,这里只记录
+		log.Printf("UNWATCH err:%s", err)
+	}
+}
@@ -0,0 +1,264 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+const (
+	defaultPubSubPingInterval = 30 * time.Second
+	pubSubMaxBackoff          = 30 * time.Second
+)
+
+// PubSubMessage 收到的消息, Channel和Pattern已去掉Wrapper的前缀
+type PubSubMessage struct {
+	Channel string
+	Pattern string // 通过PSubscribe的pattern收到时不为空
+	Data    []byte
+}
+
+// PubSubHandler 在订阅goroutine中依次调用,耗时的处理需要自行异步
+type PubSubHandler func(msg PubSubMessage)
+
+// Publish channel会加上Wrapper的前缀, 返回收到消息的订阅者数量
+func (w *Wrapper) Publish(channel string, msg interface{}) (receivers int, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		receivers, err = replyInt(w.do(conn, "PUBLISH", w.WithPrefix(channel), msg))
+	})
+	return
+}
+
+type subscribeOption struct {
+	patterns     []string
+	pingInterval time.Duration
+	onSubscribed func()
+}
+
+type SubscribeOption func(o *subscribeOption)
+
+// WithPatterns 同时按pattern订阅(PSUBSCRIBE), pattern会加上Wrapper的前缀
+func WithPatterns(patterns ...string) SubscribeOption {
+	return func(o *subscribeOption) {
+		o.patterns = append(o.patterns, patterns...)
+	}
+}
+
+// WithPingInterval 定期PING检测连接,默认30s,<=0时使用默认值
+func WithPingInterval(d time.Duration) SubscribeOption {
+	return func(o *subscribeOption) {
+		o.pingInterval = d
+	}
+}
+
+// WithOnSubscribed 每次(重新)订阅成功后调用, 断线期间的消息会丢失,可以在这里做补偿
+func WithOnSubscribed(fn func()) SubscribeOption {
+	return func(o *subscribeOption) {
+		o.onSubscribed = fn
+	}
+}
+
+// Subscription Subscribe返回的句柄
+type Subscription struct {
+	w        *Wrapper
+	channels []string
+	option   subscribeOption
+	handler  PubSubHandler
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	ready  chan struct{}
+	once   sync.Once
+}
+
+// Subscribe 使用独占连接订阅channels(和WithPatterns指定的pattern),连接断开后自动重连并重新订阅。
+// ctx取消或调用Stop后退出
+func (w *Wrapper) Subscribe(ctx context.Context, channels []string, handler PubSubHandler, opts ...SubscribeOption) (*Subscription, error) {
+	option := subscribeOption{pingInterval: defaultPubSubPingInterval}
+	for _, opt := range opts {
+		opt(&option)
+	}
+	if option.pingInterval <= 0 {
+		option.pingInterval = defaultPubSubPingInterval
+	}
+	if len(channels) == 0 && len(option.patterns) == 0 {
+		return nil, errors.New("no channels or patterns")
+	}
+	if handler == nil {
+		return nil, errors.New("nil handler")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Subscription{
+		w:        w,
+		channels: channels,
+		option:   option,
+		handler:  handler,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		ready:    make(chan struct{}),
+	}
+	go s.run(ctx)
+	return s, nil
+}
+
+// Stop 取消订阅并等待后台goroutine退出
+func (s *Subscription) Stop() {
+	s.cancel()
+	<-s.done
+}
+
+// Done 订阅彻底退出后关闭
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Ready 第一次订阅成功后关闭
+func (s *Subscription) Ready() <-chan struct{} {
+	return s.ready
+}
+
+func (s *Subscription) run(ctx context.Context) {
+	defer close(s.done)
+
+	failures := 0
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := s.receive(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(start) > pubSubMaxBackoff {
+			failures = 0
+		}
+		failures++
+		retry := time.Second << uint(failures-1)
+		if retry > pubSubMaxBackoff || retry <= 0 {
+			retry = pubSubMaxBackoff
+		}
+		logx.Errorf("redis subscribe %v err:%s, retry after %s", s.channels, err, retry)
+
+		timer := time.NewTimer(retry)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// receive 订阅一次,直到连接出错或ctx取消
+func (s *Subscription) receive(ctx context.Context) error {
+	conn, err := s.w.pubSubConn(ctx)
+	if err != nil {
+		return errors.WithMessage(err, "get conn")
+	}
+	defer conn.Close()
+
+	psc := redigo.PubSubConn{Conn: conn}
+	if len(s.channels) > 0 {
+		if err := psc.Subscribe(redigo.Args{}.AddFlat(s.prefixed(s.channels))...); err != nil {
+			return errors.WithMessage(err, "subscribe")
+		}
+	}
+	if len(s.option.patterns) > 0 {
+		if err := psc.PSubscribe(redigo.Args{}.AddFlat(s.prefixed(s.option.patterns))...); err != nil {
+			return errors.WithMessage(err, "psubscribe")
+		}
+	}
+
+	// 写: 定期PING, ctx取消时退订, 读循环收到退订完成后退出
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		// 等写goroutine退出后才能关闭连接
+		close(stop)
+		wg.Wait()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(s.option.pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := psc.Ping(""); err != nil {
+					return
+				}
+			case <-ctx.Done():
+				psc.Unsubscribe()
+				psc.PUnsubscribe()
+				return
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	subscribed := len(s.channels) + len(s.option.patterns)
+	// 超过两个PING间隔没有任何回复,认为连接已断开
+	timeout := 2 * s.option.pingInterval
+	for {
+		switch v := psc.ReceiveWithTimeout(timeout).(type) {
+		case redigo.Message:
+			s.dispatch(v)
+		case redigo.Subscription:
+			if v.Kind == "subscribe" || v.Kind == "psubscribe" {
+				subscribed--
+				if subscribed == 0 {
+					if s.option.onSubscribed != nil {
+						s.option.onSubscribed()
+					}
+					s.once.Do(func() { close(s.ready) })
+				}
+			}
+			if v.Count == 0 && ctx.Err() != nil {
+				return nil
+			}
+		case redigo.Pong:
+		case error:
+			return v
+		}
+	}
+}
+
+func (s *Subscription) prefixed(names []string) []string {
+	ret := make([]string, len(names))
+	for i, name := range names {
+		ret[i] = s.w.WithPrefix(name)
+	}
+	return ret
+}
+
+func (s *Subscription) dispatch(v redigo.Message) {
+	defer func() {
+		if r := recover(); r != nil {
+			logx.Errorf("redis subscribe handler panic: %v", r)
+		}
+	}()
+
+	prefix := s.w.WithPrefix("")
+	s.handler(PubSubMessage{
+		Channel: strings.TrimPrefix(v.Channel, prefix),
+		Pattern: strings.TrimPrefix(v.Pattern, prefix),
+		Data:    v.Data,
+	})
+}
+
+// pubSubConn 订阅使用的独占连接, cluster模式下直接使用某个节点的连接
+func (w *Wrapper) pubSubConn(ctx context.Context) (redigo.Conn, error) {
+	if s, ok := w.source.(interface {
+		pubSubConn(ctx context.Context) (redigo.Conn, error)
+	}); ok {
+		return s.pubSubConn(ctx)
+	}
+	return w.source.GetContext(ctx)
+}
@@ -0,0 +1,533 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	QueueEnqueueScriptName = "queue_enqueue"
+	QueueLeaseScriptName   = "queue_lease"
+	QueueAckScriptName     = "queue_ack"
+	QueueRetryScriptName   = "queue_retry"
+	QueueTouchScriptName   = "queue_touch"
+	QueueReapScriptName    = "queue_reap"
+	QueuePromoteScriptName = "queue_promote"
+
+	// ErrJobLost 租约已过期,任务被reaper放回队列,可能已被其他worker取出
+	ErrJobLost = errors.New("queue: job lease lost")
+)
+
+func init() {
+	AddScript(NewLuaScript(QueueEnqueueScriptName, queueEnqueueScript, 3))
+	AddScript(NewLuaScript(QueueLeaseScriptName, queueLeaseScript, 4))
+	AddScript(NewLuaScript(QueueAckScriptName, queueAckScript, 4))
+	AddScript(NewLuaScript(QueueRetryScriptName, queueRetryScript, 6))
+	AddScript(NewLuaScript(QueueTouchScriptName, queueTouchScript, 2))
+	AddScript(NewLuaScript(QueueReapScriptName, queueReapScript, 4))
+	AddScript(NewLuaScript(QueuePromoteScriptName, queuePromoteScript, 2))
+}
+
+// 队列的key都带{name}作为hash tag, cluster模式下落在同一slot:
+//   jobs       hash  id=>任务内容
+//   ready      list  待处理id, LPUSH进, BLMOVE从右边取
+//   processing list  处理中id
+//   inflight   zset  处理中id=>租约到期时间(毫秒)
+//   leases     hash  处理中id=>本次取出的租约token, Ack/Nack/Touch时校验, 租约过期后删除
+//   delayed    zset  延迟/重试id=>到期时间(毫秒)
+//   dead       list  超过最大重试次数的id
+
+// KEYS: jobs, ready, delayed; ARGV: id, body, 延迟毫秒
+var queueEnqueueScript = nowMsLua + `
+redis.call('hset', KEYS[1], ARGV[1], ARGV[2]);
+local delay = tonumber(ARGV[3]);
+if delay > 0 then
+	redis.call('zadd', KEYS[3], now + delay, ARGV[1]);
+else
+	redis.call('lpush', KEYS[2], ARGV[1]);
+end;
+return 1;
+`
+
+// BLMOVE之后登记租约和token并返回任务内容, 任务已被删除时从processing移除并返回nil
+// KEYS: processing, inflight, jobs, leases; ARGV: id, 可见性超时毫秒, 租约token
+var queueLeaseScript = nowMsLua + `
+local body = redis.call('hget', KEYS[3], ARGV[1]);
+if not body then
+	redis.call('lrem', KEYS[1], 1, ARGV[1]);
+	redis.call('zrem', KEYS[2], ARGV[1]);
+	redis.call('hdel', KEYS[4], ARGV[1]);
+	return false;
+end;
+redis.call('zadd', KEYS[2], now + tonumber(ARGV[2]), ARGV[1]);
+redis.call('hset', KEYS[4], ARGV[1], ARGV[3]);
+return body;
+`
+
+// KEYS: processing, inflight, jobs, leases; ARGV: id, 租约token
+// 返回0表示租约已不属于调用方(过期被放回, 可能已被其他worker取出)
+var queueAckScript = `
+if redis.call('hget', KEYS[4], ARGV[1]) ~= ARGV[2] then
+	return 0;
+end;
+redis.call('lrem', KEYS[1], 1, ARGV[1]);
+redis.call('zrem', KEYS[2], ARGV[1]);
+redis.call('hdel', KEYS[3], ARGV[1]);
+redis.call('hdel', KEYS[4], ARGV[1]);
+return 1;
+`
+
+// 处理失败, 延迟毫秒<0时进入死信队列, 否则延迟后重试
+// KEYS: processing, inflight, jobs, delayed, dead, leases; ARGV: id, 更新后的body, 延迟毫秒, 租约token
+var queueRetryScript = nowMsLua + `
+if redis.call('hget', KEYS[6], ARGV[1]) ~= ARGV[4] then
+	return 0;
+end;
+redis.call('lrem', KEYS[1], 1, ARGV[1]);
+redis.call('zrem', KEYS[2], ARGV[1]);
+redis.call('hdel', KEYS[6], ARGV[1]);
+redis.call('hset', KEYS[3], ARGV[1], ARGV[2]);
+local delay = tonumber(ARGV[3]);
+if delay < 0 then
+	redis.call('lpush', KEYS[5], ARGV[1]);
+else
+	redis.call('zadd', KEYS[4], now + delay, ARGV[1]);
+end;
+return 1;
+`
+
+// 延长租约, 租约已不属于调用方时返回0
+// KEYS: inflight, leases; ARGV: id, 可见性超时毫秒, 租约token
+var queueTouchScript = nowMsLua + `
+if redis.call('hget', KEYS[2], ARGV[1]) ~= ARGV[3] then
+	return 0;
+end;
+redis.call('zadd', KEYS[1], now + tonumber(ARGV[2]), ARGV[1]);
+return 1;
+`
+
+// 租约过期的任务放回ready的右边优先处理, 并删除token使原worker的Ack/Nack/Touch失效。
+// processing比inflight多时有没有租约的id(BLMOVE后worker崩溃), 从ARGV[3]开始检查一批并补登记租约,
+// 返回下次检查的起点, 检查到末尾时为0
+// KEYS: processing, inflight, ready, leases; ARGV: 可见性超时毫秒, 单次最多处理数, 检查起点
+// 返回: {放回的任务数, 下次检查起点}
+var queueReapScript = nowMsLua + `
+local batch = tonumber(ARGV[2]);
+local offset = 0;
+if redis.call('llen', KEYS[1]) > redis.call('zcard', KEYS[2]) then
+	local start = tonumber(ARGV[3]);
+	local ids = redis.call('lrange', KEYS[1], start, start + batch - 1);
+	for _, id in ipairs(ids) do
+		if not redis.call('zscore', KEYS[2], id) then
+			redis.call('zadd', KEYS[2], now + tonumber(ARGV[1]), id);
+		end;
+	end;
+	if #ids == batch then
+		offset = start + batch;
+	end;
+end;
+local expired = redis.call('zrangebyscore', KEYS[2], '-inf', now, 'limit', 0, batch);
+for _, id in ipairs(expired) do
+	redis.call('lrem', KEYS[1], 1, id);
+	redis.call('zrem', KEYS[2], id);
+	redis.call('hdel', KEYS[4], id);
+	redis.call('rpush', KEYS[3], id);
+end;
+return {#expired, offset};
+`
+
+// 到期的延迟任务放入ready
+// KEYS: delayed, ready; ARGV: 单次最多处理数
+var queuePromoteScript = nowMsLua + `
+local due = redis.call('zrangebyscore', KEYS[1], '-inf', now, 'limit', 0, tonumber(ARGV[1]));
+for _, id in ipairs(due) do
+	redis.call('zrem', KEYS[1], id);
+	redis.call('lpush', KEYS[2], id);
+end;
+return #due;
+`
+
+const queueReapBatch = 100
+
+// Job 队列中的任务
+type Job struct {
+	ID         string    `json:"id"`
+	Payload    []byte    `json:"payload"`
+	Attempts   int       `json:"attempts"` // 已失败的次数
+	EnqueuedAt time.Time `json:"enqueuedAt"`
+	LastError  string    `json:"lastError,omitempty"`
+	Lease      string    `json:"-"` // 本次取出的租约token, Ack/Nack/Touch时校验
+}
+
+type queueOption struct {
+	visibilityTimeout time.Duration
+	maxAttempts       int
+	backoff           func(attempts int) time.Duration
+	pollTimeout       time.Duration
+	reapInterval      time.Duration
+}
+
+type QueueOption func(o *queueOption)
+
+// WithQueueVisibilityTimeout 取出后超过该时间未Ack/Nack的任务会被放回队列,默认30s
+func WithQueueVisibilityTimeout(d time.Duration) QueueOption {
+	return func(o *queueOption) {
+		o.visibilityTimeout = d
+	}
+}
+
+// WithQueueMaxAttempts 失败次数达到后进入死信队列,默认5
+func WithQueueMaxAttempts(n int) QueueOption {
+	return func(o *queueOption) {
+		o.maxAttempts = n
+	}
+}
+
+// WithQueueBackoff 第attempts次失败后的重试间隔,默认1s起指数增长,最长10分钟
+func WithQueueBackoff(backoff func(attempts int) time.Duration) QueueOption {
+	return func(o *queueOption) {
+		o.backoff = backoff
+	}
+}
+
+// WithQueuePollTimeout Dequeue阻塞等待的时间,默认1s,需要小于RedisReadTimeout
+func WithQueuePollTimeout(d time.Duration) QueueOption {
+	return func(o *queueOption) {
+		o.pollTimeout = d
+	}
+}
+
+// WithQueueReapInterval Run中回收过期租约、投递延迟任务的间隔,默认1s
+func WithQueueReapInterval(d time.Duration) QueueOption {
+	return func(o *queueOption) {
+		o.reapInterval = d
+	}
+}
+
+func defaultQueueBackoff(attempts int) time.Duration {
+	d := time.Second
+	for i := 1; i < attempts && d < 10*time.Minute; i++ {
+		d *= 2
+	}
+	if d > 10*time.Minute {
+		d = 10 * time.Minute
+	}
+	return d
+}
+
+// Queue 可靠队列: 取出的任务在Ack前留在processing中,worker崩溃后由reaper放回;
+// 支持延迟投递、失败重试和死信队列。
+// Dequeue使用BLMOVE和小数秒的阻塞超时,需要redis 6.2+
+type Queue struct {
+	w      *Wrapper
+	name   string
+	option queueOption
+
+	jobs, ready, processing, inflight, delayed, dead, leases string
+
+	reapOffset int64 // processing中下次检查有没有租约的起点
+}
+
+func NewQueue(w *Wrapper, name string, opts ...QueueOption) *Queue {
+	option := queueOption{
+		visibilityTimeout: 30 * time.Second,
+		maxAttempts:       5,
+		backoff:           defaultQueueBackoff,
+		pollTimeout:       time.Second,
+		reapInterval:      time.Second,
+	}
+	for _, opt := range opts {
+		opt(&option)
+	}
+
+	key := func(kind string) string {
+		return fmt.Sprintf("queue:{%s}:%s", name, kind)
+	}
+	return &Queue{
+		w:          w,
+		name:       name,
+		option:     option,
+		jobs:       key("jobs"),
+		ready:      key("ready"),
+		processing: key("processing"),
+		inflight:   key("inflight"),
+		delayed:    key("delayed"),
+		dead:       key("dead"),
+		leases:     key("leases"),
+	}
+}
+
+func (q *Queue) wrapper(ctx context.Context) *Wrapper {
+	if ctx == nil {
+		return q.w
+	}
+	return q.w.WithContext(ctx)
+}
+
+// Enqueue 立即投递,返回任务id
+func (q *Queue) Enqueue(ctx context.Context, payload []byte) (string, error) {
+	return q.EnqueueIn(ctx, payload, 0)
+}
+
+// EnqueueIn delay后投递
+func (q *Queue) EnqueueIn(ctx context.Context, payload []byte, delay time.Duration) (string, error) {
+	job := &Job{
+		ID:         newLockToken(),
+		Payload:    payload,
+		EnqueuedAt: time.Now(),
+	}
+	body, err := json.Marshal(job)
+	if err != nil {
+		return "", errors.WithMessage(err, "marshal job")
+	}
+
+	_, err = q.wrapper(ctx).EvalSha(QueueEnqueueScriptName,
+		[]string{q.jobs, q.ready, q.delayed}, []interface{}{job.ID, body, delay.Milliseconds()})
+	if err != nil {
+		return "", errors.WithMessage(err, "enqueue")
+	}
+	return job.ID, nil
+}
+
+// Dequeue 取出一个任务并登记租约,等待pollTimeout仍没有任务时返回ErrNil。
+// 处理完成后必须调用Ack或Nack,处理时间可能超过可见性超时的调用Touch续租
+func (q *Queue) Dequeue(ctx context.Context) (*Job, error) {
+	w := q.wrapper(ctx)
+	for {
+		id, err := replyString(w.ExecRedisCommand("BLMOVE",
+			w.WithPrefix(q.ready), w.WithPrefix(q.processing), "RIGHT", "LEFT", q.option.pollTimeout.Seconds()))
+		if err != nil {
+			return nil, err
+		}
+
+		lease := newLockToken()
+		body, err := replyBytes(w.EvalSha(QueueLeaseScriptName,
+			[]string{q.processing, q.inflight, q.jobs, q.leases}, []interface{}{id, q.option.visibilityTimeout.Milliseconds(), lease}))
+		if IsNil(err) {
+			// 任务内容已被删除,跳过
+			continue
+		}
+		if err != nil {
+			return nil, errors.WithMessage(err, "lease")
+		}
+
+		job := &Job{}
+		if err := json.Unmarshal(body, job); err != nil {
+			return nil, errors.WithMessage(err, "unmarshal job "+id)
+		}
+		job.Lease = lease
+		return job, nil
+	}
+}
+
+// Ack 处理成功,删除任务。租约已过期(包括已被其他worker重新取出)时返回ErrJobLost
+func (q *Queue) Ack(ctx context.Context, job *Job) error {
+	n, err := replyInt(q.wrapper(ctx).EvalSha(QueueAckScriptName,
+		[]string{q.processing, q.inflight, q.jobs, q.leases}, []interface{}{job.ID, job.Lease}))
+	if err != nil {
+		return errors.WithMessage(err, "ack")
+	}
+	if n == 0 {
+		return ErrJobLost
+	}
+	return nil
+}
+
+// Nack 处理失败,按backoff延迟重试,失败次数达到maxAttempts时进入死信队列。租约已过期时返回ErrJobLost
+func (q *Queue) Nack(ctx context.Context, job *Job, cause error) error {
+	retry := *job
+	retry.Attempts++
+	retry.Lease = ""
+	if cause != nil {
+		retry.LastError = cause.Error()
+	}
+	body, err := json.Marshal(&retry)
+	if err != nil {
+		return errors.WithMessage(err, "marshal job")
+	}
+
+	delay := int64(-1)
+	if retry.Attempts < q.option.maxAttempts {
+		delay = q.option.backoff(retry.Attempts).Milliseconds()
+	}
+	n, err := replyInt(q.wrapper(ctx).EvalSha(QueueRetryScriptName,
+		[]string{q.processing, q.inflight, q.jobs, q.delayed, q.dead, q.leases}, []interface{}{job.ID, body, delay, job.Lease}))
+	if err != nil {
+		return errors.WithMessage(err, "retry")
+	}
+	if n == 0 {
+		return ErrJobLost
+	}
+	return nil
+}
+
+// Touch 续租到可见性超时, 租约已过期时返回ErrJobLost
+func (q *Queue) Touch(ctx context.Context, job *Job) error {
+	n, err := replyInt(q.wrapper(ctx).EvalSha(QueueTouchScriptName,
+		[]string{q.inflight, q.leases}, []interface{}{job.ID, q.option.visibilityTimeout.Milliseconds(), job.Lease}))
+	if err != nil {
+		return errors.WithMessage(err, "touch")
+	}
+	if n == 0 {
+		return ErrJobLost
+	}
+	return nil
+}
+
+// Reap 放回租约过期的任务,并投递到期的延迟任务。Run会定期调用
+func (q *Queue) Reap(ctx context.Context) (requeued, promoted int, err error) {
+	w := q.wrapper(ctx)
+	ret, err := replyInt64s(w.EvalSha(QueueReapScriptName,
+		[]string{q.processing, q.inflight, q.ready, q.leases},
+		[]interface{}{q.option.visibilityTimeout.Milliseconds(), queueReapBatch, atomic.LoadInt64(&q.reapOffset)}))
+	if err == nil && len(ret) != 2 {
+		err = errors.Errorf("unexpected reply %v", ret)
+	}
+	if err != nil {
+		return 0, 0, errors.WithMessage(err, "reap")
+	}
+	requeued = int(ret[0])
+	atomic.StoreInt64(&q.reapOffset, ret[1])
+	promoted, err = replyInt(w.EvalSha(QueuePromoteScriptName,
+		[]string{q.delayed, q.ready}, []interface{}{queueReapBatch}))
+	if err != nil {
+		return requeued, 0, errors.WithMessage(err, "promote")
+	}
+	return requeued, promoted, nil
+}
+
+// DeadLetters 死信队列中的任务,最新进入的在前
+func (q *Queue) DeadLetters(ctx context.Context, start, stop int64) ([]*Job, error) {
+	w := q.wrapper(ctx)
+	ids, err := replyStrings(w.ExecRedisCommand("LRANGE", w.WithPrefix(q.dead), start, stop))
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	fields := make([]interface{}, len(ids))
+	for i, id := range ids {
+		fields[i] = id
+	}
+	bodies, err := replyByteSlices(w.ExecRedisCommand("HMGET", append([]interface{}{w.WithPrefix(q.jobs)}, fields...)...))
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0, len(bodies))
+	for _, body := range bodies {
+		if body == nil {
+			continue
+		}
+		job := &Job{}
+		if err := json.Unmarshal(body, job); err != nil {
+			return nil, errors.WithMessage(err, "unmarshal job")
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Len ready、processing、delayed、dead中的任务数
+func (q *Queue) Len(ctx context.Context) (ready, processing, delayed, dead int64, err error) {
+	p := q.wrapper(ctx).Pipeline()
+	r1 := p.Do("LLEN", q.ready)
+	r2 := p.Do("LLEN", q.processing)
+	r3 := p.Do("ZCARD", q.delayed)
+	r4 := p.Do("LLEN", q.dead)
+	if err = p.Exec(); err != nil {
+		return
+	}
+	ready, _ = r1.Int64()
+	processing, _ = r2.Int64()
+	delayed, _ = r3.Int64()
+	dead, _ = r4.Int64()
+	return
+}
+
+// JobHandler 返回nil时Ack,返回错误或panic时Nack
+type JobHandler func(ctx context.Context, job *Job) error
+
+// Run 启动concurrency个worker和一个reaper,阻塞到ctx结束。
+// ctx结束后不再取新任务,等待处理中的任务完成后返回
+func (q *Queue) Run(ctx context.Context, concurrency int, handler JobHandler) error {
+	if concurrency <= 0 {
+		return errors.New("concurrency must be positive")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		q.reapLoop(ctx)
+	}()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.workLoop(ctx, handler)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (q *Queue) reapLoop(ctx context.Context) {
+	ticker := time.NewTicker(q.option.reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, _, err := q.Reap(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("queue %s reap err:%s", q.name, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (q *Queue) workLoop(ctx context.Context, handler JobHandler) {
+	for ctx.Err() == nil {
+		job, err := q.Dequeue(ctx)
+		if IsNil(err) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("queue %s dequeue err:%s", q.name, err)
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		// Ack/Nack不受ctx结束影响,保证已取出的任务有结果
+		err = q.handle(ctx, job, handler)
+		if err == nil {
+			err = q.Ack(context.Background(), job)
+		} else {
+			err = q.Nack(context.Background(), job, err)
+		}
+		if err != nil {
+			log.Printf("queue %s finish job %s err:%s", q.name, job.ID, err)
+		}
+	}
+}
+
+func (q *Queue) handle(ctx context.Context, job *Job, handler JobHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
@@ -0,0 +1,239 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ziyoumeng/sdk/driver/redis"
+)
+
+// assertQueueLen 检查ready、processing、delayed、dead中的任务数
+func assertQueueLen(t *testing.T, q *redis.Queue, want ...int64) {
+	t.Helper()
+	ready, processing, delayed, dead, err := q.Len(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, want, []int64{ready, processing, delayed, dead})
+}
+
+func newTestQueue(w *redis.Wrapper, opts ...redis.QueueOption) *redis.Queue {
+	opts = append([]redis.QueueOption{
+		redis.WithQueuePollTimeout(20 * time.Millisecond),
+		redis.WithQueueVisibilityTimeout(10 * time.Second),
+	}, opts...)
+	return redis.NewQueue(w, "q", opts...)
+}
+
+func TestQueueAck(t *testing.T) {
+	w, _, _ := getWrapper(t)
+	ctx := context.Background()
+	q := newTestQueue(w)
+
+	id, err := q.Enqueue(ctx, []byte("a"))
+	assert.NoError(t, err)
+	assertQueueLen(t, q, 1, 0, 0, 0)
+
+	job, err := q.Dequeue(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, id, job.ID)
+	assert.Equal(t, []byte("a"), job.Payload)
+	assertQueueLen(t, q, 0, 1, 0, 0)
+
+	assert.NoError(t, q.Ack(ctx, job))
+	assertQueueLen(t, q, 0, 0, 0, 0)
+	assert.Equal(t, redis.ErrJobLost, q.Ack(ctx, job))
+
+	_, err = q.Dequeue(ctx)
+	assert.True(t, redis.IsNil(err))
+}
+
+func TestQueueRetry(t *testing.T) {
+	w, _, clock := getWrapper(t)
+	ctx := context.Background()
+	q := newTestQueue(w, redis.WithQueueMaxAttempts(2), redis.WithQueueBackoff(func(int) time.Duration {
+		return time.Second
+	}))
+
+	_, err := q.Enqueue(ctx, []byte("a"))
+	assert.NoError(t, err)
+	job, err := q.Dequeue(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, q.Nack(ctx, job, errors.New("boom")))
+	assertQueueLen(t, q, 0, 0, 1, 0)
+
+	// backoff到期前不会投递
+	_, promoted, err := q.Reap(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, promoted)
+	clock.Add(time.Second)
+	_, promoted, err = q.Reap(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, promoted)
+
+	job, err = q.Dequeue(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 1, job.Attempts)
+	assert.Equal(t, "boom", job.LastError)
+
+	// 达到maxAttempts进入死信队列
+	assert.NoError(t, q.Nack(ctx, job, errors.New("again")))
+	assertQueueLen(t, q, 0, 0, 0, 1)
+	dead, err := q.DeadLetters(ctx, 0, -1)
+	assert.NoError(t, err)
+	if assert.Len(t, dead, 1) {
+		assert.Equal(t, job.ID, dead[0].ID)
+		assert.Equal(t, 2, dead[0].Attempts)
+		assert.Equal(t, "again", dead[0].LastError)
+	}
+}
+
+func TestQueueVisibility(t *testing.T) {
+	w, _, clock := getWrapper(t)
+	ctx := context.Background()
+	q := newTestQueue(w)
+
+	_, err := q.Enqueue(ctx, []byte("a"))
+	assert.NoError(t, err)
+	job, err := q.Dequeue(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// 续租后原来的到期时间不再生效
+	clock.Add(5 * time.Second)
+	assert.NoError(t, q.Touch(ctx, job))
+	clock.Add(5 * time.Second)
+	requeued, _, err := q.Reap(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, requeued)
+
+	// 租约过期后放回队列, 原worker的Ack/Touch失败
+	clock.Add(5 * time.Second)
+	requeued, _, err = q.Reap(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, requeued)
+	assertQueueLen(t, q, 1, 0, 0, 0)
+	assert.Equal(t, redis.ErrJobLost, q.Ack(ctx, job))
+	assert.Equal(t, redis.ErrJobLost, q.Touch(ctx, job))
+
+	again, err := q.Dequeue(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, job.ID, again.ID)
+	assert.NoError(t, q.Ack(ctx, again))
+	assertQueueLen(t, q, 0, 0, 0, 0)
+}
+
+func TestQueueStaleLease(t *testing.T) {
+	w, _, clock := getWrapper(t)
+	ctx := context.Background()
+	q := newTestQueue(w, redis.WithQueueMaxAttempts(1))
+
+	_, err := q.Enqueue(ctx, []byte("a"))
+	assert.NoError(t, err)
+	first, err := q.Dequeue(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// 租约过期后被另一个worker取出, 原worker的Ack/Nack/Touch不能影响新的租约
+	clock.Add(10 * time.Second)
+	requeued, _, err := q.Reap(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, requeued)
+	second, err := q.Dequeue(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, first.ID, second.ID)
+	assert.NotEqual(t, first.Lease, second.Lease)
+
+	assert.Equal(t, redis.ErrJobLost, q.Ack(ctx, first))
+	assert.Equal(t, redis.ErrJobLost, q.Nack(ctx, first, errors.New("late")))
+	assert.Equal(t, redis.ErrJobLost, q.Touch(ctx, first))
+	assertQueueLen(t, q, 0, 1, 0, 0)
+
+	assert.NoError(t, q.Touch(ctx, second))
+	assert.NoError(t, q.Ack(ctx, second))
+	assertQueueLen(t, q, 0, 0, 0, 0)
+}
+
+func TestQueueOrphan(t *testing.T) {
+	w, srv, clock := getWrapper(t)
+	ctx := context.Background()
+	q := newTestQueue(w)
+
+	// BLMOVE之后、登记租约之前worker崩溃, 任务只在processing中
+	for i := 0; i < 3; i++ {
+		_, err := q.Enqueue(ctx, []byte("a"))
+		assert.NoError(t, err)
+		_, err = srv.Do("LMOVE", testPrefix+":queue:{q}:ready", testPrefix+":queue:{q}:processing", "RIGHT", "LEFT")
+		assert.NoError(t, err)
+	}
+	requeued, _, err := q.Reap(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, requeued)
+	assertQueueLen(t, q, 0, 3, 0, 0)
+
+	clock.Add(10 * time.Second)
+	requeued, _, err = q.Reap(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, requeued)
+	assertQueueLen(t, q, 3, 0, 0, 0)
+}
+
+func TestQueueDelay(t *testing.T) {
+	w, _, clock := getWrapper(t)
+	ctx := context.Background()
+	q := newTestQueue(w)
+
+	_, err := q.EnqueueIn(ctx, []byte("a"), 5*time.Second)
+	assert.NoError(t, err)
+	assertQueueLen(t, q, 0, 0, 1, 0)
+	_, err = q.Dequeue(ctx)
+	assert.True(t, redis.IsNil(err))
+
+	clock.Add(5 * time.Second)
+	_, promoted, err := q.Reap(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, promoted)
+	job, err := q.Dequeue(ctx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []byte("a"), job.Payload)
+	}
+}
+
+func TestQueueRun(t *testing.T) {
+	w, _, _ := getWrapper(t)
+	q := newTestQueue(w, redis.WithQueueReapInterval(10*time.Millisecond), redis.WithQueueBackoff(func(int) time.Duration {
+		return 0
+	}))
+
+	_, err := q.Enqueue(context.Background(), []byte("a"))
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testWaitTimeout)
+	defer cancel()
+	var calls int32
+	err = q.Run(ctx, 2, func(ctx context.Context, job *redis.Job) error {
+		// 第一次panic, 由reaper重新投递后成功
+		if atomic.AddInt32(&calls, 1) == 1 {
+			panic("boom")
+		}
+		cancel()
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+	assertQueueLen(t, q, 0, 0, 0, 0)
+}
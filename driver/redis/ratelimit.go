@@ -0,0 +1,221 @@
+package redis
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	FixedWindowScriptName = "ratelimit_fixed_window"
+	SlidingLogScriptName  = "ratelimit_sliding_log"
+	TokenBucketScriptName = "ratelimit_token_bucket"
+	GCRAScriptName        = "ratelimit_gcra"
+)
+
+func init() {
+	AddScript(NewLuaScript(FixedWindowScriptName, fixedWindowScript, 1))
+	AddScript(NewLuaScript(SlidingLogScriptName, slidingLogScript, 1))
+	AddScript(NewLuaScript(TokenBucketScriptName, tokenBucketScript, 1))
+	AddScript(NewLuaScript(GCRAScriptName, gcraScript, 1))
+}
+
+// 各限流脚本都返回 {是否放行(0/1), 需要等待的毫秒数, 剩余额度},
+// 时间取redis的TIME,避免各副本时钟不一致
+const nowMsLua = `
+redis.replicate_commands();
+local t = redis.call('time');
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000);
+`
+
+// 固定窗口, 窗口从key第一次请求开始计时
+// ARGV[1] 窗口内额度 ARGV[2] 窗口(毫秒) ARGV[3] 本次消耗
+var fixedWindowScript = `
+local limit, window, n = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]);
+local cur = tonumber(redis.call('get', KEYS[1]) or '0');
+if cur + n > limit then
+	local ttl = redis.call('pttl', KEYS[1]);
+	if ttl < 0 then ttl = window end;
+	return {0, ttl, limit - cur};
+end;
+cur = redis.call('incrby', KEYS[1], n);
+if cur == n then
+	redis.call('pexpire', KEYS[1], window);
+end;
+return {1, 0, limit - cur};
+`
+
+// 滑动日志, 用zset记录窗口内每次请求的时间
+// ARGV[1] 窗口内额度 ARGV[2] 窗口(毫秒) ARGV[3] 本次消耗 ARGV[4] 请求唯一标识
+var slidingLogScript = nowMsLua + `
+local limit, window, n = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]);
+redis.call('zremrangebyscore', KEYS[1], '-inf', now - window);
+local count = redis.call('zcard', KEYS[1]);
+if count + n > limit then
+	local retry = window;
+	local idx = count + n - limit - 1;
+	if idx < count then
+		-- 第idx+1早的记录滑出窗口后额度才够
+		local e = redis.call('zrange', KEYS[1], idx, idx, 'withscores');
+		retry = tonumber(e[2]) + window - now;
+	end;
+	return {0, retry, limit - count};
+end;
+for i = 1, n do
+	redis.call('zadd', KEYS[1], now, now .. ':' .. ARGV[4] .. ':' .. i);
+end;
+redis.call('pexpire', KEYS[1], window);
+return {1, 0, limit - count - n};
+`
+
+// 令牌桶, hash中记录剩余令牌和上次更新时间
+// ARGV[1] 桶容量 ARGV[2] 每毫秒生成的令牌数 ARGV[3] 本次消耗
+var tokenBucketScript = nowMsLua + `
+local capacity, rate, n = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]);
+local b = redis.call('hmget', KEYS[1], 'tokens', 'ts');
+local tokens = tonumber(b[1]) or capacity;
+local ts = tonumber(b[2]) or now;
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate);
+local allowed, retry = 0, 0;
+if tokens >= n then
+	tokens = tokens - n;
+	allowed = 1;
+else
+	retry = math.ceil((n - tokens) / rate);
+end;
+redis.call('hmset', KEYS[1], 'tokens', tokens, 'ts', now);
+redis.call('pexpire', KEYS[1], math.ceil(capacity / rate));
+return {allowed, retry, math.floor(tokens)};
+`
+
+// GCRA, 只记录理论到达时间(TAT)
+// ARGV[1] 每个请求的间隔(毫秒) ARGV[2] 允许的突发量 ARGV[3] 本次消耗
+var gcraScript = nowMsLua + `
+local emission, burst, n = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]);
+local dvt = emission * burst;
+local tat = tonumber(redis.call('get', KEYS[1]) or '0');
+if tat < now then tat = now end;
+local newTat = tat + n * emission;
+local diff = now - (newTat - dvt);
+if diff < 0 then
+	return {0, math.ceil(-diff), math.floor((now - (tat - dvt)) / emission)};
+end;
+redis.call('set', KEYS[1], newTat, 'px', math.ceil(newTat - now));
+return {1, 0, math.floor(diff / emission)};
+`
+
+// RateLimiter 多副本共享的限流器
+type RateLimiter interface {
+	// Allow 消耗n个额度,不允许时retryAfter为额度足够前需要等待的时间,
+	// remaining为剩余额度
+	Allow(ctx context.Context, key string, n int) (allowed bool, retryAfter time.Duration, remaining int, err error)
+}
+
+type scriptLimiter struct {
+	w          *Wrapper
+	scriptName string
+	keyPrefix  string
+	args       func(n int) []interface{}
+}
+
+func (l *scriptLimiter) Allow(ctx context.Context, key string, n int) (bool, time.Duration, int, error) {
+	if n <= 0 {
+		return false, 0, 0, errors.New("n must be positive")
+	}
+	w := l.w
+	if ctx != nil {
+		w = w.WithContext(ctx)
+	}
+
+	values, err := replyInt64s(w.EvalSha(l.scriptName, []string{l.keyPrefix + key}, l.args(n)))
+	if err != nil {
+		return false, 0, 0, errors.WithMessage(err, l.scriptName)
+	}
+	if len(values) != 3 {
+		return false, 0, 0, errors.Errorf("%s: unexpected reply %v", l.scriptName, values)
+	}
+	return values[0] == 1, time.Duration(values[1]) * time.Millisecond, int(values[2]), nil
+}
+
+// NewFixedWindowLimiter 每个window内最多limit个额度,窗口边界处可能出现两倍突发
+func NewFixedWindowLimiter(w *Wrapper, limit int, window time.Duration) (RateLimiter, error) {
+	if err := checkWindowLimit(limit, window); err != nil {
+		return nil, err
+	}
+	return &scriptLimiter{
+		w:          w,
+		scriptName: FixedWindowScriptName,
+		keyPrefix:  "ratelimit:fw:",
+		args: func(n int) []interface{} {
+			return []interface{}{limit, window.Milliseconds(), n}
+		},
+	}, nil
+}
+
+// NewSlidingLogLimiter 任意window长度的时间段内最多limit个额度,精确但每个额度占用一条zset记录
+func NewSlidingLogLimiter(w *Wrapper, limit int, window time.Duration) (RateLimiter, error) {
+	if err := checkWindowLimit(limit, window); err != nil {
+		return nil, err
+	}
+	return &scriptLimiter{
+		w:          w,
+		scriptName: SlidingLogScriptName,
+		keyPrefix:  "ratelimit:sl:",
+		args: func(n int) []interface{} {
+			return []interface{}{limit, window.Milliseconds(), n, newLockToken()}
+		},
+	}, nil
+}
+
+// NewTokenBucketLimiter 每秒生成ratePerSecond个令牌,最多积攒capacity个
+func NewTokenBucketLimiter(w *Wrapper, ratePerSecond float64, capacity int) (RateLimiter, error) {
+	// 脚本中按rate做除数
+	if !(ratePerSecond > 0) || math.IsInf(ratePerSecond, 1) {
+		return nil, errors.Errorf("ratelimit: invalid rate %v", ratePerSecond)
+	}
+	if capacity <= 0 {
+		return nil, errors.New("ratelimit: capacity must be positive")
+	}
+	perMs := strconv.FormatFloat(ratePerSecond/1000, 'g', -1, 64)
+	return &scriptLimiter{
+		w:          w,
+		scriptName: TokenBucketScriptName,
+		keyPrefix:  "ratelimit:tb:",
+		args: func(n int) []interface{} {
+			return []interface{}{capacity, perMs, n}
+		},
+	}, nil
+}
+
+// NewGCRALimiter 平均每period最多limit个额度,允许burst个突发,每个key只占一个string
+func NewGCRALimiter(w *Wrapper, limit int, period time.Duration, burst int) (RateLimiter, error) {
+	if err := checkWindowLimit(limit, period); err != nil {
+		return nil, err
+	}
+	if burst <= 0 {
+		return nil, errors.New("ratelimit: burst must be positive")
+	}
+	emission := strconv.FormatFloat(float64(period.Milliseconds())/float64(limit), 'g', -1, 64)
+	return &scriptLimiter{
+		w:          w,
+		scriptName: GCRAScriptName,
+		keyPrefix:  "ratelimit:gcra:",
+		args: func(n int) []interface{} {
+			return []interface{}{emission, burst, n}
+		},
+	}, nil
+}
+
+// checkWindowLimit 脚本按毫秒计时, window不足1ms时为0
+func checkWindowLimit(limit int, window time.Duration) error {
+	if limit <= 0 {
+		return errors.New("ratelimit: limit must be positive")
+	}
+	if window < time.Millisecond {
+		return errors.Errorf("ratelimit: window %s less than 1ms", window)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package redis_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ziyoumeng/sdk/driver/redis"
+)
+
+func TestRateLimiter(t *testing.T) {
+	w, _, clock := getWrapper(t)
+	ctx := context.Background()
+
+	limiters := make(map[string]redis.RateLimiter)
+	for name, newLimiter := range map[string]func() (redis.RateLimiter, error){
+		"fixed_window": func() (redis.RateLimiter, error) { return redis.NewFixedWindowLimiter(w, 2, time.Second) },
+		"sliding_log":  func() (redis.RateLimiter, error) { return redis.NewSlidingLogLimiter(w, 2, time.Second) },
+		"token_bucket": func() (redis.RateLimiter, error) { return redis.NewTokenBucketLimiter(w, 2, 2) },
+		"gcra":         func() (redis.RateLimiter, error) { return redis.NewGCRALimiter(w, 2, time.Second, 2) },
+	} {
+		limiter, err := newLimiter()
+		if !assert.NoError(t, err, name) {
+			return
+		}
+		limiters[name] = limiter
+	}
+	for name, limiter := range limiters {
+		for i := 0; i < 2; i++ {
+			allowed, _, _, err := limiter.Allow(ctx, "user", 1)
+			assert.NoError(t, err, name)
+			assert.True(t, allowed, name)
+		}
+		allowed, retryAfter, remaining, err := limiter.Allow(ctx, "user", 1)
+		assert.NoError(t, err, name)
+		assert.False(t, allowed, name)
+		assert.Equal(t, 0, remaining, name)
+		assert.True(t, retryAfter > 0 && retryAfter <= time.Second, "%s retryAfter %s", name, retryAfter)
+
+		clock.Add(retryAfter)
+		allowed, _, _, err = limiter.Allow(ctx, "user", 1)
+		assert.NoError(t, err, name)
+		assert.True(t, allowed, name)
+		clock.Add(time.Second)
+	}
+}
+
+func TestRateLimiterInvalid(t *testing.T) {
+	w, _, _ := getWrapper(t)
+
+	for name, newLimiter := range map[string]func() (redis.RateLimiter, error){
+		"fixed_window_limit":  func() (redis.RateLimiter, error) { return redis.NewFixedWindowLimiter(w, 0, time.Second) },
+		"fixed_window_window": func() (redis.RateLimiter, error) { return redis.NewFixedWindowLimiter(w, 1, time.Microsecond) },
+		"sliding_log_limit":   func() (redis.RateLimiter, error) { return redis.NewSlidingLogLimiter(w, -1, time.Second) },
+		"token_bucket_rate":   func() (redis.RateLimiter, error) { return redis.NewTokenBucketLimiter(w, 0, 2) },
+		"token_bucket_nan":    func() (redis.RateLimiter, error) { return redis.NewTokenBucketLimiter(w, math.NaN(), 2) },
+		"token_bucket_inf":    func() (redis.RateLimiter, error) { return redis.NewTokenBucketLimiter(w, math.Inf(1), 2) },
+		"token_bucket_cap":    func() (redis.RateLimiter, error) { return redis.NewTokenBucketLimiter(w, 1, 0) },
+		"gcra_limit":          func() (redis.RateLimiter, error) { return redis.NewGCRALimiter(w, 0, time.Second, 1) },
+		"gcra_period":         func() (redis.RateLimiter, error) { return redis.NewGCRALimiter(w, 1, 0, 1) },
+		"gcra_burst":          func() (redis.RateLimiter, error) { return redis.NewGCRALimiter(w, 1, time.Second, 0) },
+	} {
+		limiter, err := newLimiter()
+		assert.Error(t, err, name)
+		assert.Nil(t, limiter, name)
+	}
+}
@@ -1,53 +1,120 @@
 package redis
 
 import (
+	"context"
 	"fmt"
 	"github.com/creasty/defaults"
-	"github.com/garyburd/redigo/redis"
 	redigo "github.com/gomodule/redigo/redis"
 	"github.com/pkg/errors"
 	"log"
+	"strings"
+	"time"
 )
 
 var (
 	ErrRedisAddrIncorrect = errors.New("redis addr incorrect")
+	// ErrNil key/field不存在或阻塞命令超时, Wrapper和Result返回的redigo.ErrNil都会换成ErrNil
+	ErrNil = errors.New("redis: nil")
 )
 
-func newPool(conf Config) (*redigo.Pool, error) {
-	if conf.RedisAddr == "" {
-		return nil, ErrRedisAddrIncorrect
-	}
+// IsNil 判断是否为不存在的错误,支持errors.WithMessage包装过的错误, 直接使用redigo得到的redigo.ErrNil也算
+func IsNil(err error) bool {
+	cause := errors.Cause(err)
+	return cause == ErrNil || cause == redigo.ErrNil
+}
 
-	if err := defaults.Set(&conf); err != nil {
-		return nil, errors.WithMessage(err, "set defaults")
+// dialOptions 单节点、sentinel发现的master和cluster各节点共用的连接参数
+func dialOptions(conf Config) []redigo.DialOption {
+	options := []redigo.DialOption{
+		redigo.DialConnectTimeout(conf.RedisDialTimeout),
+		redigo.DialReadTimeout(conf.RedisReadTimeout),
+		redigo.DialWriteTimeout(conf.RedisWriteTimeout),
+		redigo.DialDatabase(conf.RedisDB),
 	}
+	if conf.RedisPassword != "" {
+		options = append(options, redigo.DialPassword(conf.RedisPassword))
+	}
+	if conf.RedisUsername != "" {
+		options = append(options, redigo.DialUsername(conf.RedisUsername))
+	}
+	if conf.RedisTLS {
+		options = append(options,
+			redigo.DialUseTLS(true),
+			redigo.DialTLSSkipVerify(conf.RedisTLSSkipVerify))
+		if conf.RedisTLSConfig != nil {
+			options = append(options, redigo.DialTLSConfig(conf.RedisTLSConfig))
+		}
+	}
+	return options
+}
 
-
+// newNodePool 连接单个redis节点的连接池, afterDial可为空,用于sentinel校验角色等
+func newNodePool(conf Config, addr string, afterDial func(redigo.Conn) error) *redigo.Pool {
+	options := dialOptions(conf)
 	pool := &redigo.Pool{
-		Dial: func() (redigo.Conn, error) {
-			c, err := redis.Dial("tcp", conf.RedisAddr)
+		DialContext: func(ctx context.Context) (redigo.Conn, error) {
+			c, err := redigo.DialContext(ctx, "tcp", addr, options...)
 			if err != nil {
 				return nil, err
 			}
-
-			if _, err := c.Do("AUTH", conf.RedisPassword); err != nil {
-				c.Close()
-				return nil, err
+			if afterDial != nil {
+				if err := afterDial(c); err != nil {
+					c.Close()
+					return nil, err
+				}
 			}
-			//if _, err := c.Do("SELECT", db); err != nil {
-			//  c.Close()
-			//  return nil, err
-			//}
 			return c, nil
 		},
+		MaxIdle:         conf.RedisMaxIdl,
+		MaxActive:       conf.RedisMaxActive,
+		Wait:            conf.RedisWait,
+		MaxConnLifetime: conf.RedisMaxConnLifetime,
+	}
+	if conf.RedisIdleTimeout > 0 {
+		pool.IdleTimeout = conf.RedisIdleTimeout
+	}
+	if conf.RedisTestOnBorrowAfter >= 0 {
+		testAfter := conf.RedisTestOnBorrowAfter
+		pool.TestOnBorrow = func(c redigo.Conn, lastUsed time.Time) error {
+			if time.Since(lastUsed) < testAfter {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		}
+	}
+	return pool
+}
+
+// newSource 按配置选择cluster、sentinel或单节点
+func newSource(conf Config) (ConnSource, error) {
+	if err := defaults.Set(&conf); err != nil {
+		return nil, errors.WithMessage(err, "set defaults")
+	}
+
+	if conf.RedisUsername != "" && conf.RedisPassword == "" {
+		return nil, errors.New("username without password")
+	}
+
+	switch {
+	case len(conf.RedisClusterAddrs) > 0:
+		return newClusterSource(conf)
+	case len(conf.RedisSentinelAddrs) > 0:
+		return newSentinelSource(conf)
+	case conf.RedisAddr == "":
+		return nil, ErrRedisAddrIncorrect
+	default:
+		return poolSource{newNodePool(conf, conf.RedisAddr, nil)}, nil
 	}
-	return pool, nil
 }
 
 type Wrapper struct {
-	pool      *redigo.Pool
-	luaScript map[string]*LuaScript //脚本名=>LuaScript
+	source    ConnSource
+	luaScript *scriptRegistry // 脚本名=>LuaScript, WithContext复制出的Wrapper共用
 	prefix    string
+	ctx       context.Context
+	health    *healthChecker
+	hooks     *hookRegistry // WithContext复制出的Wrapper共用
 }
 
 func NewWrapper(c Config, prefix string) (*Wrapper, error) {
@@ -55,101 +122,185 @@ func NewWrapper(c Config, prefix string) (*Wrapper, error) {
 		return nil, errors.New("empty prefix")
 	}
 
-	pool, err := newPool(c)
+	source, err := newSource(c)
 	if err != nil {
-		return nil, errors.WithMessage(err, "newPool")
+		return nil, errors.WithMessage(err, "newSource")
+	}
+
+	cache, err := NewWrapperWithSource(source, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if c.RedisHealthCheckInterval > 0 {
+		go cache.health.run(cache, c.RedisHealthCheckInterval)
+	}
+	if c.RedisSlowThreshold > 0 {
+		cache.AddHook(NewSlowLogHook(c.RedisSlowThreshold))
+	}
+	if c.RedisPoolStatsInterval > 0 {
+		go cache.runPoolStats(c.RedisPoolStatsInterval)
+	}
+	return cache, nil
+}
+
+// NewWrapperWithSource 使用自定义的连接来源, 如测试时使用redistest.Server.Source()。
+// 创建失败时会关闭source
+func NewWrapperWithSource(source ConnSource, prefix string) (*Wrapper, error) {
+	if prefix == "" {
+		source.Close()
+		return nil, errors.New("empty prefix")
 	}
 
 	cache := &Wrapper{
-		pool:   pool,
+		source: source,
 		prefix: prefix,
+		health: newHealthChecker(),
+		hooks:  &hookRegistry{},
 	}
-	err = cache.batchLoadLuaScript(Scripts)
+	err := cache.batchLoadLuaScript(Scripts)
 	if err != nil {
+		source.Close()
 		return nil, errors.WithMessage(err, "batchLoadLuaScript")
 	}
-
 	return cache, nil
 }
 
 func (w *Wrapper) batchLoadLuaScript(scripts []*LuaScript) error {
-	w.luaScript = make(map[string]*LuaScript)
+	w.luaScript = newScriptRegistry()
 	for _, script := range scripts {
 		err := w.loadLuaScript(script)
 		if err != nil {
 			return err
 		}
-		w.luaScript[script.GetScriptName()] = script
+		w.luaScript.add(script)
 	}
 	return nil
 }
 
+// loadLuaScript SCRIPT LOAD, cluster模式下会发往所有master
 func (w *Wrapper) loadLuaScript(script *LuaScript) (err error) {
-	conn := w.pool.Get()
+	conn := w.getConn()
 	defer func() {
 		if err1 := conn.Close(); err1 != nil {
 			log.Printf("err:%s", err)
 		}
 	}()
 
-	val := redigo.NewScript(script.GetKeyCount(), script.GetScript())
-	err = val.Load(conn)
+	// sha在NewLuaScript时已由脚本内容算出, 这里不再写入, 避免和并发执行脚本的goroutine竞争
+	_, err = w.do(conn, "SCRIPT", "LOAD", script.GetScript())
 	if err != nil {
 		err = errors.WithMessage(err, script.GetScriptName())
-		return
 	}
-	script.SetScriptSha(val.Hash())
 	return
 }
 
+// WithContext 返回绑定了ctx的Wrapper,其上所有命令在ctx取消或超时后立即返回
+//
+//	w.WithContext(ctx).GetString("key")
+func (w *Wrapper) WithContext(ctx context.Context) *Wrapper {
+	if ctx == nil {
+		panic("nil context")
+	}
+	w2 := *w
+	w2.ctx = ctx
+	return &w2
+}
+
+// Context 返回WithContext绑定的ctx,未绑定时为context.Background()
+func (w *Wrapper) Context() context.Context {
+	if w.ctx != nil {
+		return w.ctx
+	}
+	return context.Background()
+}
+
 // 执行redis命令, 执行完成后连接自动放回连接池
-func (w *Wrapper) ExecRedisCommand(command string, args ...interface{}) (interface{}, error) {
-	conn := w.pool.Get()
-	defer func() {
-		if err1 := conn.Close(); err1 != nil {
-			log.Printf("%s", err1)
-		}
-	}()
+func (w *Wrapper) ExecRedisCommand(command string, args ...interface{}) (ret interface{}, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		//log.Debugf("====>%+v", args)
+		ret, err = w.do(conn, command, args...)
+	})
+	return
+}
 
-	//log.Debugf("====>%+v", args)
-	return conn.Do(command, args...)
+// do 带上Wrapper绑定的ctx执行命令
+func (w *Wrapper) do(conn redigo.Conn, command string, args ...interface{}) (interface{}, error) {
+	if w.ctx == nil {
+		return conn.Do(command, args...)
+	}
+	return redigo.DoContext(conn, w.ctx, command, args...)
 }
 
 func (w *Wrapper) WithPrefix(key string) string {
 	return fmt.Sprintf("%s:%s", w.prefix, key)
 }
 
+// keyArgs 加上前缀的key和其余参数
+func (w *Wrapper) keyArgs(key string, args ...interface{}) []interface{} {
+	ret := make([]interface{}, 0, len(args)+1)
+	ret = append(ret, w.WithPrefix(key))
+	return append(ret, args...)
+}
+
+// prefixKeys 多key命令使用,cluster模式下keys需要用hash tag落在同一slot
+func (w *Wrapper) prefixKeys(keys []string) []interface{} {
+	ret := make([]interface{}, len(keys))
+	for i, key := range keys {
+		ret[i] = w.WithPrefix(key)
+	}
+	return ret
+}
+
 func (w *Wrapper) GetString(key string) (ret string, err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		ret, err = redigo.String(conn.Do("GET", w.WithPrefix(key)))
+		ret, err = replyString(w.do(conn, "GET", w.WithPrefix(key)))
 	})
 	return
 }
 
 func (w *Wrapper) GetBytes(key string) (ret []byte, err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		ret, err = redigo.Bytes(conn.Do("GET", w.WithPrefix(key)))
+		ret, err = replyBytes(w.do(conn, "GET", w.WithPrefix(key)))
+	})
+	return
+}
+
+// MGetBytes 返回值与keys一一对应,不存在的key为nil; cluster模式下按slot拆分
+func (w *Wrapper) MGetBytes(keys ...string) (ret [][]byte, err error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	w.Wrap(func(conn redigo.Conn) {
+		ret, err = replyByteSlices(w.do(conn, "MGET", w.prefixKeys(keys)...))
+	})
+	return
+}
+
+func (w *Wrapper) GetInt64(key string) (ret int64, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		ret, err = replyInt64(w.do(conn, "GET", w.WithPrefix(key)))
 	})
 	return
 }
 
+// Get 不存在时返回nil, nil; 建议使用GetBytes/GetString等带类型的方法
 func (w *Wrapper) Get(key string) (ret interface{}, err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		ret, err = conn.Do("GET", w.WithPrefix(key))
+		ret, err = w.do(conn, "GET", w.WithPrefix(key))
 	})
 	return
 }
 
 func (w *Wrapper) Set(key string, value interface{}) (err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		_, err = conn.Do("SET", w.WithPrefix(key), value)
+		_, err = w.do(conn, "SET", w.WithPrefix(key), value)
 	})
 	return
 }
 
 func (w *Wrapper) Del(key string) (err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		_, err = conn.Do("DEL", w.WithPrefix(key))
+		_, err = w.do(conn, "DEL", w.WithPrefix(key))
 	})
 	return
 }
@@ -157,16 +308,16 @@ func (w *Wrapper) Del(key string) (err error) {
 func (w *Wrapper) SetEX(key string, seconds int64, value interface{}) (err error) {
 	w.Wrap(func(conn redigo.Conn) {
 		if seconds <= 0 {
-			_, err = conn.Do("SET", w.WithPrefix(key), value)
+			_, err = w.do(conn, "SET", w.WithPrefix(key), value)
 		} else {
-			_, err = conn.Do("SETEX", w.WithPrefix(key), seconds, value)
+			_, err = w.do(conn, "SETEX", w.WithPrefix(key), seconds, value)
 		}
 	})
 	return
 }
 
 func (w *Wrapper) Wrap(doSomething func(conn redigo.Conn)) {
-	conn := w.pool.Get()
+	conn := w.instrument(w.getConn())
 	defer func() {
 		if err1 := conn.Close(); err1 != nil {
 			log.Printf("%s", err1)
@@ -176,14 +327,23 @@ func (w *Wrapper) Wrap(doSomething func(conn redigo.Conn)) {
 	doSomething(conn)
 }
 
+// getConn 从连接池取连接,绑定了ctx时等待空闲连接也受ctx控制
+func (w *Wrapper) getConn() redigo.Conn {
+	conn, err := w.source.GetContext(w.Context())
+	if err != nil {
+		return errorConn{err: err}
+	}
+	return conn
+}
+
 func (w *Wrapper) SetNX(key string, value interface{}) (ok bool, err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		ok, err = redigo.Bool(conn.Do("SETNX", w.WithPrefix(key), value))
+		ok, err = replyBool(w.do(conn, "SETNX", w.WithPrefix(key), value))
 	})
 	return
 }
 
-//pair = <score, value>
+// pair = <score, value>
 func (w *Wrapper) ZAdd(key string, pairs ...interface{}) (newAddNum int, err error) {
 	if len(pairs) == 0 || len(pairs)%2 != 0 {
 		return 0, errors.New("invalid pairs num")
@@ -193,24 +353,46 @@ func (w *Wrapper) ZAdd(key string, pairs ...interface{}) (newAddNum int, err err
 	args = append(args, pairs...)
 	//log.Debugf("ZADD===>%+v",args)
 	w.Wrap(func(conn redigo.Conn) {
-		newAddNum, err = redigo.Int(conn.Do("ZADD", args...))
+		newAddNum, err = replyInt(w.do(conn, "ZADD", args...))
 	})
 	return
 }
 
 func (w *Wrapper) ZRank(key, member string) (rank int, err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		rank, err = redigo.Int(conn.Do("ZRANK", w.WithPrefix(key), member))
+		rank, err = replyInt(w.do(conn, "ZRANK", w.WithPrefix(key), member))
+	})
+	return
+}
+
+// ZMember 有序集合的成员和分数
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// ZRangeWithScores 按分数从小到大返回[start, stop]的成员和分数
+func (w *Wrapper) ZRangeWithScores(key string, start, stop int64) (ret []ZMember, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		ret, err = zMembers(w.do(conn, "ZRANGE", w.WithPrefix(key), start, stop, "WITHSCORES"))
+	})
+	return
+}
+
+func (w *Wrapper) ZRangeMembers(key string, start, stop int64) (ret []string, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		ret, err = replyStrings(w.do(conn, "ZRANGE", w.WithPrefix(key), start, stop))
 	})
 	return
 }
 
+// ZRange 建议使用ZRangeWithScores/ZRangeMembers
 func (w *Wrapper) ZRange(key string, start, stop int32, isWithScore bool) (ret interface{}, err error) {
 	w.Wrap(func(conn redigo.Conn) {
 		if isWithScore {
-			ret, err = conn.Do("zrange", w.WithPrefix(key), start, stop, "WITHSCORES")
+			ret, err = w.do(conn, "zrange", w.WithPrefix(key), start, stop, "WITHSCORES")
 		} else {
-			ret, err = conn.Do("zrange", w.WithPrefix(key), start, stop)
+			ret, err = w.do(conn, "zrange", w.WithPrefix(key), start, stop)
 		}
 	})
 	return
@@ -218,14 +400,22 @@ func (w *Wrapper) ZRange(key string, start, stop int32, isWithScore bool) (ret i
 
 func (w *Wrapper) ZScore(key, member string) (score int64, err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		score, err = redigo.Int64(conn.Do("ZSCORE", w.WithPrefix(key), member))
+		score, err = replyInt64(w.do(conn, "ZSCORE", w.WithPrefix(key), member))
+	})
+	return
+}
+
+// ZScoreFloat 成员不存在时返回ErrNil
+func (w *Wrapper) ZScoreFloat(key, member string) (score float64, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		score, err = replyFloat64(w.do(conn, "ZSCORE", w.WithPrefix(key), member))
 	})
 	return
 }
 
 func (w *Wrapper) ZCard(key string) (num int64, err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		num, err = redigo.Int64(conn.Do("ZCARD", w.WithPrefix(key)))
+		num, err = replyInt64(w.do(conn, "ZCARD", w.WithPrefix(key)))
 	})
 	return
 }
@@ -238,98 +428,132 @@ func (w *Wrapper) ZRem(key string, members ...interface{}) (remNum int, err erro
 	args = append(args, w.WithPrefix(key))
 	args = append(args, members...)
 	w.Wrap(func(conn redigo.Conn) {
-		remNum, err = redigo.Int(conn.Do("ZREM", args...))
+		remNum, err = replyInt(w.do(conn, "ZREM", args...))
 	})
 	return
 }
 
 func (w *Wrapper) HSetBytes(key, field string, value []byte) (err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		_, err = conn.Do("HSET", w.WithPrefix(key), field, value)
+		_, err = w.do(conn, "HSET", w.WithPrefix(key), field, value)
 	})
 	return
 }
 
 func (w *Wrapper) HGetBytes(key, field string) (value []byte, err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		value, err = redigo.Bytes(conn.Do("HGET", w.WithPrefix(key), field))
+		value, err = replyBytes(w.do(conn, "HGET", w.WithPrefix(key), field))
 	})
 	return
 }
 
 func (w *Wrapper) HSetString(key, field, value string) (ret int, err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		ret, err = redigo.Int(conn.Do("HSET", w.WithPrefix(key), field, value))
+		ret, err = replyInt(w.do(conn, "HSET", w.WithPrefix(key), field, value))
 	})
 	return
 }
 
+// LPushInt64 建议使用LPush
 func (w *Wrapper) LPushInt64(key string, value int64) (err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		_, err = conn.Do("LPUSH", w.WithPrefix(key), value)
+		_, err = w.do(conn, "LPUSH", w.WithPrefix(key), value)
 	})
 	return
 }
 
+// LRangeAllInt64 建议使用LRangeInt64s
 func (w *Wrapper) LRangeAllInt64(key string) (ret []int64, err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		ret, err = redigo.Int64s(conn.Do("LRANGE", w.WithPrefix(key), 0, -1))
+		ret, err = replyInt64s(w.do(conn, "LRANGE", w.WithPrefix(key), 0, -1))
 	})
 	return
 }
 
 func (w *Wrapper) HIncrby(key, field string, num int32) (err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		_, err = redigo.Int(conn.Do("HINCRBY", w.WithPrefix(key), field, num))
+		_, err = replyInt(w.do(conn, "HINCRBY", w.WithPrefix(key), field, num))
 	})
 	return
 }
 
 func (w *Wrapper) HGetInt64(key, field string) (num int64, err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		num, err = redigo.Int64(conn.Do("HGET", w.WithPrefix(key), field))
+		num, err = replyInt64(w.do(conn, "HGET", w.WithPrefix(key), field))
 	})
 	return
 }
 
 func (w *Wrapper) HGetString(key, field string) (ret string, err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		ret, err = redigo.String(conn.Do("HGET", w.WithPrefix(key), field))
+		ret, err = replyString(w.do(conn, "HGET", w.WithPrefix(key), field))
 	})
 	return
 }
 
-//注意: 即使都不存在会,ret长度不为0,返回空字符串数组
+// 注意: 即使都不存在会,ret长度不为0,返回空字符串数组
 func (w *Wrapper) HMGetString(key string, field ...interface{}) (ret []string, err error) {
 	w.Wrap(func(conn redigo.Conn) {
 		var args []interface{}
 		args = append(args, w.WithPrefix(key))
 		args = append(args, field...)
-		ret, err = redigo.Strings(conn.Do("HMGET", args...))
+		ret, err = replyStrings(w.do(conn, "HMGET", args...))
+	})
+	return
+}
+
+// HMGetBytes 返回值与fields一一对应,不存在的field为nil
+func (w *Wrapper) HMGetBytes(key string, fields ...string) (ret [][]byte, err error) {
+	args := make([]interface{}, 0, len(fields)+1)
+	args = append(args, w.WithPrefix(key))
+	for _, field := range fields {
+		args = append(args, field)
+	}
+	w.Wrap(func(conn redigo.Conn) {
+		ret, err = replyByteSlices(w.do(conn, "HMGET", args...))
 	})
 	return
 }
 
+// HMGet 建议使用HMGetBytes/HMGetString
 func (w *Wrapper) HMGet(key string, field ...interface{}) (ret interface{}, err error) {
 	w.Wrap(func(conn redigo.Conn) {
 		var args []interface{}
 		args = append(args, w.WithPrefix(key))
 		args = append(args, field...)
-		ret, err = conn.Do("HMGET", args...)
+		ret, err = w.do(conn, "HMGET", args...)
+	})
+	return
+}
+
+// BRPopBytes timeout秒内没有数据时返回ErrNil, timeout为0表示一直阻塞
+func (w *Wrapper) BRPopBytes(key string, timeout int64) (ret []byte, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		var values [][]byte
+		values, err = replyByteSlices(w.do(conn, "BRPOP", w.WithPrefix(key), timeout))
+		if err != nil {
+			return
+		}
+		if len(values) != 2 {
+			err = errors.Errorf("unexpected BRPOP reply length %d", len(values))
+			return
+		}
+		ret = values[1]
 	})
 	return
 }
 
+// BRPOP 建议使用BRPopBytes
 func (w *Wrapper) BRPOP(key string, timeout int64) (ret interface{}, err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		ret, err = conn.Do("BRPOP", w.WithPrefix(key), timeout)
+		ret, err = w.do(conn, "BRPOP", w.WithPrefix(key), timeout)
 	})
 	return
 }
 
 func (w *Wrapper) HGetAllBytes(key string) (bytes [][]byte, err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		ret, err := redigo.StringMap(conn.Do("HGETALL", w.WithPrefix(key)))
+		ret, err := replyStringMap(w.do(conn, "HGETALL", w.WithPrefix(key)))
 		if err != nil {
 			return
 		}
@@ -345,7 +569,7 @@ func (w *Wrapper) HGetAllBytes(key string) (bytes [][]byte, err error) {
 
 func (w *Wrapper) HGetAll(key string) (ret map[string]string, err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		ret, err = redigo.StringMap(conn.Do("HGETALL", w.WithPrefix(key)))
+		ret, err = replyStringMap(w.do(conn, "HGETALL", w.WithPrefix(key)))
 		return
 	})
 	return
@@ -356,28 +580,72 @@ func (w *Wrapper) HDel(hKey string, field ...interface{}) (delNum int, err error
 		var args []interface{}
 		args = append(args, w.WithPrefix(hKey))
 		args = append(args, field...)
-		delNum, err = redigo.Int(conn.Do("HDEL", args...))
+		delNum, err = replyInt(w.do(conn, "HDEL", args...))
 	})
 	return
 }
 
 func (w *Wrapper) IncrBy(key string, val int) (num int, err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		num, err = redigo.Int(conn.Do("incrby", w.WithPrefix(key), val))
+		num, err = replyInt(w.do(conn, "incrby", w.WithPrefix(key), val))
 	})
 	return
 }
 
 func (w *Wrapper) Expire(key string, sec int32) (err error) {
 	w.Wrap(func(conn redigo.Conn) {
-		_, err = conn.Do("Expire", w.WithPrefix(key), sec)
+		_, err = w.do(conn, "Expire", w.WithPrefix(key), sec)
 	})
 	return
 }
 
+// Keys pattern和返回的key都不带前缀。KEYS会阻塞redis, 建议使用Scan
 func (w *Wrapper) Keys(pattern string) (keys []string, err error) {
+	prefix := w.WithPrefix("")
 	w.Wrap(func(conn redigo.Conn) {
-		keys, err = redigo.Strings(conn.Do("KEYS", pattern))
+		keys, err = replyStrings(w.do(conn, "KEYS", escapeGlob(prefix)+pattern))
 	})
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, prefix)
+	}
 	return
 }
+
+func zMembers(reply interface{}, err error) ([]ZMember, error) {
+	values, err := replyValues(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	if len(values)%2 != 0 {
+		return nil, errors.New("expects even number of values result")
+	}
+
+	members := make([]ZMember, 0, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		member, err := replyString(values[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		score, err := replyFloat64(values[i+1], nil)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, ZMember{Member: member, Score: score})
+	}
+	return members, nil
+}
+
+// errorConn 取连接失败时使用,所有命令都返回取连接时的错误
+type errorConn struct {
+	err error
+}
+
+func (ec errorConn) Close() error                                   { return nil }
+func (ec errorConn) Err() error                                     { return ec.err }
+func (ec errorConn) Do(string, ...interface{}) (interface{}, error) { return nil, ec.err }
+func (ec errorConn) Send(string, ...interface{}) error              { return ec.err }
+func (ec errorConn) Flush() error                                   { return ec.err }
+func (ec errorConn) Receive() (interface{}, error)                  { return nil, ec.err }
+func (ec errorConn) DoContext(context.Context, string, ...interface{}) (interface{}, error) {
+	return nil, ec.err
+}
@@ -0,0 +1,242 @@
+package redis_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/ziyoumeng/sdk/driver/redis"
+	"github.com/ziyoumeng/sdk/driver/redis/redistest"
+)
+
+const (
+	testPrefix      = "test"
+	testWaitTimeout = 5 * time.Second
+)
+
+// testClock 可拨动的时钟, 用于测试过期
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func getWrapper(t *testing.T) (*redis.Wrapper, *redistest.Server, *testClock) {
+	srv := redistest.NewServer()
+	t.Cleanup(srv.Close)
+	clock := &testClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	srv.SetNow(clock.Now)
+
+	w, err := srv.NewWrapper(testPrefix)
+	if err != nil {
+		t.Fatalf("NewWrapper %s", err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w, srv, clock
+}
+
+func TestWrapper(t *testing.T) {
+	w, srv, clock := getWrapper(t)
+
+	assert.NoError(t, w.Set("k", "v"))
+	v, err := w.GetString("k")
+	assert.NoError(t, err)
+	assert.Equal(t, "v", v)
+	assert.True(t, srv.Exists(testPrefix+":k"))
+
+	_, err = w.GetString("not-exist")
+	assert.True(t, redis.IsNil(err))
+
+	assert.NoError(t, w.SetEX("ex", 10, "v"))
+	assert.Equal(t, 10*time.Second, srv.TTL(testPrefix+":ex"))
+	clock.Add(10 * time.Second)
+	_, err = w.GetString("ex")
+	assert.True(t, redis.IsNil(err))
+
+	n, err := w.IncrBy("counter", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	_, err = w.HSetString("h", "f1", "a")
+	assert.NoError(t, err)
+	assert.NoError(t, w.HIncrby("h", "f2", 3))
+	m, err := w.HGetAll("h")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"f1": "a", "f2": "3"}, m)
+
+	_, err = w.ZAdd("z", 2, "b", 1.5, "a")
+	assert.NoError(t, err)
+	members, err := w.ZRangeWithScores("z", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []redis.ZMember{{Member: "a", Score: 1.5}, {Member: "b", Score: 2}}, members)
+
+	assert.NoError(t, w.LPushInt64("l", 1))
+	assert.NoError(t, w.LPushInt64("l", 2))
+	list, err := w.LRangeAllInt64("l")
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 1}, list)
+
+	keys, err := w.Keys("*")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"k", "counter", "h", "z", "l"}, keys)
+}
+
+func TestErrNil(t *testing.T) {
+	w, _, _ := getWrapper(t)
+
+	// 返回本包的ErrNil, 不是redigo.ErrNil
+	_, err := w.GetString("not-exist")
+	assert.Equal(t, redis.ErrNil, err)
+	_, err = w.HGetString("not-exist", "f")
+	assert.Equal(t, redis.ErrNil, err)
+	_, err = w.ZScoreFloat("not-exist", "m")
+	assert.Equal(t, redis.ErrNil, err)
+	_, err = w.LPopBytes("not-exist")
+	assert.Equal(t, redis.ErrNil, err)
+
+	p := w.Pipeline()
+	get := p.Get("not-exist")
+	assert.NoError(t, p.Exec())
+	_, err = get.String()
+	assert.Equal(t, redis.ErrNil, err)
+
+	assert.NotEqual(t, redigo.ErrNil, redis.ErrNil)
+	assert.True(t, redis.IsNil(errors.WithMessage(redis.ErrNil, "get")))
+	assert.True(t, redis.IsNil(redigo.ErrNil))
+	assert.False(t, redis.IsNil(nil))
+}
+
+func TestPipelineAndTx(t *testing.T) {
+	w, _, _ := getWrapper(t)
+
+	p := w.Pipeline()
+	set := p.Set("a", 1)
+	incr := p.Incr("a")
+	get := p.Get("a")
+	assert.NoError(t, p.Exec())
+	assert.NoError(t, set.Err())
+	n, _ := incr.Int64()
+	assert.EqualValues(t, 2, n)
+	s, _ := get.String()
+	assert.Equal(t, "2", s)
+
+	err := w.Tx(func(tx *redis.Tx) error {
+		v, err := tx.Read("GET", "a")
+		if err != nil {
+			return err
+		}
+		tx.Set("b", v)
+		return nil
+	}, "a")
+	assert.NoError(t, err)
+	b, err := w.GetString("b")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", b)
+}
+
+func TestScriptReload(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+
+	// 脚本缓存丢失后改用EVAL执行
+	_, err := srv.Do("SCRIPT", "FLUSH")
+	assert.NoError(t, err)
+	ok, err := redis.NewLocker("lock", w, redis.WithLockWatchdog(false)).TryLock(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	for _, script := range redis.Scripts {
+		if script.GetScriptName() == redis.LockScriptName {
+			reply, err := srv.Do("SCRIPT", "EXISTS", script.GetScriptSha())
+			assert.NoError(t, err)
+			assert.Equal(t, []interface{}{int64(1)}, reply)
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	w, _, _ := getWrapper(t)
+
+	// ping间隔<=0时使用默认值
+	for _, opts := range [][]redis.SubscribeOption{nil, {redis.WithPingInterval(0)}, {redis.WithPingInterval(-time.Second)}} {
+		received := make(chan redis.PubSubMessage, 1)
+		sub, err := w.Subscribe(context.Background(), []string{"ch"}, func(msg redis.PubSubMessage) {
+			received <- msg
+		}, opts...)
+		assert.NoError(t, err)
+
+		select {
+		case <-sub.Ready():
+		case <-time.After(testWaitTimeout):
+			t.Fatalf("timeout waiting subscription")
+		}
+		n, err := w.Publish("ch", "hello")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		select {
+		case msg := <-received:
+			assert.Equal(t, "ch", msg.Channel)
+			assert.Equal(t, []byte("hello"), msg.Data)
+		case <-time.After(testWaitTimeout):
+			t.Fatalf("timeout waiting message")
+		}
+		sub.Stop()
+		<-sub.Done()
+	}
+}
+
+func TestLuaScript(t *testing.T) {
+	w, _, _ := getWrapper(t)
+
+	// redistest用gopher-lua执行脚本, 回复的转换规则同redis
+	s, err := w.RegisterScript(redis.NewLuaScript("test_script", `
+local n = redis.call('incrby', KEYS[1], ARGV[1])
+if n > 10 then
+	return redis.call('hget', KEYS[1], 'f')
+end
+return {KEYS[1], n * 1.5, false, nil, 'not returned'}`, 1))
+	assert.NoError(t, err)
+
+	reply, err := s.Run(context.Background(), []string{"n"}, []interface{}{3})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{[]byte(testPrefix + ":n"), int64(4), nil}, reply)
+
+	_, err = s.Run(context.Background(), []string{"n"}, []interface{}{10})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "WRONGTYPE")
+}
+
+func TestScriptReloadConcurrent(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+
+	// 多个goroutine同时遇到NOSCRIPT并重新加载同一个脚本
+	_, err := srv.Do("SCRIPT", "FLUSH")
+	assert.NoError(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, err := redis.NewLocker(fmt.Sprintf("lock:%d", i), w, redis.WithLockWatchdog(false)).TryLock(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,211 @@
+package redistest
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const clusterSlots = 16384
+
+// Cluster 由多个Server组成的cluster替身: 每个节点负责一段slot并响应CLUSTER SLOTS,
+// key不属于本节点时返回MOVED, 迁移中的slot在原节点上不存在的key返回ASK。
+// 只按命令的第一个key路由, 不检查CROSSSLOT
+type Cluster struct {
+	Nodes []*Server
+
+	mu        sync.Mutex
+	owner     [clusterSlots]int // slot=>节点下标
+	migrating map[int]int       // slot=>迁入的节点下标
+	redirects int
+}
+
+// NewCluster 启动n个节点, slot平均分配, 用完需要调用Close
+func NewCluster(n int) *Cluster {
+	c := &Cluster{migrating: make(map[int]int)}
+	for i := 0; i < n; i++ {
+		s := NewServer()
+		s.cluster = c
+		c.Nodes = append(c.Nodes, s)
+	}
+	for slot := range c.owner {
+		c.owner[slot] = slot * n / clusterSlots
+	}
+	return c
+}
+
+// Addrs 所有节点的地址,可直接作为redis.Config.RedisClusterAddrs
+func (c *Cluster) Addrs() []string {
+	addrs := make([]string, len(c.Nodes))
+	for i, s := range c.Nodes {
+		addrs[i] = s.Addr()
+	}
+	return addrs
+}
+
+func (c *Cluster) Close() {
+	for _, s := range c.Nodes {
+		s.Close()
+	}
+}
+
+// NodeFor key当前所在的节点
+func (c *Cluster) NodeFor(key string) *Server {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Nodes[c.owner[KeySlot(key)]]
+}
+
+// Redirects 返回过的MOVED/ASK次数
+func (c *Cluster) Redirects() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.redirects
+}
+
+// MigrateSlot 开始把slot迁往node: 原节点上已不存在的key返回ASK, node只接受ASKING之后的命令
+func (c *Cluster) MigrateSlot(slot, node int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.migrating[slot] = node
+}
+
+// MoveSlot 把slot的数据移到node并完成迁移, 原节点此后返回MOVED
+func (c *Cluster) MoveSlot(slot, node int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	src, dst := c.Nodes[c.owner[slot]], c.Nodes[node]
+	delete(c.migrating, slot)
+	c.owner[slot] = node
+	if src == dst {
+		return
+	}
+
+	src.mu.Lock()
+	dst.mu.Lock()
+	defer src.mu.Unlock()
+	defer dst.mu.Unlock()
+	for key, e := range src.data {
+		if KeySlot(key) == slot {
+			src.del(key)
+			dst.add(key, e)
+			dst.touch(key)
+		}
+	}
+}
+
+// route 检查命令的key是否由s负责, 不是时返回MOVED/ASK错误
+func (c *Cluster) route(s *Server, asking bool, name string, args []string) interface{} {
+	key, ok := commandKey(name, args)
+	if !ok {
+		return nil
+	}
+	slot := KeySlot(key)
+
+	c.mu.Lock()
+	owner := c.Nodes[c.owner[slot]]
+	target, migrating := c.migrating[slot]
+	c.mu.Unlock()
+
+	if owner != s {
+		if migrating && c.Nodes[target] == s && asking {
+			return nil
+		}
+		return c.redirect("MOVED", slot, owner)
+	}
+	if migrating {
+		s.mu.Lock()
+		exists := s.lookup(key) != nil
+		s.mu.Unlock()
+		if !exists {
+			return c.redirect("ASK", slot, c.Nodes[target])
+		}
+	}
+	return nil
+}
+
+func (c *Cluster) redirect(kind string, slot int, to *Server) errorReply {
+	c.mu.Lock()
+	c.redirects++
+	c.mu.Unlock()
+	return errorReply(kind + " " + strconv.Itoa(slot) + " " + to.Addr())
+}
+
+// slots CLUSTER SLOTS的回复: [[start, end, [ip, port, id]], ...]
+func (c *Cluster) slots() interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ret []interface{}
+	for start := 0; start < clusterSlots; {
+		end := start
+		for end+1 < clusterSlots && c.owner[end+1] == c.owner[start] {
+			end++
+		}
+		node := c.owner[start]
+		host, port, _ := net.SplitHostPort(c.Nodes[node].Addr())
+		p, _ := strconv.ParseInt(port, 10, 64)
+		ret = append(ret, []interface{}{int64(start), int64(end), []interface{}{host, p, "node" + strconv.Itoa(node)}})
+		start = end + 1
+	}
+	return ret
+}
+
+// noKeyCommands 不需要路由的命令
+var noKeyCommands = map[string]bool{
+	"PING": true, "ECHO": true, "AUTH": true, "SELECT": true, "TIME": true, "DBSIZE": true, "FLUSHDB": true,
+	"FLUSHALL": true, "KEYS": true, "SCAN": true, "SCRIPT": true, "MULTI": true, "EXEC": true, "DISCARD": true,
+	"UNWATCH": true, "PUBLISH": true, "SUBSCRIBE": true, "UNSUBSCRIBE": true, "PSUBSCRIBE": true,
+	"PUNSUBSCRIBE": true, "CLUSTER": true, "ASKING": true, "ROLE": true, "SENTINEL": true,
+}
+
+// commandKey 命令的第一个key
+func commandKey(name string, args []string) (string, bool) {
+	if noKeyCommands[name] {
+		return "", false
+	}
+	switch name {
+	case "EVAL", "EVALSHA":
+		if len(args) > 3 && args[2] != "0" {
+			return args[3], true
+		}
+		return "", false
+	case "BITOP":
+		if len(args) > 2 {
+			return args[2], true
+		}
+		return "", false
+	case "XREADGROUP":
+		for i, arg := range args {
+			if strings.ToUpper(arg) == "STREAMS" && i+1 < len(args) {
+				return args[i+1], true
+			}
+		}
+		return "", false
+	}
+	if len(args) > 1 {
+		return args[1], true
+	}
+	return "", false
+}
+
+// KeySlot CRC16(XMODEM) % 16384, key中有非空的{tag}时只计算tag
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return int(crc) % clusterSlots
+}
@@ -0,0 +1,239 @@
+package redistest
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// geo同redis存为zset, 分数是52位geohash(纬度在偶数位), 坐标取所在格子的中心
+const (
+	geoStep        = 26
+	geoLatMax      = 85.05112878
+	geoEarthRadius = 6372797.560856 // 米, 同redis
+)
+
+var geoUnits = map[string]float64{"m": 1, "km": 1000, "ft": 0.3048, "mi": 1609.34}
+
+func init() {
+	register("GEOADD", -5, func(s *Server, args []string) interface{} {
+		if (len(args)-2)%3 != 0 {
+			return syntaxErr()
+		}
+		zadd := []string{"ZADD", args[1]}
+		for i := 2; i < len(args); i += 3 {
+			lon, lat, errReply := geoCoord(args[i], args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			zadd = append(zadd, formatFloat(geoEncode(lon, lat)), args[i+2])
+		}
+		return cmdZAdd(s, zadd)
+	})
+	register("GEOPOS", -2, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindZSet)
+		if errReply != nil {
+			return errReply
+		}
+		ret := make([]interface{}, 0, len(args)-2)
+		for _, member := range args[2:] {
+			score, ok := 0.0, false
+			if e != nil {
+				score, ok = e.zset[member]
+			}
+			if !ok {
+				ret = append(ret, nilArray{})
+				continue
+			}
+			lon, lat := geoDecode(score)
+			ret = append(ret, []string{formatGeo(lon), formatGeo(lat)})
+		}
+		return ret
+	})
+	register("GEODIST", -4, func(s *Server, args []string) interface{} {
+		if len(args) > 5 {
+			return syntaxErr()
+		}
+		unit := 1.0
+		if len(args) == 5 {
+			var ok bool
+			if unit, ok = geoUnits[strings.ToLower(args[4])]; !ok {
+				return errorReply("ERR unsupported unit provided. please use M, KM, FT, MI")
+			}
+		}
+		e, errReply := s.get(args[1], kindZSet)
+		if errReply != nil || e == nil {
+			return errReply
+		}
+		s1, ok1 := e.zset[args[2]]
+		s2, ok2 := e.zset[args[3]]
+		if !ok1 || !ok2 {
+			return nil
+		}
+		lon1, lat1 := geoDecode(s1)
+		lon2, lat2 := geoDecode(s2)
+		return strconv.FormatFloat(geoDistance(lon1, lat1, lon2, lat2)/unit, 'f', 4, 64)
+	})
+	register("GEORADIUS", -6, func(s *Server, args []string) interface{} {
+		lon, lat, errReply := geoCoord(args[2], args[3])
+		if errReply != nil {
+			return errReply
+		}
+		return s.geoRadius(args[1], lon, lat, args[4:])
+	})
+	register("GEORADIUSBYMEMBER", -5, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindZSet)
+		if errReply != nil {
+			return errReply
+		}
+		var score float64
+		ok := false
+		if e != nil {
+			score, ok = e.zset[args[2]]
+		}
+		if !ok {
+			return errorReply("ERR could not decode requested zset member")
+		}
+		lon, lat := geoDecode(score)
+		return s.geoRadius(args[1], lon, lat, args[3:])
+	})
+}
+
+// geoRadius args: radius unit [WITHCOORD] [WITHDIST] [WITHHASH] [COUNT n] [ASC|DESC]
+func (s *Server) geoRadius(key string, lon, lat float64, args []string) interface{} {
+	radius, ok := parseFloat(args[0])
+	if !ok || radius < 0 {
+		return errorReply("ERR need numeric radius")
+	}
+	unit, ok := geoUnits[strings.ToLower(args[1])]
+	if !ok {
+		return errorReply("ERR unsupported unit provided. please use M, KM, FT, MI")
+	}
+	var withCoord, withDist, withHash, desc bool
+	count := 0
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "WITHCOORD":
+			withCoord = true
+		case "WITHDIST":
+			withDist = true
+		case "WITHHASH":
+			withHash = true
+		case "ASC":
+			desc = false
+		case "DESC":
+			desc = true
+		case "COUNT":
+			if i+1 >= len(args) {
+				return syntaxErr()
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n <= 0 {
+				return errorReply("ERR COUNT must be > 0")
+			}
+			count = n
+		default:
+			return syntaxErr()
+		}
+	}
+
+	e, errReply := s.get(key, kindZSet)
+	if errReply != nil {
+		return errReply
+	}
+	type found struct {
+		name     string
+		score    float64
+		lon, lat float64
+		dist     float64
+	}
+	var matches []found
+	if e != nil {
+		for _, m := range e.sorted() {
+			mlon, mlat := geoDecode(m.score)
+			if dist := geoDistance(lon, lat, mlon, mlat); dist <= radius*unit {
+				matches = append(matches, found{m.name, m.score, mlon, mlat, dist})
+			}
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if desc {
+			return matches[i].dist > matches[j].dist
+		}
+		return matches[i].dist < matches[j].dist
+	})
+	if count > 0 && len(matches) > count {
+		matches = matches[:count]
+	}
+
+	ret := make([]interface{}, 0, len(matches))
+	for _, m := range matches {
+		if !withCoord && !withDist && !withHash {
+			ret = append(ret, m.name)
+			continue
+		}
+		item := []interface{}{m.name}
+		if withDist {
+			item = append(item, strconv.FormatFloat(m.dist/unit, 'f', 4, 64))
+		}
+		if withHash {
+			item = append(item, int64(m.score))
+		}
+		if withCoord {
+			item = append(item, []string{formatGeo(m.lon), formatGeo(m.lat)})
+		}
+		ret = append(ret, item)
+	}
+	return ret
+}
+
+func geoCoord(lonArg, latArg string) (lon, lat float64, errReply interface{}) {
+	lon, ok1 := parseFloat(lonArg)
+	lat, ok2 := parseFloat(latArg)
+	if !ok1 || !ok2 {
+		return 0, 0, notFloat()
+	}
+	if lon < -180 || lon > 180 || lat < -geoLatMax || lat > geoLatMax {
+		return 0, 0, errorReply("ERR invalid longitude,latitude pair " + lonArg + "," + latArg)
+	}
+	return lon, lat, nil
+}
+
+func geoEncode(lon, lat float64) float64 {
+	cells := float64(uint64(1) << geoStep)
+	latBits := uint64(math.Min((lat+geoLatMax)/(2*geoLatMax)*cells, cells-1))
+	lonBits := uint64(math.Min((lon+180)/360*cells, cells-1))
+	var hash uint64
+	for i := uint(0); i < geoStep; i++ {
+		hash |= (latBits>>i&1)<<(2*i) | (lonBits>>i&1)<<(2*i+1)
+	}
+	return float64(hash)
+}
+
+func geoDecode(score float64) (lon, lat float64) {
+	hash := uint64(score)
+	var latBits, lonBits uint64
+	for i := uint(0); i < geoStep; i++ {
+		latBits |= (hash >> (2 * i) & 1) << i
+		lonBits |= (hash >> (2*i + 1) & 1) << i
+	}
+	cells := float64(uint64(1) << geoStep)
+	lon = -180 + (float64(lonBits)+0.5)*360/cells
+	lat = -geoLatMax + (float64(latBits)+0.5)*2*geoLatMax/cells
+	return lon, lat
+}
+
+// geoDistance haversine距离, 单位米
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	rad := math.Pi / 180
+	u := math.Sin((lat2 - lat1) * rad / 2)
+	v := math.Sin((lon2 - lon1) * rad / 2)
+	a := u*u + math.Cos(lat1*rad)*math.Cos(lat2*rad)*v*v
+	return 2 * geoEarthRadius * math.Asin(math.Sqrt(a))
+}
+
+func formatGeo(f float64) string {
+	return strconv.FormatFloat(f, 'f', 17, 64)
+}
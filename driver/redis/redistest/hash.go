@@ -0,0 +1,226 @@
+package redistest
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+func init() {
+	register("HSET", -4, cmdHSet)
+	register("HMSET", -4, func(s *Server, args []string) interface{} {
+		if reply := cmdHSet(s, args); isError(reply) {
+			return reply
+		}
+		return ok
+	})
+	register("HSETNX", 4, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindHash)
+		if errReply != nil {
+			return errReply
+		}
+		if e != nil {
+			if _, ok := e.hash[args[2]]; ok {
+				return int64(0)
+			}
+		}
+		e, _ = s.write(args[1], kindHash)
+		e.hash[args[2]] = args[3]
+		return int64(1)
+	})
+	register("HGET", 3, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindHash)
+		if errReply != nil {
+			return errReply
+		}
+		if e == nil {
+			return nil
+		}
+		if v, ok := e.hash[args[2]]; ok {
+			return v
+		}
+		return nil
+	})
+	register("HMGET", -3, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindHash)
+		if errReply != nil {
+			return errReply
+		}
+		values := make([]interface{}, 0, len(args)-2)
+		for _, field := range args[2:] {
+			if v, ok := e.field(field); ok {
+				values = append(values, v)
+			} else {
+				values = append(values, nil)
+			}
+		}
+		return values
+	})
+	register("HGETALL", 2, func(s *Server, args []string) interface{} {
+		return hashItems(s, args[1], true, true)
+	})
+	register("HKEYS", 2, func(s *Server, args []string) interface{} {
+		return hashItems(s, args[1], true, false)
+	})
+	register("HVALS", 2, func(s *Server, args []string) interface{} {
+		return hashItems(s, args[1], false, true)
+	})
+	register("HDEL", -3, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindHash)
+		if errReply != nil || e == nil {
+			return zeroOr(errReply)
+		}
+		var n int64
+		for _, field := range args[2:] {
+			if _, ok := e.hash[field]; ok {
+				delete(e.hash, field)
+				n++
+			}
+		}
+		if n > 0 {
+			s.touch(args[1])
+			s.cleanup(args[1], e)
+		}
+		return n
+	})
+	register("HEXISTS", 3, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindHash)
+		if errReply != nil {
+			return errReply
+		}
+		if _, ok := e.field(args[2]); ok {
+			return int64(1)
+		}
+		return int64(0)
+	})
+	register("HLEN", 2, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindHash)
+		if errReply != nil || e == nil {
+			return zeroOr(errReply)
+		}
+		return int64(len(e.hash))
+	})
+	register("HINCRBY", 4, func(s *Server, args []string) interface{} {
+		delta, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil {
+			return notInteger()
+		}
+		e, errReply := s.get(args[1], kindHash)
+		if errReply != nil {
+			return errReply
+		}
+		var cur int64
+		if v, ok := e.field(args[2]); ok {
+			if cur, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return errorReply("ERR hash value is not an integer")
+			}
+		}
+		if (delta > 0 && cur > math.MaxInt64-delta) || (delta < 0 && cur < math.MinInt64-delta) {
+			return errorReply("ERR increment or decrement would overflow")
+		}
+		cur += delta
+		e, _ = s.write(args[1], kindHash)
+		e.hash[args[2]] = strconv.FormatInt(cur, 10)
+		return cur
+	})
+	register("HINCRBYFLOAT", 4, func(s *Server, args []string) interface{} {
+		delta, ok := parseFloat(args[3])
+		if !ok || math.IsInf(delta, 0) {
+			return notFloat()
+		}
+		e, errReply := s.get(args[1], kindHash)
+		if errReply != nil {
+			return errReply
+		}
+		cur := 0.0
+		if v, exists := e.field(args[2]); exists {
+			if cur, ok = parseFloat(v); !ok {
+				return errorReply("ERR hash value is not a float")
+			}
+		}
+		cur += delta
+		if math.IsInf(cur, 0) {
+			return errorReply("ERR increment would produce NaN or Infinity")
+		}
+		e, _ = s.write(args[1], kindHash)
+		e.hash[args[2]] = formatFloat(cur)
+		return e.hash[args[2]]
+	})
+	register("HSCAN", -3, func(s *Server, args []string) interface{} {
+		cursor, pattern, count, _, errReply := scanOptions(args[2:])
+		if errReply != nil {
+			return errReply
+		}
+		items := hashItems(s, args[1], true, true)
+		if isError(items) {
+			return items
+		}
+		return scanPage(items.([]string), 2, cursor, count, pattern)
+	})
+}
+
+func cmdHSet(s *Server, args []string) interface{} {
+	if len(args)%2 != 0 {
+		return wrongArgs(args[0])
+	}
+	e, errReply := s.write(args[1], kindHash)
+	if errReply != nil {
+		return errReply
+	}
+	var n int64
+	for i := 2; i < len(args); i += 2 {
+		if _, ok := e.hash[args[i]]; !ok {
+			n++
+		}
+		e.hash[args[i]] = args[i+1]
+	}
+	return n
+}
+
+// field key不存在时e为nil
+func (e *entry) field(field string) (string, bool) {
+	if e == nil {
+		return "", false
+	}
+	v, ok := e.hash[field]
+	return v, ok
+}
+
+// hashItems 按field排序, 使结果稳定
+func hashItems(s *Server, key string, fields, values bool) interface{} {
+	e, errReply := s.get(key, kindHash)
+	if errReply != nil {
+		return errReply
+	}
+	items := []string{}
+	if e == nil {
+		return items
+	}
+	names := make([]string, 0, len(e.hash))
+	for field := range e.hash {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+	for _, field := range names {
+		if fields {
+			items = append(items, field)
+		}
+		if values {
+			items = append(items, e.hash[field])
+		}
+	}
+	return items
+}
+
+func isError(reply interface{}) bool {
+	_, ok := reply.(errorReply)
+	return ok
+}
+
+// zeroOr 有错误时返回错误, 否则返回0
+func zeroOr(errReply interface{}) interface{} {
+	if errReply != nil {
+		return errReply
+	}
+	return int64(0)
+}
@@ -0,0 +1,107 @@
+package redistest
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hllMagic HyperLogLog存为string, 以此开头; 替身保存全部元素, 基数是精确值
+const hllMagic = "HYLL"
+
+func init() {
+	register("PFADD", -2, func(s *Server, args []string) interface{} {
+		e := s.lookup(args[1])
+		members, errReply := hllMembers(e)
+		if errReply != nil {
+			return errReply
+		}
+		changed := e == nil
+		for _, member := range args[2:] {
+			if _, ok := members[member]; !ok {
+				members[member] = struct{}{}
+				changed = true
+			}
+		}
+		if !changed {
+			return int64(0)
+		}
+		s.setHLL(args[1], members, e)
+		return int64(1)
+	})
+	register("PFCOUNT", -2, func(s *Server, args []string) interface{} {
+		union := make(map[string]struct{})
+		for _, key := range args[1:] {
+			members, errReply := hllMembers(s.lookup(key))
+			if errReply != nil {
+				return errReply
+			}
+			for member := range members {
+				union[member] = struct{}{}
+			}
+		}
+		return int64(len(union))
+	})
+	register("PFMERGE", -2, func(s *Server, args []string) interface{} {
+		dst := s.lookup(args[1])
+		union, errReply := hllMembers(dst)
+		if errReply != nil {
+			return errReply
+		}
+		for _, key := range args[2:] {
+			members, errReply := hllMembers(s.lookup(key))
+			if errReply != nil {
+				return errReply
+			}
+			for member := range members {
+				union[member] = struct{}{}
+			}
+		}
+		s.setHLL(args[1], union, dst)
+		return ok
+	})
+}
+
+// hllMembers 解码HyperLogLog, e为nil时返回空集合
+func hllMembers(e *entry) (map[string]struct{}, interface{}) {
+	members := make(map[string]struct{})
+	if e == nil {
+		return members, nil
+	}
+	if e.kind != kindString {
+		return nil, wrongType()
+	}
+	if !strings.HasPrefix(e.str, hllMagic) {
+		return nil, errorReply("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	}
+	// 编码为 长度:元素 依次拼接
+	for str := e.str[len(hllMagic):]; str != ""; {
+		i := strings.IndexByte(str, ':')
+		n, _ := strconv.Atoi(str[:i])
+		members[str[i+1:i+1+n]] = struct{}{}
+		str = str[i+1+n:]
+	}
+	return members, nil
+}
+
+// setHLL 写入HyperLogLog, 保留原来的过期时间
+func (s *Server) setHLL(key string, members map[string]struct{}, old *entry) {
+	sorted := make([]string, 0, len(members))
+	for member := range members {
+		sorted = append(sorted, member)
+	}
+	sort.Strings(sorted)
+	var b strings.Builder
+	b.WriteString(hllMagic)
+	for _, member := range sorted {
+		b.WriteString(strconv.Itoa(len(member)))
+		b.WriteByte(':')
+		b.WriteString(member)
+	}
+	var expireAt time.Time
+	if old != nil {
+		expireAt = old.expireAt
+	}
+	s.setString(key, b.String(), expireAt)
+}
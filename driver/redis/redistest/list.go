@@ -0,0 +1,319 @@
+package redistest
+
+import (
+	"strconv"
+	"strings"
+)
+
+func init() {
+	register("LPUSH", -3, cmdPush(true, false))
+	register("RPUSH", -3, cmdPush(false, false))
+	register("LPUSHX", -3, cmdPush(true, true))
+	register("RPUSHX", -3, cmdPush(false, true))
+	register("LPOP", -2, cmdPop(true))
+	register("RPOP", -2, cmdPop(false))
+	register("LLEN", 2, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindList)
+		if errReply != nil || e == nil {
+			return zeroOr(errReply)
+		}
+		return int64(len(e.list))
+	})
+	register("LRANGE", 4, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindList)
+		if errReply != nil {
+			return errReply
+		}
+		start, stop, errReply := parseRange(args[2], args[3])
+		if errReply != nil {
+			return errReply
+		}
+		if e == nil {
+			return []string{}
+		}
+		from, to, ok := normalizeRange(start, stop, len(e.list))
+		if !ok {
+			return []string{}
+		}
+		return append([]string(nil), e.list[from:to+1]...)
+	})
+	register("LINDEX", 3, func(s *Server, args []string) interface{} {
+		index, err := strconv.Atoi(args[2])
+		if err != nil {
+			return notInteger()
+		}
+		e, errReply := s.get(args[1], kindList)
+		if errReply != nil || e == nil {
+			return errReply
+		}
+		if index < 0 {
+			index += len(e.list)
+		}
+		if index < 0 || index >= len(e.list) {
+			return nil
+		}
+		return e.list[index]
+	})
+	register("LSET", 4, func(s *Server, args []string) interface{} {
+		index, err := strconv.Atoi(args[2])
+		if err != nil {
+			return notInteger()
+		}
+		e, errReply := s.get(args[1], kindList)
+		if errReply != nil {
+			return errReply
+		}
+		if e == nil {
+			return errorReply("ERR no such key")
+		}
+		if index < 0 {
+			index += len(e.list)
+		}
+		if index < 0 || index >= len(e.list) {
+			return errorReply("ERR index out of range")
+		}
+		e.list[index] = args[3]
+		s.touch(args[1])
+		return ok
+	})
+	register("LREM", 4, func(s *Server, args []string) interface{} {
+		count, err := strconv.Atoi(args[2])
+		if err != nil {
+			return notInteger()
+		}
+		e, errReply := s.get(args[1], kindList)
+		if errReply != nil || e == nil {
+			return zeroOr(errReply)
+		}
+		limit := count
+		if limit < 0 {
+			limit = -limit
+		}
+		removed := 0
+		keep := make([]bool, len(e.list))
+		for i := range e.list {
+			idx := i
+			if count < 0 {
+				idx = len(e.list) - 1 - i
+			}
+			keep[idx] = true
+			if e.list[idx] == args[3] && (limit == 0 || removed < limit) {
+				keep[idx] = false
+				removed++
+			}
+		}
+		list := e.list[:0]
+		for i, v := range e.list {
+			if keep[i] {
+				list = append(list, v)
+			}
+		}
+		e.list = list
+		if removed > 0 {
+			s.touch(args[1])
+			s.cleanup(args[1], e)
+		}
+		return int64(removed)
+	})
+	register("LTRIM", 4, func(s *Server, args []string) interface{} {
+		start, stop, errReply := parseRange(args[2], args[3])
+		if errReply != nil {
+			return errReply
+		}
+		e, errReply := s.get(args[1], kindList)
+		if errReply != nil || e == nil {
+			if errReply != nil {
+				return errReply
+			}
+			return ok
+		}
+		from, to, valid := normalizeRange(start, stop, len(e.list))
+		if valid {
+			e.list = append([]string(nil), e.list[from:to+1]...)
+		} else {
+			e.list = nil
+		}
+		s.touch(args[1])
+		s.cleanup(args[1], e)
+		return ok
+	})
+	register("LINSERT", 5, func(s *Server, args []string) interface{} {
+		where := strings.ToUpper(args[2])
+		if where != "BEFORE" && where != "AFTER" {
+			return syntaxErr()
+		}
+		e, errReply := s.get(args[1], kindList)
+		if errReply != nil || e == nil {
+			return zeroOr(errReply)
+		}
+		for i, v := range e.list {
+			if v != args[3] {
+				continue
+			}
+			if where == "AFTER" {
+				i++
+			}
+			e.list = append(e.list[:i], append([]string{args[4]}, e.list[i:]...)...)
+			s.touch(args[1])
+			return int64(len(e.list))
+		}
+		return int64(-1)
+	})
+	register("LMOVE", 5, func(s *Server, args []string) interface{} {
+		return s.lmove(args[1], args[2], args[3], args[4])
+	})
+	// 在MULTI和脚本中阻塞命令不阻塞, 没有数据时直接返回nil
+	for _, name := range []string{"BLPOP", "BRPOP", "BLMOVE"} {
+		name := name
+		register(name, -3, func(s *Server, args []string) interface{} {
+			if reply := s.tryPop(name, args[1:len(args)-1]); reply != nil {
+				return reply
+			}
+			return nilArray{}
+		})
+	}
+}
+
+func cmdPush(left, exists bool) func(s *Server, args []string) interface{} {
+	return func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindList)
+		if errReply != nil {
+			return errReply
+		}
+		if e == nil && exists {
+			return int64(0)
+		}
+		e, _ = s.write(args[1], kindList)
+		for _, v := range args[2:] {
+			if left {
+				e.list = append([]string{v}, e.list...)
+			} else {
+				e.list = append(e.list, v)
+			}
+		}
+		return int64(len(e.list))
+	}
+}
+
+func cmdPop(left bool) func(s *Server, args []string) interface{} {
+	return func(s *Server, args []string) interface{} {
+		if len(args) > 3 {
+			return syntaxErr()
+		}
+		count := -1
+		if len(args) == 3 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n < 0 {
+				return errorReply("ERR value is out of range, must be positive")
+			}
+			count = n
+		}
+		e, errReply := s.get(args[1], kindList)
+		if errReply != nil {
+			return errReply
+		}
+		if e == nil {
+			if count >= 0 {
+				return nilArray{}
+			}
+			return nil
+		}
+		n := count
+		if n < 0 {
+			n = 1
+		}
+		if n > len(e.list) {
+			n = len(e.list)
+		}
+		popped := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			popped = append(popped, s.popOne(args[1], e, left))
+		}
+		if count < 0 {
+			return popped[0]
+		}
+		return popped
+	}
+}
+
+// popOne 从列表一端取出一个, 调用方保证列表非空
+func (s *Server) popOne(key string, e *entry, left bool) string {
+	var v string
+	if left {
+		v, e.list = e.list[0], e.list[1:]
+	} else {
+		v, e.list = e.list[len(e.list)-1], e.list[:len(e.list)-1]
+	}
+	s.touch(key)
+	s.cleanup(key, e)
+	return v
+}
+
+func (s *Server) lmove(src, dst, whereFrom, whereTo string) interface{} {
+	from, to := strings.ToUpper(whereFrom), strings.ToUpper(whereTo)
+	if (from != "LEFT" && from != "RIGHT") || (to != "LEFT" && to != "RIGHT") {
+		return syntaxErr()
+	}
+	e, errReply := s.get(src, kindList)
+	if errReply != nil || e == nil {
+		return errReply
+	}
+	if _, errReply := s.get(dst, kindList); errReply != nil {
+		return errReply
+	}
+	v := s.popOne(src, e, from == "LEFT")
+	cmdPush(to == "LEFT", false)(s, []string{"PUSH", dst, v})
+	return v
+}
+
+// tryPop 阻塞命令的一次尝试, 没有数据时返回nil
+func (s *Server) tryPop(name string, args []string) interface{} {
+	if name == "BLMOVE" {
+		if len(args) != 4 {
+			return wrongArgs(name)
+		}
+		return s.lmove(args[0], args[1], args[2], args[3])
+	}
+	for _, key := range args {
+		e, errReply := s.get(key, kindList)
+		if errReply != nil {
+			return errReply
+		}
+		if e != nil {
+			return []string{key, s.popOne(key, e, name == "BLPOP")}
+		}
+	}
+	return nil
+}
+
+func parseRange(startArg, stopArg string) (int, int, interface{}) {
+	start, err := strconv.Atoi(startArg)
+	if err != nil {
+		return 0, 0, notInteger()
+	}
+	stop, err := strconv.Atoi(stopArg)
+	if err != nil {
+		return 0, 0, notInteger()
+	}
+	return start, stop, nil
+}
+
+// normalizeRange 把支持负数的[start, stop]转成下标, 为空时返回false
+func normalizeRange(start, stop, n int) (int, int, bool) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
@@ -0,0 +1,169 @@
+package redistest
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+// 回复的类型, 由writeReply编码:
+// status为简单字符串, errorReply为错误, nil为空bulk, nilArray为空数组,
+// int64为整数, string/[]byte为bulk, []interface{}/[]string为数组
+type (
+	status      string
+	errorReply  string
+	nilArray    struct{}
+	noReplyType struct{}
+)
+
+var (
+	ok = status("OK")
+	// noReply 回复已由命令自己写出,如SUBSCRIBE
+	noReply = noReplyType{}
+
+	errProtocol = errors.New("redistest: protocol error")
+)
+
+func wrongArgs(name string) errorReply {
+	return errorReply("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+}
+
+func wrongType() errorReply {
+	return errorReply("WRONGTYPE Operation against a key holding the wrong kind of value")
+}
+
+func notInteger() errorReply {
+	return errorReply("ERR value is not an integer or out of range")
+}
+
+func notFloat() errorReply {
+	return errorReply("ERR value is not a valid float")
+}
+
+func syntaxErr() errorReply {
+	return errorReply("ERR syntax error")
+}
+
+// readCommand 读取一条命令, 支持RESP数组和inline命令
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return nil, errProtocol
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errProtocol
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, errProtocol
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case nilArray:
+		w.WriteString("*-1\r\n")
+	case status:
+		w.WriteString("+" + string(v) + "\r\n")
+	case errorReply:
+		w.WriteString("-" + string(v) + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case int:
+		w.WriteString(":" + strconv.Itoa(v) + "\r\n")
+	case string:
+		writeBulk(w, v)
+	case []byte:
+		writeBulk(w, string(v))
+	case []string:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, s := range v {
+			writeBulk(w, s)
+		}
+	case []interface{}:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	default:
+		w.WriteString("-ERR redistest: unsupported reply type\r\n")
+	}
+}
+
+func writeBulk(w *bufio.Writer, s string) {
+	w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+// toResult 把回复转成redigo的返回值: 错误为redigo.Error, status为string, bulk为[]byte
+func toResult(reply interface{}) (interface{}, error) {
+	switch v := reply.(type) {
+	case errorReply:
+		return nil, redigo.Error(v)
+	case nilArray:
+		return nil, nil
+	}
+	return toValue(reply), nil
+}
+
+func toValue(reply interface{}) interface{} {
+	switch v := reply.(type) {
+	case errorReply:
+		return redigo.Error(v)
+	case nilArray:
+		return nil
+	case status:
+		return string(v)
+	case int:
+		return int64(v)
+	case string:
+		return []byte(v)
+	case []string:
+		values := make([]interface{}, len(v))
+		for i, s := range v {
+			values[i] = []byte(s)
+		}
+		return values
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, item := range v {
+			values[i] = toValue(item)
+		}
+		return values
+	}
+	return reply
+}
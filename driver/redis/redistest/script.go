@@ -0,0 +1,234 @@
+package redistest
+
+import (
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+func init() {
+	register("SCRIPT", -2, func(s *Server, args []string) interface{} {
+		switch strings.ToUpper(args[1]) {
+		case "LOAD":
+			if len(args) != 3 {
+				return wrongArgs("SCRIPT|LOAD")
+			}
+			if _, err := parse.Parse(strings.NewReader(args[2]), "script"); err != nil {
+				return errorReply("ERR Error compiling script " + err.Error())
+			}
+			sha := sha1Hex(args[2])
+			s.scripts[sha] = args[2]
+			return sha
+		case "EXISTS":
+			exists := make([]interface{}, 0, len(args)-2)
+			for _, sha := range args[2:] {
+				if _, ok := s.scripts[strings.ToLower(sha)]; ok {
+					exists = append(exists, int64(1))
+				} else {
+					exists = append(exists, int64(0))
+				}
+			}
+			return exists
+		case "FLUSH":
+			s.scripts = make(map[string]string)
+			return ok
+		}
+		return errorReply("ERR unknown subcommand '" + args[1] + "'")
+	})
+	register("EVAL", -3, func(s *Server, args []string) interface{} {
+		s.scripts[sha1Hex(args[1])] = args[1]
+		return s.eval(args[1], args[2:])
+	})
+	register("EVALSHA", -3, func(s *Server, args []string) interface{} {
+		script, ok := s.scripts[strings.ToLower(args[1])]
+		if !ok {
+			return errorReply("NOSCRIPT No matching script. Please use EVAL.")
+		}
+		return s.eval(script, args[2:])
+	})
+}
+
+// eval 用gopher-lua执行脚本, 提供KEYS、ARGV和redis.call/pcall等, 类型转换同redis
+func (s *Server) eval(script string, args []string) interface{} {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil {
+		return notInteger()
+	}
+	if numKeys < 0 || numKeys > len(args)-1 {
+		return errorReply("ERR Number of keys can't be greater than number of args")
+	}
+
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	L.SetGlobal("KEYS", luaStrings(L, args[1:1+numKeys]))
+	L.SetGlobal("ARGV", luaStrings(L, args[1+numKeys:]))
+	L.SetGlobal("redis", s.luaRedis(L))
+
+	fn, err := L.LoadString(script)
+	if err != nil {
+		return errorReply("ERR Error compiling script " + err.Error())
+	}
+	L.Push(fn)
+	if err := L.PCall(0, 1, nil); err != nil {
+		if e, ok := err.(*lua.ApiError); ok {
+			// redis.call出错时抛出的是{err=...}
+			if t, ok := e.Object.(*lua.LTable); ok {
+				if msg, ok := t.RawGetString("err").(lua.LString); ok {
+					return errorReply(msg)
+				}
+			}
+			return errorReply("ERR Error running script: " + e.Object.String())
+		}
+		return errorReply("ERR Error running script: " + err.Error())
+	}
+	return fromLua(L.Get(-1))
+}
+
+// luaRedis 脚本中的redis表
+func (s *Server) luaRedis(L *lua.LState) *lua.LTable {
+	call := func(protected bool) lua.LGFunction {
+		return func(L *lua.LState) int {
+			n := L.GetTop()
+			if n == 0 {
+				L.RaiseError("Please specify at least one argument for this redis lib call")
+			}
+			cmd := make([]string, 0, n)
+			for i := 1; i <= n; i++ {
+				switch v := L.Get(i).(type) {
+				case lua.LString:
+					cmd = append(cmd, string(v))
+				case lua.LNumber:
+					// 同redis, 数字按%.14g转成字符串
+					cmd = append(cmd, strconv.FormatFloat(float64(v), 'g', 14, 64))
+				default:
+					L.RaiseError("Lua redis lib command arguments must be strings or integers")
+				}
+			}
+			reply := s.exec(cmd)
+			if e, ok := reply.(errorReply); ok && !protected {
+				t := L.NewTable()
+				t.RawSetString("err", lua.LString(e))
+				L.Error(t, 1)
+			}
+			L.Push(toLua(L, reply))
+			return 1
+		}
+	}
+	replyTable := func(field string) lua.LGFunction {
+		return func(L *lua.LState) int {
+			t := L.NewTable()
+			t.RawSetString(field, lua.LString(L.CheckString(1)))
+			L.Push(t)
+			return 1
+		}
+	}
+
+	t := L.NewTable()
+	L.SetFuncs(t, map[string]lua.LGFunction{
+		"call":         call(false),
+		"pcall":        call(true),
+		"error_reply":  replyTable("err"),
+		"status_reply": replyTable("ok"),
+		"sha1hex": func(L *lua.LState) int {
+			L.Push(lua.LString(sha1Hex(L.CheckString(1))))
+			return 1
+		},
+		"replicate_commands": func(L *lua.LState) int {
+			L.Push(lua.LTrue)
+			return 1
+		},
+		"log": func(L *lua.LState) int { return 0 },
+	})
+	for i, level := range []string{"LOG_DEBUG", "LOG_VERBOSE", "LOG_NOTICE", "LOG_WARNING"} {
+		t.RawSetString(level, lua.LNumber(i))
+	}
+	return t
+}
+
+func luaStrings(L *lua.LState, values []string) *lua.LTable {
+	t := L.CreateTable(len(values), 0)
+	for _, v := range values {
+		t.Append(lua.LString(v))
+	}
+	return t
+}
+
+// toLua 命令的回复转成Lua值: 整数为number, bulk为string, 空为false, 状态为{ok=...}, 错误为{err=...}
+func toLua(L *lua.LState, reply interface{}) lua.LValue {
+	switch v := reply.(type) {
+	case nil, nilArray:
+		return lua.LFalse
+	case status:
+		t := L.NewTable()
+		t.RawSetString("ok", lua.LString(v))
+		return t
+	case errorReply:
+		t := L.NewTable()
+		t.RawSetString("err", lua.LString(v))
+		return t
+	case int:
+		return lua.LNumber(v)
+	case int64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case []byte:
+		return lua.LString(v)
+	case []string:
+		return luaStrings(L, v)
+	case []interface{}:
+		t := L.CreateTable(len(v), 0)
+		for _, item := range v {
+			t.Append(toLua(L, item))
+		}
+		return t
+	}
+	return lua.LFalse
+}
+
+// fromLua 脚本返回值转成回复: number截断为整数, false/nil为空, true为1, 表按数组转换并在第一个nil处截断
+func fromLua(v lua.LValue) interface{} {
+	switch v := v.(type) {
+	case lua.LNumber:
+		return int64(v)
+	case lua.LString:
+		return string(v)
+	case lua.LBool:
+		if v {
+			return int64(1)
+		}
+		return nil
+	case *lua.LTable:
+		if msg, ok := v.RawGetString("err").(lua.LString); ok {
+			return errorReply(msg)
+		}
+		if msg, ok := v.RawGetString("ok").(lua.LString); ok {
+			return status(msg)
+		}
+		values := make([]interface{}, 0, v.Len())
+		for i := 1; ; i++ {
+			item := v.RawGetInt(i)
+			if item == lua.LNil {
+				break
+			}
+			values = append(values, fromLua(item))
+		}
+		return values
+	}
+	return nil
+}
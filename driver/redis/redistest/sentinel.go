@@ -0,0 +1,58 @@
+package redistest
+
+import (
+	"net"
+	"strings"
+)
+
+// SetRole 设置ROLE命令返回的角色, 默认为master
+func (s *Server) SetRole(role string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.role = role
+}
+
+// SetMaster 让s作为sentinel, 对SENTINEL get-master-addr-by-name name返回addr
+func (s *Server) SetMaster(name, addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setMaster(name, addr)
+}
+
+// Failover 把name的master切换到addr并发布+switch-master, 返回收到消息的订阅者数量
+func (s *Server) Failover(name, addr string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.masters[name]
+	s.setMaster(name, addr)
+	oldHost, oldPort, _ := net.SplitHostPort(old)
+	host, port, _ := net.SplitHostPort(addr)
+	return s.publish("+switch-master", strings.Join([]string{name, oldHost, oldPort, host, port}, " "))
+}
+
+func (s *Server) setMaster(name, addr string) {
+	if s.masters == nil {
+		s.masters = make(map[string]string)
+	}
+	s.masters[name] = addr
+}
+
+func init() {
+	register("ROLE", 1, func(s *Server, args []string) interface{} {
+		if s.role == "" {
+			return []interface{}{"master", int64(0), []interface{}{}}
+		}
+		return []interface{}{s.role}
+	})
+	register("SENTINEL", -2, func(s *Server, args []string) interface{} {
+		if strings.ToLower(args[1]) != "get-master-addr-by-name" || len(args) != 3 {
+			return errorReply("ERR unknown sentinel subcommand '" + args[1] + "'")
+		}
+		addr, found := s.masters[args[2]]
+		if !found {
+			return nilArray{}
+		}
+		host, port, _ := net.SplitHostPort(addr)
+		return []string{host, port}
+	})
+}
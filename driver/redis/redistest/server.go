@@ -0,0 +1,690 @@
+// Package redistest 提供进程内的redis替身(RESP协议),用于在go test中不依赖真实redis地测试Wrapper及其上层组件
+//
+// 支持的命令:
+//   - 通用: PING ECHO AUTH(SetPassword/SetUser) SELECT(SetDatabases设置库的个数, 各库共用同一份数据) TIME DBSIZE DEL UNLINK EXISTS TOUCH TYPE KEYS SCAN FLUSHDB FLUSHALL
+//   - 过期: EXPIRE PEXPIRE EXPIREAT PEXPIREAT TTL PTTL PERSIST, 时间由SetNow控制
+//   - string: GET SET SETEX PSETEX SETNX MGET MSET INCR INCRBY DECR DECRBY INCRBYFLOAT STRLEN
+//   - bitmap: SETBIT GETBIT BITCOUNT BITPOS BITOP
+//   - hash: HSET HMSET HSETNX HGET HMGET HGETALL HDEL HEXISTS HLEN HKEYS HVALS HINCRBY HINCRBYFLOAT HSCAN
+//   - list: LPUSH RPUSH LPUSHX RPUSHX LPOP RPOP LLEN LRANGE LINDEX LSET LREM LTRIM LINSERT LMOVE BLPOP BRPOP BLMOVE
+//   - set: SADD SREM SMEMBERS SISMEMBER SCARD SPOP SRANDMEMBER SMOVE SINTER SUNION SDIFF(及STORE) SSCAN
+//   - zset: ZADD ZINCRBY ZSCORE ZRANK ZREVRANK ZRANGE ZREVRANGE ZRANGEBYSCORE ZREVRANGEBYSCORE ZCARD ZREM ZCOUNT
+//     ZREMRANGEBYRANK ZREMRANGEBYSCORE ZPOPMIN ZPOPMAX ZUNIONSTORE ZINTERSTORE ZSCAN
+//   - geo: GEOADD GEOPOS GEODIST GEORADIUS GEORADIUSBYMEMBER, 存为zset, 编码同redis
+//   - HyperLogLog: PFADD PFCOUNT PFMERGE, 保存全部元素, 基数是精确值
+//   - 事务: MULTI EXEC DISCARD WATCH UNWATCH
+//   - stream: XADD XLEN XRANGE XDEL XTRIM(MAXLEN) XGROUP CREATE/DESTROY XREADGROUP XACK XPENDING XAUTOCLAIM
+//   - Pub/Sub: PUBLISH SUBSCRIBE UNSUBSCRIBE PSUBSCRIBE PUNSUBSCRIBE
+//   - 脚本: SCRIPT LOAD/EXISTS/FLUSH EVAL EVALSHA。用gopher-lua执行真实的Lua脚本, 提供base、table、string、math库
+//     和redis.call/pcall/error_reply/status_reply/sha1hex; 没有cjson、bit等库, 也不禁止全局变量
+//   - cluster: NewCluster启动多个节点, 支持CLUSTER SLOTS、ASKING和MOVED/ASK重定向
+//   - sentinel: ROLE(SetRole), SENTINEL get-master-addr-by-name(SetMaster), Failover发布+switch-master
+//
+// 阻塞命令的超时使用真实时间
+package redistest
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/ziyoumeng/sdk/driver/redis"
+)
+
+// blockPollInterval 阻塞命令检查数据的间隔
+const blockPollInterval = 5 * time.Millisecond
+
+var errClosed = errors.New("redistest: server closed")
+
+type Server struct {
+	mu       sync.Mutex
+	data     map[string]*entry
+	versions map[string]uint64 // WATCH使用, key每次修改递增
+	version  uint64
+	seq      uint64            // 最后分配的entry.id
+	scripts  map[string]string // sha=>脚本内容
+	now      func() time.Time
+	password string
+	username string            // SetUser设置的ACL用户, 为空时是default用户
+	dbs      int               // SELECT允许的库的个数, 0按1处理
+	conns    int64             // 累计建立的连接数
+	cluster  *Cluster          // 作为Cluster的节点时不为空
+	role     string            // ROLE命令返回的角色, 为空时是master
+	masters  map[string]string // 作为sentinel时master名=>地址
+
+	clients  map[*client]struct{}
+	listener net.Listener
+	wg       sync.WaitGroup
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// NewServer 启动一个监听本地随机端口的redis替身,用完需要调用Close
+func NewServer() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("redistest: listen: " + err.Error())
+	}
+	s := &Server{
+		data:     make(map[string]*entry),
+		versions: make(map[string]uint64),
+		scripts:  make(map[string]string),
+		now:      time.Now,
+		clients:  make(map[*client]struct{}),
+		listener: l,
+		closed:   make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+	return s
+}
+
+// Addr 返回host:port,可直接作为redis.Config.RedisAddr
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Source 返回不经过tcp的连接来源,配合redis.NewWrapperWithSource使用
+func (s *Server) Source() redis.ConnSource {
+	return redis.NewPoolSource(&redigo.Pool{
+		DialContext: func(ctx context.Context) (redigo.Conn, error) {
+			server, client := net.Pipe()
+			if err := s.serve(server); err != nil {
+				client.Close()
+				return nil, err
+			}
+			conn := redigo.NewConn(client, 0, 0)
+			if username, password := s.credentials(); password != "" {
+				args := []interface{}{password}
+				if username != "" {
+					args = []interface{}{username, password}
+				}
+				if _, err := conn.Do("AUTH", args...); err != nil {
+					conn.Close()
+					return nil, err
+				}
+			}
+			return conn, nil
+		},
+		MaxIdle: 8,
+	})
+}
+
+// NewWrapper 创建使用该Server的Wrapper
+func (s *Server) NewWrapper(prefix string) (*redis.Wrapper, error) {
+	return redis.NewWrapperWithSource(s.Source(), prefix)
+}
+
+// Close 断开所有连接并停止监听
+func (s *Server) Close() {
+	s.once.Do(func() {
+		close(s.closed)
+		s.listener.Close()
+		s.mu.Lock()
+		for c := range s.clients {
+			c.conn.Close()
+		}
+		s.mu.Unlock()
+		s.wg.Wait()
+	})
+}
+
+// SetNow 替换时钟,用于测试过期等依赖时间的逻辑, TIME命令和脚本也使用该时钟
+func (s *Server) SetNow(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// SetPassword 设置后连接需要先AUTH
+func (s *Server) SetPassword(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.password = password
+}
+
+// SetUser 设置后连接需要用AUTH username password认证, default用户不可用
+func (s *Server) SetUser(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.username, s.password = username, password
+}
+
+func (s *Server) credentials() (username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.username, s.password
+}
+
+// SetDatabases SELECT允许的库的个数, 默认只有0号库。各库共用同一份数据, 只用于检查客户端选择的库
+func (s *Server) SetDatabases(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dbs = n
+}
+
+// SelectedDBs 当前每个连接选择的库, 从小到大排列
+func (s *Server) SelectedDBs() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dbs := make([]int, 0, len(s.clients))
+	for c := range s.clients {
+		dbs = append(dbs, c.db)
+	}
+	sort.Ints(dbs)
+	return dbs
+}
+
+// Connections 累计建立的连接数
+func (s *Server) Connections() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+// DropClients 断开所有连接但继续监听, 模拟网络中断或服务端超时断开
+func (s *Server) DropClients() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		c.conn.Close()
+	}
+}
+
+// Do 直接在Server上执行命令,不经过连接, 用于测试中准备和检查数据; key需要自行带上Wrapper的前缀
+func (s *Server) Do(args ...string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return toResult(s.exec(args))
+}
+
+// Exists key是否存在(未过期), key需要自行带上前缀
+func (s *Server) Exists(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookup(key) != nil
+}
+
+// TTL 剩余过期时间, 没有过期时间或不存在时返回0
+func (s *Server) TTL(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(key)
+	if e == nil || e.expireAt.IsZero() {
+		return 0
+	}
+	return e.expireAt.Sub(s.now())
+}
+
+// Keys 所有未过期的key
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys("*")
+}
+
+// FlushAll 清空数据
+func (s *Server) FlushAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.data {
+		s.del(key)
+	}
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		if err := s.serve(conn); err != nil {
+			conn.Close()
+		}
+	}
+}
+
+func (s *Server) serve(conn net.Conn) error {
+	select {
+	case <-s.closed:
+		return errClosed
+	default:
+	}
+
+	c := &client{
+		server: s,
+		conn:   conn,
+	}
+	c.cond = sync.NewCond(&c.qmu)
+	s.mu.Lock()
+	c.authed = s.password == ""
+	s.clients[c] = struct{}{}
+	s.conns++
+	s.mu.Unlock()
+
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		c.writeLoop()
+		conn.Close()
+	}()
+	go func() {
+		defer s.wg.Done()
+		c.run()
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+		c.stop()
+	}()
+	return nil
+}
+
+// client 一个连接的状态
+type client struct {
+	server *Server
+	conn   net.Conn
+
+	// 回复和推送的消息按顺序放入queue, 由writeLoop写出; 不限长度, 避免net.Pipe上的大pipeline死锁
+	qmu   sync.Mutex
+	cond  *sync.Cond
+	queue []interface{}
+	done  bool
+
+	authed   bool
+	db       int  // SELECT选择的库, 修改时持有server.mu
+	asking   bool // 上一条命令是ASKING
+	inMulti  bool
+	multiErr bool
+	queued   [][]string
+	watched  map[string]uint64
+
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+func (c *client) run() {
+	r := bufio.NewReader(c.conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if reply := c.handle(args); reply != noReply {
+			c.send(reply)
+		}
+	}
+}
+
+func (c *client) send(replies ...interface{}) {
+	c.qmu.Lock()
+	c.queue = append(c.queue, replies...)
+	c.qmu.Unlock()
+	c.cond.Signal()
+}
+
+func (c *client) writeLoop() {
+	w := bufio.NewWriter(c.conn)
+	for {
+		c.qmu.Lock()
+		for len(c.queue) == 0 && !c.done {
+			c.cond.Wait()
+		}
+		if len(c.queue) == 0 {
+			c.qmu.Unlock()
+			return
+		}
+		batch := c.queue
+		c.queue = nil
+		c.qmu.Unlock()
+
+		for _, reply := range batch {
+			writeReply(w, reply)
+		}
+		if err := w.Flush(); err != nil {
+			c.conn.Close()
+		}
+	}
+}
+
+// stop 写完剩余的回复后结束writeLoop
+func (c *client) stop() {
+	c.qmu.Lock()
+	c.done = true
+	c.qmu.Unlock()
+	c.cond.Signal()
+}
+
+func (c *client) subscribed() bool {
+	return len(c.channels)+len(c.patterns) > 0
+}
+
+func (c *client) handle(args []string) interface{} {
+	name := strings.ToUpper(args[0])
+	s := c.server
+
+	if name == "AUTH" {
+		return c.auth(args)
+	}
+	if !c.authed {
+		return errorReply("NOAUTH Authentication required.")
+	}
+
+	if c.subscribed() {
+		switch name {
+		case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		case "PING":
+			msg := ""
+			if len(args) > 1 {
+				msg = args[1]
+			}
+			return []interface{}{"pong", msg}
+		default:
+			return errorReply("ERR Can't execute '" + strings.ToLower(name) + "': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context")
+		}
+	}
+
+	asking := c.asking
+	c.asking = false
+	if s.cluster != nil {
+		switch name {
+		case "ASKING":
+			c.asking = true
+			return ok
+		case "CLUSTER":
+			if len(args) == 2 && strings.ToUpper(args[1]) == "SLOTS" {
+				return s.cluster.slots()
+			}
+			return errorReply("ERR unknown subcommand '" + strings.Join(args[1:], " ") + "'")
+		}
+		if reply := s.cluster.route(s, asking, name, args); reply != nil {
+			return reply
+		}
+	}
+
+	switch name {
+	case "SELECT":
+		if len(args) != 2 {
+			return wrongArgs(name)
+		}
+		db, err := strconv.Atoi(args[1])
+		if err != nil {
+			return notInteger()
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if db < 0 || db > 0 && db >= s.dbs {
+			return errorReply("ERR DB index is out of range")
+		}
+		c.db = db
+		return ok
+	case "MULTI":
+		if c.inMulti {
+			return errorReply("ERR MULTI calls can not be nested")
+		}
+		c.inMulti, c.multiErr, c.queued = true, false, nil
+		return ok
+	case "EXEC":
+		return c.exec()
+	case "DISCARD":
+		if !c.inMulti {
+			return errorReply("ERR DISCARD without MULTI")
+		}
+		c.inMulti, c.queued, c.watched = false, nil, nil
+		return ok
+	case "WATCH":
+		if c.inMulti {
+			return errorReply("ERR WATCH inside MULTI is not allowed")
+		}
+		if len(args) < 2 {
+			return wrongArgs(name)
+		}
+		s.mu.Lock()
+		if c.watched == nil {
+			c.watched = make(map[string]uint64)
+		}
+		for _, key := range args[1:] {
+			s.lookup(key) // 过期的key视为修改
+			c.watched[key] = s.versions[key]
+		}
+		s.mu.Unlock()
+		return ok
+	case "UNWATCH":
+		c.watched = nil
+		return ok
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		return c.subscribe(name == "PSUBSCRIBE", args[1:])
+	case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		return c.unsubscribe(name == "PUNSUBSCRIBE", args[1:])
+	case "BLPOP", "BRPOP", "BLMOVE":
+		if c.inMulti {
+			break
+		}
+		return c.blockPop(name, args)
+	case "XREADGROUP":
+		if c.inMulti {
+			break
+		}
+		return c.blockRead(args)
+	}
+
+	if c.inMulti {
+		if _, ok := commands[name]; !ok {
+			c.multiErr = true
+			return errorReply("ERR unknown command '" + args[0] + "'")
+		}
+		c.queued = append(c.queued, args)
+		return status("QUEUED")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exec(args)
+}
+
+func (c *client) auth(args []string) interface{} {
+	username, password := c.server.credentials()
+	if len(args) < 2 || len(args) > 3 {
+		return wrongArgs("AUTH")
+	}
+	if password == "" {
+		return errorReply("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	}
+	user, want := "default", "default"
+	if len(args) == 3 {
+		user = args[1]
+	}
+	if username != "" {
+		want = username
+	}
+	if user != want || args[len(args)-1] != password {
+		return errorReply("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	c.authed = true
+	return ok
+}
+
+func (c *client) exec() interface{} {
+	if !c.inMulti {
+		return errorReply("ERR EXEC without MULTI")
+	}
+	queued, watched, multiErr := c.queued, c.watched, c.multiErr
+	c.inMulti, c.queued, c.watched = false, nil, nil
+	if multiErr {
+		return errorReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, version := range watched {
+		s.lookup(key)
+		if s.versions[key] != version {
+			return nilArray{}
+		}
+	}
+	replies := make([]interface{}, len(queued))
+	for i, args := range queued {
+		replies[i] = s.exec(args)
+	}
+	return replies
+}
+
+// block 阻塞命令, 不持有锁地轮询try直到返回非nil或超时, timeout为0时一直等待
+func (c *client) block(timeout time.Duration, try func() interface{}) interface{} {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	s := c.server
+	for {
+		s.mu.Lock()
+		reply := try()
+		s.mu.Unlock()
+		if reply != nil {
+			return reply
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nilArray{}
+		}
+		select {
+		case <-s.closed:
+			return nilArray{}
+		case <-time.After(blockPollInterval):
+		}
+	}
+}
+
+// blockPop BLPOP/BRPOP/BLMOVE, 最后一个参数为超时秒数
+func (c *client) blockPop(name string, args []string) interface{} {
+	seconds, err := strconv.ParseFloat(args[len(args)-1], 64)
+	if err != nil || seconds < 0 {
+		return errorReply("ERR timeout is not a float or out of range")
+	}
+	return c.block(time.Duration(seconds*float64(time.Second)), func() interface{} {
+		return c.server.tryPop(name, args[1:len(args)-1])
+	})
+}
+
+// blockRead 带BLOCK的XREADGROUP, 没有新消息时等待
+func (c *client) blockRead(args []string) interface{} {
+	var timeout time.Duration
+	blocking := false
+	for i := 1; i+1 < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "STREAMS":
+			i = len(args)
+		case "BLOCK":
+			ms, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || ms < 0 {
+				return errorReply("ERR timeout is not an integer or out of range")
+			}
+			timeout, blocking = time.Duration(ms)*time.Millisecond, true
+		}
+	}
+	s := c.server
+	if !blocking {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.exec(args)
+	}
+	return c.block(timeout, func() interface{} {
+		if reply := s.exec(args); reply != (nilArray{}) {
+			return reply
+		}
+		return nil
+	})
+}
+
+func (c *client) subscribe(pattern bool, names []string) interface{} {
+	if len(names) == 0 {
+		return wrongArgs("SUBSCRIBE")
+	}
+	kind := "subscribe"
+	s := c.server
+	s.mu.Lock()
+	if c.channels == nil {
+		c.channels = make(map[string]struct{})
+		c.patterns = make(map[string]struct{})
+	}
+	replies := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		if pattern {
+			kind = "psubscribe"
+			c.patterns[name] = struct{}{}
+		} else {
+			c.channels[name] = struct{}{}
+		}
+		replies = append(replies, []interface{}{kind, name, int64(len(c.channels) + len(c.patterns))})
+	}
+	// 持有锁时放入队列, 保证回复在之后PUBLISH的消息之前
+	c.send(replies...)
+	s.mu.Unlock()
+	return noReply
+}
+
+func (c *client) unsubscribe(pattern bool, names []string) interface{} {
+	kind := "unsubscribe"
+	if pattern {
+		kind = "punsubscribe"
+	}
+	s := c.server
+	s.mu.Lock()
+	subs := c.channels
+	if pattern {
+		subs = c.patterns
+	}
+	if len(names) == 0 {
+		for name := range subs {
+			names = append(names, name)
+		}
+	}
+	replies := make([]interface{}, 0, len(names)+1)
+	for _, name := range names {
+		delete(subs, name)
+		replies = append(replies, []interface{}{kind, name, int64(len(c.channels) + len(c.patterns))})
+	}
+	if len(replies) == 0 {
+		replies = append(replies, []interface{}{kind, nil, int64(len(c.channels) + len(c.patterns))})
+	}
+	c.send(replies...)
+	s.mu.Unlock()
+	return noReply
+}
+
+// publish 调用方需持有s.mu
+func (s *Server) publish(channel, message string) int64 {
+	var n int64
+	for c := range s.clients {
+		if _, ok := c.channels[channel]; ok {
+			n++
+			c.send([]interface{}{"message", channel, message})
+		}
+		for pattern := range c.patterns {
+			if globMatch(pattern, channel) {
+				n++
+				c.send([]interface{}{"pmessage", pattern, channel, message})
+			}
+		}
+	}
+	return n
+}
+
+func sha1Hex(script string) string {
+	sum := sha1.Sum([]byte(script))
+	return hex.EncodeToString(sum[:])
+}
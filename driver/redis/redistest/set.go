@@ -0,0 +1,235 @@
+package redistest
+
+import (
+	"sort"
+	"strconv"
+)
+
+func init() {
+	register("SADD", -3, func(s *Server, args []string) interface{} {
+		e, errReply := s.write(args[1], kindSet)
+		if errReply != nil {
+			return errReply
+		}
+		var n int64
+		for _, member := range args[2:] {
+			if _, ok := e.set[member]; !ok {
+				e.set[member] = struct{}{}
+				n++
+			}
+		}
+		return n
+	})
+	register("SREM", -3, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindSet)
+		if errReply != nil || e == nil {
+			return zeroOr(errReply)
+		}
+		var n int64
+		for _, member := range args[2:] {
+			if _, ok := e.set[member]; ok {
+				delete(e.set, member)
+				n++
+			}
+		}
+		if n > 0 {
+			s.touch(args[1])
+			s.cleanup(args[1], e)
+		}
+		return n
+	})
+	register("SMEMBERS", 2, func(s *Server, args []string) interface{} {
+		members, errReply := s.members(args[1])
+		if errReply != nil {
+			return errReply
+		}
+		return members
+	})
+	register("SISMEMBER", 3, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindSet)
+		if errReply != nil || e == nil {
+			return zeroOr(errReply)
+		}
+		if _, ok := e.set[args[2]]; ok {
+			return int64(1)
+		}
+		return int64(0)
+	})
+	register("SCARD", 2, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindSet)
+		if errReply != nil || e == nil {
+			return zeroOr(errReply)
+		}
+		return int64(len(e.set))
+	})
+	register("SPOP", -2, func(s *Server, args []string) interface{} {
+		return s.randMembers(args, true)
+	})
+	register("SRANDMEMBER", -2, func(s *Server, args []string) interface{} {
+		return s.randMembers(args, false)
+	})
+	register("SMOVE", 4, func(s *Server, args []string) interface{} {
+		src, errReply := s.get(args[1], kindSet)
+		if errReply != nil {
+			return errReply
+		}
+		if _, errReply := s.get(args[2], kindSet); errReply != nil {
+			return errReply
+		}
+		if src == nil {
+			return int64(0)
+		}
+		if _, ok := src.set[args[3]]; !ok {
+			return int64(0)
+		}
+		delete(src.set, args[3])
+		s.touch(args[1])
+		s.cleanup(args[1], src)
+		dst, _ := s.write(args[2], kindSet)
+		dst.set[args[3]] = struct{}{}
+		return int64(1)
+	})
+	for _, name := range []string{"SINTER", "SUNION", "SDIFF"} {
+		name := name
+		register(name, -2, func(s *Server, args []string) interface{} {
+			members, errReply := s.setOp(name, args[1:])
+			if errReply != nil {
+				return errReply
+			}
+			return members
+		})
+		register(name+"STORE", -3, func(s *Server, args []string) interface{} {
+			members, errReply := s.setOp(name, args[2:])
+			if errReply != nil {
+				return errReply
+			}
+			s.del(args[1])
+			if len(members) > 0 {
+				e, _ := s.write(args[1], kindSet)
+				for _, member := range members {
+					e.set[member] = struct{}{}
+				}
+			}
+			return int64(len(members))
+		})
+	}
+	register("SSCAN", -3, func(s *Server, args []string) interface{} {
+		cursor, pattern, count, _, errReply := scanOptions(args[2:])
+		if errReply != nil {
+			return errReply
+		}
+		members, errReply := s.members(args[1])
+		if errReply != nil {
+			return errReply
+		}
+		return scanPage(members, 1, cursor, count, pattern)
+	})
+}
+
+// members 排序后的集合成员
+func (s *Server) members(key string) ([]string, interface{}) {
+	e, errReply := s.get(key, kindSet)
+	if errReply != nil {
+		return nil, errReply
+	}
+	members := []string{}
+	if e == nil {
+		return members, nil
+	}
+	for member := range e.set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+func (s *Server) setOp(name string, keys []string) ([]string, interface{}) {
+	result := make(map[string]struct{})
+	for i, key := range keys {
+		members, errReply := s.members(key)
+		if errReply != nil {
+			return nil, errReply
+		}
+		switch {
+		case i == 0 || name == "SUNION":
+			for _, member := range members {
+				result[member] = struct{}{}
+			}
+		case name == "SINTER":
+			set := make(map[string]struct{}, len(members))
+			for _, member := range members {
+				set[member] = struct{}{}
+			}
+			for member := range result {
+				if _, ok := set[member]; !ok {
+					delete(result, member)
+				}
+			}
+		case name == "SDIFF":
+			for _, member := range members {
+				delete(result, member)
+			}
+		}
+	}
+	members := make([]string, 0, len(result))
+	for member := range result {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// randMembers SPOP/SRANDMEMBER, 依赖map遍历顺序的随机性; SRANDMEMBER的count为负数时允许重复
+func (s *Server) randMembers(args []string, pop bool) interface{} {
+	if len(args) > 3 {
+		return syntaxErr()
+	}
+	count, hasCount := 1, len(args) == 3
+	if hasCount {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || (pop && n < 0) {
+			return errorReply("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+	e, errReply := s.get(args[1], kindSet)
+	if errReply != nil {
+		return errReply
+	}
+	if e == nil {
+		if hasCount {
+			return []string{}
+		}
+		return nil
+	}
+
+	var picked []string
+	if count < 0 {
+		for len(picked) < -count {
+			for member := range e.set {
+				picked = append(picked, member)
+				break
+			}
+		}
+	} else {
+		for member := range e.set {
+			if len(picked) >= count {
+				break
+			}
+			picked = append(picked, member)
+		}
+	}
+	if pop {
+		for _, member := range picked {
+			delete(e.set, member)
+		}
+		if len(picked) > 0 {
+			s.touch(args[1])
+			s.cleanup(args[1], e)
+		}
+	}
+	if !hasCount {
+		return picked[0]
+	}
+	return picked
+}
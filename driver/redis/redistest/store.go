@@ -0,0 +1,500 @@
+package redistest
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	kindString = "string"
+	kindHash   = "hash"
+	kindList   = "list"
+	kindSet    = "set"
+	kindZSet   = "zset"
+	kindStream = "stream"
+)
+
+// entry 一个key的值, 按kind使用对应的字段
+type entry struct {
+	id       uint64 // 创建顺序, 作为SCAN的游标, 删除其他key不影响遍历
+	kind     string
+	str      string
+	hash     map[string]string
+	list     []string
+	set      map[string]struct{}
+	zset     map[string]float64
+	stream   *stream
+	expireAt time.Time
+}
+
+func newEntry(kind string) *entry {
+	e := &entry{kind: kind}
+	switch kind {
+	case kindHash:
+		e.hash = make(map[string]string)
+	case kindSet:
+		e.set = make(map[string]struct{})
+	case kindZSet:
+		e.zset = make(map[string]float64)
+	case kindStream:
+		e.stream = newStream()
+	}
+	return e
+}
+
+func (e *entry) empty() bool {
+	switch e.kind {
+	case kindHash:
+		return len(e.hash) == 0
+	case kindList:
+		return len(e.list) == 0
+	case kindSet:
+		return len(e.set) == 0
+	case kindZSet:
+		return len(e.zset) == 0
+	}
+	return false
+}
+
+// command arity同redis: 正数为参数个数(含命令名), 负数为最少个数
+type command struct {
+	arity int
+	fn    func(s *Server, args []string) interface{}
+}
+
+var commands = make(map[string]command)
+
+func register(name string, arity int, fn func(s *Server, args []string) interface{}) {
+	commands[name] = command{arity: arity, fn: fn}
+}
+
+// exec 执行一条命令, 调用方需持有s.mu
+func (s *Server) exec(args []string) interface{} {
+	if len(args) == 0 {
+		return errorReply("ERR empty command")
+	}
+	name := strings.ToUpper(args[0])
+	cmd, ok := commands[name]
+	if !ok {
+		return errorReply("ERR unknown command '" + args[0] + "'")
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		return wrongArgs(name)
+	}
+	return cmd.fn(s, args)
+}
+
+// lookup 返回未过期的entry, 过期的顺便删除
+func (s *Server) lookup(key string) *entry {
+	e, ok := s.data[key]
+	if !ok {
+		return nil
+	}
+	if !e.expireAt.IsZero() && !s.now().Before(e.expireAt) {
+		s.del(key)
+		return nil
+	}
+	return e
+}
+
+// get 取指定类型的entry, 不存在时返回nil, 类型不对时返回WRONGTYPE
+func (s *Server) get(key, kind string) (*entry, interface{}) {
+	e := s.lookup(key)
+	if e == nil {
+		return nil, nil
+	}
+	if e.kind != kind {
+		return nil, wrongType()
+	}
+	return e, nil
+}
+
+// write 取指定类型的entry用于修改, 不存在时创建
+func (s *Server) write(key, kind string) (*entry, interface{}) {
+	e, errReply := s.get(key, kind)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if e == nil {
+		e = newEntry(kind)
+		s.add(key, e)
+	}
+	s.touch(key)
+	return e, nil
+}
+
+// add 新建或覆盖key, 覆盖时保留原来的id
+func (s *Server) add(key string, e *entry) {
+	if old, ok := s.data[key]; ok {
+		e.id = old.id
+	} else {
+		s.seq++
+		e.id = s.seq
+	}
+	s.data[key] = e
+}
+
+// touch 标记key被修改, 使WATCH该key的事务失败
+func (s *Server) touch(key string) {
+	s.version++
+	s.versions[key] = s.version
+}
+
+// cleanup 容器为空时删除key
+func (s *Server) cleanup(key string, e *entry) {
+	if e.empty() {
+		s.del(key)
+	}
+}
+
+func (s *Server) del(key string) bool {
+	if _, ok := s.data[key]; !ok {
+		return false
+	}
+	delete(s.data, key)
+	s.touch(key)
+	return true
+}
+
+// keys 匹配pattern的未过期key, 按字典序
+func (s *Server) keys(pattern string) []string {
+	keys := make([]string, 0, len(s.data))
+	for key := range s.data {
+		if s.lookup(key) != nil && globMatch(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func init() {
+	register("PING", -1, func(s *Server, args []string) interface{} {
+		if len(args) > 1 {
+			return args[1]
+		}
+		return status("PONG")
+	})
+	register("ECHO", 2, func(s *Server, args []string) interface{} {
+		return args[1]
+	})
+	register("TIME", 1, func(s *Server, args []string) interface{} {
+		now := s.now()
+		return []string{strconv.FormatInt(now.Unix(), 10), strconv.Itoa(now.Nanosecond() / 1000)}
+	})
+	register("DBSIZE", 1, func(s *Server, args []string) interface{} {
+		return int64(len(s.keys("*")))
+	})
+	register("FLUSHDB", -1, cmdFlush)
+	register("FLUSHALL", -1, cmdFlush)
+	register("DEL", -2, cmdDel)
+	register("UNLINK", -2, cmdDel)
+	register("EXISTS", -2, cmdExists)
+	register("TOUCH", -2, cmdExists)
+	register("TYPE", 2, func(s *Server, args []string) interface{} {
+		e := s.lookup(args[1])
+		if e == nil {
+			return status("none")
+		}
+		return status(e.kind)
+	})
+	register("KEYS", 2, func(s *Server, args []string) interface{} {
+		return s.keys(args[1])
+	})
+	register("SCAN", -2, cmdScan)
+	register("EXPIRE", -3, cmdExpire(time.Second, false))
+	register("PEXPIRE", -3, cmdExpire(time.Millisecond, false))
+	register("EXPIREAT", -3, cmdExpire(time.Second, true))
+	register("PEXPIREAT", -3, cmdExpire(time.Millisecond, true))
+	register("TTL", 2, cmdTTL(time.Second))
+	register("PTTL", 2, cmdTTL(time.Millisecond))
+	register("PERSIST", 2, func(s *Server, args []string) interface{} {
+		e := s.lookup(args[1])
+		if e == nil || e.expireAt.IsZero() {
+			return int64(0)
+		}
+		e.expireAt = time.Time{}
+		s.touch(args[1])
+		return int64(1)
+	})
+	register("PUBLISH", 3, func(s *Server, args []string) interface{} {
+		return s.publish(args[1], args[2])
+	})
+}
+
+func cmdFlush(s *Server, args []string) interface{} {
+	for key := range s.data {
+		s.del(key)
+	}
+	return ok
+}
+
+func cmdDel(s *Server, args []string) interface{} {
+	var n int64
+	for _, key := range args[1:] {
+		if s.lookup(key) != nil && s.del(key) {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdExists(s *Server, args []string) interface{} {
+	var n int64
+	for _, key := range args[1:] {
+		if s.lookup(key) != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// cmdExpire at为true时参数是unix时间戳, 支持NX/XX/GT/LT
+func cmdExpire(unit time.Duration, at bool) func(s *Server, args []string) interface{} {
+	return func(s *Server, args []string) interface{} {
+		n, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return notInteger()
+		}
+		e := s.lookup(args[1])
+		if e == nil {
+			return int64(0)
+		}
+
+		var expireAt time.Time
+		if at {
+			expireAt = time.Unix(0, 0).Add(time.Duration(n) * unit)
+		} else {
+			expireAt = s.now().Add(time.Duration(n) * unit)
+		}
+		for _, flag := range args[3:] {
+			switch strings.ToUpper(flag) {
+			case "NX":
+				if !e.expireAt.IsZero() {
+					return int64(0)
+				}
+			case "XX":
+				if e.expireAt.IsZero() {
+					return int64(0)
+				}
+			case "GT":
+				if e.expireAt.IsZero() || !expireAt.After(e.expireAt) {
+					return int64(0)
+				}
+			case "LT":
+				if !e.expireAt.IsZero() && !expireAt.Before(e.expireAt) {
+					return int64(0)
+				}
+			default:
+				return errorReply("ERR Unsupported option " + flag)
+			}
+		}
+
+		if !s.now().Before(expireAt) {
+			s.del(args[1])
+			return int64(1)
+		}
+		e.expireAt = expireAt
+		s.touch(args[1])
+		return int64(1)
+	}
+}
+
+func cmdTTL(unit time.Duration) func(s *Server, args []string) interface{} {
+	return func(s *Server, args []string) interface{} {
+		e := s.lookup(args[1])
+		if e == nil {
+			return int64(-2)
+		}
+		if e.expireAt.IsZero() {
+			return int64(-1)
+		}
+		left := e.expireAt.Sub(s.now())
+		return int64((left + unit/2) / unit)
+	}
+}
+
+// scanOptions 解析SCAN系列命令的MATCH/COUNT/TYPE
+func scanOptions(args []string) (cursor int, pattern string, count int, keyType string, errReply interface{}) {
+	cursor, err := strconv.Atoi(args[0])
+	if err != nil || cursor < 0 {
+		return 0, "", 0, "", errorReply("ERR invalid cursor")
+	}
+	pattern, count = "*", 10
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return 0, "", 0, "", syntaxErr()
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil || count < 1 {
+				return 0, "", 0, "", syntaxErr()
+			}
+		case "TYPE":
+			keyType = strings.ToLower(args[i+1])
+		default:
+			return 0, "", 0, "", syntaxErr()
+		}
+	}
+	return cursor, pattern, count, keyType, nil
+}
+
+// scanPage 以下标作为游标, 每次返回count个。items每step项为一个元素, 按第一项匹配pattern
+func scanPage(items []string, step, cursor, count int, pattern string) interface{} {
+	start := cursor * step
+	if start > len(items) {
+		start = len(items)
+	}
+	end := start + count*step
+	next := cursor + count
+	if end >= len(items) {
+		end, next = len(items), 0
+	}
+	page := make([]string, 0, end-start)
+	for i := start; i < end; i += step {
+		if globMatch(pattern, items[i]) {
+			page = append(page, items[i:i+step]...)
+		}
+	}
+	return []interface{}{strconv.Itoa(next), page}
+}
+
+func cmdScan(s *Server, args []string) interface{} {
+	cursor, pattern, count, keyType, errReply := scanOptions(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	var entries []*entry
+	keys := make(map[*entry]string)
+	for key := range s.data {
+		e := s.lookup(key)
+		if e != nil && e.id >= uint64(cursor) {
+			entries = append(entries, e)
+			keys[e] = key
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].id < entries[j].id })
+
+	next := "0"
+	if len(entries) > count {
+		entries = entries[:count]
+		next = strconv.FormatUint(entries[count-1].id+1, 10)
+	}
+	page := []string{}
+	for _, e := range entries {
+		if (keyType == "" || e.kind == keyType) && globMatch(pattern, keys[e]) {
+			page = append(page, keys[e])
+		}
+	}
+	return []interface{}{next, page}
+}
+
+// globMatch 同redis的stringmatch, 支持* ? [abc] [^a-z] 和\转义
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				// 没有闭合的[按普通字符处理
+				if s[0] != '[' {
+					return false
+				}
+				s, pattern = s[1:], pattern[1:]
+				continue
+			}
+			class := pattern[1 : end+1]
+			pattern = pattern[end+2:]
+			negate := len(class) > 0 && class[0] == '^'
+			if negate {
+				class = class[1:]
+			}
+			matched := false
+			for i := 0; i < len(class); i++ {
+				if class[i] == '\\' && i+1 < len(class) {
+					i++
+					matched = matched || class[i] == s[0]
+				} else if i+2 < len(class) && class[i+1] == '-' {
+					lo, hi := class[i], class[i+2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					matched = matched || (s[0] >= lo && s[0] <= hi)
+					i += 2
+				} else {
+					matched = matched || class[i] == s[0]
+				}
+			}
+			if matched == negate {
+				return false
+			}
+			s = s[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			s, pattern = s[1:], pattern[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// parseFloat 同redis, 支持inf/+inf/-inf
+func parseFloat(s string) (float64, bool) {
+	switch strings.ToLower(s) {
+	case "inf", "+inf":
+		return math.Inf(1), true
+	case "-inf":
+		return math.Inf(-1), true
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case f == math.Trunc(f) && math.Abs(f) < 1e17:
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
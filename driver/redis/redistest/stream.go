@@ -0,0 +1,585 @@
+package redistest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamID 消息id, 格式为ms-seq
+type streamID struct {
+	ms, seq uint64
+}
+
+var maxStreamID = streamID{ms: ^uint64(0), seq: ^uint64(0)}
+
+func (id streamID) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
+
+func (id streamID) less(other streamID) bool {
+	return id.ms < other.ms || (id.ms == other.ms && id.seq < other.seq)
+}
+
+// parseStreamID 解析ms-seq, 只有ms时seq取missingSeq
+func parseStreamID(s string, missingSeq uint64) (streamID, bool) {
+	parts := strings.SplitN(s, "-", 2)
+	ms, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	if len(parts) == 1 {
+		return streamID{ms: ms, seq: missingSeq}, true
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	return streamID{ms: ms, seq: seq}, true
+}
+
+// parseRangeID XRANGE等的起止id, 支持-和+
+func parseRangeID(s string, end bool) (streamID, bool) {
+	switch s {
+	case "-":
+		return streamID{}, true
+	case "+":
+		return maxStreamID, true
+	}
+	if end {
+		return parseStreamID(s, ^uint64(0))
+	}
+	return parseStreamID(s, 0)
+}
+
+func invalidStreamID() errorReply {
+	return errorReply("ERR Invalid stream ID specified as stream command argument")
+}
+
+type streamEntry struct {
+	id     streamID
+	fields []string
+}
+
+type pendingEntry struct {
+	consumer    string
+	deliveredAt time.Time
+	deliveries  int64
+}
+
+type streamGroup struct {
+	lastID  streamID
+	pending map[streamID]*pendingEntry
+}
+
+// stream 消息按id递增保存
+type stream struct {
+	entries []streamEntry
+	lastID  streamID
+	groups  map[string]*streamGroup
+}
+
+func newStream() *stream {
+	return &stream{groups: make(map[string]*streamGroup)}
+}
+
+// find 返回第一个id不小于id的下标
+func (st *stream) find(id streamID) int {
+	return sort.Search(len(st.entries), func(i int) bool {
+		return !st.entries[i].id.less(id)
+	})
+}
+
+func (st *stream) lookup(id streamID) (streamEntry, bool) {
+	i := st.find(id)
+	if i < len(st.entries) && st.entries[i].id == id {
+		return st.entries[i], true
+	}
+	return streamEntry{}, false
+}
+
+func (st *stream) trim(maxLen int) int64 {
+	if len(st.entries) <= maxLen {
+		return 0
+	}
+	n := len(st.entries) - maxLen
+	st.entries = append([]streamEntry(nil), st.entries[n:]...)
+	return int64(n)
+}
+
+func entryReply(e streamEntry) interface{} {
+	return []interface{}{e.id.String(), e.fields}
+}
+
+// sortedPending 按id排序的未ack消息
+func (g *streamGroup) sortedPending() []streamID {
+	ids := make([]streamID, 0, len(g.pending))
+	for id := range g.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].less(ids[j]) })
+	return ids
+}
+
+// getGroup 取stream和消费组, 不存在时返回NOGROUP
+func (s *Server) getGroup(key, group, command string) (*stream, *streamGroup, interface{}) {
+	e, errReply := s.get(key, kindStream)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	if e != nil {
+		if g, ok := e.stream.groups[group]; ok {
+			return e.stream, g, nil
+		}
+	}
+	return nil, nil, errorReply("NOGROUP No such key '" + key + "' or consumer group '" + group + "' in " + command + " with GROUP option")
+}
+
+func init() {
+	register("XADD", -5, func(s *Server, args []string) interface{} {
+		key := args[1]
+		args = args[2:]
+		maxLen := -1
+		if strings.ToUpper(args[0]) == "MAXLEN" {
+			args = args[1:]
+			if len(args) > 1 && (args[0] == "~" || args[0] == "=") {
+				args = args[1:]
+			}
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 0 {
+				return notInteger()
+			}
+			maxLen, args = n, args[1:]
+		}
+		if len(args) < 3 || len(args)%2 != 1 {
+			return wrongArgs("XADD")
+		}
+
+		e, errReply := s.write(key, kindStream)
+		if errReply != nil {
+			return errReply
+		}
+		st := e.stream
+		var id streamID
+		if args[0] == "*" {
+			id = streamID{ms: uint64(s.now().UnixNano() / int64(time.Millisecond))}
+			if !st.lastID.less(id) {
+				id = streamID{ms: st.lastID.ms, seq: st.lastID.seq + 1}
+			}
+		} else {
+			var ok bool
+			if id, ok = parseStreamID(args[0], 0); !ok {
+				return invalidStreamID()
+			}
+			if !st.lastID.less(id) {
+				return errorReply("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+			}
+		}
+		st.entries = append(st.entries, streamEntry{id: id, fields: append([]string(nil), args[1:]...)})
+		st.lastID = id
+		if maxLen >= 0 {
+			st.trim(maxLen)
+		}
+		return id.String()
+	})
+	register("XLEN", 2, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindStream)
+		if errReply != nil || e == nil {
+			return zeroOr(errReply)
+		}
+		return int64(len(e.stream.entries))
+	})
+	register("XRANGE", -4, func(s *Server, args []string) interface{} {
+		start, ok1 := parseRangeID(args[2], false)
+		end, ok2 := parseRangeID(args[3], true)
+		if !ok1 || !ok2 {
+			return invalidStreamID()
+		}
+		count := -1
+		if len(args) == 6 && strings.ToUpper(args[4]) == "COUNT" {
+			n, err := strconv.Atoi(args[5])
+			if err != nil {
+				return notInteger()
+			}
+			count = n
+		} else if len(args) != 4 {
+			return syntaxErr()
+		}
+		e, errReply := s.get(args[1], kindStream)
+		if errReply != nil {
+			return errReply
+		}
+		values := []interface{}{}
+		if e == nil {
+			return values
+		}
+		for _, entry := range e.stream.entries[e.stream.find(start):] {
+			if end.less(entry.id) || (count >= 0 && len(values) >= count) {
+				break
+			}
+			values = append(values, entryReply(entry))
+		}
+		return values
+	})
+	register("XDEL", -3, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindStream)
+		if errReply != nil || e == nil {
+			return zeroOr(errReply)
+		}
+		st := e.stream
+		var n int64
+		for _, arg := range args[2:] {
+			id, ok := parseStreamID(arg, 0)
+			if !ok {
+				return invalidStreamID()
+			}
+			i := st.find(id)
+			if i < len(st.entries) && st.entries[i].id == id {
+				st.entries = append(st.entries[:i:i], st.entries[i+1:]...)
+				n++
+			}
+		}
+		if n > 0 {
+			s.touch(args[1])
+		}
+		return n
+	})
+	register("XTRIM", -4, func(s *Server, args []string) interface{} {
+		if strings.ToUpper(args[2]) != "MAXLEN" {
+			return syntaxErr()
+		}
+		arg := args[3]
+		if (arg == "~" || arg == "=") && len(args) == 5 {
+			arg = args[4]
+		}
+		maxLen, err := strconv.Atoi(arg)
+		if err != nil || maxLen < 0 {
+			return notInteger()
+		}
+		e, errReply := s.get(args[1], kindStream)
+		if errReply != nil || e == nil {
+			return zeroOr(errReply)
+		}
+		n := e.stream.trim(maxLen)
+		if n > 0 {
+			s.touch(args[1])
+		}
+		return n
+	})
+	register("XGROUP", -2, func(s *Server, args []string) interface{} {
+		switch strings.ToUpper(args[1]) {
+		case "CREATE":
+			if len(args) < 5 || len(args) > 6 {
+				return wrongArgs("XGROUP|CREATE")
+			}
+			key, group := args[2], args[3]
+			e, errReply := s.get(key, kindStream)
+			if errReply != nil {
+				return errReply
+			}
+			if e == nil {
+				if len(args) != 6 || strings.ToUpper(args[5]) != "MKSTREAM" {
+					return errorReply("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+				}
+				e, _ = s.write(key, kindStream)
+			}
+			if _, ok := e.stream.groups[group]; ok {
+				return errorReply("BUSYGROUP Consumer Group name already exists")
+			}
+			lastID := e.stream.lastID
+			if args[4] != "$" {
+				var ok bool
+				if lastID, ok = parseStreamID(args[4], 0); !ok {
+					return invalidStreamID()
+				}
+			}
+			e.stream.groups[group] = &streamGroup{lastID: lastID, pending: make(map[streamID]*pendingEntry)}
+			s.touch(key)
+			return ok
+		case "DESTROY":
+			if len(args) != 4 {
+				return wrongArgs("XGROUP|DESTROY")
+			}
+			e, errReply := s.get(args[2], kindStream)
+			if errReply != nil || e == nil {
+				return zeroOr(errReply)
+			}
+			if _, ok := e.stream.groups[args[3]]; !ok {
+				return int64(0)
+			}
+			delete(e.stream.groups, args[3])
+			s.touch(args[2])
+			return int64(1)
+		}
+		return errorReply("ERR unknown subcommand '" + args[1] + "'")
+	})
+	register("XREADGROUP", -7, cmdXReadGroup)
+	register("XACK", -4, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindStream)
+		if errReply != nil || e == nil {
+			return zeroOr(errReply)
+		}
+		g, ok := e.stream.groups[args[2]]
+		if !ok {
+			return int64(0)
+		}
+		var n int64
+		for _, arg := range args[3:] {
+			id, ok := parseStreamID(arg, 0)
+			if !ok {
+				return invalidStreamID()
+			}
+			if _, ok := g.pending[id]; ok {
+				delete(g.pending, id)
+				n++
+			}
+		}
+		return n
+	})
+	register("XPENDING", -3, cmdXPending)
+	register("XAUTOCLAIM", -6, cmdXAutoClaim)
+}
+
+// cmdXReadGroup XREADGROUP GROUP group consumer [COUNT n] [BLOCK ms] [NOACK] STREAMS key... id...
+// 没有新消息时返回空数组, BLOCK由client.block处理
+func cmdXReadGroup(s *Server, args []string) interface{} {
+	if strings.ToUpper(args[1]) != "GROUP" {
+		return syntaxErr()
+	}
+	group, consumer := args[2], args[3]
+	count, noAck := -1, false
+	streams := -1
+	for i := 4; i < len(args) && streams < 0; i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			if i+1 >= len(args) {
+				return syntaxErr()
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return notInteger()
+			}
+			if n > 0 {
+				count = n
+			}
+			i++
+		case "BLOCK":
+			i++
+		case "NOACK":
+			noAck = true
+		case "STREAMS":
+			streams = i + 1
+		default:
+			return syntaxErr()
+		}
+	}
+	if streams < 0 {
+		return syntaxErr()
+	}
+
+	rest := args[streams:]
+	if len(rest) == 0 || len(rest)%2 != 0 {
+		return errorReply("ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '>' must be specified.")
+	}
+	keys, ids := rest[:len(rest)/2], rest[len(rest)/2:]
+
+	var replies []interface{}
+	history := false
+	for k, key := range keys {
+		st, g, errReply := s.getGroup(key, group, "XREADGROUP")
+		if errReply != nil {
+			return errReply
+		}
+		messages := []interface{}{}
+		if ids[k] == ">" {
+			for _, entry := range st.entries[st.find(streamID{ms: g.lastID.ms, seq: g.lastID.seq + 1}):] {
+				if g.lastID.less(entry.id) {
+					if count >= 0 && len(messages) >= count {
+						break
+					}
+					messages = append(messages, entryReply(entry))
+					g.lastID = entry.id
+					if !noAck {
+						g.pending[entry.id] = &pendingEntry{consumer: consumer, deliveredAt: s.now(), deliveries: 1}
+					}
+				}
+			}
+			if len(messages) == 0 {
+				continue
+			}
+		} else {
+			// 本consumer已投递未ack的消息, 已删除的消息返回[id, nil]
+			history = true
+			start, ok := parseStreamID(ids[k], 0)
+			if !ok {
+				return invalidStreamID()
+			}
+			for _, id := range g.sortedPending() {
+				p := g.pending[id]
+				if p.consumer != consumer || id.less(start) || id == start {
+					continue
+				}
+				if count >= 0 && len(messages) >= count {
+					break
+				}
+				p.deliveredAt = s.now()
+				p.deliveries++
+				if entry, ok := st.lookup(id); ok {
+					messages = append(messages, entryReply(entry))
+				} else {
+					messages = append(messages, []interface{}{id.String(), nil})
+				}
+			}
+		}
+		replies = append(replies, []interface{}{key, messages})
+	}
+	if len(replies) == 0 && !history {
+		return nilArray{}
+	}
+	return replies
+}
+
+// cmdXPending XPENDING key group 或 XPENDING key group [IDLE ms] start end count [consumer]
+func cmdXPending(s *Server, args []string) interface{} {
+	_, g, errReply := s.getGroup(args[1], args[2], "XPENDING")
+	if errReply != nil {
+		return errReply
+	}
+	ids := g.sortedPending()
+
+	if len(args) == 3 {
+		if len(ids) == 0 {
+			return []interface{}{int64(0), nil, nil, nilArray{}}
+		}
+		counts := make(map[string]int64)
+		for _, id := range ids {
+			counts[g.pending[id].consumer]++
+		}
+		names := make([]string, 0, len(counts))
+		for name := range counts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		consumers := make([]interface{}, 0, len(names))
+		for _, name := range names {
+			consumers = append(consumers, []string{name, strconv.FormatInt(counts[name], 10)})
+		}
+		return []interface{}{int64(len(ids)), ids[0].String(), ids[len(ids)-1].String(), consumers}
+	}
+
+	args = args[3:]
+	var minIdle time.Duration
+	if strings.ToUpper(args[0]) == "IDLE" {
+		if len(args) < 2 {
+			return syntaxErr()
+		}
+		ms, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return notInteger()
+		}
+		minIdle, args = time.Duration(ms)*time.Millisecond, args[2:]
+	}
+	if len(args) != 3 && len(args) != 4 {
+		return syntaxErr()
+	}
+	start, ok1 := parseRangeID(args[0], false)
+	end, ok2 := parseRangeID(args[1], true)
+	if !ok1 || !ok2 {
+		return invalidStreamID()
+	}
+	count, err := strconv.Atoi(args[2])
+	if err != nil {
+		return notInteger()
+	}
+	consumer := ""
+	if len(args) == 4 {
+		consumer = args[3]
+	}
+
+	values := []interface{}{}
+	now := s.now()
+	for _, id := range ids {
+		if len(values) >= count {
+			break
+		}
+		p := g.pending[id]
+		idle := now.Sub(p.deliveredAt)
+		if id.less(start) || end.less(id) || (consumer != "" && p.consumer != consumer) || idle < minIdle {
+			continue
+		}
+		values = append(values, []interface{}{id.String(), p.consumer, int64(idle / time.Millisecond), p.deliveries})
+	}
+	return values
+}
+
+// cmdXAutoClaim XAUTOCLAIM key group consumer min-idle start [COUNT n] [JUSTID], 回复格式同redis 7
+func cmdXAutoClaim(s *Server, args []string) interface{} {
+	st, g, errReply := s.getGroup(args[1], args[2], "XAUTOCLAIM")
+	if errReply != nil {
+		return errReply
+	}
+	consumer := args[3]
+	ms, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil || ms < 0 {
+		return errorReply("ERR Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	minIdle := time.Duration(ms) * time.Millisecond
+	start, ok := parseRangeID(args[5], false)
+	if !ok {
+		return invalidStreamID()
+	}
+	count, justID := 100, false
+	for i := 6; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			if i+1 >= len(args) {
+				return syntaxErr()
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 1 {
+				return errorReply("ERR COUNT must be > 0")
+			}
+			count = n
+			i++
+		case "JUSTID":
+			justID = true
+		default:
+			return syntaxErr()
+		}
+	}
+
+	now := s.now()
+	claimed, deleted := []interface{}{}, []string{}
+	next := streamID{}
+	scanned := 0
+	for _, id := range g.sortedPending() {
+		if id.less(start) {
+			continue
+		}
+		if scanned >= count {
+			next = id
+			break
+		}
+		scanned++
+		p := g.pending[id]
+		if now.Sub(p.deliveredAt) < minIdle {
+			continue
+		}
+		entry, ok := st.lookup(id)
+		if !ok {
+			delete(g.pending, id)
+			deleted = append(deleted, id.String())
+			continue
+		}
+		p.consumer, p.deliveredAt = consumer, now
+		if justID {
+			claimed = append(claimed, id.String())
+		} else {
+			p.deliveries++
+			claimed = append(claimed, entryReply(entry))
+		}
+	}
+	return []interface{}{next.String(), claimed, deleted}
+}
@@ -0,0 +1,406 @@
+package redistest
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	register("GET", 2, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindString)
+		if errReply != nil {
+			return errReply
+		}
+		if e == nil {
+			return nil
+		}
+		return e.str
+	})
+	register("SET", -3, cmdSet)
+	register("SETEX", 4, func(s *Server, args []string) interface{} {
+		return cmdSet(s, []string{"SET", args[1], args[3], "EX", args[2]})
+	})
+	register("PSETEX", 4, func(s *Server, args []string) interface{} {
+		return cmdSet(s, []string{"SET", args[1], args[3], "PX", args[2]})
+	})
+	register("SETNX", 3, func(s *Server, args []string) interface{} {
+		if cmdSet(s, []string{"SET", args[1], args[2], "NX"}) == nil {
+			return int64(0)
+		}
+		return int64(1)
+	})
+	register("MGET", -2, func(s *Server, args []string) interface{} {
+		values := make([]interface{}, 0, len(args)-1)
+		for _, key := range args[1:] {
+			e, _ := s.get(key, kindString)
+			if e == nil {
+				values = append(values, nil)
+			} else {
+				values = append(values, e.str)
+			}
+		}
+		return values
+	})
+	register("MSET", -3, func(s *Server, args []string) interface{} {
+		if len(args)%2 != 1 {
+			return wrongArgs("MSET")
+		}
+		for i := 1; i < len(args); i += 2 {
+			s.setString(args[i], args[i+1], time.Time{})
+		}
+		return ok
+	})
+	register("INCR", 2, func(s *Server, args []string) interface{} {
+		return s.incrBy(args[1], "1")
+	})
+	register("INCRBY", 3, func(s *Server, args []string) interface{} {
+		return s.incrBy(args[1], args[2])
+	})
+	register("DECR", 2, func(s *Server, args []string) interface{} {
+		return s.incrBy(args[1], "-1")
+	})
+	register("DECRBY", 3, func(s *Server, args []string) interface{} {
+		if strings.HasPrefix(args[2], "-") {
+			return s.incrBy(args[1], args[2][1:])
+		}
+		return s.incrBy(args[1], "-"+args[2])
+	})
+	register("INCRBYFLOAT", 3, func(s *Server, args []string) interface{} {
+		delta, ok := parseFloat(args[2])
+		if !ok || math.IsInf(delta, 0) {
+			return notFloat()
+		}
+		e, errReply := s.write(args[1], kindString)
+		if errReply != nil {
+			return errReply
+		}
+		cur := 0.0
+		if e.str != "" {
+			if cur, ok = parseFloat(e.str); !ok {
+				return notFloat()
+			}
+		}
+		cur += delta
+		if math.IsInf(cur, 0) {
+			return errorReply("ERR increment would produce NaN or Infinity")
+		}
+		e.str = formatFloat(cur)
+		return e.str
+	})
+	register("STRLEN", 2, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindString)
+		if errReply != nil {
+			return errReply
+		}
+		if e == nil {
+			return int64(0)
+		}
+		return int64(len(e.str))
+	})
+	register("SETBIT", 4, func(s *Server, args []string) interface{} {
+		offset, err := strconv.ParseUint(args[2], 10, 32)
+		if err != nil {
+			return errorReply("ERR bit offset is not an integer or out of range")
+		}
+		if args[3] != "0" && args[3] != "1" {
+			return errorReply("ERR bit is not an integer or out of range")
+		}
+		e, errReply := s.write(args[1], kindString)
+		if errReply != nil {
+			return errReply
+		}
+		buf := []byte(e.str)
+		idx := int(offset / 8)
+		if idx >= len(buf) {
+			buf = append(buf, make([]byte, idx+1-len(buf))...)
+		}
+		mask := byte(1) << (7 - offset%8)
+		old := int64(0)
+		if buf[idx]&mask != 0 {
+			old = 1
+		}
+		if args[3] == "1" {
+			buf[idx] |= mask
+		} else {
+			buf[idx] &^= mask
+		}
+		e.str = string(buf)
+		return old
+	})
+	register("GETBIT", 3, func(s *Server, args []string) interface{} {
+		offset, err := strconv.ParseUint(args[2], 10, 32)
+		if err != nil {
+			return errorReply("ERR bit offset is not an integer or out of range")
+		}
+		e, errReply := s.get(args[1], kindString)
+		if errReply != nil {
+			return errReply
+		}
+		if e == nil || int(offset/8) >= len(e.str) {
+			return int64(0)
+		}
+		return int64(e.str[offset/8] >> (7 - offset%8) & 1)
+	})
+	register("BITCOUNT", -2, func(s *Server, args []string) interface{} {
+		if len(args) != 2 && len(args) != 4 {
+			return syntaxErr()
+		}
+		e, errReply := s.get(args[1], kindString)
+		if errReply != nil || e == nil {
+			return zeroOr(errReply)
+		}
+		str := e.str
+		if len(args) == 4 {
+			start, err1 := strconv.Atoi(args[2])
+			end, err2 := strconv.Atoi(args[3])
+			if err1 != nil || err2 != nil {
+				return notInteger()
+			}
+			str = byteRange(str, start, end)
+		}
+		var n int64
+		for i := 0; i < len(str); i++ {
+			for b := str[i]; b != 0; b &= b - 1 {
+				n++
+			}
+		}
+		return n
+	})
+	register("BITPOS", -3, func(s *Server, args []string) interface{} {
+		if args[2] != "0" && args[2] != "1" {
+			return errorReply("ERR The bit argument must be 1 or 0.")
+		}
+		bit := args[2] == "1"
+		e, errReply := s.get(args[1], kindString)
+		if errReply != nil {
+			return errReply
+		}
+		if e == nil {
+			if bit {
+				return int64(-1)
+			}
+			return int64(0)
+		}
+		str, offset := e.str, 0
+		if len(args) > 5 {
+			return syntaxErr()
+		}
+		if len(args) >= 4 {
+			start, err := strconv.Atoi(args[3])
+			if err != nil {
+				return notInteger()
+			}
+			end := -1
+			if len(args) == 5 {
+				if end, err = strconv.Atoi(args[4]); err != nil {
+					return notInteger()
+				}
+			}
+			if start < 0 {
+				start += len(str)
+			}
+			if start < 0 {
+				start = 0
+			}
+			offset = start
+			str = byteRange(str, start, end)
+		}
+		for i := 0; i < len(str); i++ {
+			for j := 0; j < 8; j++ {
+				if (str[i]>>(7-j)&1 == 1) == bit {
+					return int64((offset+i)*8 + j)
+				}
+			}
+		}
+		// 找0但没有指定end时, 字符串右边视为补0
+		if !bit && len(args) < 5 {
+			return int64((offset + len(str)) * 8)
+		}
+		return int64(-1)
+	})
+	register("BITOP", -4, func(s *Server, args []string) interface{} {
+		op := strings.ToUpper(args[1])
+		switch op {
+		case "AND", "OR", "XOR", "NOT":
+		default:
+			return syntaxErr()
+		}
+		if op == "NOT" && len(args) != 4 {
+			return errorReply("ERR BITOP NOT must be called with a single source key.")
+		}
+		var values []string
+		size := 0
+		for _, key := range args[3:] {
+			e, errReply := s.get(key, kindString)
+			if errReply != nil {
+				return errReply
+			}
+			var v string
+			if e != nil {
+				v = e.str
+			}
+			if len(v) > size {
+				size = len(v)
+			}
+			values = append(values, v)
+		}
+		result := make([]byte, size)
+		for i := range result {
+			// 较短的值右边补0
+			at := func(v string) byte {
+				if i < len(v) {
+					return v[i]
+				}
+				return 0
+			}
+			b := at(values[0])
+			for _, v := range values[1:] {
+				switch op {
+				case "AND":
+					b &= at(v)
+				case "OR":
+					b |= at(v)
+				case "XOR":
+					b ^= at(v)
+				}
+			}
+			if op == "NOT" {
+				b = ^b
+			}
+			result[i] = b
+		}
+		s.del(args[2])
+		if size > 0 {
+			s.setString(args[2], string(result), time.Time{})
+		}
+		return int64(size)
+	})
+}
+
+// byteRange 同GETRANGE, start和end为字节下标, 可以为负数
+func byteRange(str string, start, end int) string {
+	if start < 0 {
+		start += len(str)
+	}
+	if end < 0 {
+		end += len(str)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= len(str) {
+		end = len(str) - 1
+	}
+	if start > end {
+		return ""
+	}
+	return str[start : end+1]
+}
+
+// cmdSet 支持EX/PX/EXAT/PXAT/NX/XX/KEEPTTL/GET, 没有写入时返回nil
+func cmdSet(s *Server, args []string) interface{} {
+	key, value := args[1], args[2]
+	var (
+		expireAt      time.Time
+		nx, xx, keep  bool
+		get           bool
+		expireOptions int
+	)
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "KEEPTTL":
+			keep = true
+		case "GET":
+			get = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if i+1 >= len(args) {
+				return syntaxErr()
+			}
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return notInteger()
+			}
+			if n <= 0 {
+				return errorReply("ERR invalid expire time in 'set' command")
+			}
+			expireOptions++
+			switch opt {
+			case "EX":
+				expireAt = s.now().Add(time.Duration(n) * time.Second)
+			case "PX":
+				expireAt = s.now().Add(time.Duration(n) * time.Millisecond)
+			case "EXAT":
+				expireAt = time.Unix(n, 0)
+			case "PXAT":
+				expireAt = time.Unix(0, n*int64(time.Millisecond))
+			}
+		default:
+			return syntaxErr()
+		}
+	}
+	if (nx && xx) || expireOptions > 1 || (keep && expireOptions > 0) {
+		return syntaxErr()
+	}
+
+	var old interface{}
+	cur := s.lookup(key)
+	if get && cur != nil {
+		if cur.kind != kindString {
+			return wrongType()
+		}
+		old = cur.str
+	}
+	if (nx && cur != nil) || (xx && cur == nil) {
+		if get {
+			return old
+		}
+		return nil
+	}
+	if keep && cur != nil {
+		expireAt = cur.expireAt
+	}
+	s.setString(key, value, expireAt)
+	if get {
+		return old
+	}
+	return ok
+}
+
+// setString 覆盖key, 不论原来的类型
+func (s *Server) setString(key, value string, expireAt time.Time) {
+	e := newEntry(kindString)
+	e.str = value
+	e.expireAt = expireAt
+	s.add(key, e)
+	s.touch(key)
+}
+
+func (s *Server) incrBy(key, deltaArg string) interface{} {
+	delta, err := strconv.ParseInt(deltaArg, 10, 64)
+	if err != nil {
+		return notInteger()
+	}
+	e, errReply := s.write(key, kindString)
+	if errReply != nil {
+		return errReply
+	}
+	var cur int64
+	if e.str != "" {
+		if cur, err = strconv.ParseInt(e.str, 10, 64); err != nil {
+			return notInteger()
+		}
+	}
+	if (delta > 0 && cur > math.MaxInt64-delta) || (delta < 0 && cur < math.MinInt64-delta) {
+		return errorReply("ERR increment or decrement would overflow")
+	}
+	cur += delta
+	e.str = strconv.FormatInt(cur, 10)
+	return cur
+}
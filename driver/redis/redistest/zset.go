@@ -0,0 +1,508 @@
+package redistest
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type zmember struct {
+	name  string
+	score float64
+}
+
+// sorted 按分数升序, 同分按成员字典序
+func (e *entry) sorted() []zmember {
+	members := make([]zmember, 0, len(e.zset))
+	for name, score := range e.zset {
+		members = append(members, zmember{name, score})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].score != members[j].score {
+			return members[i].score < members[j].score
+		}
+		return members[i].name < members[j].name
+	})
+	return members
+}
+
+func init() {
+	register("ZADD", -4, cmdZAdd)
+	register("ZINCRBY", 4, func(s *Server, args []string) interface{} {
+		return cmdZAdd(s, []string{"ZADD", args[1], "INCR", args[2], args[3]})
+	})
+	register("ZSCORE", 3, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindZSet)
+		if errReply != nil || e == nil {
+			return errReply
+		}
+		if score, ok := e.zset[args[2]]; ok {
+			return formatFloat(score)
+		}
+		return nil
+	})
+	register("ZCARD", 2, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindZSet)
+		if errReply != nil || e == nil {
+			return zeroOr(errReply)
+		}
+		return int64(len(e.zset))
+	})
+	register("ZRANK", 3, cmdZRank(false))
+	register("ZREVRANK", 3, cmdZRank(true))
+	register("ZRANGE", -4, cmdZRange(false))
+	register("ZREVRANGE", -4, cmdZRange(true))
+	register("ZRANGEBYSCORE", -4, cmdZRangeByScore(false))
+	register("ZREVRANGEBYSCORE", -4, cmdZRangeByScore(true))
+	register("ZCOUNT", 4, func(s *Server, args []string) interface{} {
+		members, errReply := s.zrangeByScore(args[1], args[2], args[3])
+		if errReply != nil {
+			return errReply
+		}
+		return int64(len(members))
+	})
+	register("ZREM", -3, func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindZSet)
+		if errReply != nil || e == nil {
+			return zeroOr(errReply)
+		}
+		var n int64
+		for _, member := range args[2:] {
+			if _, ok := e.zset[member]; ok {
+				delete(e.zset, member)
+				n++
+			}
+		}
+		if n > 0 {
+			s.touch(args[1])
+			s.cleanup(args[1], e)
+		}
+		return n
+	})
+	register("ZREMRANGEBYRANK", 4, func(s *Server, args []string) interface{} {
+		start, stop, errReply := parseRange(args[2], args[3])
+		if errReply != nil {
+			return errReply
+		}
+		e, errReply := s.get(args[1], kindZSet)
+		if errReply != nil || e == nil {
+			return zeroOr(errReply)
+		}
+		members := e.sorted()
+		from, to, ok := normalizeRange(start, stop, len(members))
+		if !ok {
+			return int64(0)
+		}
+		return s.zremMembers(args[1], e, members[from:to+1])
+	})
+	register("ZREMRANGEBYSCORE", 4, func(s *Server, args []string) interface{} {
+		members, errReply := s.zrangeByScore(args[1], args[2], args[3])
+		if errReply != nil || len(members) == 0 {
+			return zeroOr(errReply)
+		}
+		e, _ := s.get(args[1], kindZSet)
+		return s.zremMembers(args[1], e, members)
+	})
+	register("ZPOPMIN", -2, cmdZPop(false))
+	register("ZPOPMAX", -2, cmdZPop(true))
+	register("ZUNIONSTORE", -4, cmdZStore(false))
+	register("ZINTERSTORE", -4, cmdZStore(true))
+	register("ZSCAN", -3, func(s *Server, args []string) interface{} {
+		cursor, pattern, count, _, errReply := scanOptions(args[2:])
+		if errReply != nil {
+			return errReply
+		}
+		e, errReply := s.get(args[1], kindZSet)
+		if errReply != nil {
+			return errReply
+		}
+		var items []string
+		if e != nil {
+			for _, m := range e.sorted() {
+				items = append(items, m.name, formatFloat(m.score))
+			}
+		}
+		return scanPage(items, 2, cursor, count, pattern)
+	})
+}
+
+// cmdZAdd 支持NX/XX/GT/LT/CH/INCR
+func cmdZAdd(s *Server, args []string) interface{} {
+	var nx, xx, gt, lt, ch, incr bool
+	i := 2
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		case "CH":
+			ch = true
+		case "INCR":
+			incr = true
+		default:
+			break flags
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 || (incr && len(pairs) != 2) {
+		return syntaxErr()
+	}
+	if (nx && xx) || (gt && lt) || (nx && (gt || lt)) {
+		return errorReply("ERR XX and NX options at the same time are not compatible")
+	}
+	scores := make([]float64, len(pairs)/2)
+	for j := range scores {
+		score, ok := parseFloat(pairs[j*2])
+		if !ok {
+			return notFloat()
+		}
+		scores[j] = score
+	}
+
+	e, errReply := s.get(args[1], kindZSet)
+	if errReply != nil {
+		return errReply
+	}
+	if e == nil {
+		if xx {
+			if incr {
+				return nil
+			}
+			return int64(0)
+		}
+		e, _ = s.write(args[1], kindZSet)
+	}
+
+	var added, changed int64
+	var result interface{}
+	for j, score := range scores {
+		member := pairs[j*2+1]
+		cur, exists := e.zset[member]
+		if (nx && exists) || (xx && !exists) {
+			continue
+		}
+		if incr && exists {
+			score += cur
+			if math.IsNaN(score) {
+				return errorReply("ERR resulting score is not a number (NaN)")
+			}
+		}
+		if exists && ((gt && score <= cur) || (lt && score >= cur)) {
+			continue
+		}
+		if !exists {
+			added++
+		} else if score != cur {
+			changed++
+		}
+		e.zset[member] = score
+		result = formatFloat(score)
+	}
+	s.touch(args[1])
+	s.cleanup(args[1], e)
+	if incr {
+		return result
+	}
+	if ch {
+		return added + changed
+	}
+	return added
+}
+
+func cmdZRank(rev bool) func(s *Server, args []string) interface{} {
+	return func(s *Server, args []string) interface{} {
+		e, errReply := s.get(args[1], kindZSet)
+		if errReply != nil || e == nil {
+			return errReply
+		}
+		if _, ok := e.zset[args[2]]; !ok {
+			return nil
+		}
+		members := e.sorted()
+		for i, m := range members {
+			if m.name == args[2] {
+				if rev {
+					return int64(len(members) - 1 - i)
+				}
+				return int64(i)
+			}
+		}
+		return nil
+	}
+}
+
+func cmdZRange(rev bool) func(s *Server, args []string) interface{} {
+	return func(s *Server, args []string) interface{} {
+		withScores, desc := false, rev
+		for _, opt := range args[4:] {
+			switch strings.ToUpper(opt) {
+			case "WITHSCORES":
+				withScores = true
+			case "REV":
+				desc = !desc
+			default:
+				return syntaxErr()
+			}
+		}
+		start, stop, errReply := parseRange(args[2], args[3])
+		if errReply != nil {
+			return errReply
+		}
+		e, errReply := s.get(args[1], kindZSet)
+		if errReply != nil {
+			return errReply
+		}
+		if e == nil {
+			return []string{}
+		}
+		members := e.sorted()
+		if desc {
+			reverse(members)
+		}
+		from, to, ok := normalizeRange(start, stop, len(members))
+		if !ok {
+			return []string{}
+		}
+		return zreply(members[from:to+1], withScores)
+	}
+}
+
+// cmdZRangeByScore 逆序时参数为max min
+func cmdZRangeByScore(rev bool) func(s *Server, args []string) interface{} {
+	return func(s *Server, args []string) interface{} {
+		min, max := args[2], args[3]
+		if rev {
+			min, max = max, min
+		}
+		withScores, offset, count := false, 0, -1
+		for i := 4; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "WITHSCORES":
+				withScores = true
+			case "LIMIT":
+				if i+2 >= len(args) {
+					return syntaxErr()
+				}
+				var err1, err2 error
+				offset, err1 = strconv.Atoi(args[i+1])
+				count, err2 = strconv.Atoi(args[i+2])
+				if err1 != nil || err2 != nil {
+					return notInteger()
+				}
+				i += 2
+			default:
+				return syntaxErr()
+			}
+		}
+		members, errReply := s.zrangeByScore(args[1], min, max)
+		if errReply != nil {
+			return errReply
+		}
+		if rev {
+			reverse(members)
+		}
+		if offset < 0 || offset >= len(members) {
+			return []string{}
+		}
+		members = members[offset:]
+		if count >= 0 && count < len(members) {
+			members = members[:count]
+		}
+		return zreply(members, withScores)
+	}
+}
+
+func cmdZPop(max bool) func(s *Server, args []string) interface{} {
+	return func(s *Server, args []string) interface{} {
+		if len(args) > 3 {
+			return syntaxErr()
+		}
+		count := 1
+		if len(args) == 3 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n < 0 {
+				return errorReply("ERR value is out of range, must be positive")
+			}
+			count = n
+		}
+		e, errReply := s.get(args[1], kindZSet)
+		if errReply != nil || e == nil {
+			if errReply != nil {
+				return errReply
+			}
+			return []string{}
+		}
+		members := e.sorted()
+		if max {
+			reverse(members)
+		}
+		if count < len(members) {
+			members = members[:count]
+		}
+		s.zremMembers(args[1], e, members)
+		return zreply(members, true)
+	}
+}
+
+// cmdZStore ZUNIONSTORE/ZINTERSTORE dst numkeys key... [WEIGHTS w...] [AGGREGATE SUM|MIN|MAX], 源key也可以是set
+func cmdZStore(inter bool) func(s *Server, args []string) interface{} {
+	return func(s *Server, args []string) interface{} {
+		numKeys, err := strconv.Atoi(args[2])
+		if err != nil || numKeys < 1 || 3+numKeys > len(args) {
+			return syntaxErr()
+		}
+		keys := args[3 : 3+numKeys]
+		weights := make([]float64, numKeys)
+		for i := range weights {
+			weights[i] = 1
+		}
+		aggregate := "SUM"
+		for i := 3 + numKeys; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "WEIGHTS":
+				if i+numKeys >= len(args) {
+					return syntaxErr()
+				}
+				for j := range weights {
+					w, ok := parseFloat(args[i+1+j])
+					if !ok {
+						return errorReply("ERR weight value is not a float")
+					}
+					weights[j] = w
+				}
+				i += numKeys
+			case "AGGREGATE":
+				if i+1 >= len(args) {
+					return syntaxErr()
+				}
+				aggregate = strings.ToUpper(args[i+1])
+				if aggregate != "SUM" && aggregate != "MIN" && aggregate != "MAX" {
+					return syntaxErr()
+				}
+				i++
+			default:
+				return syntaxErr()
+			}
+		}
+
+		var result map[string]float64
+		for i, key := range keys {
+			scores := make(map[string]float64)
+			e := s.lookup(key)
+			switch {
+			case e == nil:
+			case e.kind == kindZSet:
+				for member, score := range e.zset {
+					scores[member] = score * weights[i]
+				}
+			case e.kind == kindSet:
+				for member := range e.set {
+					scores[member] = weights[i]
+				}
+			default:
+				return wrongType()
+			}
+
+			if i == 0 {
+				result = scores
+				continue
+			}
+			if inter {
+				for member := range result {
+					if _, ok := scores[member]; !ok {
+						delete(result, member)
+					}
+				}
+			}
+			for member, score := range scores {
+				cur, ok := result[member]
+				if !ok {
+					if !inter {
+						result[member] = score
+					}
+					continue
+				}
+				switch aggregate {
+				case "SUM":
+					result[member] = cur + score
+				case "MIN":
+					result[member] = math.Min(cur, score)
+				case "MAX":
+					result[member] = math.Max(cur, score)
+				}
+			}
+		}
+
+		s.del(args[1])
+		if len(result) > 0 {
+			e, _ := s.write(args[1], kindZSet)
+			e.zset = result
+		}
+		return int64(len(result))
+	}
+}
+
+// zrangeByScore 分数在[min, max]内的成员, 支持(开区间和±inf
+func (s *Server) zrangeByScore(key, minArg, maxArg string) ([]zmember, interface{}) {
+	min, minEx, ok1 := parseScoreBound(minArg)
+	max, maxEx, ok2 := parseScoreBound(maxArg)
+	if !ok1 || !ok2 {
+		return nil, errorReply("ERR min or max is not a float")
+	}
+	e, errReply := s.get(key, kindZSet)
+	if errReply != nil || e == nil {
+		return nil, errReply
+	}
+	var members []zmember
+	for _, m := range e.sorted() {
+		if m.score < min || (minEx && m.score == min) || m.score > max || (maxEx && m.score == max) {
+			continue
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+func parseScoreBound(arg string) (float64, bool, bool) {
+	exclusive := strings.HasPrefix(arg, "(")
+	if exclusive {
+		arg = arg[1:]
+	}
+	score, ok := parseFloat(arg)
+	return score, exclusive, ok
+}
+
+func (s *Server) zremMembers(key string, e *entry, members []zmember) int64 {
+	for _, m := range members {
+		delete(e.zset, m.name)
+	}
+	if len(members) > 0 {
+		s.touch(key)
+		s.cleanup(key, e)
+	}
+	return int64(len(members))
+}
+
+func zreply(members []zmember, withScores bool) []string {
+	reply := make([]string, 0, len(members)*2)
+	for _, m := range members {
+		reply = append(reply, m.name)
+		if withScores {
+			reply = append(reply, formatFloat(m.score))
+		}
+	}
+	return reply
+}
+
+func reverse(members []zmember) {
+	for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+		members[i], members[j] = members[j], members[i]
+	}
+}
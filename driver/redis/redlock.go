@@ -0,0 +1,225 @@
+package redis
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultRedlockDriftFactor = 0.01
+	// redlockDriftBase 时钟漂移的固定部分,补偿各节点ttl精度
+	redlockDriftBase = 2 * time.Millisecond
+)
+
+var ErrRedlockNotAcquired = errors.New("redlock: quorum not acquired")
+
+// Lease Redlock一次成功加锁的结果
+type Lease struct {
+	Fence    int64         // 单调递增的fencing token
+	Validity time.Duration // 扣除加锁耗时和时钟漂移后锁的有效时间
+	Until    time.Time     // 本地时间, 超过后不应再认为持有锁
+}
+
+// Redlock 在多个互相独立的redis节点上加锁,多数节点成功且有效时间大于0才算成功。
+// 每次成功加锁返回fencing token: 各节点的计数器取最大值后再写回多数节点,
+// 任意两次加锁的多数节点必有交集,因此token单调递增
+type Redlock struct {
+	key         string
+	nodes       []*Wrapper
+	option      lockOption
+	driftFactor float64
+}
+
+type RedlockOption func(r *Redlock)
+
+// WithRedlockDriftFactor 时钟漂移系数,有效时间 = ttl - 加锁耗时 - ttl*factor - 2ms
+func WithRedlockDriftFactor(factor float64) RedlockOption {
+	return func(r *Redlock) {
+		r.driftFactor = factor
+	}
+}
+
+// WithRedlockLockOption 复用Locker的ttl、token、退避配置, watchdog对Redlock无效
+func WithRedlockLockOption(opts ...LockOption) RedlockOption {
+	return func(r *Redlock) {
+		for _, opt := range opts {
+			opt(&r.option)
+		}
+	}
+}
+
+func NewRedlock(key string, nodes []*Wrapper, opts ...RedlockOption) (*Redlock, error) {
+	if len(nodes) == 0 {
+		return nil, errors.New("redlock: no nodes")
+	}
+	r := &Redlock{
+		key:   key,
+		nodes: nodes,
+		option: lockOption{
+			ttl:        defaultLockTTL,
+			minBackoff: defaultLockMinBackoff,
+			maxBackoff: defaultLockMaxBackoff,
+		},
+		driftFactor: defaultRedlockDriftFactor,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.option.token == "" {
+		r.option.token = newLockToken()
+	}
+	if r.option.ttl <= 0 {
+		return nil, errors.New("redlock: ttl must be positive")
+	}
+	return r, nil
+}
+
+func (r *Redlock) quorum() int {
+	return len(r.nodes)/2 + 1
+}
+
+// Lock 加锁,失败时按退避重试直到成功或ctx结束
+func (r *Redlock) Lock(ctx context.Context) (lease *Lease, err error) {
+	err = retryWithBackoff(ctx, r.option.minBackoff, r.option.maxBackoff, func() (bool, error) {
+		lease, err = r.TryLock(ctx)
+		if err == ErrRedlockNotAcquired {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	return lease, errors.WithMessage(err, "wait redlock "+r.key)
+}
+
+// TryLock 尝试一轮加锁,未达到多数或有效时间不足时释放已加的锁并返回ErrRedlockNotAcquired
+func (r *Redlock) TryLock(ctx context.Context) (*Lease, error) {
+	ttl := r.option.ttl
+	start := time.Now()
+
+	// 单个节点的超时远小于ttl,避免在故障节点上耗尽有效时间
+	fences := make([]int64, len(r.nodes))
+	r.eachNode(ctx, ttl, func(i int, w *Wrapper) {
+		fence, err := acquireLock(w, r.key, r.option.token, ttl, true)
+		if err == nil {
+			fences[i] = fence
+		}
+	})
+
+	var acquired []int
+	var maxFence int64
+	for i, fence := range fences {
+		if fence > 0 {
+			acquired = append(acquired, i)
+			if fence > maxFence {
+				maxFence = fence
+			}
+		}
+	}
+
+	validity := r.validity(ttl, start)
+	if len(acquired) < r.quorum() || validity <= 0 {
+		r.release(ctx)
+		return nil, ErrRedlockNotAcquired
+	}
+
+	// 抬高多数节点的计数器,下次加锁的多数节点中至少有一个不小于maxFence
+	raised := make([]bool, len(r.nodes))
+	r.eachNode(ctx, ttl, func(i int, w *Wrapper) {
+		if fences[i] == 0 {
+			return
+		}
+		_, err := w.EvalSha(RaiseFenceScriptName, []string{fenceKey(r.key)}, []interface{}{maxFence})
+		raised[i] = err == nil
+	})
+	count := 0
+	for _, ok := range raised {
+		if ok {
+			count++
+		}
+	}
+
+	validity = r.validity(ttl, start)
+	if count < r.quorum() || validity <= 0 {
+		r.release(ctx)
+		return nil, ErrRedlockNotAcquired
+	}
+	return &Lease{
+		Fence:    maxFence,
+		Validity: validity,
+		Until:    start.Add(validity),
+	}, nil
+}
+
+func (r *Redlock) validity(ttl time.Duration, start time.Time) time.Duration {
+	drift := time.Duration(float64(ttl)*r.driftFactor) + redlockDriftBase
+	return ttl - time.Since(start) - drift
+}
+
+// Extend 在多数节点上续期, 返回新的有效时间
+func (r *Redlock) Extend(ctx context.Context, ttl time.Duration) (time.Duration, error) {
+	start := time.Now()
+	extended := make([]bool, len(r.nodes))
+	r.eachNode(ctx, ttl, func(i int, w *Wrapper) {
+		ok, err := replyBool(w.EvalSha(ExtendLockScriptName,
+			[]string{r.key}, []interface{}{r.option.token, ttl.Milliseconds()}))
+		extended[i] = err == nil && ok
+	})
+	count := 0
+	for _, ok := range extended {
+		if ok {
+			count++
+		}
+	}
+
+	validity := r.validity(ttl, start)
+	if count < r.quorum() || validity <= 0 {
+		return 0, ErrLockNotHeld
+	}
+	return validity, nil
+}
+
+// Unlock 在所有节点上释放,包括加锁时失败的节点(可能实际已加上但响应丢失)
+func (r *Redlock) Unlock(ctx context.Context) error {
+	released := r.release(ctx)
+	if released < r.quorum() {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func (r *Redlock) release(ctx context.Context) int {
+	var mu sync.Mutex
+	released := 0
+	r.eachNode(ctx, r.option.ttl, func(i int, w *Wrapper) {
+		// 锁的格式与Locker相同,重复TryLock会累加重入次数,这里释放到0为止
+		n, err := replyInt(w.EvalSha(UnlockScriptName,
+			[]string{r.key}, []interface{}{r.option.token, r.option.ttl.Milliseconds()}))
+		for err == nil && n > 0 {
+			n, err = replyInt(w.EvalSha(UnlockScriptName,
+				[]string{r.key}, []interface{}{r.option.token, r.option.ttl.Milliseconds()}))
+		}
+		if err == nil && n >= 0 {
+			mu.Lock()
+			released++
+			mu.Unlock()
+		}
+	})
+	return released
+}
+
+// eachNode 并发地在每个节点上执行fn,单节点超时为ttl/10
+func (r *Redlock) eachNode(ctx context.Context, ttl time.Duration, fn func(i int, w *Wrapper)) {
+	var wg sync.WaitGroup
+	for i, node := range r.nodes {
+		wg.Add(1)
+		go func(i int, node *Wrapper) {
+			defer wg.Done()
+			nodeCtx, cancel := context.WithTimeout(ctx, ttl/10)
+			defer cancel()
+			fn(i, node.WithContext(nodeCtx))
+		}(i, node)
+	}
+	wg.Wait()
+}
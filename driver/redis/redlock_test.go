@@ -0,0 +1,166 @@
+package redis_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ziyoumeng/sdk/driver/redis"
+	"github.com/ziyoumeng/sdk/driver/redis/redistest"
+)
+
+const redlockKey = "redlock"
+
+// getRedlockNodes n个独立的节点, 每个节点有自己的时钟
+func getRedlockNodes(t *testing.T, n int) ([]*redis.Wrapper, []*redistest.Server, []*testClock) {
+	var (
+		nodes   []*redis.Wrapper
+		servers []*redistest.Server
+		clocks  []*testClock
+	)
+	for i := 0; i < n; i++ {
+		w, srv, clock := getWrapper(t)
+		nodes = append(nodes, w)
+		servers = append(servers, srv)
+		clocks = append(clocks, clock)
+	}
+	return nodes, servers, clocks
+}
+
+func newTestRedlock(t *testing.T, nodes []*redis.Wrapper, opts ...redis.RedlockOption) *redis.Redlock {
+	opts = append([]redis.RedlockOption{redis.WithRedlockLockOption(redis.WithLockTTL(10 * time.Second))}, opts...)
+	r, err := redis.NewRedlock(redlockKey, nodes, opts...)
+	if err != nil {
+		t.Fatalf("NewRedlock %s", err)
+	}
+	return r
+}
+
+// lockedOn 持有锁的节点下标
+func lockedOn(servers []*redistest.Server) []int {
+	var ret []int
+	for i, srv := range servers {
+		if srv.Exists(testPrefix + ":" + redlockKey) {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+func TestRedlockQuorum(t *testing.T) {
+	nodes, servers, _ := getRedlockNodes(t, 5)
+	ctx := context.Background()
+	r := newTestRedlock(t, nodes)
+
+	// 少数节点不可用时仍可加锁
+	servers[0].Close()
+	servers[3].Close()
+	lease, err := r.TryLock(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, lease.Fence > 0)
+	assert.True(t, lease.Validity > 0 && lease.Validity < 10*time.Second, lease.Validity)
+	assert.Equal(t, []int{1, 2, 4}, lockedOn(servers))
+
+	assert.NoError(t, r.Unlock(ctx))
+	assert.Empty(t, lockedOn(servers))
+}
+
+func TestRedlockMinority(t *testing.T) {
+	nodes, servers, _ := getRedlockNodes(t, 5)
+	ctx := context.Background()
+	r := newTestRedlock(t, nodes)
+
+	servers[0].Close()
+	servers[1].Close()
+	servers[2].Close()
+	_, err := r.TryLock(ctx)
+	assert.Equal(t, redis.ErrRedlockNotAcquired, err)
+	// 已加上的少数节点全部释放
+	assert.Empty(t, lockedOn(servers))
+	assert.Equal(t, redis.ErrLockNotHeld, r.Unlock(ctx))
+}
+
+func TestRedlockContention(t *testing.T) {
+	nodes, servers, _ := getRedlockNodes(t, 3)
+	ctx := context.Background()
+	a, b := newTestRedlock(t, nodes), newTestRedlock(t, nodes)
+
+	_, err := a.TryLock(ctx)
+	assert.NoError(t, err)
+	_, err = b.TryLock(ctx)
+	assert.Equal(t, redis.ErrRedlockNotAcquired, err)
+	// b失败后的释放不影响a
+	assert.Equal(t, []int{0, 1, 2}, lockedOn(servers))
+
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = b.Lock(waitCtx)
+	assert.Error(t, err)
+
+	assert.NoError(t, a.Unlock(ctx))
+	_, err = b.TryLock(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Unlock(ctx))
+}
+
+func TestRedlockExtend(t *testing.T) {
+	nodes, _, clocks := getRedlockNodes(t, 3)
+	ctx := context.Background()
+	r := newTestRedlock(t, nodes)
+
+	_, err := r.TryLock(ctx)
+	assert.NoError(t, err)
+	clocks[0].Add(9 * time.Second)
+	validity, err := r.Extend(ctx, 20*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, validity > 10*time.Second, validity)
+
+	// 多数节点上已过期
+	clocks[0].Add(21 * time.Second)
+	clocks[1].Add(21 * time.Second)
+	_, err = r.Extend(ctx, 20*time.Second)
+	assert.Equal(t, redis.ErrLockNotHeld, err)
+}
+
+func TestRedlockFence(t *testing.T) {
+	nodes, servers, _ := getRedlockNodes(t, 5)
+	ctx := context.Background()
+	r := newTestRedlock(t, nodes)
+
+	// 每次由不同的多数节点授予锁, 被占用的节点不参与
+	var last int64
+	for _, busy := range [][]int{{0, 1}, {3, 4}, {0, 2}, {1, 2}, {}} {
+		for _, i := range busy {
+			_, err := servers[i].Do("HSET", testPrefix+":"+redlockKey, "other", "1")
+			assert.NoError(t, err)
+		}
+		lease, err := r.TryLock(ctx)
+		if !assert.NoError(t, err, "busy %v", busy) {
+			return
+		}
+		assert.True(t, lease.Fence > last, "busy %v fence %d last %d", busy, lease.Fence, last)
+		last = lease.Fence
+		assert.NoError(t, r.Unlock(ctx))
+		for _, i := range busy {
+			_, err := servers[i].Do("DEL", testPrefix+":"+redlockKey)
+			assert.NoError(t, err)
+		}
+	}
+}
+
+func TestRedlockValidity(t *testing.T) {
+	nodes, servers, _ := getRedlockNodes(t, 3)
+	ctx := context.Background()
+	// 漂移扣除整个ttl后有效时间<=0, 即使所有节点都加锁成功也算失败
+	r := newTestRedlock(t, nodes, redis.WithRedlockDriftFactor(1))
+
+	_, err := r.TryLock(ctx)
+	assert.Equal(t, redis.ErrRedlockNotAcquired, err)
+	assert.Empty(t, lockedOn(servers))
+
+	_, err = redis.NewRedlock(redlockKey, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,75 @@
+package redis
+
+import (
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+// 以下函数与redigo的同名转换函数相同, 只是回复为nil时返回本包的ErrNil
+
+// nilErr redigo.ErrNil换成ErrNil
+func nilErr(err error) error {
+	if err == redigo.ErrNil {
+		return ErrNil
+	}
+	return err
+}
+
+func replyString(reply interface{}, err error) (string, error) {
+	ret, err := redigo.String(reply, err)
+	return ret, nilErr(err)
+}
+
+func replyBytes(reply interface{}, err error) ([]byte, error) {
+	ret, err := redigo.Bytes(reply, err)
+	return ret, nilErr(err)
+}
+
+func replyInt(reply interface{}, err error) (int, error) {
+	ret, err := redigo.Int(reply, err)
+	return ret, nilErr(err)
+}
+
+func replyInt64(reply interface{}, err error) (int64, error) {
+	ret, err := redigo.Int64(reply, err)
+	return ret, nilErr(err)
+}
+
+func replyFloat64(reply interface{}, err error) (float64, error) {
+	ret, err := redigo.Float64(reply, err)
+	return ret, nilErr(err)
+}
+
+func replyBool(reply interface{}, err error) (bool, error) {
+	ret, err := redigo.Bool(reply, err)
+	return ret, nilErr(err)
+}
+
+func replyValues(reply interface{}, err error) ([]interface{}, error) {
+	ret, err := redigo.Values(reply, err)
+	return ret, nilErr(err)
+}
+
+func replyStrings(reply interface{}, err error) ([]string, error) {
+	ret, err := redigo.Strings(reply, err)
+	return ret, nilErr(err)
+}
+
+func replyByteSlices(reply interface{}, err error) ([][]byte, error) {
+	ret, err := redigo.ByteSlices(reply, err)
+	return ret, nilErr(err)
+}
+
+func replyInt64s(reply interface{}, err error) ([]int64, error) {
+	ret, err := redigo.Int64s(reply, err)
+	return ret, nilErr(err)
+}
+
+func replyFloat64s(reply interface{}, err error) ([]float64, error) {
+	ret, err := redigo.Float64s(reply, err)
+	return ret, nilErr(err)
+}
+
+func replyStringMap(reply interface{}, err error) (map[string]string, error) {
+	ret, err := redigo.StringMap(reply, err)
+	return ret, nilErr(err)
+}
@@ -0,0 +1,253 @@
+package redis
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const defaultDeleteBatch = 500
+
+type scanOption struct {
+	count   int64
+	keyType string
+}
+
+type ScanOption func(o *scanOption)
+
+// WithScanCount 每次迭代建议redis返回的个数(COUNT)
+func WithScanCount(count int64) ScanOption {
+	return func(o *scanOption) {
+		o.count = count
+	}
+}
+
+// WithScanType 只返回指定类型的key(TYPE),如string/list/set/zset/hash/stream,只对Scan有效,需要redis 6.0+
+func WithScanType(keyType string) ScanOption {
+	return func(o *scanOption) {
+		o.keyType = keyType
+	}
+}
+
+// ScanIterator 基于游标的迭代器, 不会像KEYS一样阻塞redis。
+// 迭代期间一直存在的元素一定会返回,但可能重复返回
+//
+//	it := w.Scan("user:*")
+//	for it.Next() {
+//		key := it.Key()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+type ScanIterator struct {
+	w       *Wrapper
+	command string
+	key     string // HSCAN/SSCAN/ZSCAN的key,已加前缀
+	args    []interface{}
+	step    int // 每个元素在回复中占几项
+	strip   string
+
+	nodes   []string // cluster模式下Scan逐个master执行
+	node    int
+	cursor  string
+	started bool
+
+	buf []string
+	cur []string
+	err error
+}
+
+// Scan 遍历匹配pattern的key, pattern和返回的key都不带Wrapper的前缀。
+// pattern为空时遍历前缀下所有key
+func (w *Wrapper) Scan(pattern string, opts ...ScanOption) *ScanIterator {
+	option := newScanOption(opts)
+	if pattern == "" {
+		pattern = "*"
+	}
+	it := w.newScanIterator("SCAN", "", escapeGlob(w.WithPrefix(""))+pattern, 1, option)
+	if option.keyType != "" {
+		it.args = append(it.args, "TYPE", option.keyType)
+	}
+	it.strip = w.WithPrefix("")
+	if cs, ok := w.source.(*clusterSource); ok {
+		it.nodes = cs.masters()
+	}
+	return it
+}
+
+// HScan 遍历hash中匹配pattern的field, Key()为field, Value()为值
+func (w *Wrapper) HScan(key, pattern string, opts ...ScanOption) *ScanIterator {
+	return w.newScanIterator("HSCAN", w.WithPrefix(key), pattern, 2, newScanOption(opts))
+}
+
+// SScan 遍历集合中匹配pattern的成员, Key()为成员
+func (w *Wrapper) SScan(key, pattern string, opts ...ScanOption) *ScanIterator {
+	return w.newScanIterator("SSCAN", w.WithPrefix(key), pattern, 1, newScanOption(opts))
+}
+
+// ZScan 遍历有序集合中匹配pattern的成员, Key()为成员, Value()为分数
+func (w *Wrapper) ZScan(key, pattern string, opts ...ScanOption) *ScanIterator {
+	return w.newScanIterator("ZSCAN", w.WithPrefix(key), pattern, 2, newScanOption(opts))
+}
+
+func newScanOption(opts []ScanOption) scanOption {
+	var option scanOption
+	for _, opt := range opts {
+		opt(&option)
+	}
+	return option
+}
+
+func (w *Wrapper) newScanIterator(command, key, pattern string, step int, option scanOption) *ScanIterator {
+	it := &ScanIterator{
+		w:       w,
+		command: command,
+		key:     key,
+		step:    step,
+		cursor:  "0",
+	}
+	if pattern != "" {
+		it.args = append(it.args, "MATCH", pattern)
+	}
+	if option.count > 0 {
+		it.args = append(it.args, "COUNT", option.count)
+	}
+	return it
+}
+
+// Next 取下一个元素, 没有更多元素或出错时返回false
+func (it *ScanIterator) Next() bool {
+	for len(it.buf) < it.step {
+		if it.err != nil {
+			return false
+		}
+		if it.started && it.cursor == "0" {
+			// 当前节点遍历完
+			if it.node+1 >= len(it.nodes) {
+				return false
+			}
+			it.node++
+			it.started = false
+		}
+		it.fetch()
+	}
+	it.cur, it.buf = it.buf[:it.step], it.buf[it.step:]
+	return true
+}
+
+// Key 当前的key/field/成员
+func (it *ScanIterator) Key() string {
+	if len(it.cur) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(it.cur[0], it.strip)
+}
+
+// Value HScan时为field的值, ZScan时为分数
+func (it *ScanIterator) Value() string {
+	if len(it.cur) < 2 {
+		return ""
+	}
+	return it.cur[1]
+}
+
+func (it *ScanIterator) Err() error {
+	return it.err
+}
+
+// rawKey 带前缀的key, 只对Scan有意义
+func (it *ScanIterator) rawKey() string {
+	return it.cur[0]
+}
+
+func (it *ScanIterator) fetch() {
+	args := make([]interface{}, 0, len(it.args)+2)
+	if it.key != "" {
+		args = append(args, it.key)
+	}
+	args = append(args, it.cursor)
+	args = append(args, it.args...)
+
+	var values []interface{}
+	if len(it.nodes) > 0 {
+		cs := it.w.source.(*clusterSource)
+		conn, err := cs.nodeConn(it.w.Context(), it.nodes[it.node])
+		if err != nil {
+			it.err = errors.WithMessage(err, "nodeConn")
+			return
+		}
+		values, it.err = replyValues(it.w.do(it.w.instrument(conn), it.command, args...))
+		conn.Close()
+	} else {
+		values, it.err = replyValues(it.w.ExecRedisCommand(it.command, args...))
+	}
+	if it.err != nil {
+		return
+	}
+
+	if len(values) != 2 {
+		it.err = errors.Errorf("unexpected %s reply length %d", it.command, len(values))
+		return
+	}
+	if it.cursor, it.err = replyString(values[0], nil); it.err != nil {
+		return
+	}
+	items, err := replyStrings(values[1], nil)
+	if err != nil {
+		it.err = err
+		return
+	}
+	it.started = true
+	it.buf = append(it.buf, items...)
+}
+
+// DeleteByPattern 用Scan找出匹配pattern的key, 每batch个UNLINK一次, 返回删除的个数
+func (w *Wrapper) DeleteByPattern(pattern string, batch int) (deleted int64, err error) {
+	if pattern == "" {
+		return 0, errors.New("empty pattern")
+	}
+	if batch <= 0 {
+		batch = defaultDeleteBatch
+	}
+
+	it := w.Scan(pattern, WithScanCount(int64(batch)))
+	keys := make([]interface{}, 0, batch)
+	unlink := func() error {
+		n, err := replyInt64(w.ExecRedisCommand("UNLINK", keys...))
+		if err != nil {
+			return errors.WithMessage(err, "unlink")
+		}
+		deleted += n
+		keys = keys[:0]
+		return nil
+	}
+	for it.Next() {
+		keys = append(keys, it.rawKey())
+		if len(keys) >= batch {
+			if err := unlink(); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return deleted, errors.WithMessage(err, "scan")
+	}
+	if len(keys) > 0 {
+		if err := unlink(); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// escapeGlob 转义前缀中的glob特殊字符,避免前缀被当作pattern
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,125 @@
+package redis_test
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ziyoumeng/sdk/driver/redis"
+)
+
+// scanAll 取出迭代器的全部元素, 游标可能重复返回元素, 这里去重后排序
+func scanAll(t *testing.T, it *redis.ScanIterator) map[string]string {
+	items := make(map[string]string)
+	for it.Next() {
+		items[it.Key()] = it.Value()
+	}
+	assert.NoError(t, it.Err())
+	return items
+}
+
+func sortedKeys(items map[string]string) []string {
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestScan(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+
+	var want []string
+	for i := 0; i < 12; i++ {
+		key := fmt.Sprintf("user:%02d", i)
+		assert.NoError(t, w.Set(key, i))
+		want = append(want, key)
+	}
+	_, err := w.HSet("user:h", "f", 1)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Set("order:1", 1))
+	// 没有前缀或其他前缀的key不会返回
+	_, err = srv.Do("SET", "user:00", "1")
+	assert.NoError(t, err)
+	_, err = srv.Do("SET", "other:user:00", "1")
+	assert.NoError(t, err)
+
+	keys := sortedKeys(scanAll(t, w.Scan("user:*", redis.WithScanCount(5))))
+	assert.Equal(t, append(append([]string{}, want...), "user:h"), keys)
+	keys = sortedKeys(scanAll(t, w.Scan("user:*", redis.WithScanType("hash"))))
+	assert.Equal(t, []string{"user:h"}, keys)
+	keys = sortedKeys(scanAll(t, w.Scan("")))
+	assert.Len(t, keys, 14)
+
+	keys, err = w.Keys("user:0*")
+	assert.NoError(t, err)
+	sort.Strings(keys)
+	assert.Equal(t, want[:10], keys)
+}
+
+func TestScanGlobPrefix(t *testing.T) {
+	_, srv, _ := getWrapper(t)
+	// 前缀中的glob字符按字面匹配
+	w, err := srv.NewWrapper("t[1]")
+	assert.NoError(t, err)
+	defer w.Close()
+
+	assert.NoError(t, w.Set("k", 1))
+	_, err = srv.Do("SET", "t1:k", "1")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"k"}, sortedKeys(scanAll(t, w.Scan("*"))))
+	n, err := w.DeleteByPattern("*", 0)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, n)
+	assert.True(t, srv.Exists("t1:k"))
+}
+
+func TestScanContainer(t *testing.T) {
+	w, _, _ := getWrapper(t)
+
+	want := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		field := fmt.Sprintf("f%d", i)
+		_, err := w.HSet("h", field, i)
+		assert.NoError(t, err)
+		want[field] = fmt.Sprint(i)
+		_, err = w.SAdd("s", field)
+		assert.NoError(t, err)
+		_, err = w.ZAddMembers("z", redis.ZMember{Member: field, Score: float64(i)})
+		assert.NoError(t, err)
+	}
+	_, err := w.HSet("h", "other", 1)
+	assert.NoError(t, err)
+
+	assert.Equal(t, want, scanAll(t, w.HScan("h", "f*", redis.WithScanCount(3))))
+	assert.Equal(t, want, scanAll(t, w.ZScan("z", "", redis.WithScanCount(3))))
+	members := scanAll(t, w.SScan("s", "f1*"))
+	assert.Equal(t, map[string]string{"f1": ""}, members)
+
+	assert.Empty(t, scanAll(t, w.HScan("not-exist", "")))
+}
+
+func TestDeleteByPattern(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, w.Set(fmt.Sprintf("tmp:%d", i), i))
+	}
+	assert.NoError(t, w.Set("keep", 1))
+	// 前缀外的同名key不受影响
+	for _, key := range []string{"tmp:0", "other:tmp:0", "other:" + testPrefix + ":tmp:0"} {
+		_, err := srv.Do("SET", key, "1")
+		assert.NoError(t, err)
+	}
+
+	n, err := w.DeleteByPattern("tmp:*", 2)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, n)
+	assert.Equal(t, []string{"other:" + testPrefix + ":tmp:0", "other:tmp:0", testPrefix + ":keep", "tmp:0"}, srv.Keys())
+
+	_, err = w.DeleteByPattern("", 0)
+	assert.Error(t, err)
+}
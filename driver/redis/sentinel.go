@@ -0,0 +1,285 @@
+package redis
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const switchMasterChannel = "+switch-master"
+
+var ErrNoSentinelAvailable = errors.New("no sentinel available")
+
+// sentinelSource 通过sentinel发现master,订阅+switch-master并定期轮询,master变化时切换连接池
+type sentinelSource struct {
+	conf Config
+
+	mu        sync.RWMutex
+	sentinels []string
+	addr      string
+	pool      *redigo.Pool
+
+	stop      chan struct{}
+	stopOnce  sync.Once
+	subConnMu sync.Mutex
+	subConn   redigo.Conn
+	wg        sync.WaitGroup
+}
+
+func newSentinelSource(conf Config) (ConnSource, error) {
+	if conf.RedisSentinelMasterName == "" {
+		return nil, errors.New("empty sentinel master name")
+	}
+
+	s := &sentinelSource{
+		conf:      conf,
+		sentinels: append([]string(nil), conf.RedisSentinelAddrs...),
+		stop:      make(chan struct{}),
+	}
+	addr, err := s.discover()
+	if err != nil {
+		return nil, errors.WithMessage(err, "discover master")
+	}
+	s.addr = addr
+	s.pool = s.newMasterPool(addr)
+
+	s.wg.Add(2)
+	go s.subscribeLoop()
+	go s.pollLoop()
+	return s, nil
+}
+
+func (s *sentinelSource) GetContext(ctx context.Context) (redigo.Conn, error) {
+	s.mu.RLock()
+	pool := s.pool
+	s.mu.RUnlock()
+	return pool.GetContext(ctx)
+}
+
+func (s *sentinelSource) Stats() PoolStats {
+	s.mu.RLock()
+	pool := s.pool
+	s.mu.RUnlock()
+	return toPoolStats(pool.Stats())
+}
+
+func (s *sentinelSource) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		s.subConnMu.Lock()
+		if s.subConn != nil {
+			s.subConn.Close()
+		}
+		s.subConnMu.Unlock()
+	})
+	s.wg.Wait()
+
+	s.mu.RLock()
+	pool := s.pool
+	s.mu.RUnlock()
+	return pool.Close()
+}
+
+// MasterAddr 当前master地址
+func (s *sentinelSource) MasterAddr() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.addr
+}
+
+func (s *sentinelSource) newMasterPool(addr string) *redigo.Pool {
+	// 切换过程中旧master可能已降为slave,建连时确认角色,避免写到slave上
+	return newNodePool(s.conf, addr, checkMasterRole)
+}
+
+func checkMasterRole(c redigo.Conn) error {
+	values, err := redigo.Values(c.Do("ROLE"))
+	if err != nil {
+		// 不支持ROLE命令的老版本/代理不做校验
+		if _, ok := err.(redigo.Error); ok {
+			return nil
+		}
+		return err
+	}
+	if len(values) == 0 {
+		return errors.New("empty ROLE reply")
+	}
+	role, err := redigo.String(values[0], nil)
+	if err != nil {
+		return err
+	}
+	if role != "master" {
+		return errors.Errorf("redis role is %s, not master", role)
+	}
+	return nil
+}
+
+// switchMaster master地址变化时替换连接池,旧连接池上借出的连接归还时关闭
+func (s *sentinelSource) switchMaster(addr string) {
+	s.mu.Lock()
+	if addr == s.addr {
+		s.mu.Unlock()
+		return
+	}
+	old, oldAddr := s.pool, s.addr
+	s.addr = addr
+	s.pool = s.newMasterPool(addr)
+	s.mu.Unlock()
+
+	log.Printf("redis sentinel master %s switched from %s to %s", s.conf.RedisSentinelMasterName, oldAddr, addr)
+	old.Close()
+}
+
+func (s *sentinelSource) dialSentinel(addr string) (redigo.Conn, error) {
+	options := []redigo.DialOption{
+		redigo.DialConnectTimeout(s.conf.RedisDialTimeout),
+		redigo.DialWriteTimeout(s.conf.RedisWriteTimeout),
+	}
+	if s.conf.RedisSentinelPassword != "" {
+		options = append(options, redigo.DialPassword(s.conf.RedisSentinelPassword))
+	}
+	return redigo.Dial("tcp", addr, options...)
+}
+
+// discover 依次询问sentinel,成功的sentinel移到最前面
+func (s *sentinelSource) discover() (string, error) {
+	s.mu.RLock()
+	sentinels := append([]string(nil), s.sentinels...)
+	s.mu.RUnlock()
+
+	var lastErr error = ErrNoSentinelAvailable
+	for i, sentinel := range sentinels {
+		addr, err := s.queryMaster(sentinel)
+		if err != nil {
+			lastErr = errors.WithMessage(err, sentinel)
+			continue
+		}
+
+		if i > 0 {
+			s.mu.Lock()
+			s.sentinels = append([]string{sentinel}, append(sentinels[:i:i], sentinels[i+1:]...)...)
+			s.mu.Unlock()
+		}
+		return addr, nil
+	}
+	return "", lastErr
+}
+
+func (s *sentinelSource) queryMaster(sentinel string) (string, error) {
+	conn, err := s.dialSentinel(sentinel)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	reply, err := redigo.DoWithTimeout(conn, s.conf.RedisReadTimeout,
+		"SENTINEL", "get-master-addr-by-name", s.conf.RedisSentinelMasterName)
+	parts, err := redigo.Strings(reply, err)
+	if err != nil {
+		return "", err
+	}
+	if len(parts) != 2 {
+		return "", errors.Errorf("unexpected sentinel reply %v", parts)
+	}
+	return net.JoinHostPort(parts[0], parts[1]), nil
+}
+
+func (s *sentinelSource) pollLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.conf.RedisSentinelPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			addr, err := s.discover()
+			if err != nil {
+				log.Printf("redis sentinel discover master failed: %s", err)
+				continue
+			}
+			s.switchMaster(addr)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *sentinelSource) subscribeLoop() {
+	defer s.wg.Done()
+	for {
+		err := s.subscribeOnce()
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		log.Printf("redis sentinel subscribe %s failed: %s", switchMasterChannel, err)
+
+		select {
+		case <-time.After(time.Second):
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *sentinelSource) subscribeOnce() error {
+	s.mu.RLock()
+	sentinel := s.sentinels[0]
+	s.mu.RUnlock()
+
+	conn, err := s.dialSentinel(sentinel)
+	if err != nil {
+		// 下次从其他sentinel开始
+		s.rotateSentinels()
+		return err
+	}
+
+	s.subConnMu.Lock()
+	select {
+	case <-s.stop:
+		s.subConnMu.Unlock()
+		conn.Close()
+		return nil
+	default:
+	}
+	s.subConn = conn
+	s.subConnMu.Unlock()
+	defer func() {
+		s.subConnMu.Lock()
+		s.subConn = nil
+		s.subConnMu.Unlock()
+		conn.Close()
+	}()
+
+	psc := redigo.PubSubConn{Conn: conn}
+	if err := psc.Subscribe(switchMasterChannel); err != nil {
+		return err
+	}
+	for {
+		switch v := psc.Receive().(type) {
+		case redigo.Message:
+			// <master name> <old ip> <old port> <new ip> <new port>
+			fields := strings.Fields(string(v.Data))
+			if len(fields) != 5 || fields[0] != s.conf.RedisSentinelMasterName {
+				continue
+			}
+			s.switchMaster(net.JoinHostPort(fields[3], fields[4]))
+		case error:
+			return v
+		}
+	}
+}
+
+func (s *sentinelSource) rotateSentinels() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.sentinels) > 1 {
+		s.sentinels = append(s.sentinels[1:], s.sentinels[0])
+	}
+}
@@ -0,0 +1,50 @@
+package redis_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ziyoumeng/sdk/driver/redis"
+	"github.com/ziyoumeng/sdk/driver/redis/redistest"
+)
+
+func TestSentinelFailover(t *testing.T) {
+	master, replica, sentinel := redistest.NewServer(), redistest.NewServer(), redistest.NewServer()
+	defer master.Close()
+	defer replica.Close()
+	defer sentinel.Close()
+	sentinel.SetMaster("mymaster", master.Addr())
+
+	// 轮询间隔足够长, 只能通过+switch-master切换
+	w, err := redis.NewWrapper(redis.Config{
+		RedisSentinelAddrs:        []string{"127.0.0.1:1", sentinel.Addr()},
+		RedisSentinelMasterName:   "mymaster",
+		RedisSentinelPollInterval: time.Hour,
+	}, testPrefix)
+	assert.NoError(t, err)
+	defer w.Close()
+
+	assert.NoError(t, w.Set("k", 1))
+	assert.True(t, master.Exists(testPrefix+":k"))
+	assert.False(t, replica.Exists(testPrefix+":k"))
+
+	master.SetRole("slave")
+	// 订阅可能还没建立, 重复发布直到有订阅者
+	assert.Eventually(t, func() bool {
+		return sentinel.Failover("mymaster", replica.Addr()) > 0
+	}, testWaitTimeout, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return w.Set("k2", 1) == nil && replica.Exists(testPrefix+":k2")
+	}, testWaitTimeout, 10*time.Millisecond)
+	// 切换后不再写到旧master
+	assert.NoError(t, w.Set("k3", 1))
+	assert.True(t, replica.Exists(testPrefix+":k3"))
+	assert.False(t, master.Exists(testPrefix+":k3"))
+
+	_, err = redis.NewWrapper(redis.Config{
+		RedisSentinelAddrs:      []string{sentinel.Addr()},
+		RedisSentinelMasterName: "other",
+	}, testPrefix)
+	assert.Error(t, err)
+}
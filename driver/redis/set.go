@@ -0,0 +1,127 @@
+package redis
+
+import (
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// SAdd 返回新加入的成员数
+func (w *Wrapper) SAdd(key string, members ...interface{}) (n int, err error) {
+	if len(members) == 0 {
+		return 0, errors.New("invalid members num")
+	}
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt(w.do(conn, "SADD", w.keyArgs(key, members...)...))
+	})
+	return
+}
+
+// SRem 返回删除的成员数
+func (w *Wrapper) SRem(key string, members ...interface{}) (n int, err error) {
+	if len(members) == 0 {
+		return 0, errors.New("invalid members num")
+	}
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt(w.do(conn, "SREM", w.keyArgs(key, members...)...))
+	})
+	return
+}
+
+func (w *Wrapper) SIsMember(key string, member interface{}) (ok bool, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		ok, err = replyBool(w.do(conn, "SISMEMBER", w.WithPrefix(key), member))
+	})
+	return
+}
+
+func (w *Wrapper) SMembers(key string) (members []string, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		members, err = replyStrings(w.do(conn, "SMEMBERS", w.WithPrefix(key)))
+	})
+	return
+}
+
+func (w *Wrapper) SCard(key string) (n int64, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt64(w.do(conn, "SCARD", w.WithPrefix(key)))
+	})
+	return
+}
+
+// SPop 随机弹出一个成员,集合为空时返回ErrNil
+func (w *Wrapper) SPop(key string) (member string, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		member, err = replyString(w.do(conn, "SPOP", w.WithPrefix(key)))
+	})
+	return
+}
+
+// SPopN 随机弹出最多count个成员
+func (w *Wrapper) SPopN(key string, count int64) (members []string, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		members, err = replyStrings(w.do(conn, "SPOP", w.WithPrefix(key), count))
+	})
+	return
+}
+
+// SRandMember count为正时返回最多count个不重复的成员,为负时可能重复
+func (w *Wrapper) SRandMember(key string, count int64) (members []string, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		members, err = replyStrings(w.do(conn, "SRANDMEMBER", w.WithPrefix(key), count))
+	})
+	return
+}
+
+// SMove 成员不在src中时返回false
+func (w *Wrapper) SMove(src, dst string, member interface{}) (ok bool, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		ok, err = replyBool(w.do(conn, "SMOVE", w.WithPrefix(src), w.WithPrefix(dst), member))
+	})
+	return
+}
+
+// SInter cluster模式下keys需要在同一slot, 下同
+func (w *Wrapper) SInter(keys ...string) (members []string, err error) {
+	return w.setOp("SINTER", keys)
+}
+
+func (w *Wrapper) SUnion(keys ...string) (members []string, err error) {
+	return w.setOp("SUNION", keys)
+}
+
+func (w *Wrapper) SDiff(keys ...string) (members []string, err error) {
+	return w.setOp("SDIFF", keys)
+}
+
+// SInterStore 结果写入dst, 返回dst的成员数
+func (w *Wrapper) SInterStore(dst string, keys ...string) (n int64, err error) {
+	return w.setOpStore("SINTERSTORE", dst, keys)
+}
+
+func (w *Wrapper) SUnionStore(dst string, keys ...string) (n int64, err error) {
+	return w.setOpStore("SUNIONSTORE", dst, keys)
+}
+
+func (w *Wrapper) SDiffStore(dst string, keys ...string) (n int64, err error) {
+	return w.setOpStore("SDIFFSTORE", dst, keys)
+}
+
+func (w *Wrapper) setOp(command string, keys []string) (members []string, err error) {
+	if len(keys) == 0 {
+		return nil, errors.New("invalid keys num")
+	}
+	w.Wrap(func(conn redigo.Conn) {
+		members, err = replyStrings(w.do(conn, command, w.prefixKeys(keys)...))
+	})
+	return
+}
+
+func (w *Wrapper) setOpStore(command, dst string, keys []string) (n int64, err error) {
+	if len(keys) == 0 {
+		return 0, errors.New("invalid keys num")
+	}
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt64(w.do(conn, command, w.keyArgs(dst, w.prefixKeys(keys)...)...))
+	})
+	return
+}
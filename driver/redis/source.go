@@ -0,0 +1,45 @@
+package redis
+
+import (
+	"context"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+// ConnSource Wrapper获取连接的来源,单节点、sentinel和cluster各有实现
+type ConnSource interface {
+	GetContext(ctx context.Context) (redigo.Conn, error)
+	Stats() PoolStats
+	Close() error
+}
+
+// NewPoolSource 使用自定义的连接池, 连接池由返回的ConnSource负责关闭
+func NewPoolSource(pool *redigo.Pool) ConnSource {
+	return poolSource{pool}
+}
+
+// poolSource 单节点
+type poolSource struct {
+	pool *redigo.Pool
+}
+
+func (p poolSource) GetContext(ctx context.Context) (redigo.Conn, error) {
+	return p.pool.GetContext(ctx)
+}
+
+func (p poolSource) Stats() PoolStats {
+	return toPoolStats(p.pool.Stats())
+}
+
+func (p poolSource) Close() error {
+	return p.pool.Close()
+}
+
+func toPoolStats(stats redigo.PoolStats) PoolStats {
+	return PoolStats{
+		ActiveCount:  stats.ActiveCount,
+		IdleCount:    stats.IdleCount,
+		WaitCount:    stats.WaitCount,
+		WaitDuration: stats.WaitDuration,
+	}
+}
@@ -0,0 +1,476 @@
+package redis
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// XMessage stream中的一条消息, 读取已投递未ack的消息时, 已被删除或裁剪的消息Values为nil
+type XMessage struct {
+	ID     string
+	Values map[string]string
+}
+
+// XPendingSummary XPENDING汇总
+type XPendingSummary struct {
+	Count     int64
+	Lower     string // 最小的未ack消息id
+	Upper     string // 最大的未ack消息id
+	Consumers map[string]int64
+}
+
+// XPendingEntry XPENDING明细
+type XPendingEntry struct {
+	ID         string
+	Consumer   string
+	Idle       time.Duration // 距上次投递的时间
+	Deliveries int64
+}
+
+// XAdd 追加消息,maxLen>0时按近似MAXLEN裁剪,返回消息id
+func (w *Wrapper) XAdd(stream string, maxLen int64, values map[string]interface{}) (id string, err error) {
+	if len(values) == 0 {
+		return "", errors.New("empty values")
+	}
+	args := []interface{}{w.WithPrefix(stream)}
+	if maxLen > 0 {
+		args = append(args, "MAXLEN", "~", maxLen)
+	}
+	args = append(args, "*")
+
+	fields := make([]string, 0, len(values))
+	for field := range values {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		args = append(args, field, values[field])
+	}
+
+	w.Wrap(func(conn redigo.Conn) {
+		id, err = replyString(w.do(conn, "XADD", args...))
+	})
+	return
+}
+
+func (w *Wrapper) XLen(stream string) (n int64, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt64(w.do(conn, "XLEN", w.WithPrefix(stream)))
+	})
+	return
+}
+
+// XRange start/end为"-"、"+"或消息id, count<=0时不限制
+func (w *Wrapper) XRange(stream, start, end string, count int64) (messages []XMessage, err error) {
+	args := []interface{}{w.WithPrefix(stream), start, end}
+	if count > 0 {
+		args = append(args, "COUNT", count)
+	}
+	w.Wrap(func(conn redigo.Conn) {
+		messages, err = xMessages(w.do(conn, "XRANGE", args...))
+	})
+	return
+}
+
+// XGroupCreate 创建消费组,stream不存在时自动创建,消费组已存在时不报错。
+// start为"$"时只消费之后的新消息,为"0"时从头消费
+func (w *Wrapper) XGroupCreate(stream, group, start string) (err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		_, err = w.do(conn, "XGROUP", "CREATE", w.WithPrefix(stream), group, start, "MKSTREAM")
+	})
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// XReadGroup 读取消息, id为">"时读取新消息,为"0"时读取本consumer已投递未ack的消息。
+// block>0时最多阻塞block,需要小于RedisReadTimeout; 没有消息时返回空
+func (w *Wrapper) XReadGroup(stream, group, consumer, id string, count int64, block time.Duration) (messages []XMessage, err error) {
+	args := []interface{}{"GROUP", group, consumer}
+	if count > 0 {
+		args = append(args, "COUNT", count)
+	}
+	if block > 0 {
+		args = append(args, "BLOCK", block.Milliseconds())
+	}
+	args = append(args, "STREAMS", w.WithPrefix(stream), id)
+
+	w.Wrap(func(conn redigo.Conn) {
+		var streams []interface{}
+		streams, err = replyValues(w.do(conn, "XREADGROUP", args...))
+		if err != nil {
+			if err == ErrNil {
+				err = nil
+			}
+			return
+		}
+		for _, s := range streams {
+			var kv []interface{}
+			if kv, err = replyValues(s, nil); err != nil {
+				return
+			}
+			if len(kv) != 2 {
+				err = errors.Errorf("unexpected XREADGROUP reply %v", kv)
+				return
+			}
+			var ms []XMessage
+			if ms, err = xMessages(kv[1], nil); err != nil {
+				return
+			}
+			messages = append(messages, ms...)
+		}
+	})
+	return
+}
+
+func (w *Wrapper) XAck(stream, group string, ids ...string) (n int64, err error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	args := []interface{}{w.WithPrefix(stream), group}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt64(w.do(conn, "XACK", args...))
+	})
+	return
+}
+
+// XPending 消费组未ack消息的汇总
+func (w *Wrapper) XPending(stream, group string) (summary XPendingSummary, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		var values []interface{}
+		values, err = replyValues(w.do(conn, "XPENDING", w.WithPrefix(stream), group))
+		if err != nil {
+			return
+		}
+		if len(values) != 4 {
+			err = errors.Errorf("unexpected XPENDING reply %v", values)
+			return
+		}
+		summary.Count, _ = replyInt64(values[0], nil)
+		summary.Lower, _ = replyString(values[1], nil)
+		summary.Upper, _ = replyString(values[2], nil)
+		summary.Consumers = make(map[string]int64)
+		consumers, _ := replyValues(values[3], nil)
+		for _, c := range consumers {
+			pair, e := replyValues(c, nil)
+			if e != nil || len(pair) != 2 {
+				continue
+			}
+			name, _ := replyString(pair[0], nil)
+			count, _ := replyInt64(pair[1], nil)
+			summary.Consumers[name] = count
+		}
+	})
+	return
+}
+
+// XPendingEntries 未ack消息明细, minIdle>0时只返回空闲超过minIdle的, consumer为空时不过滤
+func (w *Wrapper) XPendingEntries(stream, group string, minIdle time.Duration, start, end string, count int64, consumer string) (entries []XPendingEntry, err error) {
+	args := []interface{}{w.WithPrefix(stream), group}
+	if minIdle > 0 {
+		args = append(args, "IDLE", minIdle.Milliseconds())
+	}
+	args = append(args, start, end, count)
+	if consumer != "" {
+		args = append(args, consumer)
+	}
+
+	w.Wrap(func(conn redigo.Conn) {
+		var values []interface{}
+		values, err = replyValues(w.do(conn, "XPENDING", args...))
+		if err != nil {
+			if err == ErrNil {
+				err = nil
+			}
+			return
+		}
+		for _, v := range values {
+			item, e := replyValues(v, nil)
+			if e != nil || len(item) != 4 {
+				err = errors.Errorf("unexpected XPENDING entry %v", v)
+				return
+			}
+			var entry XPendingEntry
+			entry.ID, _ = replyString(item[0], nil)
+			entry.Consumer, _ = replyString(item[1], nil)
+			idle, _ := replyInt64(item[2], nil)
+			entry.Idle = time.Duration(idle) * time.Millisecond
+			entry.Deliveries, _ = replyInt64(item[3], nil)
+			entries = append(entries, entry)
+		}
+	})
+	return
+}
+
+// XAutoClaim 把空闲超过minIdle的未ack消息转给consumer,返回下次扫描的起点("0-0"表示扫描完一轮)。
+// 已被XDEL删除的消息id放在deleted中,调用方应直接ack
+func (w *Wrapper) XAutoClaim(stream, group, consumer string, minIdle time.Duration, start string, count int64) (next string, messages []XMessage, deleted []string, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		var values []interface{}
+		values, err = replyValues(w.do(conn, "XAUTOCLAIM", w.WithPrefix(stream), group, consumer,
+			minIdle.Milliseconds(), start, "COUNT", count))
+		if err != nil {
+			return
+		}
+		if len(values) < 2 {
+			err = errors.Errorf("unexpected XAUTOCLAIM reply %v", values)
+			return
+		}
+		if next, err = replyString(values[0], nil); err != nil {
+			return
+		}
+
+		var items []interface{}
+		if items, err = replyValues(values[1], nil); err != nil {
+			return
+		}
+		for _, item := range items {
+			msg, e := xMessage(item)
+			if e != nil {
+				err = e
+				return
+			}
+			// redis 6.2中被删除的消息返回为[id, nil]
+			if msg.Values == nil {
+				deleted = append(deleted, msg.ID)
+				continue
+			}
+			messages = append(messages, msg)
+		}
+		// redis 7.0起被删除的消息单独返回
+		if len(values) > 2 {
+			ids, _ := replyStrings(values[2], nil)
+			deleted = append(deleted, ids...)
+		}
+	})
+	return
+}
+
+func xMessages(reply interface{}, err error) ([]XMessage, error) {
+	values, err := replyValues(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]XMessage, 0, len(values))
+	for _, v := range values {
+		msg, err := xMessage(v)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+func xMessage(v interface{}) (XMessage, error) {
+	kv, err := replyValues(v, nil)
+	if err != nil || len(kv) != 2 {
+		return XMessage{}, errors.Errorf("unexpected stream entry %v", v)
+	}
+	id, err := replyString(kv[0], nil)
+	if err != nil {
+		return XMessage{}, err
+	}
+	// 已被删除的消息返回为[id, nil]
+	if kv[1] == nil {
+		return XMessage{ID: id}, nil
+	}
+	values, err := replyStringMap(kv[1], nil)
+	if err != nil {
+		return XMessage{}, err
+	}
+	return XMessage{ID: id, Values: values}, nil
+}
+
+type consumerOption struct {
+	batch         int64
+	block         time.Duration
+	start         string
+	claimIdle     time.Duration
+	claimInterval time.Duration
+}
+
+type ConsumerOption func(o *consumerOption)
+
+// WithConsumerBatch 每次读取的消息数,默认10
+func WithConsumerBatch(n int64) ConsumerOption {
+	return func(o *consumerOption) {
+		o.batch = n
+	}
+}
+
+// WithConsumerBlock 没有消息时阻塞等待的时间,默认1s,需要小于RedisReadTimeout
+func WithConsumerBlock(d time.Duration) ConsumerOption {
+	return func(o *consumerOption) {
+		o.block = d
+	}
+}
+
+// WithConsumerStart 自动创建消费组时的起点,默认"$"只消费新消息
+func WithConsumerStart(id string) ConsumerOption {
+	return func(o *consumerOption) {
+		o.start = id
+	}
+}
+
+// WithConsumerClaim 每interval用XAUTOCLAIM接管其他consumer空闲超过idle的消息,默认1分钟/30秒,idle<=0时不接管
+func WithConsumerClaim(idle, interval time.Duration) ConsumerOption {
+	return func(o *consumerOption) {
+		o.claimIdle, o.claimInterval = idle, interval
+	}
+}
+
+// StreamHandler 返回nil时ack; 返回错误或panic时消息留在pending中,空闲超时后被重新接管
+type StreamHandler func(ctx context.Context, msg XMessage) error
+
+// StreamConsumer 消费组中的一个consumer
+type StreamConsumer struct {
+	w                       *Wrapper
+	stream, group, consumer string
+	option                  consumerOption
+}
+
+func NewStreamConsumer(w *Wrapper, stream, group, consumer string, opts ...ConsumerOption) *StreamConsumer {
+	option := consumerOption{
+		batch:         10,
+		block:         time.Second,
+		start:         "$",
+		claimIdle:     time.Minute,
+		claimInterval: 30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(&option)
+	}
+	return &StreamConsumer{
+		w:        w,
+		stream:   stream,
+		group:    group,
+		consumer: consumer,
+		option:   option,
+	}
+}
+
+// Run 自动创建消费组,先处理本consumer上次未ack的消息,再循环读取新消息,
+// 定期接管其他consumer的超时消息。ctx结束后处理完当前批次再返回
+func (c *StreamConsumer) Run(ctx context.Context, handler StreamHandler) error {
+	if err := c.w.XGroupCreate(c.stream, c.group, c.option.start); err != nil {
+		return errors.WithMessage(err, "XGroupCreate")
+	}
+
+	// 重启后先处理自己已投递但未ack的消息
+	pendingID := "0"
+	lastClaim := time.Now()
+	claimStart := "0-0"
+	for ctx.Err() == nil {
+		if c.option.claimIdle > 0 && time.Since(lastClaim) >= c.option.claimInterval {
+			lastClaim = time.Now()
+			claimStart = c.claim(ctx, claimStart, handler)
+		}
+
+		id := ">"
+		block := c.option.block
+		if pendingID != "" {
+			id, block = pendingID, 0
+		}
+		messages, err := c.w.WithContext(ctx).XReadGroup(c.stream, c.group, c.consumer, id, c.option.batch, block)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			if strings.HasPrefix(errors.Cause(err).Error(), "NOGROUP") {
+				// stream被删除,重新创建消费组
+				if err := c.w.XGroupCreate(c.stream, c.group, c.option.start); err != nil {
+					log.Printf("stream %s recreate group %s err:%s", c.stream, c.group, err)
+				}
+			} else {
+				log.Printf("stream %s XREADGROUP err:%s", c.stream, err)
+			}
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		if pendingID != "" {
+			if len(messages) == 0 {
+				pendingID = ""
+				continue
+			}
+			pendingID = messages[len(messages)-1].ID
+			messages = c.ackDeleted(messages)
+		}
+		c.process(ctx, messages, handler)
+	}
+	return nil
+}
+
+// claim 接管一批超时消息并处理,返回下次扫描的起点
+func (c *StreamConsumer) claim(ctx context.Context, start string, handler StreamHandler) string {
+	next, messages, deleted, err := c.w.WithContext(ctx).XAutoClaim(c.stream, c.group, c.consumer,
+		c.option.claimIdle, start, c.option.batch)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("stream %s XAUTOCLAIM err:%s", c.stream, err)
+		}
+		return start
+	}
+	if len(deleted) > 0 {
+		if _, err := c.w.XAck(c.stream, c.group, deleted...); err != nil {
+			log.Printf("stream %s ack deleted err:%s", c.stream, err)
+		}
+	}
+	c.process(ctx, messages, handler)
+	return next
+}
+
+// ackDeleted 直接ack已被删除或裁剪的消息, 返回其余的消息
+func (c *StreamConsumer) ackDeleted(messages []XMessage) []XMessage {
+	var deleted []string
+	remain := messages[:0]
+	for _, msg := range messages {
+		if msg.Values == nil {
+			deleted = append(deleted, msg.ID)
+			continue
+		}
+		remain = append(remain, msg)
+	}
+	if len(deleted) > 0 {
+		if _, err := c.w.XAck(c.stream, c.group, deleted...); err != nil {
+			log.Printf("stream %s ack deleted err:%s", c.stream, err)
+		}
+	}
+	return remain
+}
+
+func (c *StreamConsumer) process(ctx context.Context, messages []XMessage, handler StreamHandler) {
+	for _, msg := range messages {
+		if err := c.handle(ctx, msg, handler); err != nil {
+			log.Printf("stream %s handle %s err:%s", c.stream, msg.ID, err)
+			continue
+		}
+		// ack不受ctx结束影响
+		if _, err := c.w.XAck(c.stream, c.group, msg.ID); err != nil {
+			log.Printf("stream %s ack %s err:%s", c.stream, msg.ID, err)
+		}
+	}
+}
+
+func (c *StreamConsumer) handle(ctx context.Context, msg XMessage, handler StreamHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, msg)
+}
@@ -0,0 +1,91 @@
+package redis_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ziyoumeng/sdk/driver/redis"
+)
+
+// runConsumer 运行consumer直到处理了want条消息
+func runConsumer(t *testing.T, c *redis.StreamConsumer, want int) []redis.XMessage {
+	ctx, cancel := context.WithTimeout(context.Background(), testWaitTimeout)
+	defer cancel()
+
+	var handled []redis.XMessage
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(ctx, func(ctx context.Context, msg redis.XMessage) error {
+			handled = append(handled, msg)
+			if len(handled) == want {
+				cancel()
+			}
+			return nil
+		})
+	}()
+	assert.NoError(t, <-done)
+	return handled
+}
+
+func TestStreamConsumer(t *testing.T) {
+	w, _, _ := getWrapper(t)
+
+	assert.NoError(t, w.XGroupCreate("s", "g", "$"))
+	for _, v := range []string{"a", "b"} {
+		_, err := w.XAdd("s", 0, map[string]interface{}{"v": v})
+		assert.NoError(t, err)
+	}
+
+	c := redis.NewStreamConsumer(w, "s", "g", "c1", redis.WithConsumerBlock(10*time.Millisecond))
+	handled := runConsumer(t, c, 2)
+	if assert.Len(t, handled, 2) {
+		assert.Equal(t, "a", handled[0].Values["v"])
+		assert.Equal(t, "b", handled[1].Values["v"])
+	}
+
+	summary, err := w.XPending("s", "g")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, summary.Count)
+}
+
+func TestStreamConsumerReplayTrimmed(t *testing.T) {
+	w, srv, _ := getWrapper(t)
+
+	assert.NoError(t, w.XGroupCreate("s", "g", "$"))
+	var ids []string
+	for _, v := range []string{"a", "b"} {
+		id, err := w.XAdd("s", 0, map[string]interface{}{"v": v})
+		assert.NoError(t, err)
+		ids = append(ids, id)
+	}
+	// c1投递后未ack就退出, 之后第一条消息被裁剪
+	messages, err := w.XReadGroup("s", "g", "c1", ">", 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 2)
+	_, err = srv.Do("XTRIM", testPrefix+":s", "MAXLEN", "1")
+	assert.NoError(t, err)
+
+	messages, err = w.XReadGroup("s", "g", "c1", "0", 10, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, ids[0], messages[0].ID)
+		assert.Nil(t, messages[0].Values)
+	}
+
+	_, err = w.XAdd("s", 0, map[string]interface{}{"v": "c"})
+	assert.NoError(t, err)
+
+	// 重启后跳过并ack被裁剪的消息, 继续处理其余的和新消息
+	c := redis.NewStreamConsumer(w, "s", "g", "c1", redis.WithConsumerBlock(10*time.Millisecond))
+	handled := runConsumer(t, c, 2)
+	if assert.Len(t, handled, 2) {
+		assert.Equal(t, ids[1], handled[0].ID)
+		assert.Equal(t, "c", handled[1].Values["v"])
+	}
+
+	summary, err := w.XPending("s", "g")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, summary.Count)
+}
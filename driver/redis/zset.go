@@ -0,0 +1,159 @@
+package redis
+
+import (
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// ZAddMembers 返回新加入的成员数
+func (w *Wrapper) ZAddMembers(key string, members ...ZMember) (n int, err error) {
+	if len(members) == 0 {
+		return 0, errors.New("invalid members num")
+	}
+	args := make([]interface{}, 0, 2*len(members))
+	for _, m := range members {
+		args = append(args, m.Score, m.Member)
+	}
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt(w.do(conn, "ZADD", w.keyArgs(key, args...)...))
+	})
+	return
+}
+
+// ZIncrBy 返回增加后的分数
+func (w *Wrapper) ZIncrBy(key string, increment float64, member string) (score float64, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		score, err = replyFloat64(w.do(conn, "ZINCRBY", w.WithPrefix(key), increment, member))
+	})
+	return
+}
+
+// ZRevRank 按分数从大到小的排名(从0开始),成员不存在时返回ErrNil
+func (w *Wrapper) ZRevRank(key, member string) (rank int64, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		rank, err = replyInt64(w.do(conn, "ZREVRANK", w.WithPrefix(key), member))
+	})
+	return
+}
+
+// ZRevRangeWithScores 按分数从大到小返回[start, stop]的成员和分数
+func (w *Wrapper) ZRevRangeWithScores(key string, start, stop int64) (ret []ZMember, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		ret, err = zMembers(w.do(conn, "ZREVRANGE", w.WithPrefix(key), start, stop, "WITHSCORES"))
+	})
+	return
+}
+
+func (w *Wrapper) ZRevRangeMembers(key string, start, stop int64) (ret []string, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		ret, err = replyStrings(w.do(conn, "ZREVRANGE", w.WithPrefix(key), start, stop))
+	})
+	return
+}
+
+// ZRangeByScoreWithScores 返回分数在[min, max]的成员,按分数从小到大。
+// min/max可以是"-inf"、"+inf"或"(1"表示开区间, count<=0时不限制个数
+func (w *Wrapper) ZRangeByScoreWithScores(key, min, max string, offset, count int64) (ret []ZMember, err error) {
+	args := zRangeByScoreArgs(w.WithPrefix(key), min, max, true, offset, count)
+	w.Wrap(func(conn redigo.Conn) {
+		ret, err = zMembers(w.do(conn, "ZRANGEBYSCORE", args...))
+	})
+	return
+}
+
+func (w *Wrapper) ZRangeByScoreMembers(key, min, max string, offset, count int64) (ret []string, err error) {
+	args := zRangeByScoreArgs(w.WithPrefix(key), min, max, false, offset, count)
+	w.Wrap(func(conn redigo.Conn) {
+		ret, err = replyStrings(w.do(conn, "ZRANGEBYSCORE", args...))
+	})
+	return
+}
+
+// ZRevRangeByScoreWithScores 返回分数在[min, max]的成员,按分数从大到小
+func (w *Wrapper) ZRevRangeByScoreWithScores(key, max, min string, offset, count int64) (ret []ZMember, err error) {
+	args := zRangeByScoreArgs(w.WithPrefix(key), max, min, true, offset, count)
+	w.Wrap(func(conn redigo.Conn) {
+		ret, err = zMembers(w.do(conn, "ZREVRANGEBYSCORE", args...))
+	})
+	return
+}
+
+func (w *Wrapper) ZRevRangeByScoreMembers(key, max, min string, offset, count int64) (ret []string, err error) {
+	args := zRangeByScoreArgs(w.WithPrefix(key), max, min, false, offset, count)
+	w.Wrap(func(conn redigo.Conn) {
+		ret, err = replyStrings(w.do(conn, "ZREVRANGEBYSCORE", args...))
+	})
+	return
+}
+
+func zRangeByScoreArgs(key, from, to string, withScores bool, offset, count int64) []interface{} {
+	args := []interface{}{key, from, to}
+	if withScores {
+		args = append(args, "WITHSCORES")
+	}
+	if count > 0 {
+		args = append(args, "LIMIT", offset, count)
+	}
+	return args
+}
+
+// ZCount 分数在[min, max]的成员数
+func (w *Wrapper) ZCount(key, min, max string) (n int64, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt64(w.do(conn, "ZCOUNT", w.WithPrefix(key), min, max))
+	})
+	return
+}
+
+// ZRemRangeByRank 删除排名在[start, stop]的成员,返回删除的个数
+func (w *Wrapper) ZRemRangeByRank(key string, start, stop int64) (n int64, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt64(w.do(conn, "ZREMRANGEBYRANK", w.WithPrefix(key), start, stop))
+	})
+	return
+}
+
+// ZRemRangeByScore 删除分数在[min, max]的成员,返回删除的个数
+func (w *Wrapper) ZRemRangeByScore(key, min, max string) (n int64, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt64(w.do(conn, "ZREMRANGEBYSCORE", w.WithPrefix(key), min, max))
+	})
+	return
+}
+
+// ZPopMin 弹出分数最小的最多count个成员
+func (w *Wrapper) ZPopMin(key string, count int64) (ret []ZMember, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		ret, err = zMembers(w.do(conn, "ZPOPMIN", w.WithPrefix(key), count))
+	})
+	return
+}
+
+// ZPopMax 弹出分数最大的最多count个成员
+func (w *Wrapper) ZPopMax(key string, count int64) (ret []ZMember, err error) {
+	w.Wrap(func(conn redigo.Conn) {
+		ret, err = zMembers(w.do(conn, "ZPOPMAX", w.WithPrefix(key), count))
+	})
+	return
+}
+
+// ZUnionStore 分数相加后写入dst,返回dst的成员数; cluster模式下keys需要在同一slot
+func (w *Wrapper) ZUnionStore(dst string, keys ...string) (n int64, err error) {
+	return w.zSetOpStore("ZUNIONSTORE", dst, keys)
+}
+
+// ZInterStore 分数相加后写入dst,返回dst的成员数
+func (w *Wrapper) ZInterStore(dst string, keys ...string) (n int64, err error) {
+	return w.zSetOpStore("ZINTERSTORE", dst, keys)
+}
+
+func (w *Wrapper) zSetOpStore(command, dst string, keys []string) (n int64, err error) {
+	if len(keys) == 0 {
+		return 0, errors.New("invalid keys num")
+	}
+	args := append([]interface{}{len(keys)}, w.prefixKeys(keys)...)
+	w.Wrap(func(conn redigo.Conn) {
+		n, err = replyInt64(w.do(conn, command, w.keyArgs(dst, args...)...))
+	})
+	return
+}
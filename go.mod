@@ -4,15 +4,15 @@ go 1.14
 
 require (
 	github.com/creasty/defaults v1.5.1
-	github.com/garyburd/redigo v1.6.2
 	github.com/go-playground/universal-translator v0.18.1 // indirect
-	github.com/go-playground/validator v9.31.0+incompatible // indirect
-	github.com/gomodule/redigo v1.8.4
+	github.com/go-playground/validator v9.31.0+incompatible
+	github.com/gomodule/redigo v1.8.9
 	github.com/hashicorp/consul/api v1.20.0
 	github.com/leodido/go-urn v1.2.4 // indirect
 	github.com/pkg/errors v0.9.1
 	github.com/rabbitmq/amqp091-go v1.5.0
 	github.com/stretchr/testify v1.8.2
+	github.com/yuin/gopher-lua v1.1.0
 	github.com/zeromicro/go-zero v1.5.2
 	github.com/zeromicro/zero-contrib/zrpc/registry/consul v0.0.0-20230417153749-41a096d45fc8
 	go.mongodb.org/mongo-driver v1.11.4